@@ -0,0 +1,268 @@
+// 管理服务，统一提供监控指标、pprof、健康检查、配置、路由、版本、日志等级等运维接口
+
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/yuanzhangcai/chaos/graceful"
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/config"
+)
+
+// Option 管理服务配置
+type Option struct {
+	Server   string   `json:"server"`    // 监听地址，为空时不启动
+	Username string   `json:"username"`  // basic auth用户名，为空时不校验
+	Password string   `json:"password"`  // basic auth密码
+	AllowIPs []string `json:"allow_ips"` // 允许访问的IP或网段，为空时不限制
+
+	MetricsOnly bool `json:"-"` // 只提供监控指标与健康检查接口，使用monitor.server时为true
+}
+
+// Server 管理服务
+type Server struct {
+	option  *Option
+	mux     *http.ServeMux
+	allows  []*net.IPNet
+	srv     *http.Server
+	addr    string // 实际监听地址
+	lock    sync.Mutex
+	routes  gin.RoutesInfo
+	started bool
+}
+
+var (
+	defaultServer *Server
+	defaultLock   sync.Mutex
+)
+
+// GetOptionFromConfig 读取管理服务配置，没有配置admin.server时使用monitor.server，且只提供监控指标接口
+func GetOptionFromConfig() (*Option, error) {
+	opt := Option{}
+	err := config.Scan([]string{"admin"}, &opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.Server == "" {
+		opt.Server = config.GetString("monitor", "server")
+		opt.MetricsOnly = true
+	}
+	return &opt, nil
+}
+
+// New 创建管理服务
+func New(opt *Option) (*Server, error) {
+	s := &Server{
+		option: opt,
+		mux:    http.NewServeMux(),
+	}
+
+	for _, one := range opt.AllowIPs {
+		if !strings.Contains(one, "/") {
+			if strings.Contains(one, ":") {
+				one += "/128"
+			} else {
+				one += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(one)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin allow ip (%s)", one)
+		}
+		s.allows = append(s.allows, ipNet)
+	}
+
+	s.mux.Handle("/metrics", promhttp.Handler())
+	s.mux.HandleFunc("/health", healthHandler)
+	s.mux.HandleFunc("/health/live", liveHandler)
+	if opt.MetricsOnly {
+		return s, nil
+	}
+
+	s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.mux.HandleFunc("/debug/goroutines", goroutinesHandler)
+	s.mux.HandleFunc("/config", configHandler)
+	s.mux.HandleFunc("/routes", s.routesHandler)
+	s.mux.HandleFunc("/version", versionHandler)
+	s.mux.Handle("/log/level", log.LevelHandler())
+	return s, nil
+}
+
+// Handle 注册自定义管理接口
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// SetRoutes 设置业务服务的路由，用于路由列表接口
+func (s *Server) SetRoutes(routes gin.RoutesInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.routes = routes
+}
+
+// Handler 返回带有访问控制的管理接口handler
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowIP(r.RemoteAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		if s.option.Username != "" {
+			username, password, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(username), []byte(s.option.Username)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(password), []byte(s.option.Password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		s.mux.ServeHTTP(w, r)
+	})
+}
+
+// allowIP 判断是否允许该地址访问
+func (s *Server) allowIP(remoteAddr string) bool {
+	if len(s.allows) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, one := range s.allows {
+		if one.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start 启动管理服务，监听失败时返回错误
+func (s *Server) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.started {
+		return nil
+	}
+
+	ln, err := graceful.Listen("tcp", s.option.Server)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: s.Handler()}
+	s.srv = srv
+	s.addr = ln.Addr().String()
+	s.started = true
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.L().Error("管理服务异常退出：", err)
+		}
+	}()
+	return nil
+}
+
+// Addr 返回实际监听地址，未启动时为空
+func (s *Server) Addr() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.addr
+}
+
+// Stop 停止管理服务
+func (s *Server) Stop(ctx context.Context) error {
+	s.lock.Lock()
+	srv := s.srv
+	s.srv = nil
+	s.started = false
+	s.lock.Unlock()
+
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
+
+// Start 使用配置启动默认管理服务，没有配置监听地址时不启动
+func Start(routes gin.RoutesInfo) error {
+	opt, err := GetOptionFromConfig()
+	if err != nil {
+		return err
+	}
+	if opt.Server == "" {
+		return nil
+	}
+	if config.GetString("pprof", "server") != "" {
+		log.L().Warn("pprof.server配置已不再生效，pprof接口由管理服务提供，请配置admin.server")
+	}
+
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	if defaultServer != nil {
+		defaultServer.SetRoutes(routes)
+		return nil
+	}
+
+	s, err := New(opt)
+	if err != nil {
+		return err
+	}
+	s.SetRoutes(routes)
+	if err = s.Start(); err != nil {
+		return err
+	}
+
+	defaultServer = s
+	return nil
+}
+
+// GetServer 获取默认管理服务，未启动时返回nil
+func GetServer() *Server {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	return defaultServer
+}
+
+// Stop 停止默认管理服务
+func Stop() {
+	defaultLock.Lock()
+	s := defaultServer
+	defaultServer = nil
+	defaultLock.Unlock()
+
+	if s == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	log.L().Info("Admin Shutdown Server ...")
+	if err := s.Stop(ctx); err != nil {
+		log.L().Error("Admin Server Shutdown:", err)
+	}
+}
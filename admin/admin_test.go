@@ -0,0 +1,162 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/config"
+)
+
+func request(t *testing.T, handler http.Handler, method, path string, setup func(r *http.Request)) (int, string) {
+	req := httptest.NewRequest(method, path, nil)
+	if setup != nil {
+		setup(req)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&Option{AllowIPs: []string{"invalid"}})
+	assert.NotNil(t, err)
+
+	s, err := New(&Option{AllowIPs: []string{"127.0.0.1", "10.0.0.0/8"}})
+	assert.Nil(t, err)
+	assert.True(t, s.allowIP("127.0.0.1:1234"))
+	assert.True(t, s.allowIP("10.1.2.3:1234"))
+	assert.False(t, s.allowIP("192.168.1.1:1234"))
+}
+
+func TestAuth(t *testing.T) {
+	s, _ := New(&Option{Username: "admin", Password: "pass", AllowIPs: []string{"10.0.0.0/8"}})
+	handler := s.Handler()
+
+	code, _ := request(t, handler, http.MethodGet, "/version", nil)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	fromIntranet := func(r *http.Request) { r.RemoteAddr = "10.0.0.1:1234" }
+	code, _ = request(t, handler, http.MethodGet, "/version", fromIntranet)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = request(t, handler, http.MethodGet, "/version", func(r *http.Request) {
+		fromIntranet(r)
+		r.SetBasicAuth("admin", "pass")
+	})
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestHandlers(t *testing.T) {
+	_ = config.LoadMemory(`{"db": {"master": "root:123456@tcp(127.0.0.1:3306)/chaos", "password": "123456", "list": ["master"]}}`, "json")
+
+	s, _ := New(&Option{})
+	s.SetRoutes(gin.RoutesInfo{
+		{Method: http.MethodPost, Path: "/version", Handler: "version"},
+		{Method: http.MethodGet, Path: "/version", Handler: "version"},
+	})
+	handler := s.Handler()
+
+	code, body := request(t, handler, http.MethodGet, "/health/live", nil)
+	assert.Contains(t, body, "ok")
+	assert.Equal(t, http.StatusOK, code)
+
+	RegisterHealthCheck("db", func() error { return nil })
+	code, _ = request(t, handler, http.MethodGet, "/health", nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	RegisterHealthCheck("redis", func() error { return errors.New("connection refused") })
+	code, body = request(t, handler, http.MethodGet, "/health", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "connection refused")
+	UnregisterHealthCheck("db")
+	UnregisterHealthCheck("redis")
+
+	_, body = request(t, handler, http.MethodGet, "/config", nil)
+	data := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal([]byte(body), &data))
+	db := data["db"].(map[string]interface{})
+	assert.Equal(t, maskValue, db["password"])
+	assert.Equal(t, "root:******@tcp(127.0.0.1:3306)/chaos", db["master"])
+	assert.Equal(t, []interface{}{"master"}, db["list"])
+	assert.Equal(t, "123456", config.GetString("db", "password")) // 不修改原配置
+
+	_, body = request(t, handler, http.MethodGet, "/routes", nil)
+	routes := []routeInfo{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &routes))
+	assert.Equal(t, []routeInfo{
+		{Method: http.MethodGet, Path: "/version", Handler: "version"},
+		{Method: http.MethodPost, Path: "/version", Handler: "version"},
+	}, routes)
+
+	_, body = request(t, handler, http.MethodGet, "/version", nil)
+	assert.Contains(t, body, "go_version")
+
+	_, body = request(t, handler, http.MethodGet, "/log/level", nil)
+	assert.Contains(t, body, `"level"`)
+
+	_, body = request(t, handler, http.MethodGet, "/debug/goroutines", nil)
+	assert.Contains(t, body, "goroutine")
+
+	code, _ = request(t, handler, http.MethodGet, "/metrics", nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = request(t, handler, http.MethodGet, "/debug/pprof/", nil)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestStartStop(t *testing.T) {
+	s, _ := New(&Option{Server: "127.0.0.1:0"})
+	assert.Nil(t, s.Start())
+
+	resp, err := http.Get("http://" + s.Addr() + "/health/live")
+	assert.Nil(t, err)
+	buf, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.True(t, strings.Contains(string(buf), "ok"))
+
+	assert.Nil(t, s.Stop(context.Background()))
+	_, err = http.Get("http://" + s.Addr() + "/health/live")
+	assert.NotNil(t, err)
+
+	// 端口被占用时返回错误
+	s2, _ := New(&Option{Server: "127.0.0.1:0"})
+	assert.Nil(t, s2.Start())
+	s3, _ := New(&Option{Server: s2.Addr()})
+	assert.NotNil(t, s3.Start())
+	_ = s2.Stop(context.Background())
+}
+
+func TestMetricsOnly(t *testing.T) {
+	_ = config.LoadMemory(`{"admin": {"server": ""}, "monitor": {"server": ":4446"}}`, "json")
+	opt, err := GetOptionFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, ":4446", opt.Server)
+	assert.True(t, opt.MetricsOnly)
+
+	// 使用monitor.server时不提供pprof、配置与日志等级接口
+	s, _ := New(opt)
+	handler := s.Handler()
+	code, _ := request(t, handler, http.MethodGet, "/metrics", nil)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = request(t, handler, http.MethodGet, "/health/live", nil)
+	assert.Equal(t, http.StatusOK, code)
+	for _, path := range []string{"/debug/pprof/", "/debug/goroutines", "/config", "/log/level"} {
+		code, _ = request(t, handler, http.MethodGet, path, nil)
+		assert.Equal(t, http.StatusNotFound, code, path)
+	}
+
+	_ = config.LoadMemory(`{"admin": {"server": "127.0.0.1:0"}}`, "json")
+	defer func() { _ = config.LoadMemory(`{"admin": {"server": ""}}`, "json") }()
+	opt, err = GetOptionFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:0", opt.Server)
+	assert.False(t, opt.MetricsOnly)
+}
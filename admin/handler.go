@@ -0,0 +1,197 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuanzhangcai/chaos/common"
+	"github.com/yuanzhangcai/config"
+)
+
+const (
+	maskValue    = "******"
+	checkTimeout = 3 * time.Second // 单个健康检查的超时时间
+)
+
+var (
+	checks    = make(map[string]func() error) // 健康检查
+	checkLock sync.Mutex
+
+	// sensitiveKeys 配置中需要脱敏的字段，字段名包含这些内容时不输出原值
+	sensitiveKeys = []string{"password", "secret", "token", "key", "dsn", "passwd"}
+
+	// userinfo 数据库连接串、url中的密码，如 root:123456@tcp(127.0.0.1:3306)/db
+	userinfo = regexp.MustCompile(`([^:/@\s]*):([^@/\s]+)@`)
+)
+
+// RegisterHealthCheck 注册健康检查，check返回错误时健康检查接口返回503
+func RegisterHealthCheck(name string, check func() error) {
+	checkLock.Lock()
+	defer checkLock.Unlock()
+	checks[name] = check
+}
+
+// UnregisterHealthCheck 取消健康检查
+func UnregisterHealthCheck(name string) {
+	checkLock.Lock()
+	defer checkLock.Unlock()
+	delete(checks, name)
+}
+
+func writeJSON(w http.ResponseWriter, code int, data interface{}) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(code)
+	_, _ = w.Write(buf)
+}
+
+// liveHandler 存活检查，进程能响应即返回ok
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// healthHandler 执行所有健康检查，有检查失败时返回503
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	checkLock.Lock()
+	all := make(map[string]func() error, len(checks))
+	for name, check := range checks {
+		all[name] = check
+	}
+	checkLock.Unlock()
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]string, len(all))
+	status := http.StatusOK
+	for name, check := range all {
+		wg.Add(1)
+		go func(name string, check func() error) {
+			defer wg.Done()
+			result := "ok"
+			if err := runCheck(check); err != nil {
+				result = err.Error()
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			results[name] = result
+			if result != "ok" {
+				status = http.StatusServiceUnavailable
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	ret := "ok"
+	if status != http.StatusOK {
+		ret = "error"
+	}
+	writeJSON(w, status, map[string]interface{}{"status": ret, "checks": results})
+}
+
+// runCheck 执行健康检查，超时返回错误
+func runCheck(check func() error) error {
+	ch := make(chan error, 1)
+	go func() {
+		ch <- check()
+	}()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(checkTimeout):
+		return errors.New("health check timeout")
+	}
+}
+
+// configHandler 输出当前配置，敏感字段脱敏
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, maskConfig("", config.Get()))
+}
+
+// maskConfig 复制配置并将敏感字段替换为******，不修改原配置
+func maskConfig(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, one := range v {
+			m[k] = maskConfig(k, one)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, one := range v {
+			arr[i] = maskConfig(key, one)
+		}
+		return arr
+	}
+
+	if value != nil && isSensitive(key) {
+		if s, ok := value.(string); ok && s == "" {
+			return s
+		}
+		return maskValue
+	}
+	if s, ok := value.(string); ok {
+		return userinfo.ReplaceAllString(s, "$1:"+maskValue+"@")
+	}
+	return value
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, one := range sensitiveKeys {
+		if strings.Contains(key, one) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeInfo 路由信息
+type routeInfo struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Handler string `json:"handler"`
+}
+
+// routesHandler 输出业务服务的路由列表
+func (s *Server) routesHandler(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	routes := make([]routeInfo, 0, len(s.routes))
+	for _, one := range s.routes {
+		routes = append(routes, routeInfo{Method: one.Method, Path: one.Path, Handler: one.Handler})
+	}
+	s.lock.Unlock()
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path == routes[j].Path {
+			return routes[i].Method < routes[j].Method
+		}
+		return routes[i].Path < routes[j].Path
+	})
+	writeJSON(w, http.StatusOK, routes)
+}
+
+// versionHandler 输出版本信息
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, common.GetVersion())
+}
+
+// goroutinesHandler 输出所有协程的调用栈
+func goroutinesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain;charset=utf-8")
+	_ = pprof.Lookup("goroutine").WriteTo(w, 2)
+}
@@ -0,0 +1,441 @@
+// 告警组件，支持多渠道异步发送、按指纹去重限流与路由
+
+package alert
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yuanzhangcai/chaos/common"
+	"github.com/yuanzhangcai/config"
+)
+
+// Option 告警初始化参数
+type Option struct {
+	Levels            []string        `json:"levels"`             // 需要告警的日志等级
+	Prefix            string          `json:"prefix"`             // 告警内容前缀
+	QueueSize         int             `json:"queue_size"`         // 待发送队列长度，队列满时丢弃
+	Window            int64           `json:"window"`             // 限流窗口，单位秒
+	Burst             int             `json:"burst"`              // 窗口内同一指纹最多发送的告警数
+	SummaryInterval   int64           `json:"summary_interval"`   // 发送被抑制告警汇总的间隔，单位秒
+	FingerprintFields []string        `json:"fingerprint_fields"` // 参与计算指纹的日志字段
+	Channels          []ChannelOption `json:"channels"`           // 告警渠道
+	Rules             []Rule          `json:"rules"`              // 路由规则，为空时发送到所有渠道
+}
+
+// Rule 告警路由规则，同时满足等级与字段条件时发送到指定渠道
+type Rule struct {
+	Levels   []string `json:"levels"`   // 匹配的日志等级，为空时匹配所有等级
+	Field    string   `json:"field"`    // 匹配的日志字段，为空时不限制
+	Value    string   `json:"value"`    // 字段值，为空时只要求字段存在
+	Channels []string `json:"channels"` // 发送的渠道名称
+}
+
+// Message 告警消息
+type Message struct {
+	Level       logrus.Level           `json:"-"`
+	LevelName   string                 `json:"level"`
+	Title       string                 `json:"title"`
+	Text        string                 `json:"text"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Time        time.Time              `json:"time"`
+	Fingerprint string                 `json:"fingerprint"`
+	Suppressed  int                    `json:"suppressed,omitempty"` // 汇总消息中被抑制的告警数
+}
+
+// Channel 告警渠道
+type Channel interface {
+	Name() string
+	Send(msg *Message) error
+}
+
+// throttle 同一指纹的限流状态
+type throttle struct {
+	windowStart time.Time
+	sent        int
+	suppressed  int
+	sample      *Message // 被抑制告警的样例，用于生成汇总
+}
+
+// Alerter 告警发送器
+type Alerter struct {
+	option   *Option
+	levels   map[logrus.Level]bool
+	channels map[string]Channel
+	queue    chan *Message
+	dropped  int64 // 队列满时丢弃的告警数
+
+	lock      sync.Mutex
+	throttles map[string]*throttle
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+var (
+	defaultAlerter *Alerter
+	defaultLock    sync.Mutex
+
+	digits = regexp.MustCompile(`\d+`)
+)
+
+// GetOptionFromConfig 读取告警配置，没有alert配置时兼容robot.server配置
+func GetOptionFromConfig() (*Option, error) {
+	opt := Option{
+		Levels:          []string{"error", "fatal", "panic"},
+		Prefix:          config.GetString("robot", "prefix"),
+		QueueSize:       1000,
+		Window:          60,
+		Burst:           1,
+		SummaryInterval: 300,
+	}
+	err := config.Scan([]string{"alert"}, &opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(opt.Channels) == 0 {
+		if server := config.GetString("robot", "server"); server != "" {
+			opt.Channels = []ChannelOption{{Name: "robot", Type: TypeDingTalk, URL: server}}
+		}
+	}
+	return &opt, nil
+}
+
+// New 创建告警发送器
+func New(opt *Option) (*Alerter, error) {
+	c := &Alerter{
+		option:    opt,
+		levels:    make(map[logrus.Level]bool),
+		channels:  make(map[string]Channel),
+		throttles: make(map[string]*throttle),
+		quit:      make(chan struct{}),
+	}
+
+	for _, one := range opt.Levels {
+		level, err := logrus.ParseLevel(one)
+		if err != nil {
+			return nil, err
+		}
+		c.levels[level] = true
+	}
+
+	for _, one := range opt.Channels {
+		channel, err := NewChannel(one)
+		if err != nil {
+			return nil, err
+		}
+		c.channels[channel.Name()] = channel
+	}
+
+	for _, rule := range opt.Rules {
+		for _, name := range rule.Channels {
+			if _, ok := c.channels[name]; !ok {
+				return nil, fmt.Errorf("alert rule channel %s not exist", name)
+			}
+		}
+	}
+
+	if opt.QueueSize <= 0 {
+		opt.QueueSize = 1000
+	}
+	if opt.Burst <= 0 {
+		opt.Burst = 1
+	}
+	c.queue = make(chan *Message, opt.QueueSize)
+
+	c.wg.Add(1)
+	go c.loop()
+	return c, nil
+}
+
+// Init 使用配置初始化默认告警发送器，没有配置任何渠道时不启用
+func Init() error {
+	opt, err := GetOptionFromConfig()
+	if err != nil {
+		return err
+	}
+
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	if defaultAlerter != nil {
+		return nil
+	}
+	if len(opt.Channels) == 0 {
+		return nil
+	}
+
+	defaultAlerter, err = New(opt)
+	return err
+}
+
+// GetAlerter 获取默认告警发送器，未启用时返回nil
+func GetAlerter() *Alerter {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	return defaultAlerter
+}
+
+// Stop 停止默认告警发送器，发送队列中剩余的告警
+func Stop() {
+	defaultLock.Lock()
+	alerter := defaultAlerter
+	defaultAlerter = nil
+	defaultLock.Unlock()
+
+	if alerter != nil {
+		alerter.Close()
+	}
+}
+
+// Levels 需要告警的日志等级
+func (c *Alerter) Levels() []logrus.Level {
+	levels := make([]logrus.Level, 0, len(c.levels))
+	for level := range c.levels {
+		levels = append(levels, level)
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i] < levels[j] })
+	return levels
+}
+
+// Post 提交告警，不阻塞，队列满时丢弃并返回false
+func (c *Alerter) Post(msg *Message) bool {
+	c.prepare(msg)
+
+	select {
+	case <-c.quit:
+		return false
+	default:
+	}
+
+	select {
+	case c.queue <- msg:
+		return true
+	default:
+		atomic.AddInt64(&c.dropped, 1)
+		return false
+	}
+}
+
+// sendSync 限流后同步发送告警，不经过队列，用于Fatal、Panic等之后进程会退出的告警
+func (c *Alerter) sendSync(msg *Message) {
+	c.prepare(msg)
+	c.handle(msg)
+}
+
+// prepare 补全告警的时间、等级名称与指纹
+func (c *Alerter) prepare(msg *Message) {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	if msg.LevelName == "" {
+		msg.LevelName = msg.Level.String()
+	}
+	if msg.Fingerprint == "" {
+		msg.Fingerprint = c.fingerprint(msg)
+	}
+}
+
+// Dropped 返回队列满时丢弃的告警数
+func (c *Alerter) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// Close 停止发送器，发送队列中剩余的告警与被抑制告警的汇总
+func (c *Alerter) Close() {
+	c.once.Do(func() {
+		close(c.quit)
+		c.wg.Wait()
+	})
+}
+
+// fingerprint 计算告警指纹，消息中的数字不参与计算，以便合并只有id不同的告警
+func (c *Alerter) fingerprint(msg *Message) string {
+	h := sha1.New()
+	_, _ = h.Write([]byte(msg.LevelName))
+	_, _ = h.Write([]byte(digits.ReplaceAllString(msg.Title, "#")))
+	for _, field := range c.option.FingerprintFields {
+		_, _ = h.Write([]byte(field + "=" + common.ToString(msg.Fields[field])))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Alerter) loop() {
+	defer c.wg.Done()
+
+	interval := time.Duration(c.option.SummaryInterval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-c.queue:
+			c.handle(msg)
+		case now := <-ticker.C:
+			c.summary(now, false)
+		case <-c.quit:
+			for {
+				select {
+				case msg := <-c.queue:
+					c.handle(msg)
+				default:
+					c.summary(time.Now(), true)
+					return
+				}
+			}
+		}
+	}
+}
+
+// handle 限流后发送告警
+func (c *Alerter) handle(msg *Message) {
+	if c.allow(msg) {
+		c.send(msg)
+	}
+}
+
+// allow 判断同一指纹的告警在窗口内是否还能发送，不能发送时计入被抑制数
+func (c *Alerter) allow(msg *Message) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	window := time.Duration(c.option.Window) * time.Second
+	t, ok := c.throttles[msg.Fingerprint]
+	if !ok {
+		t = &throttle{windowStart: msg.Time}
+		c.throttles[msg.Fingerprint] = t
+	}
+
+	if msg.Time.Sub(t.windowStart) >= window {
+		t.windowStart = msg.Time
+		t.sent = 0
+	}
+
+	if t.sent < c.option.Burst {
+		t.sent++
+		return true
+	}
+
+	t.suppressed++
+	t.sample = msg
+	return false
+}
+
+// summary 发送被抑制告警的汇总，清理已过期的限流状态
+func (c *Alerter) summary(now time.Time, all bool) {
+	window := time.Duration(c.option.Window) * time.Second
+
+	var messages []*Message
+	c.lock.Lock()
+	for fingerprint, t := range c.throttles {
+		if t.suppressed > 0 {
+			sample := t.sample
+			messages = append(messages, &Message{
+				Level:       sample.Level,
+				LevelName:   sample.LevelName,
+				Title:       fmt.Sprintf("%d similar alerts suppressed: %s", t.suppressed, sample.Title),
+				Text:        fmt.Sprintf("最近有%d条相似告警被抑制，最后一条：\n%s", t.suppressed, sample.Text),
+				Fields:      sample.Fields,
+				Time:        now,
+				Fingerprint: fingerprint,
+				Suppressed:  t.suppressed,
+			})
+			t.suppressed = 0
+			t.sample = nil
+		} else if all || now.Sub(t.windowStart) >= window {
+			delete(c.throttles, fingerprint)
+		}
+	}
+	c.lock.Unlock()
+
+	for _, msg := range messages {
+		c.send(msg)
+	}
+}
+
+// send 按路由规则发送到各渠道
+func (c *Alerter) send(msg *Message) {
+	prefix := c.option.Prefix
+	switch common.Env {
+	case common.EnvDev:
+		prefix += "【开发】"
+	case common.EnvTest:
+		prefix += "【测试】"
+	case common.EnvPre:
+		prefix += "【预发布】"
+	}
+	if prefix != "" && !strings.HasPrefix(msg.Text, prefix) {
+		msg.Text = prefix + msg.Text
+	}
+
+	for _, channel := range c.route(msg) {
+		if err := channel.Send(msg); err != nil {
+			// 带上internal字段，避免告警发送失败的日志再次触发告警
+			logrus.WithField(internalField, true).Warn("告警发送失败(" + channel.Name() + ")：" + err.Error())
+		}
+	}
+}
+
+// route 返回告警需要发送的渠道
+func (c *Alerter) route(msg *Message) []Channel {
+	var names []string
+	if len(c.option.Rules) == 0 {
+		for name := range c.channels {
+			names = append(names, name)
+		}
+	} else {
+		for _, rule := range c.option.Rules {
+			if rule.match(msg) {
+				names = append(names, rule.Channels...)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	var channels []Channel
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+		channels = append(channels, c.channels[name])
+	}
+	return channels
+}
+
+func (c *Rule) match(msg *Message) bool {
+	if len(c.Levels) > 0 {
+		found := false
+		for _, one := range c.Levels {
+			if level, err := logrus.ParseLevel(one); err == nil && level == msg.Level {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if c.Field != "" {
+		value, ok := msg.Fields[c.Field]
+		if !ok {
+			return false
+		}
+		if c.Value != "" && common.ToString(value) != c.Value {
+			return false
+		}
+	}
+	return true
+}
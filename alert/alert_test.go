@@ -0,0 +1,222 @@
+package alert
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookRecorder 记录webhook渠道收到的告警，请求路径为渠道名称
+type webhookRecorder struct {
+	*httptest.Server
+	lock     sync.Mutex
+	messages map[string][]*Message
+}
+
+func newWebhookRecorder() *webhookRecorder {
+	c := &webhookRecorder{messages: make(map[string][]*Message)}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := &Message{}
+		buf, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(buf, msg)
+
+		c.lock.Lock()
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		c.messages[name] = append(c.messages[name], msg)
+		c.lock.Unlock()
+	}))
+	return c
+}
+
+func (c *webhookRecorder) channel(name string) ChannelOption {
+	return ChannelOption{Name: name, Type: TypeWebhook, URL: c.URL + "/" + name}
+}
+
+func (c *webhookRecorder) received(name string) []*Message {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.messages[name]
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&Option{Levels: []string{"unknown"}})
+	assert.NotNil(t, err)
+
+	_, err = New(&Option{Channels: []ChannelOption{{Type: "unknown"}}})
+	assert.NotNil(t, err)
+
+	_, err = New(&Option{Channels: []ChannelOption{{Type: TypeDingTalk}}})
+	assert.NotNil(t, err)
+
+	_, err = New(&Option{Rules: []Rule{{Channels: []string{"dingtalk"}}}})
+	assert.NotNil(t, err)
+
+	alerter, err := New(&Option{Levels: []string{"error", "fatal"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []logrus.Level{logrus.FatalLevel, logrus.ErrorLevel}, alerter.Levels())
+	alerter.Close()
+}
+
+func TestThrottle(t *testing.T) {
+	recorder := newWebhookRecorder()
+	defer recorder.Close()
+	alerter, err := New(&Option{Window: 60, Burst: 2, SummaryInterval: 300, Channels: []ChannelOption{recorder.channel("mem")}})
+	assert.Nil(t, err)
+
+	// 只有数字不同的告警指纹相同
+	for i := 0; i < 5; i++ {
+		alerter.Post(&Message{Level: logrus.ErrorLevel, Title: "query user 1" + string(rune('0'+i)) + " failed"})
+	}
+	alerter.Post(&Message{Level: logrus.ErrorLevel, Title: "other error"})
+	alerter.Close()
+
+	// 2条正常发送，1条其它告警，1条汇总
+	assert.Equal(t, 4, len(recorder.received("mem")))
+	summary := recorder.received("mem")[3]
+	assert.Equal(t, 3, summary.Suppressed)
+	assert.Contains(t, summary.Title, "3 similar alerts suppressed")
+}
+
+func TestQueueFull(t *testing.T) {
+	alerter := &Alerter{
+		option: &Option{},
+		queue:  make(chan *Message, 1),
+		quit:   make(chan struct{}),
+	}
+
+	assert.True(t, alerter.Post(&Message{Title: "1"}))
+	assert.False(t, alerter.Post(&Message{Title: "2"}))
+	assert.Equal(t, int64(1), alerter.Dropped())
+}
+
+func TestRoute(t *testing.T) {
+	recorder := newWebhookRecorder()
+	defer recorder.Close()
+	alerter, err := New(&Option{
+		Window:   60,
+		Burst:    10,
+		Channels: []ChannelOption{recorder.channel("dingtalk"), recorder.channel("email")},
+		Rules: []Rule{
+			{Channels: []string{"dingtalk"}},
+			{Levels: []string{"fatal"}, Channels: []string{"email"}},
+			{Field: "act_id", Value: "19", Channels: []string{"email", "dingtalk"}},
+		},
+	})
+	assert.Nil(t, err)
+
+	alerter.Post(&Message{Level: logrus.ErrorLevel, Title: "error"})
+	alerter.Post(&Message{Level: logrus.FatalLevel, Title: "fatal"})
+	alerter.Post(&Message{Level: logrus.ErrorLevel, Title: "act", Fields: map[string]interface{}{"act_id": "19"}})
+	alerter.Close()
+
+	assert.Equal(t, 3, len(recorder.received("dingtalk")))
+	assert.Equal(t, 2, len(recorder.received("email")))
+}
+
+func TestHook(t *testing.T) {
+	recorder := newWebhookRecorder()
+	defer recorder.Close()
+	alerter, err := New(&Option{Levels: []string{"error"}, Window: 60, Burst: 10, Channels: []ChannelOption{recorder.channel("mem")}})
+	assert.Nil(t, err)
+	hook := NewHook(alerter)
+	assert.Equal(t, []logrus.Level{logrus.ErrorLevel}, hook.Levels())
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	logger.AddHook(hook)
+	logger.WithField("act_id", "19").Error("error")
+	logger.WithField(internalField, true).Error("internal")
+	alerter.Close()
+
+	messages := recorder.received("mem")
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "error", messages[0].Title)
+	assert.Equal(t, "19", messages[0].Fields["act_id"])
+}
+
+func TestHookFatal(t *testing.T) {
+	recorder := newWebhookRecorder()
+	defer recorder.Close()
+	alerter, err := New(&Option{Levels: []string{"error", "fatal"}, Window: 60, Burst: 10, Channels: []ChannelOption{recorder.channel("mem")}})
+	assert.Nil(t, err)
+	defer alerter.Close()
+
+	code := 0
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	logger.ExitFunc = func(c int) { code = c }
+	logger.AddHook(NewHook(alerter))
+	logger.Fatal("fatal")
+
+	// 退出前已经发送，不依赖发送队列
+	assert.Equal(t, 1, code)
+	messages := recorder.received("mem")
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "fatal", messages[0].Title)
+}
+
+func TestChannels(t *testing.T) {
+	var lock sync.Mutex
+	requests := make(map[string]map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		data := make(map[string]interface{})
+		_ = json.Unmarshal(buf, &data)
+
+		lock.Lock()
+		requests[r.URL.Path] = data
+		lock.Unlock()
+
+		if r.URL.Path == "/dingtalk" {
+			assert.NotEmpty(t, r.URL.Query().Get("timestamp"))
+			assert.NotEmpty(t, r.URL.Query().Get("sign"))
+		}
+		if r.URL.Path == "/webhook" {
+			assert.Equal(t, "token", r.Header.Get("X-Token"))
+		}
+		if r.URL.Path == "/wecom_error" {
+			_, _ = w.Write([]byte(`{"errcode":93000,"errmsg":"invalid webhook url"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer server.Close()
+
+	msg := &Message{Level: logrus.ErrorLevel, LevelName: "error", Title: "title", Text: "text", Time: time.Now()}
+
+	options := []ChannelOption{
+		{Type: TypeDingTalk, URL: server.URL + "/dingtalk", Secret: "secret"},
+		{Type: TypeWeCom, URL: server.URL + "/wecom"},
+		{Type: TypeSlack, URL: server.URL + "/slack"},
+		{Type: TypeWebhook, URL: server.URL + "/webhook", Headers: map[string]string{"X-Token": "token"}},
+	}
+	for _, opt := range options {
+		channel, err := NewChannel(opt)
+		assert.Nil(t, err)
+		assert.Equal(t, opt.Type, channel.Name())
+		assert.Nil(t, channel.Send(msg))
+	}
+
+	assert.Equal(t, "text", requests["/dingtalk"]["text"].(map[string]interface{})["content"])
+	assert.Equal(t, "text", requests["/wecom"]["text"].(map[string]interface{})["content"])
+	assert.Equal(t, "text", requests["/slack"]["text"])
+	assert.Equal(t, "title", requests["/webhook"]["title"])
+
+	channel, _ := NewChannel(ChannelOption{Type: TypeWeCom, URL: server.URL + "/wecom_error"})
+	assert.NotNil(t, channel.Send(msg))
+
+	_, err := NewChannel(ChannelOption{Type: TypeEmail, Host: "smtp.example.com"})
+	assert.NotNil(t, err)
+}
+
+func TestDingTalkSign(t *testing.T) {
+	assert.Equal(t, "aepA5vF9d6L9JRULhJ2Wado5gFjMA6WASzE4ndfuoCc=", dingTalkSign("1577808000000", "secret"))
+}
@@ -0,0 +1,324 @@
+package alert
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yuanzhangcai/chaos/common"
+)
+
+const (
+	// TypeDingTalk 钉钉机器人
+	TypeDingTalk = "dingtalk"
+	// TypeWeCom 企业微信机器人
+	TypeWeCom = "wecom"
+	// TypeSlack Slack incoming webhook
+	TypeSlack = "slack"
+	// TypeWebhook 通用webhook，以json格式post告警消息
+	TypeWebhook = "webhook"
+	// TypeEmail 邮件
+	TypeEmail = "email"
+
+	sendTimeout = 2 // 发送超时时间，单位秒
+)
+
+// ChannelOption 告警渠道配置
+type ChannelOption struct {
+	Name     string            `json:"name"`     // 渠道名称，路由规则中使用，为空时使用类型名
+	Type     string            `json:"type"`     // 渠道类型
+	URL      string            `json:"url"`      // webhook地址
+	Secret   string            `json:"secret"`   // 钉钉加签密钥
+	Headers  map[string]string `json:"headers"`  // 通用webhook附加的请求头
+	Host     string            `json:"host"`     // smtp服务器
+	Port     int               `json:"port"`     // smtp端口
+	Username string            `json:"username"` // smtp用户名
+	Password string            `json:"password"` // smtp密码
+	From     string            `json:"from"`     // 发件人
+	To       []string          `json:"to"`       // 收件人
+}
+
+// NewChannel 根据配置创建告警渠道
+func NewChannel(opt ChannelOption) (Channel, error) {
+	if opt.Name == "" {
+		opt.Name = opt.Type
+	}
+
+	switch opt.Type {
+	case TypeDingTalk, TypeWeCom, TypeSlack, TypeWebhook:
+		if opt.URL == "" {
+			return nil, fmt.Errorf("alert channel %s url is empty", opt.Name)
+		}
+	case TypeEmail:
+		if opt.Host == "" || opt.From == "" || len(opt.To) == 0 {
+			return nil, fmt.Errorf("alert channel %s smtp config is incomplete", opt.Name)
+		}
+	default:
+		return nil, fmt.Errorf("unknown alert channel type (%s)", opt.Type)
+	}
+
+	switch opt.Type {
+	case TypeDingTalk:
+		return &DingTalk{name: opt.Name, url: opt.URL, secret: opt.Secret}, nil
+	case TypeWeCom:
+		return &WeCom{name: opt.Name, url: opt.URL}, nil
+	case TypeSlack:
+		return &Slack{name: opt.Name, url: opt.URL}, nil
+	case TypeWebhook:
+		return &Webhook{name: opt.Name, url: opt.URL, headers: opt.Headers}, nil
+	}
+
+	port := opt.Port
+	if port == 0 {
+		port = 25
+	}
+	return &Email{
+		name:     opt.Name,
+		addr:     net.JoinHostPort(opt.Host, strconv.Itoa(port)),
+		host:     opt.Host,
+		username: opt.Username,
+		password: opt.Password,
+		from:     opt.From,
+		to:       opt.To,
+	}, nil
+}
+
+// postJSON 以json格式post数据，返回响应内容
+func postJSON(sURL string, data interface{}, headers map[string]string) ([]byte, error) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &common.HTTPParam{
+		URL:     sURL,
+		Method:  http.MethodPost,
+		Timeout: sendTimeout,
+		Data:    string(buf),
+		Headers: map[string]interface{}{"Content-Type": "application/json;charset=utf-8"},
+	}
+	for key, value := range headers {
+		params.Headers[key] = value
+	}
+
+	resp, code, err := common.HTTP(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if code < 200 || code >= 300 {
+		return resp, fmt.Errorf("http status code is %d", code)
+	}
+	return resp, nil
+}
+
+// checkErrCode 检查钉钉、企业微信接口返回的错误码
+func checkErrCode(resp []byte) error {
+	ret := struct {
+		ErrCode int64  `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}{}
+
+	if err := json.Unmarshal(resp, &ret); err != nil {
+		return nil
+	}
+
+	if ret.ErrCode != 0 {
+		return errors.New(ret.ErrMsg)
+	}
+	return nil
+}
+
+// DingTalk 钉钉机器人
+type DingTalk struct {
+	name   string
+	url    string
+	secret string
+}
+
+// Name 渠道名称
+func (c *DingTalk) Name() string {
+	return c.name
+}
+
+// Send 发送告警，配置了secret时使用加签方式
+func (c *DingTalk) Send(msg *Message) error {
+	sURL := c.url
+	if c.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+		sURL += separator(sURL) + "timestamp=" + timestamp + "&sign=" + url.QueryEscape(dingTalkSign(timestamp, c.secret))
+	}
+
+	data := map[string]interface{}{
+		"msgtype": "text",
+		"text": map[string]interface{}{
+			"content": msg.Text,
+		},
+	}
+
+	resp, err := postJSON(sURL, data, nil)
+	if err != nil {
+		return err
+	}
+	return checkErrCode(resp)
+}
+
+// dingTalkSign 钉钉加签：HmacSHA256(timestamp+"\n"+secret)后base64
+func dingTalkSign(timestamp, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	_, _ = h.Write([]byte(timestamp + "\n" + secret))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func separator(sURL string) string {
+	if strings.Contains(sURL, "?") {
+		return "&"
+	}
+	return "?"
+}
+
+// WeCom 企业微信机器人
+type WeCom struct {
+	name string
+	url  string
+}
+
+// Name 渠道名称
+func (c *WeCom) Name() string {
+	return c.name
+}
+
+// Send 发送告警
+func (c *WeCom) Send(msg *Message) error {
+	data := map[string]interface{}{
+		"msgtype": "text",
+		"text": map[string]interface{}{
+			"content": msg.Text,
+		},
+	}
+
+	resp, err := postJSON(c.url, data, nil)
+	if err != nil {
+		return err
+	}
+	return checkErrCode(resp)
+}
+
+// Slack Slack incoming webhook
+type Slack struct {
+	name string
+	url  string
+}
+
+// Name 渠道名称
+func (c *Slack) Name() string {
+	return c.name
+}
+
+// Send 发送告警
+func (c *Slack) Send(msg *Message) error {
+	_, err := postJSON(c.url, map[string]interface{}{"text": msg.Text}, nil)
+	return err
+}
+
+// Webhook 通用webhook
+type Webhook struct {
+	name    string
+	url     string
+	headers map[string]string
+}
+
+// Name 渠道名称
+func (c *Webhook) Name() string {
+	return c.name
+}
+
+// Send 以json格式post整个告警消息
+func (c *Webhook) Send(msg *Message) error {
+	_, err := postJSON(c.url, msg, c.headers)
+	return err
+}
+
+// Email 邮件
+type Email struct {
+	name     string
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// Name 渠道名称
+func (c *Email) Name() string {
+	return c.name
+}
+
+// Send 发送告警邮件，服务器支持时使用STARTTLS
+func (c *Email) Send(msg *Message) error {
+	conn, err := net.DialTimeout("tcp", c.addr, sendTimeout*time.Second)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(3 * sendTimeout * time.Second))
+
+	client, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: c.host}); err != nil {
+			return err
+		}
+	}
+
+	if c.username != "" {
+		if err = client.Auth(smtp.PlainAuth("", c.username, c.password, c.host)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(c.from); err != nil {
+		return err
+	}
+	for _, one := range c.to {
+		if err = client.Rcpt(one); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	body := "From: " + c.from + "\r\n" +
+		"To: " + strings.Join(c.to, ",") + "\r\n" +
+		"Subject: " + mime.BEncoding.Encode("UTF-8", msg.Title) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + msg.Text
+	if _, err = w.Write([]byte(body)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
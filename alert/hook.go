@@ -0,0 +1,52 @@
+package alert
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// internalField 告警组件自身日志的标记字段，带有该字段的日志不触发告警
+const internalField = "alert_internal"
+
+// Hook 将日志转为告警的logrus hook，只提交到队列，不阻塞日志调用，Fatal与Panic日志同步发送
+type Hook struct {
+	alerter *Alerter
+}
+
+// NewHook 创建告警hook
+func NewHook(alerter *Alerter) *Hook {
+	return &Hook{alerter: alerter}
+}
+
+// Fire 提交告警
+func (c *Hook) Fire(entry *logrus.Entry) error {
+	if _, ok := entry.Data[internalField]; ok {
+		return nil
+	}
+
+	text, _ := entry.String()
+	fields := make(map[string]interface{}, len(entry.Data))
+	for key, value := range entry.Data {
+		fields[key] = value
+	}
+
+	msg := &Message{
+		Level:  entry.Level,
+		Title:  entry.Message,
+		Text:   text,
+		Fields: fields,
+		Time:   entry.Time,
+	}
+
+	// Fatal、Panic之后进程会退出，队列中的告警来不及发送，直接同步发送
+	if entry.Level <= logrus.FatalLevel {
+		c.alerter.sendSync(msg)
+		return nil
+	}
+	c.alerter.Post(msg)
+	return nil
+}
+
+// Levels hook等级
+func (c *Hook) Levels() []logrus.Level {
+	return c.alerter.Levels()
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+)
+
+// APIKey 单个API Key
+type APIKey struct {
+	Name   string   `json:"name"`   // 调用方名称
+	Key    string   `json:"key"`    // API Key
+	Scopes []string `json:"scopes"` // 权限范围
+}
+
+// APIKeyOption API Key认证配置
+type APIKeyOption struct {
+	Header string   `json:"header"` // 读取API Key的请求头
+	Query  string   `json:"query"`  // 读取API Key的请求参数，为空时不读取
+	Keys   []APIKey `json:"keys"`
+}
+
+// APIKeyVerifier API Key校验
+type APIKeyVerifier struct {
+	option *APIKeyOption
+	keys   map[[sha256.Size]byte]*APIKey // 按摘要查找，比较耗时与key内容无关
+}
+
+// NewAPIKeyVerifier 创建API Key校验，key为空或重复时返回错误
+func NewAPIKeyVerifier(opt *APIKeyOption) (*APIKeyVerifier, error) {
+	v := &APIKeyVerifier{
+		option: opt,
+		keys:   make(map[[sha256.Size]byte]*APIKey, len(opt.Keys)),
+	}
+	for i := range opt.Keys {
+		one := &opt.Keys[i]
+		if one.Key == "" {
+			return nil, fmt.Errorf("api key of (%s) is empty", one.Name)
+		}
+		sum := sha256.Sum256([]byte(one.Key))
+		if _, ok := v.keys[sum]; ok {
+			return nil, fmt.Errorf("api key of (%s) is duplicated", one.Name)
+		}
+		v.keys[sum] = one
+	}
+	return v, nil
+}
+
+// Verify 校验API Key，返回调用方身份
+func (c *APIKeyVerifier) Verify(key string) (*Principal, error) {
+	one, ok := c.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown api key", ErrInvalidCredentials)
+	}
+	return &Principal{Type: TypeAPIKey, Subject: one.Name, Scopes: one.Scopes}, nil
+}
+
+// Authenticate 实现Authenticator
+func (c *APIKeyVerifier) Authenticate(r *http.Request) (*Principal, error) {
+	key := ""
+	if c.option.Header != "" {
+		key = r.Header.Get(c.option.Header)
+	}
+	if key == "" && c.option.Query != "" {
+		key = r.URL.Query().Get(c.option.Query)
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	return c.Verify(key)
+}
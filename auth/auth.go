@@ -0,0 +1,165 @@
+// 接口认证，支持JWT、HMAC请求签名与API Key，认证通过后调用方身份保存在请求上下文中，控制器通过Principal读取
+
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuanzhangcai/chaos/tools"
+	"github.com/yuanzhangcai/config"
+)
+
+const (
+	// TypeJWT JWT认证
+	TypeJWT = "jwt"
+	// TypeHMAC HMAC请求签名认证，用于服务间调用
+	TypeHMAC = "hmac"
+	// TypeAPIKey API Key认证
+	TypeAPIKey = "api_key"
+
+	// ContextKey gin.Context中保存调用方身份的key
+	ContextKey = "chaos:principal"
+
+	// NonceStoreMemory 使用内存保存请求nonce，多实例部署时无法防止重放到其它实例
+	NonceStoreMemory = "memory"
+	// NonceStoreRedis 使用tools.GetRedis()保存请求nonce
+	NonceStoreRedis = "redis"
+)
+
+var (
+	// ErrNoCredentials 请求中没有对应认证方式的凭证
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials 凭证无效
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrExpired 凭证已过期或时间戳超出允许范围
+	ErrExpired = errors.New("credentials expired")
+	// ErrReplay 重复的请求
+	ErrReplay = errors.New("replayed request")
+)
+
+// Principal 认证通过的调用方身份
+type Principal struct {
+	Type    string                 // 认证方式：jwt/hmac/api_key
+	Subject string                 // 调用方标识，JWT为sub，HMAC为key id，API Key为名称
+	Scopes  []string               // 权限范围，JWT取scope或scopes声明
+	Claims  map[string]interface{} // JWT的所有声明，其它认证方式为nil
+}
+
+// HasScope 是否拥有指定权限
+func (c *Principal) HasScope(scope string) bool {
+	if c == nil {
+		return false
+	}
+	for _, one := range c.Scopes {
+		if one == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator 认证方式，请求中没有对应凭证时返回ErrNoCredentials
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Option 认证配置
+type Option struct {
+	JWT    JWTOption    `json:"jwt"`
+	HMAC   HMACOption   `json:"hmac"`
+	APIKey APIKeyOption `json:"api_key"`
+}
+
+// GetOptionFromConfig 读取认证配置
+func GetOptionFromConfig() (*Option, error) {
+	opt := Option{
+		JWT: JWTOption{
+			Algorithms: []string{"HS256"},
+			Leeway:     60,
+			Header:     "Authorization",
+		},
+		HMAC: HMACOption{
+			MaxSkew:    300,
+			NonceStore: NonceStoreMemory,
+		},
+		APIKey: APIKeyOption{
+			Header: "X-API-Key",
+		},
+	}
+	err := config.Scan([]string{"auth"}, &opt)
+	if err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
+// New 按配置创建认证方式，依次为JWT、HMAC、API Key，没有配置密钥的认证方式不创建
+func New(opt *Option) ([]Authenticator, error) {
+	var list []Authenticator
+	if opt.JWT.Secret != "" || opt.JWT.PublicKey != "" || opt.JWT.JWKSFile != "" {
+		v, err := NewJWTVerifier(&opt.JWT)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+
+	if len(opt.HMAC.Keys) > 0 {
+		if opt.HMAC.MaxSkew <= 0 {
+			return nil, errors.New("hmac max_skew must be positive")
+		}
+		store, err := newNonceStore(opt.HMAC.NonceStore)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, NewHMACVerifier(&opt.HMAC, store))
+	}
+
+	if len(opt.APIKey.Keys) > 0 {
+		v, err := NewAPIKeyVerifier(&opt.APIKey)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func newNonceStore(typ string) (NonceStore, error) {
+	switch typ {
+	case "", NonceStoreMemory:
+		return NewMemoryNonceStore(), nil
+	case NonceStoreRedis:
+		r := tools.GetRedis()
+		if r == nil {
+			return nil, errors.New("redis is not initialized for hmac nonce store")
+		}
+		return NewRedisNonceStore(r, "chaos:nonce:"), nil
+	}
+	return nil, errors.New("unknown hmac nonce store (" + typ + ")")
+}
+
+// FromContext 获取认证中间件保存的调用方身份，未认证时返回nil
+func FromContext(c *gin.Context) *Principal {
+	if c != nil {
+		if value, ok := c.Get(ContextKey); ok {
+			if p, ok := value.(*Principal); ok {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// SetPrincipal 保存调用方身份
+func SetPrincipal(c *gin.Context, p *Principal) {
+	c.Set(ContextKey, p)
+}
+
+// seconds 秒数转为time.Duration
+func seconds(n int64) time.Duration {
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/config"
+)
+
+func TestGetOptionFromConfig(t *testing.T) {
+	_ = config.LoadMemory(`{"auth": {
+		"jwt": {"secret": "secret", "issuer": "chaos"},
+		"hmac": {"keys": {"order": "secret"}},
+		"api_key": {"keys": [{"name": "ops", "key": "k1", "scopes": ["admin"]}]}
+	}}`, "json")
+	opt, err := GetOptionFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", opt.JWT.Secret)
+	assert.Equal(t, []string{"HS256"}, opt.JWT.Algorithms)
+	assert.Equal(t, "Authorization", opt.JWT.Header)
+	assert.Equal(t, int64(300), opt.HMAC.MaxSkew)
+	assert.Equal(t, "secret", opt.HMAC.Keys["order"])
+	assert.Equal(t, "X-API-Key", opt.APIKey.Header)
+	assert.Equal(t, "ops", opt.APIKey.Keys[0].Name)
+
+	list, err := New(opt)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(list))
+
+	// redis没有初始化
+	opt.HMAC.NonceStore = NonceStoreRedis
+	_, err = New(opt)
+	assert.NotNil(t, err)
+	opt.HMAC.NonceStore = "file"
+	_, err = New(opt)
+	assert.NotNil(t, err)
+	opt.HMAC.NonceStore = NonceStoreMemory
+	opt.HMAC.MaxSkew = 0
+	_, err = New(opt)
+	assert.NotNil(t, err)
+
+	list, err = New(&Option{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(list))
+}
+
+func TestAPIKey(t *testing.T) {
+	_, err := NewAPIKeyVerifier(&APIKeyOption{Keys: []APIKey{{Name: "a"}}})
+	assert.NotNil(t, err)
+	_, err = NewAPIKeyVerifier(&APIKeyOption{Keys: []APIKey{{Name: "a", Key: "k"}, {Name: "b", Key: "k"}}})
+	assert.NotNil(t, err)
+
+	v, err := NewAPIKeyVerifier(&APIKeyOption{
+		Header: "X-API-Key",
+		Query:  "api_key",
+		Keys:   []APIKey{{Name: "ops", Key: "k1", Scopes: []string{"admin"}}, {Name: "web", Key: "k2"}},
+	})
+	assert.Nil(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err = v.Authenticate(r)
+	assert.Equal(t, ErrNoCredentials, err)
+
+	r.Header.Set("X-API-Key", "k1")
+	p, err := v.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, TypeAPIKey, p.Type)
+	assert.Equal(t, "ops", p.Subject)
+	assert.True(t, p.HasScope("admin"))
+
+	p, err = v.Authenticate(httptest.NewRequest(http.MethodGet, "/?api_key=k2", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, "web", p.Subject)
+	assert.False(t, p.HasScope("admin"))
+
+	_, err = v.Authenticate(httptest.NewRequest(http.MethodGet, "/?api_key=k3", nil))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Nil(t, FromContext(nil))
+	var p *Principal
+	assert.False(t, p.HasScope("a"))
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Nil(t, FromContext(c))
+	p = &Principal{Type: TypeAPIKey, Subject: "ops"}
+	SetPrincipal(c, p)
+	assert.Equal(t, p, FromContext(c))
+}
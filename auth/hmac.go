@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	cache "github.com/patrickmn/go-cache"
+	"github.com/yuanzhangcai/chaos/tools"
+)
+
+const (
+	// HeaderKeyID 签名使用的key id
+	HeaderKeyID = "X-Chaos-Key"
+	// HeaderTimestamp 签名时间戳，单位秒
+	HeaderTimestamp = "X-Chaos-Timestamp"
+	// HeaderNonce 请求唯一标识，用于防重放
+	HeaderNonce = "X-Chaos-Nonce"
+	// HeaderSignature 签名，值为hex(HmacSHA256(secret, StringToSign))
+	HeaderSignature = "X-Chaos-Signature"
+)
+
+// minNonceTTL nonce最短保存时间，max_skew为0时同一秒内的时间戳仍然有效
+const minNonceTTL = 2 * time.Second
+
+// HMACOption HMAC请求签名配置
+type HMACOption struct {
+	Keys       map[string]string `json:"keys"`        // key id => 密钥
+	MaxSkew    int64             `json:"max_skew"`    // 时间戳允许的偏差，单位秒，nonce保存两倍的时间
+	NonceStore string            `json:"nonce_store"` // nonce保存方式：memory/redis
+}
+
+// NonceStore 保存已使用的nonce
+type NonceStore interface {
+	// Add 保存nonce，已存在时返回false
+	Add(nonce string, ttl time.Duration) (bool, error)
+}
+
+// MemoryNonceStore 内存nonce存储
+type MemoryNonceStore struct {
+	cache *cache.Cache
+}
+
+// NewMemoryNonceStore 创建内存nonce存储
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{cache: cache.New(time.Minute, time.Minute)}
+}
+
+// Add 实现NonceStore
+func (c *MemoryNonceStore) Add(nonce string, ttl time.Duration) (bool, error) {
+	return c.cache.Add(nonce, struct{}{}, ttl) == nil, nil
+}
+
+// RedisNonceStore redis nonce存储，多实例共享
+type RedisNonceStore struct {
+	redis  *tools.Redis
+	prefix string
+}
+
+// NewRedisNonceStore 创建redis nonce存储
+func NewRedisNonceStore(r *tools.Redis, prefix string) *RedisNonceStore {
+	return &RedisNonceStore{redis: r, prefix: prefix}
+}
+
+// Add 实现NonceStore
+func (c *RedisNonceStore) Add(nonce string, ttl time.Duration) (bool, error) {
+	return c.redis.SetNX(c.prefix+nonce, 1, ttl).Result()
+}
+
+// readBody 读取请求体并还原，以便后续处理继续读取
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	buf, err := ioutil.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	return buf, nil
+}
+
+// StringToSign 生成待签名字符串：
+// METHOD\nPATH\n按key排序的query\nkey id\n时间戳\nnonce\nhex(sha256(body))
+func StringToSign(r *http.Request, keyID, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(r.Method),
+		r.URL.EscapedPath(),
+		r.URL.Query().Encode(),
+		keyID,
+		timestamp,
+		nonce,
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+}
+
+func hmacSign(secret, str string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	_, _ = h.Write([]byte(str))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Sign 为服务间调用的请求签名，设置key id、时间戳、nonce与签名请求头
+func Sign(r *http.Request, keyID, secret string) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := uuid.New().String()
+
+	r.Header.Set(HeaderKeyID, keyID)
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, hmacSign(secret, StringToSign(r, keyID, timestamp, nonce, body)))
+	return nil
+}
+
+// HMACVerifier HMAC请求签名校验
+type HMACVerifier struct {
+	option *HMACOption
+	store  NonceStore
+	now    func() time.Time // 测试时替换
+}
+
+// NewHMACVerifier 创建HMAC请求签名校验
+func NewHMACVerifier(opt *HMACOption, store NonceStore) *HMACVerifier {
+	return &HMACVerifier{
+		option: opt,
+		store:  store,
+		now:    time.Now,
+	}
+}
+
+// Verify 校验签名、时间戳与nonce，返回调用方身份
+func (c *HMACVerifier) Verify(r *http.Request) (*Principal, error) {
+	keyID := r.Header.Get(HeaderKeyID)
+	signature := r.Header.Get(HeaderSignature)
+	if keyID == "" || signature == "" {
+		return nil, ErrNoCredentials
+	}
+	secret, ok := c.option.Keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key (%s)", ErrInvalidCredentials, keyID)
+	}
+
+	timestamp, nonce := r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" {
+		return nil, fmt.Errorf("%w: invalid timestamp or nonce", ErrInvalidCredentials)
+	}
+	if skew := c.now().Unix() - ts; skew > c.option.MaxSkew || skew < -c.option.MaxSkew {
+		return nil, ErrExpired
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	expect := hmacSign(secret, StringToSign(r, keyID, timestamp, nonce, body))
+	if !hmac.Equal([]byte(expect), []byte(strings.ToLower(signature))) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidCredentials)
+	}
+
+	// 签名校验通过后再保存nonce，避免伪造请求占用nonce
+	ttl := 2 * seconds(c.option.MaxSkew)
+	if ttl < minNonceTTL {
+		ttl = minNonceTTL
+	}
+	added, err := c.store.Add(keyID+":"+nonce, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return nil, ErrReplay
+	}
+	return &Principal{Type: TypeHMAC, Subject: keyID}, nil
+}
+
+// Authenticate 实现Authenticator
+func (c *HMACVerifier) Authenticate(r *http.Request) (*Principal, error) {
+	return c.Verify(r)
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// errorStore 保存nonce失败
+type errorStore struct{}
+
+func (c *errorStore) Add(nonce string, ttl time.Duration) (bool, error) {
+	return false, errors.New("store error")
+}
+
+// ttlStore 记录nonce的保存时间
+type ttlStore struct {
+	ttl time.Duration
+}
+
+func (c *ttlStore) Add(nonce string, ttl time.Duration) (bool, error) {
+	c.ttl = ttl
+	return true, nil
+}
+
+func signedRequest(t *testing.T, keyID, secret, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/score?b=2&a=1", strings.NewReader(body))
+	assert.Nil(t, Sign(r, keyID, secret))
+	return r
+}
+
+func TestHMAC(t *testing.T) {
+	opt := &HMACOption{Keys: map[string]string{"order": "secret"}, MaxSkew: 300}
+	v := NewHMACVerifier(opt, NewMemoryNonceStore())
+
+	r := signedRequest(t, "order", "secret", "nid=1707357")
+	assert.NotEmpty(t, r.Header.Get(HeaderNonce))
+	assert.NotEmpty(t, r.Header.Get(HeaderTimestamp))
+	p, err := v.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, TypeHMAC, p.Type)
+	assert.Equal(t, "order", p.Subject)
+
+	// 校验后请求体可以继续读取
+	buf, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(t, "nid=1707357", string(buf))
+
+	// 重放
+	r2 := httptest.NewRequest(http.MethodPost, "/api/score?b=2&a=1", strings.NewReader("nid=1707357"))
+	r2.Header = r.Header.Clone()
+	_, err = v.Verify(r2)
+	assert.Equal(t, ErrReplay, err)
+
+	// 没有签名、未知key、密钥错误
+	_, err = v.Verify(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, ErrNoCredentials, err)
+	_, err = v.Verify(signedRequest(t, "other", "secret", ""))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = v.Verify(signedRequest(t, "order", "wrong", ""))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// 请求被篡改
+	r = signedRequest(t, "order", "secret", "nid=1707357")
+	r.Body = ioutil.NopCloser(strings.NewReader("nid=1"))
+	_, err = v.Verify(r)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	r = signedRequest(t, "order", "secret", "")
+	r.URL.RawQuery = "a=1&b=3"
+	_, err = v.Verify(r)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// 时间戳超出允许范围
+	r = signedRequest(t, "order", "secret", "")
+	v.now = func() time.Time { return time.Now().Add(10 * time.Minute) }
+	_, err = v.Verify(r)
+	assert.Equal(t, ErrExpired, err)
+	v.now = time.Now
+	r.Header.Set(HeaderTimestamp, "abc")
+	_, err = v.Verify(r)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// max_skew为0时nonce仍有过期时间
+	store := &ttlStore{}
+	v = NewHMACVerifier(&HMACOption{Keys: opt.Keys}, store)
+	r = signedRequest(t, "order", "secret", "")
+	ts, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	v.now = func() time.Time { return time.Unix(ts, 0) }
+	_, err = v.Verify(r)
+	assert.Nil(t, err)
+	assert.Equal(t, minNonceTTL, store.ttl)
+
+	// nonce保存失败
+	v = NewHMACVerifier(opt, &errorStore{})
+	_, err = v.Verify(signedRequest(t, "order", "secret", ""))
+	assert.NotNil(t, err)
+}
+
+func TestStringToSign(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/a%20b?b=2&a=1", nil)
+	str := StringToSign(r, "order", "1600000000", "n", nil)
+	assert.Equal(t, "GET\n/api/a%20b\na=1&b=2\norder\n1600000000\nn\n"+
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", str)
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	assert.NotEqual(t, str, StringToSign(r, "order", ts, "n", nil))
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	store := NewMemoryNonceStore()
+	added, err := store.Add("a", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, added)
+	added, _ = store.Add("a", time.Minute)
+	assert.False(t, added)
+
+	added, _ = store.Add("b", 10*time.Millisecond)
+	assert.True(t, added)
+	time.Sleep(20 * time.Millisecond)
+	added, _ = store.Add("b", time.Minute)
+	assert.True(t, added)
+}
@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// JWTOption JWT认证配置
+type JWTOption struct {
+	Secret     string   `json:"secret"`     // HS256/HS384/HS512的密钥
+	PublicKey  string   `json:"public_key"` // RS/PS/ES算法的公钥PEM文件
+	JWKSFile   string   `json:"jwks_file"`  // JWKS文件，按token的kid选择公钥，文件变化后自动重新加载
+	Algorithms []string `json:"algorithms"` // 允许的签名算法
+	Issuer     string   `json:"issuer"`     // 校验iss，为空时不校验
+	Audience   string   `json:"audience"`   // 校验aud，为空时不校验
+	Leeway     int64    `json:"leeway"`     // exp、nbf允许的时钟偏差，单位秒
+	Header     string   `json:"header"`     // 读取token的请求头，值为 Bearer token
+	Query      string   `json:"query"`      // 读取token的请求参数，为空时不读取
+	Cookie     string   `json:"cookie"`     // 读取token的cookie，为空时不读取
+}
+
+// JWTVerifier JWT校验
+type JWTVerifier struct {
+	option    *JWTOption
+	secret    []byte
+	publicKey interface{}
+
+	lock    sync.RWMutex
+	jwks    map[string]interface{} // kid => 公钥或密钥
+	jwksMod time.Time
+	now     func() time.Time // 测试时替换
+}
+
+// NewJWTVerifier 创建JWT校验
+func NewJWTVerifier(opt *JWTOption) (*JWTVerifier, error) {
+	if len(opt.Algorithms) == 0 {
+		return nil, fmt.Errorf("jwt algorithms is empty")
+	}
+	for _, alg := range opt.Algorithms {
+		if jwt.GetSigningMethod(alg) == nil {
+			return nil, fmt.Errorf("unknown jwt algorithm (%s)", alg)
+		}
+	}
+
+	v := &JWTVerifier{
+		option: opt,
+		secret: []byte(opt.Secret),
+		now:    time.Now,
+	}
+	if opt.PublicKey != "" {
+		buf, err := ioutil.ReadFile(opt.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if v.publicKey, err = parsePublicKey(buf); err != nil {
+			return nil, err
+		}
+	}
+	if opt.JWKSFile != "" {
+		if _, err := v.loadJWKS(); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// parsePublicKey 解析RSA或ECDSA公钥PEM
+func parsePublicKey(buf []byte) (interface{}, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(buf); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(buf); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("invalid jwt public key")
+}
+
+// jwk JWKS中的单个密钥
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func decodeBase64(str string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(str, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
+
+// key 转换为RSA、ECDSA公钥或对称密钥
+func (c *jwk) key() (interface{}, error) {
+	switch c.Kty {
+	case "RSA":
+		n, err := decodeBase64(c.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64(c.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[c.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unknown jwk curve (%s)", c.Crv)
+		}
+		x, err := decodeBase64(c.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64(c.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(c.K, "="))
+	}
+	return nil, fmt.Errorf("unknown jwk kty (%s)", c.Kty)
+}
+
+// loadJWKS 加载JWKS文件，文件没有变化时返回false
+func (c *JWTVerifier) loadJWKS() (bool, error) {
+	fi, err := os.Stat(c.option.JWKSFile)
+	if err != nil {
+		return false, err
+	}
+	c.lock.RLock()
+	changed := !fi.ModTime().Equal(c.jwksMod)
+	c.lock.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	buf, err := ioutil.ReadFile(c.option.JWKSFile)
+	if err != nil {
+		return false, err
+	}
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err = json.Unmarshal(buf, &set); err != nil {
+		return false, err
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, one := range set.Keys {
+		key, err := one.key()
+		if err != nil {
+			return false, fmt.Errorf("jwk %s: %s", one.Kid, err)
+		}
+		keys[one.Kid] = key
+	}
+
+	c.lock.Lock()
+	c.jwks = keys
+	c.jwksMod = fi.ModTime()
+	c.lock.Unlock()
+	return true, nil
+}
+
+// lookup 按kid查找JWKS中的密钥，找不到时检查文件是否更新(密钥轮换)
+func (c *JWTVerifier) lookup(kid string) (interface{}, bool) {
+	c.lock.RLock()
+	key, ok := c.jwks[kid]
+	c.lock.RUnlock()
+	if ok {
+		return key, true
+	}
+
+	if changed, err := c.loadJWKS(); err != nil || !changed {
+		return nil, false
+	}
+	c.lock.RLock()
+	key, ok = c.jwks[kid]
+	c.lock.RUnlock()
+	return key, ok
+}
+
+// keyFunc 按签名算法与kid选择校验密钥
+func (c *JWTVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	_, hmac := token.Method.(*jwt.SigningMethodHMAC)
+	if c.option.JWKSFile != "" {
+		if kid, _ := token.Header["kid"].(string); kid != "" {
+			key, ok := c.lookup(kid)
+			if !ok {
+				return nil, fmt.Errorf("unknown jwt kid (%s)", kid)
+			}
+			// 防止用公钥作为HMAC密钥伪造token
+			if _, isSecret := key.([]byte); isSecret != hmac {
+				return nil, fmt.Errorf("jwt kid (%s) does not match algorithm", kid)
+			}
+			return key, nil
+		}
+	}
+
+	if hmac {
+		if len(c.secret) == 0 {
+			return nil, fmt.Errorf("jwt secret is empty")
+		}
+		return c.secret, nil
+	}
+	if c.publicKey == nil {
+		return nil, fmt.Errorf("jwt public key is empty")
+	}
+	return c.publicKey, nil
+}
+
+// Verify 校验token签名与声明，返回调用方身份
+func (c *JWTVerifier) Verify(token string) (*Principal, error) {
+	parser := &jwt.Parser{ValidMethods: c.option.Algorithms, SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	if _, err := parser.ParseWithClaims(token, claims, c.keyFunc); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredentials, err)
+	}
+	if err := c.validate(claims); err != nil {
+		return nil, err
+	}
+
+	p := &Principal{Type: TypeJWT, Claims: claims}
+	p.Subject, _ = claims["sub"].(string)
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	} else if scopes, ok := claims["scopes"].([]interface{}); ok {
+		for _, one := range scopes {
+			if str, ok := one.(string); ok {
+				p.Scopes = append(p.Scopes, str)
+			}
+		}
+	}
+	return p, nil
+}
+
+// validate 校验exp、nbf、iss与aud，exp与nbf允许一定的时钟偏差
+func (c *JWTVerifier) validate(claims jwt.MapClaims) error {
+	now := c.now().Unix()
+	leeway := c.option.Leeway
+	if exp, ok := claims["exp"].(float64); ok && now > int64(exp)+leeway {
+		return ErrExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now+leeway < int64(nbf) {
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalidCredentials)
+	}
+	if c.option.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != c.option.Issuer {
+			return fmt.Errorf("%w: invalid issuer", ErrInvalidCredentials)
+		}
+	}
+	if c.option.Audience != "" && !hasAudience(claims["aud"], c.option.Audience) {
+		return fmt.Errorf("%w: invalid audience", ErrInvalidCredentials)
+	}
+	return nil
+}
+
+// hasAudience aud可以是字符串或字符串数组
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, one := range v {
+			if str, ok := one.(string); ok && str == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Token 从请求头、请求参数或cookie中读取token
+func (c *JWTVerifier) Token(r *http.Request) string {
+	if c.option.Header != "" {
+		value := r.Header.Get(c.option.Header)
+		if len(value) > 7 && strings.EqualFold(value[:7], "Bearer ") {
+			return strings.TrimSpace(value[7:])
+		}
+	}
+	if c.option.Query != "" {
+		if value := r.URL.Query().Get(c.option.Query); value != "" {
+			return value
+		}
+	}
+	if c.option.Cookie != "" {
+		if cookie, err := r.Cookie(c.option.Cookie); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// Authenticate 实现Authenticator
+func (c *JWTVerifier) Authenticate(r *http.Request) (*Principal, error) {
+	token := c.Token(r)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	return c.Verify(token)
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	str, err := token.SignedString(key)
+	assert.Nil(t, err)
+	return str
+}
+
+func b64(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func TestJWTSecret(t *testing.T) {
+	_, err := NewJWTVerifier(&JWTOption{})
+	assert.NotNil(t, err)
+	_, err = NewJWTVerifier(&JWTOption{Algorithms: []string{"XX256"}})
+	assert.NotNil(t, err)
+
+	v, err := NewJWTVerifier(&JWTOption{
+		Secret:     "secret",
+		Algorithms: []string{"HS256"},
+		Issuer:     "chaos",
+		Audience:   "api",
+		Leeway:     60,
+	})
+	assert.Nil(t, err)
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	claims := jwt.MapClaims{
+		"sub":   "1707357",
+		"iss":   "chaos",
+		"aud":   []string{"web", "api"},
+		"exp":   now.Add(-30 * time.Second).Unix(), // 在允许的时钟偏差内
+		"scope": "read write",
+		"name":  "zacyuan",
+	}
+	p, err := v.Verify(sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims))
+	assert.Nil(t, err)
+	assert.Equal(t, TypeJWT, p.Type)
+	assert.Equal(t, "1707357", p.Subject)
+	assert.Equal(t, []string{"read", "write"}, p.Scopes)
+	assert.True(t, p.HasScope("write"))
+	assert.False(t, p.HasScope("admin"))
+	assert.Equal(t, "zacyuan", p.Claims["name"])
+
+	// 过期、未生效、签发者与受众不符
+	cases := []struct {
+		key   string
+		value interface{}
+		err   error
+	}{
+		{"exp", now.Add(-2 * time.Minute).Unix(), ErrExpired},
+		{"nbf", now.Add(2 * time.Minute).Unix(), ErrInvalidCredentials},
+		{"iss", "other", ErrInvalidCredentials},
+		{"aud", "web", ErrInvalidCredentials},
+	}
+	for _, one := range cases {
+		bad := jwt.MapClaims{}
+		for k, v := range claims {
+			bad[k] = v
+		}
+		bad[one.key] = one.value
+		_, err = v.Verify(sign(t, jwt.SigningMethodHS256, []byte("secret"), "", bad))
+		assert.ErrorIs(t, err, one.err, one.key)
+	}
+
+	// 密钥错误与不允许的算法
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, []byte("other"), "", claims))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS512, []byte("secret"), "", claims))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = v.Verify("abc")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestJWTPublicKey(t *testing.T) {
+	dir := t.TempDir()
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	file := filepath.Join(dir, "public.pem")
+	assert.Nil(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+
+	_, err := NewJWTVerifier(&JWTOption{PublicKey: filepath.Join(dir, "none.pem"), Algorithms: []string{"RS256"}})
+	assert.NotNil(t, err)
+
+	v, err := NewJWTVerifier(&JWTOption{PublicKey: file, Algorithms: []string{"RS256", "HS256"}})
+	assert.Nil(t, err)
+	p, err := v.Verify(sign(t, jwt.SigningMethodRS256, key, "", jwt.MapClaims{"sub": "rs", "scopes": []string{"a", "b"}}))
+	assert.Nil(t, err)
+	assert.Equal(t, "rs", p.Subject)
+	assert.Equal(t, []string{"a", "b"}, p.Scopes)
+
+	// 没有配置密钥时不能使用HMAC
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, []byte(""), "", jwt.MapClaims{"sub": "hs"}))
+	assert.NotNil(t, err)
+}
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "jwks.json")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	write := func(keys ...map[string]string) {
+		buf, _ := json.Marshal(map[string]interface{}{"keys": keys})
+		assert.Nil(t, ioutil.WriteFile(file, buf, 0644))
+	}
+	rsaJWK := map[string]string{"kid": "rsa", "kty": "RSA", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))}
+	write(rsaJWK)
+
+	v, err := NewJWTVerifier(&JWTOption{JWKSFile: file, Algorithms: []string{"RS256", "ES256", "HS256"}})
+	assert.Nil(t, err)
+	p, err := v.Verify(sign(t, jwt.SigningMethodRS256, rsaKey, "rsa", jwt.MapClaims{"sub": "rsa"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "rsa", p.Subject)
+
+	// 密钥轮换，新增的kid在文件更新后自动加载
+	token := sign(t, jwt.SigningMethodES256, ecKey, "ec", jwt.MapClaims{"sub": "ec"})
+	_, err = v.Verify(token)
+	assert.NotNil(t, err)
+	write(rsaJWK, map[string]string{"kid": "ec", "kty": "EC", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+		map[string]string{"kid": "oct", "kty": "oct", "k": base64.RawURLEncoding.EncodeToString([]byte("secret"))})
+	mtime := time.Now().Add(time.Second)
+	assert.Nil(t, os.Chtimes(file, mtime, mtime))
+	p, err = v.Verify(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "ec", p.Subject)
+
+	p, err = v.Verify(sign(t, jwt.SigningMethodHS256, []byte("secret"), "oct", jwt.MapClaims{"sub": "oct"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "oct", p.Subject)
+
+	// 不能使用公钥作为HMAC密钥
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, der, "rsa", jwt.MapClaims{"sub": "forged"}))
+	assert.NotNil(t, err)
+
+	// 无效的JWKS
+	assert.Nil(t, ioutil.WriteFile(file, []byte(`{"keys": [{"kid": "x", "kty": "EC", "crv": "P-1"}]}`), 0644))
+	_, err = NewJWTVerifier(&JWTOption{JWKSFile: file, Algorithms: []string{"ES256"}})
+	assert.NotNil(t, err)
+}
+
+func TestJWTToken(t *testing.T) {
+	v, err := NewJWTVerifier(&JWTOption{Secret: "secret", Algorithms: []string{"HS256"}, Header: "Authorization", Query: "token", Cookie: "jwt"})
+	assert.Nil(t, err)
+	token := sign(t, jwt.SigningMethodHS256, []byte("secret"), "", jwt.MapClaims{"sub": "1"})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err = v.Authenticate(r)
+	assert.Equal(t, ErrNoCredentials, err)
+
+	r.Header.Set("Authorization", "Basic abc")
+	assert.Equal(t, "", v.Token(r))
+	r.Header.Set("Authorization", "bearer "+token)
+	assert.Equal(t, token, v.Token(r))
+	p, err := v.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", p.Subject)
+
+	r = httptest.NewRequest(http.MethodGet, "/?token=abc", nil)
+	assert.Equal(t, "abc", v.Token(r))
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "jwt", Value: "def"})
+	assert.Equal(t, "def", v.Token(r))
+}
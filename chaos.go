@@ -1,14 +1,12 @@
 package chaos
 
 import (
-	"fmt"
-	"net/http"
-	_ "net/http/pprof"
-
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
+	"github.com/yuanzhangcai/chaos/admin"
 	"github.com/yuanzhangcai/chaos/common"
+	"github.com/yuanzhangcai/chaos/crypto"
 	"github.com/yuanzhangcai/chaos/log"
 	"github.com/yuanzhangcai/chaos/models"
 	"github.com/yuanzhangcai/chaos/monitor"
@@ -39,6 +37,9 @@ func init() {
 	// 初始化监控
 	monitor.Init()
 
+	// 异步写日志丢弃的日志数上报监控
+	log.SetDropHandler(monitor.AddLogDropped)
+
 	// 初始化Redis
 	if config.GetString("redis", "server") != "" {
 		if err = tools.InitRedis(config.GetString("redis", "server"),
@@ -46,24 +47,23 @@ func init() {
 			config.GetString("redis", "prefix")); err != nil {
 			logrus.Fatal(err)
 		}
+		admin.RegisterHealthCheck("redis", func() error { return tools.GetRedis().Ping().Err() })
+	}
+
+	// 加载密钥环
+	if err := crypto.InitKeyring(); err != nil {
+		logrus.Fatal(err)
 	}
 
 	// 初始化DB
 	if err := models.Init(); err != nil {
 		logrus.Fatal(err)
 	}
+	admin.RegisterHealthCheck("db", models.Ping)
 }
 
-// Start 启动服务
+// Start 启动服务，pprof、监控指标与日志等级等运维接口由admin管理服务提供
 func Start(setRouter func(*gin.Engine)) {
-	pprof := config.GetString("pprof", "server")
-	fmt.Println("pprof =", pprof)
-	if pprof != "" {
-		go func() {
-			_ = http.ListenAndServe(pprof, nil) // 非正式环境，开启pprof服务
-		}()
-	}
-
 	services.Start(setRouter)
 }
 
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"net"
 	"net/http"
 	"os"
@@ -21,6 +20,7 @@ import (
 
 	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
+	"github.com/yuanzhangcai/chaos/crypto"
 	"github.com/yuanzhangcai/config"
 )
 
@@ -114,16 +114,17 @@ func Decimal(value float64, num int) float64 {
 	return value
 }
 
-// GetRandomString 生成随机字符串
+// GetRandomString 生成由字母与数字组成的随机字符串，使用crypto/rand，可以用作token
+// l小于等于0时返回空字符串，读取系统随机数失败时panic，不返回可预测的字符串
 func GetRandomString(l int) string {
-	str := "ABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890abcdefghijklmnopqrstuvwxyz"
-	bytes := []byte(str)
-	result := []byte{}
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	for i := 0; i < l; i++ {
-		result = append(result, bytes[r.Intn(len(bytes))])
+	if l <= 0 {
+		return ""
+	}
+	str, err := crypto.RandomString(l, crypto.Alphanumeric)
+	if err != nil {
+		panic("read crypto/rand failed: " + err.Error())
 	}
-	return string(result)
+	return str
 }
 
 // GetFileNameWithoutSuffix 获取不带后缀文件名
@@ -383,7 +384,7 @@ func ShowInfo() {
 	fmt.Println("     BuildUser : " + BuildUser)
 	fmt.Println("     GoVersion : " + GoVersion)
 	fmt.Println("     Address   : " + config.GetString("common", "address"))
-	fmt.Println("     PProf     : " + config.GetString("pprof", "server"))
+	fmt.Println("     Admin     : " + config.GetString("admin", "server"))
 	fmt.Println("     Metrics   : " + config.GetString("monitor", "server"))
 	fmt.Println("=======================================================================")
 }
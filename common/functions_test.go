@@ -108,6 +108,8 @@ func TestGetRandomString(t *testing.T) {
 		two := GetRandomString(5)
 		assert.NotEqual(t, one, two)
 	}
+	assert.Equal(t, "", GetRandomString(0))
+	assert.Equal(t, "", GetRandomString(-1))
 }
 
 func TestGetFileNameWithoutSuffix(t *testing.T) {
@@ -5,9 +5,11 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
+	"github.com/yuanzhangcai/chaos/auth"
 	"github.com/yuanzhangcai/chaos/common"
 	"github.com/yuanzhangcai/chaos/errors"
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/chaos/session"
 )
 
 // ControllerInterface Controller接口定义
@@ -22,6 +24,10 @@ type Controller struct {
 	Ctx    *gin.Context
 	Params *url.Values
 	Result map[string]interface{} // 返回给前端的数据
+	Log    log.Logger             // 请求日志对象，请求开启了debug时输出debug日志
+
+	Principal *auth.Principal  // 认证中间件保存的调用方身份，没有经过认证时为nil
+	Session   *session.Session // 会话中间件保存的会话，没有使用会话中间件时为nil
 }
 
 // Prepare 在主逻辑处理之前的前置操作
@@ -39,9 +45,12 @@ func (c *Controller) Prepare() bool {
 func (c *Controller) Init(ctx *gin.Context) {
 	c.Result = make(map[string]interface{})
 	c.Ctx = ctx
+	c.Log = log.FromContext(ctx)
+	c.Principal = auth.FromContext(ctx)
+	c.Session = session.FromContext(ctx)
 	err := c.Ctx.Request.ParseForm()
 	if err != nil {
-		logrus.Panic("parse from failed")
+		log.L().Panic("parse from failed")
 	}
 
 	// 所有输入参数去空格
@@ -0,0 +1,288 @@
+// 加解密工具，推荐使用AEAD认证加密，AesEncrypt/AesDecrypt仅用于兼容旧数据
+
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Version 当前密文格式版本
+const Version byte = 1
+
+// Algorithm 认证加密算法，写入密文头部
+type Algorithm byte
+
+const (
+	// AESGCM AES-GCM，密钥16、24或32字节
+	AESGCM Algorithm = 1
+	// ChaCha20Poly1305 ChaCha20-Poly1305，密钥32字节，没有AES硬件加速时更快
+	ChaCha20Poly1305 Algorithm = 2
+)
+
+// String 返回算法名称
+func (c Algorithm) String() string {
+	switch c {
+	case AESGCM:
+		return "aes-gcm"
+	case ChaCha20Poly1305:
+		return "chacha20-poly1305"
+	}
+	return "unknown"
+}
+
+// ParseAlgorithm 按名称解析算法，名称不区分大小写
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch strings.ToLower(name) {
+	case "aes-gcm", "aesgcm":
+		return AESGCM, nil
+	case "chacha20-poly1305", "chacha20poly1305":
+		return ChaCha20Poly1305, nil
+	}
+	return 0, ErrUnsupportedAlgorithm
+}
+
+var (
+	// ErrInvalidCiphertext 密文格式错误
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+	// ErrUnsupportedVersion 不支持的密文版本
+	ErrUnsupportedVersion = errors.New("unsupported ciphertext version")
+	// ErrUnsupportedAlgorithm 不支持的加密算法
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	// ErrKeyMismatch 密文使用的算法或密钥ID与当前密钥不一致
+	ErrKeyMismatch = errors.New("ciphertext key mismatch")
+	// ErrDecrypt 密钥错误，或者密文、附加数据被篡改
+	ErrDecrypt = errors.New("message authentication failed")
+)
+
+// Header 密文头部，格式为：版本(1字节)|算法(1字节)|密钥ID长度(1字节)|密钥ID，之后为随机nonce与密文
+type Header struct {
+	Version   byte
+	Algorithm Algorithm
+	KeyID     string
+}
+
+// ParseHeader 解析密文头部，用于按算法与密钥ID选择解密密钥
+func ParseHeader(data []byte) (*Header, error) {
+	header, _, err := parseHeader(data)
+	return header, err
+}
+
+// parseHeader 返回头部与头部长度
+func parseHeader(data []byte) (*Header, int, error) {
+	if len(data) < 3 {
+		return nil, 0, ErrInvalidCiphertext
+	}
+	if data[0] != Version {
+		return nil, 0, ErrUnsupportedVersion
+	}
+	n := 3 + int(data[2])
+	if len(data) < n {
+		return nil, 0, ErrInvalidCiphertext
+	}
+	header := &Header{Version: data[0], Algorithm: Algorithm(data[1]), KeyID: string(data[3:n])}
+	return header, n, nil
+}
+
+func (c *Header) bytes() []byte {
+	buf := make([]byte, 3, 3+len(c.KeyID))
+	buf[0], buf[1], buf[2] = c.Version, byte(c.Algorithm), byte(len(c.KeyID))
+	return append(buf, c.KeyID...)
+}
+
+// AEAD 认证加密，每次加密使用随机nonce，密文头部记录版本、算法与密钥ID，可以并发使用
+type AEAD struct {
+	header *Header
+	aead   cipher.AEAD
+}
+
+// NewAEAD 创建认证加密，keyID写入密文头部，用于更换密钥后找到对应的解密密钥，可以为空，最长255字节
+func NewAEAD(alg Algorithm, keyID string, key []byte) (*AEAD, error) {
+	if len(keyID) > 255 {
+		return nil, errors.New("key id is longer than 255 bytes")
+	}
+
+	var aead cipher.AEAD
+	switch alg {
+	case AESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	case ChaCha20Poly1305:
+		var err error
+		if aead, err = chacha20poly1305.New(key); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	return &AEAD{
+		header: &Header{Version: Version, Algorithm: alg, KeyID: keyID},
+		aead:   aead,
+	}, nil
+}
+
+// Algorithm 返回加密算法
+func (c *AEAD) Algorithm() Algorithm {
+	return c.header.Algorithm
+}
+
+// KeyID 返回密钥ID
+func (c *AEAD) KeyID() string {
+	return c.header.KeyID
+}
+
+// Overhead 密文比明文多出的长度
+func (c *AEAD) Overhead() int {
+	return 3 + len(c.header.KeyID) + c.aead.NonceSize() + c.aead.Overhead()
+}
+
+// Encrypt 加密，ad为附加数据，不加密但参与认证，解密时需要传入相同的值，可以为nil
+func (c *AEAD) Encrypt(plain, ad []byte) ([]byte, error) {
+	header := c.header.bytes()
+	nonceSize := c.aead.NonceSize()
+
+	out := make([]byte, len(header)+nonceSize, c.Overhead()+len(plain))
+	copy(out, header)
+	nonce := out[len(header):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(out, nonce, plain, additional(header, ad)), nil
+}
+
+// Decrypt 解密Encrypt的结果，密文头部的算法与密钥ID需要与当前密钥一致
+func (c *AEAD) Decrypt(data, ad []byte) ([]byte, error) {
+	header, n, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if header.Algorithm != c.header.Algorithm || header.KeyID != c.header.KeyID {
+		return nil, ErrKeyMismatch
+	}
+
+	nonceSize := c.aead.NonceSize()
+	if len(data) < n+nonceSize+c.aead.Overhead() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce, sealed := data[n:n+nonceSize], data[n+nonceSize:]
+	plain, err := c.aead.Open(nil, nonce, sealed, additional(data[:n], ad))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// EncryptToBase64 加密并返回URL安全的base64
+func (c *AEAD) EncryptToBase64(plain, ad []byte) (string, error) {
+	data, err := c.Encrypt(plain, ad)
+	if err != nil {
+		return "", err
+	}
+	return EncodeBase64(data), nil
+}
+
+// DecryptBase64 解密EncryptToBase64的结果
+func (c *AEAD) DecryptBase64(str string, ad []byte) ([]byte, error) {
+	data, err := DecodeBase64(str)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return c.Decrypt(data, ad)
+}
+
+// EncryptToHex 加密并返回十六进制字符串
+func (c *AEAD) EncryptToHex(plain, ad []byte) (string, error) {
+	data, err := c.Encrypt(plain, ad)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// DecryptHex 解密EncryptToHex的结果
+func (c *AEAD) DecryptHex(str string, ad []byte) ([]byte, error) {
+	data, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return c.Decrypt(data, ad)
+}
+
+// additional 头部参与认证，防止修改头部中的算法或密钥ID
+func additional(header, ad []byte) []byte {
+	if len(ad) == 0 {
+		return header
+	}
+	buf := make([]byte, 0, len(header)+len(ad))
+	return append(append(buf, header...), ad...)
+}
+
+// Encrypt 使用AES-GCM加密，key为16、24或32字节
+func Encrypt(key, plain, ad []byte) ([]byte, error) {
+	aead, err := NewAEAD(AESGCM, "", key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Encrypt(plain, ad)
+}
+
+// Decrypt 按密文头部中的算法解密，不校验密钥ID
+func Decrypt(key, data, ad []byte) ([]byte, error) {
+	header, err := ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := NewAEAD(header.Algorithm, header.KeyID, key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Decrypt(data, ad)
+}
+
+// DecryptCompat 兼容解密，先按新格式解密，失败时再按AesDecrypt的旧格式解密，用于迁移旧数据。
+// 旧格式没有完整性校验，ad不参与旧格式的解密，迁移完成后应改用Decrypt
+func DecryptCompat(key, data, ad []byte) ([]byte, error) {
+	plain, err := Decrypt(key, data, ad)
+	if err == nil {
+		return plain, nil
+	}
+	if legacy, legacyErr := AesDecrypt(data, key); legacyErr == nil {
+		return legacy, nil
+	}
+	return nil, err
+}
+
+// EncodeBase64 URL安全、不带填充的base64编码
+func EncodeBase64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeBase64 base64解码，兼容标准与URL安全字符集，带或不带填充
+func DecodeBase64(str string) ([]byte, error) {
+	str = strings.TrimRight(str, "=")
+	str = strings.NewReplacer("+", "-", "/", "_").Replace(str)
+	return base64.RawURLEncoding.DecodeString(str)
+}
+
+// EncodeHex 十六进制编码
+func EncodeHex(data []byte) string {
+	return hex.EncodeToString(data)
+}
+
+// DecodeHex 十六进制解码
+func DecodeHex(str string) ([]byte, error) {
+	return hex.DecodeString(str)
+}
@@ -0,0 +1,185 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAEAD(t *testing.T) {
+	key := []byte("12345678901234567890123456789012")
+	plain := []byte("hello world.")
+	ad := []byte("user:1707357")
+
+	for _, alg := range []Algorithm{AESGCM, ChaCha20Poly1305} {
+		aead, err := NewAEAD(alg, "k1", key)
+		assert.Nil(t, err)
+		assert.Equal(t, alg, aead.Algorithm())
+		assert.Equal(t, "k1", aead.KeyID())
+
+		data, err := aead.Encrypt(plain, ad)
+		assert.Nil(t, err)
+		assert.Equal(t, len(plain)+aead.Overhead(), len(data))
+		buf, err := aead.Decrypt(data, ad)
+		assert.Nil(t, err)
+		assert.Equal(t, plain, buf)
+
+		// 随机nonce，相同明文每次加密结果不同
+		other, _ := aead.Encrypt(plain, ad)
+		assert.NotEqual(t, data, other)
+
+		header, err := ParseHeader(data)
+		assert.Nil(t, err)
+		assert.Equal(t, &Header{Version: Version, Algorithm: alg, KeyID: "k1"}, header)
+
+		// 附加数据不同
+		_, err = aead.Decrypt(data, []byte("user:1"))
+		assert.Equal(t, ErrDecrypt, err)
+		_, err = aead.Decrypt(data, nil)
+		assert.Equal(t, ErrDecrypt, err)
+
+		// 篡改密文
+		tampered := append([]byte{}, data...)
+		tampered[len(tampered)-1] ^= 1
+		_, err = aead.Decrypt(tampered, ad)
+		assert.Equal(t, ErrDecrypt, err)
+		_, err = aead.Decrypt(data[:20], ad)
+		assert.Equal(t, ErrInvalidCiphertext, err)
+
+		// 密钥ID或密钥不同
+		k2, _ := NewAEAD(alg, "k2", key)
+		_, err = k2.Decrypt(data, ad)
+		assert.Equal(t, ErrKeyMismatch, err)
+		wrong, _ := NewAEAD(alg, "k1", bytes.Repeat([]byte{1}, 32))
+		_, err = wrong.Decrypt(data, ad)
+		assert.Equal(t, ErrDecrypt, err)
+
+		// 编码
+		str, err := aead.EncryptToBase64(plain, nil)
+		assert.Nil(t, err)
+		buf, err = aead.DecryptBase64(str, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, plain, buf)
+		_, err = aead.DecryptBase64("!!!", nil)
+		assert.Equal(t, ErrInvalidCiphertext, err)
+
+		str, err = aead.EncryptToHex(plain, nil)
+		assert.Nil(t, err)
+		buf, err = aead.DecryptHex(str, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, plain, buf)
+		_, err = aead.DecryptHex("xyz", nil)
+		assert.Equal(t, ErrInvalidCiphertext, err)
+	}
+
+	// 头部中的算法不能被替换
+	aesGCM, _ := NewAEAD(AESGCM, "", key)
+	chacha, _ := NewAEAD(ChaCha20Poly1305, "", key)
+	data, _ := aesGCM.Encrypt(plain, nil)
+	_, err := chacha.Decrypt(data, nil)
+	assert.Equal(t, ErrKeyMismatch, err)
+	data[1] = byte(ChaCha20Poly1305)
+	_, err = chacha.Decrypt(data, nil)
+	assert.Equal(t, ErrDecrypt, err)
+}
+
+func TestNewAEAD(t *testing.T) {
+	_, err := NewAEAD(AESGCM, "", []byte("123"))
+	assert.NotNil(t, err)
+	_, err = NewAEAD(ChaCha20Poly1305, "", []byte("1234567890123456"))
+	assert.NotNil(t, err)
+	_, err = NewAEAD(Algorithm(9), "", []byte("1234567890123456"))
+	assert.Equal(t, ErrUnsupportedAlgorithm, err)
+	_, err = NewAEAD(AESGCM, string(make([]byte, 256)), []byte("1234567890123456"))
+	assert.NotNil(t, err)
+
+	alg, err := ParseAlgorithm("AES-GCM")
+	assert.Nil(t, err)
+	assert.Equal(t, AESGCM, alg)
+	alg, _ = ParseAlgorithm("chacha20-poly1305")
+	assert.Equal(t, "chacha20-poly1305", alg.String())
+	_, err = ParseAlgorithm("des")
+	assert.Equal(t, ErrUnsupportedAlgorithm, err)
+	assert.Equal(t, "unknown", Algorithm(9).String())
+}
+
+func TestParseHeader(t *testing.T) {
+	_, err := ParseHeader(nil)
+	assert.Equal(t, ErrInvalidCiphertext, err)
+	_, err = ParseHeader([]byte{2, 1, 0})
+	assert.Equal(t, ErrUnsupportedVersion, err)
+	_, err = ParseHeader([]byte{Version, 1, 3, 'k'})
+	assert.Equal(t, ErrInvalidCiphertext, err)
+	header, err := ParseHeader([]byte{Version, 2, 1, 'k'})
+	assert.Nil(t, err)
+	assert.Equal(t, ChaCha20Poly1305, header.Algorithm)
+	assert.Equal(t, "k", header.KeyID)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := []byte("12345678901234567890123456789012")
+	data, err := Encrypt(key, []byte("hello"), []byte("ad"))
+	assert.Nil(t, err)
+	buf, err := Decrypt(key, data, []byte("ad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// 按头部选择算法
+	chacha, _ := NewAEAD(ChaCha20Poly1305, "v2", key)
+	data, _ = chacha.Encrypt([]byte("hello"), nil)
+	buf, err = Decrypt(key, data, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	_, err = Encrypt([]byte("123"), []byte("hello"), nil)
+	assert.NotNil(t, err)
+	_, err = Decrypt(key, []byte{9, 1, 0}, nil)
+	assert.Equal(t, ErrUnsupportedVersion, err)
+}
+
+func TestDecryptCompat(t *testing.T) {
+	key := []byte("1234567890123456")
+
+	data, _ := Encrypt(key, []byte("new"), []byte("ad"))
+	buf, err := DecryptCompat(key, data, []byte("ad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "new", string(buf))
+	_, err = DecryptCompat(key, data, []byte("other"))
+	assert.Equal(t, ErrDecrypt, err)
+
+	// AesEncrypt生成的旧数据
+	for _, value := range []string{"", "old", "\x01 starts like a version byte", "0123456789abcdef0123456789abcdef"} {
+		legacy, err := AesEncrypt([]byte(value), key)
+		assert.Nil(t, err)
+		buf, err = DecryptCompat(key, legacy, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, value, string(buf))
+	}
+
+	_, err = DecryptCompat(key, []byte("garbage"), nil)
+	assert.NotNil(t, err)
+	_, err = DecryptCompat(key, nil, nil)
+	assert.NotNil(t, err)
+}
+
+func TestBase64Hex(t *testing.T) {
+	data := []byte{0xfb, 0xff, 0xfe, 0x01}
+	str := EncodeBase64(data)
+	assert.Equal(t, "-__-AQ", str)
+	for _, one := range []string{str, "-__-AQ==", base64.StdEncoding.EncodeToString(data), base64.RawStdEncoding.EncodeToString(data)} {
+		buf, err := DecodeBase64(one)
+		assert.Nil(t, err)
+		assert.Equal(t, data, buf)
+	}
+	_, err := DecodeBase64("!!")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, "fbfffe01", EncodeHex(data))
+	buf, err := DecodeHex("fbfffe01")
+	assert.Nil(t, err)
+	assert.Equal(t, data, buf)
+	_, err = DecodeHex("xyz")
+	assert.NotNil(t, err)
+}
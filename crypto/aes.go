@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
 )
 
+// ErrInvalidPadding PKCS7填充错误，通常是密钥错误或密文被篡改
+var ErrInvalidPadding = errors.New("invalid pkcs7 padding")
+
 // PKCS7Padding PKCS7Padding
 func PKCS7Padding(ciphertext []byte, blockSize int) []byte {
 	padding := blockSize - len(ciphertext)%blockSize
@@ -13,14 +18,35 @@ func PKCS7Padding(ciphertext []byte, blockSize int) []byte {
 	return append(ciphertext, padtext...)
 }
 
-// PKCS7UnPadding PKCS7UnPadding
+// PKCS7UnPadding PKCS7UnPadding，填充不合法时返回nil
 func PKCS7UnPadding(origData []byte) []byte {
-	length := len(origData)
-	unpadding := int(origData[length-1])
-	return origData[:(length - unpadding)]
+	data, err := pkcs7Unpad(origData, 256)
+	if err != nil {
+		return nil
+	}
+	return data
 }
 
-// AesEncrypt Aes加密
+// pkcs7Unpad 校验并去除填充，所有填充字节都需要等于填充长度，比较时不提前返回
+func pkcs7Unpad(data []byte, blockSize int) ([]byte, error) {
+	length := len(data)
+	if length == 0 {
+		return nil, ErrInvalidPadding
+	}
+	padding := int(data[length-1])
+	if padding == 0 || padding > blockSize || padding > length {
+		return nil, ErrInvalidPadding
+	}
+	expect := bytes.Repeat([]byte{byte(padding)}, padding)
+	if subtle.ConstantTimeCompare(data[length-padding:], expect) != 1 {
+		return nil, ErrInvalidPadding
+	}
+	return data[:length-padding], nil
+}
+
+// AesEncrypt Aes加密，CBC模式并以密钥作为IV，相同明文的密文相同且没有完整性校验。
+//
+// Deprecated: 新数据使用Encrypt或AEAD，旧数据使用DecryptCompat解密
 func AesEncrypt(origData, key []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -35,7 +61,9 @@ func AesEncrypt(origData, key []byte) ([]byte, error) {
 	return crypted, nil
 }
 
-// AesDecrypt Aes 解密
+// AesDecrypt Aes 解密，密文长度或填充不合法时返回错误。
+//
+// Deprecated: 使用Decrypt，需要兼容旧数据时使用DecryptCompat
 func AesDecrypt(crypted, key []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -43,9 +71,11 @@ func AesDecrypt(crypted, key []byte) ([]byte, error) {
 	}
 
 	blockSize := block.BlockSize()
+	if len(crypted) == 0 || len(crypted)%blockSize != 0 {
+		return nil, errors.New("aes ciphertext is not a multiple of the block size")
+	}
 	blockMode := cipher.NewCBCDecrypter(block, key[:blockSize])
 	origData := make([]byte, len(crypted))
 	blockMode.CryptBlocks(origData, crypted)
-	origData = PKCS7UnPadding(origData)
-	return origData, nil
+	return pkcs7Unpad(origData, blockSize)
 }
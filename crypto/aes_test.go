@@ -20,3 +20,31 @@ func TestAes(t *testing.T) {
 
 	assert.Equal(t, value, string(buf))
 }
+
+func TestAesDecryptInvalid(t *testing.T) {
+	key := []byte("1234567890123456")
+	_, err := AesDecrypt(nil, key)
+	assert.NotNil(t, err)
+	_, err = AesDecrypt([]byte("123"), key)
+	assert.NotNil(t, err)
+
+	// 错误的密钥解密后填充不合法
+	buf, _ := AesEncrypt([]byte("hello world."), key)
+	_, err = AesDecrypt(buf, []byte("6543210987654321"))
+	assert.Equal(t, ErrInvalidPadding, err)
+}
+
+func TestPKCS7UnPadding(t *testing.T) {
+	assert.Equal(t, []byte("abc"), PKCS7UnPadding([]byte("abc\x01")))
+	assert.Equal(t, []byte("a"), PKCS7UnPadding([]byte("a\x03\x03\x03")))
+	assert.Nil(t, PKCS7UnPadding(nil))
+	assert.Nil(t, PKCS7UnPadding([]byte("abc\x00")))
+	assert.Nil(t, PKCS7UnPadding([]byte("a\x02\x03\x03")))
+	assert.Nil(t, PKCS7UnPadding([]byte("\x05")))
+
+	_, err := pkcs7Unpad([]byte("abc\x05"), 4)
+	assert.Equal(t, ErrInvalidPadding, err)
+	buf, err := pkcs7Unpad(PKCS7Padding([]byte("abcd"), 4), 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abcd"), buf)
+}
@@ -0,0 +1,56 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"hash"
+)
+
+// Hmac 计算HMAC，h为摘要算法，如sha256.New
+func Hmac(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	_, _ = mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// HmacSHA256 计算HMAC-SHA256
+func HmacSHA256(key, data []byte) []byte {
+	return Hmac(sha256.New, key, data)
+}
+
+// HmacSHA256Hex 计算HMAC-SHA256，返回十六进制字符串
+func HmacSHA256Hex(key, data []byte) string {
+	return hex.EncodeToString(HmacSHA256(key, data))
+}
+
+// HmacSHA512 计算HMAC-SHA512
+func HmacSHA512(key, data []byte) []byte {
+	return Hmac(sha512.New, key, data)
+}
+
+// VerifyHmac 校验HMAC，比较时间与mac内容无关，防止计时攻击
+func VerifyHmac(h func() hash.Hash, key, data, mac []byte) bool {
+	return hmac.Equal(mac, Hmac(h, key, data))
+}
+
+// VerifyHmacSHA256 校验HMAC-SHA256
+func VerifyHmacSHA256(key, data, mac []byte) bool {
+	return VerifyHmac(sha256.New, key, data, mac)
+}
+
+// VerifyHmacSHA256Hex 校验十六进制的HMAC-SHA256，不区分大小写
+func VerifyHmacSHA256Hex(key, data []byte, mac string) bool {
+	buf, err := hex.DecodeString(mac)
+	if err != nil {
+		return false
+	}
+	return VerifyHmacSHA256(key, data, buf)
+}
+
+// ConstantTimeEqual 常量时间比较两个字符串，用于比较token、签名等，长度不同时直接返回false
+func ConstantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,33 @@
+package crypto
+
+import (
+	"crypto/sha1"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHmac(t *testing.T) {
+	key, data := []byte("key"), []byte("The quick brown fox jumps over the lazy dog")
+
+	// RFC 4231风格的已知值
+	assert.Equal(t, "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", HmacSHA256Hex(key, data))
+	assert.Equal(t, "de7c9b85b8b78aa6bc8a7a36f70a90701c9db4d9", EncodeHex(Hmac(sha1.New, key, data)))
+	assert.Equal(t, 64, len(HmacSHA512(key, data)))
+
+	mac := HmacSHA256(key, data)
+	assert.True(t, VerifyHmacSHA256(key, data, mac))
+	assert.False(t, VerifyHmacSHA256([]byte("other"), data, mac))
+	assert.False(t, VerifyHmacSHA256(key, data, mac[:10]))
+	assert.True(t, VerifyHmac(sha1.New, key, data, Hmac(sha1.New, key, data)))
+
+	str := HmacSHA256Hex(key, data)
+	assert.True(t, VerifyHmacSHA256Hex(key, data, str))
+	assert.True(t, VerifyHmacSHA256Hex(key, data, strings.ToUpper(str)))
+	assert.False(t, VerifyHmacSHA256Hex(key, data, "xyz"))
+
+	assert.True(t, ConstantTimeEqual("token", "token"))
+	assert.False(t, ConstantTimeEqual("token", "Token"))
+	assert.False(t, ConstantTimeEqual("token", "token1"))
+}
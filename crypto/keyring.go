@@ -0,0 +1,457 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yuanzhangcai/config"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// EnvelopeVersion 信封加密格式版本，与Version区分
+	EnvelopeVersion byte = 0x81
+
+	dataKeySize = 32
+)
+
+var (
+	// ErrKeyNotFound 密钥环中没有密文使用的密钥
+	ErrKeyNotFound = errors.New("key not found in keyring")
+	// ErrNoActiveKey 密钥环中没有用于加密的密钥
+	ErrNoActiveKey = errors.New("keyring has no active key")
+
+	envelopeAD     = []byte("chaos:envelope")    // 加密数据密钥时的附加数据
+	blindIndexInfo = []byte("chaos:blind-index") // 派生盲索引密钥时的HKDF info
+)
+
+// KeyOption 单个密钥配置，key、file、env三选一，内容均为base64编码的密钥
+type KeyOption struct {
+	ID        string `json:"id"`        // 密钥ID，写入密文，如v1、2021-06
+	Algorithm string `json:"algorithm"` // 加密算法，为空时使用密钥环的算法
+	Key       string `json:"key"`       // base64编码的密钥，建议只在测试环境使用
+	File      string `json:"file"`      // 密钥文件
+	Env       string `json:"env"`       // 保存密钥的环境变量
+}
+
+// KeyringOption 密钥环配置
+type KeyringOption struct {
+	Active    string      `json:"active"`    // 加密使用的密钥ID，为空时使用最后加载的密钥
+	Algorithm string      `json:"algorithm"` // 默认加密算法：aes-gcm/chacha20-poly1305
+	Dir       string      `json:"dir"`       // 密钥目录，加载其中的*.key文件，文件名为密钥ID，按文件名顺序在keys之后加载
+	Keys      []KeyOption `json:"keys"`      // 密钥列表
+}
+
+// GetKeyringOptionFromConfig 读取所有密钥环配置，key为密钥环名称
+func GetKeyringOptionFromConfig() (map[string]*KeyringOption, error) {
+	opts := map[string]*KeyringOption{}
+	err := config.Scan([]string{"keyring"}, &opts)
+	if err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// Keyring 密钥环，使用当前密钥加密并在密文中记录密钥ID，解密时按密钥ID选择密钥，更换密钥后旧数据仍可解密
+type Keyring struct {
+	name    string
+	lock    sync.RWMutex
+	active  string
+	keys    map[string]*AEAD
+	macKeys map[string][]byte // 由密钥派生的HMAC密钥，用于盲索引
+	ids     []string          // 按加载顺序
+}
+
+// NewKeyring 创建空密钥环
+func NewKeyring(name string) *Keyring {
+	return &Keyring{name: name, keys: map[string]*AEAD{}, macKeys: map[string][]byte{}}
+}
+
+// NewKeyringFromOption 按配置创建密钥环
+func NewKeyringFromOption(name string, opt *KeyringOption) (*Keyring, error) {
+	defAlg := AESGCM
+	if opt.Algorithm != "" {
+		alg, err := ParseAlgorithm(opt.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		defAlg = alg
+	}
+
+	keys := append([]KeyOption{}, opt.Keys...)
+	if opt.Dir != "" {
+		files, err := filepath.Glob(filepath.Join(opt.Dir, "*.key"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			keys = append(keys, KeyOption{ID: strings.TrimSuffix(filepath.Base(file), ".key"), File: file})
+		}
+	}
+
+	k := NewKeyring(name)
+	for _, one := range keys {
+		alg := defAlg
+		if one.Algorithm != "" {
+			var err error
+			if alg, err = ParseAlgorithm(one.Algorithm); err != nil {
+				return nil, err
+			}
+		}
+		key, err := one.load()
+		if err != nil {
+			return nil, errors.New("keyring " + name + " key " + one.ID + ": " + err.Error())
+		}
+		if err = k.Add(one.ID, alg, key); err != nil {
+			return nil, errors.New("keyring " + name + " key " + one.ID + ": " + err.Error())
+		}
+	}
+	active := opt.Active
+	if active == "" && len(keys) > 0 {
+		active = keys[len(keys)-1].ID
+	}
+	if active != "" {
+		if err := k.SetActive(active); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// load 读取base64编码的密钥
+func (c *KeyOption) load() ([]byte, error) {
+	str := c.Key
+	switch {
+	case c.File != "":
+		buf, err := ioutil.ReadFile(c.File)
+		if err != nil {
+			return nil, err
+		}
+		str = string(buf)
+	case c.Env != "":
+		str = os.Getenv(c.Env)
+	}
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return nil, errors.New("key is empty")
+	}
+	return DecodeBase64(str)
+}
+
+// Name 返回密钥环名称
+func (c *Keyring) Name() string {
+	return c.name
+}
+
+// Add 添加密钥，第一个添加的密钥为当前密钥，之后使用SetActive更换
+func (c *Keyring) Add(id string, alg Algorithm, key []byte) error {
+	if id == "" {
+		return errors.New("key id is empty")
+	}
+	aead, err := NewAEAD(alg, id, key)
+	if err != nil {
+		return err
+	}
+	macKey := make([]byte, sha256.Size)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, key, nil, blindIndexInfo), macKey); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.keys[id]; ok {
+		return errors.New("duplicate key id " + id)
+	}
+	c.keys[id] = aead
+	c.macKeys[id] = macKey
+	c.ids = append(c.ids, id)
+	if c.active == "" {
+		c.active = id
+	}
+	return nil
+}
+
+// SetActive 设置加密使用的密钥
+func (c *Keyring) SetActive(id string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.keys[id]; !ok {
+		return ErrKeyNotFound
+	}
+	c.active = id
+	return nil
+}
+
+// Remove 删除不再使用的密钥，不能删除当前密钥，删除后使用该密钥加密的数据无法解密
+func (c *Keyring) Remove(id string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if id == c.active {
+		return errors.New("can not remove active key " + id)
+	}
+	if _, ok := c.keys[id]; !ok {
+		return ErrKeyNotFound
+	}
+	delete(c.keys, id)
+	delete(c.macKeys, id)
+	for i, one := range c.ids {
+		if one == id {
+			c.ids = append(c.ids[:i:i], c.ids[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Active 返回当前密钥ID
+func (c *Keyring) Active() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.active
+}
+
+// IDs 返回所有密钥ID，按添加顺序
+func (c *Keyring) IDs() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]string{}, c.ids...)
+}
+
+func (c *Keyring) activeKey() (*AEAD, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.active == "" {
+		return nil, ErrNoActiveKey
+	}
+	return c.keys[c.active], nil
+}
+
+func (c *Keyring) key(id string) (*AEAD, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	aead, ok := c.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return aead, nil
+}
+
+// Encrypt 使用当前密钥加密，密文格式与AEAD相同
+func (c *Keyring) Encrypt(plain, ad []byte) ([]byte, error) {
+	aead, err := c.activeKey()
+	if err != nil {
+		return nil, err
+	}
+	return aead.Encrypt(plain, ad)
+}
+
+// Decrypt 按密文中的密钥ID选择密钥解密
+func (c *Keyring) Decrypt(data, ad []byte) ([]byte, error) {
+	header, err := ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := c.key(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Decrypt(data, ad)
+}
+
+// BlindIndex 使用当前密钥派生的HMAC密钥计算HMAC-SHA256，相同输入的结果相同，用于加密字段的等值查询。
+// 更换当前密钥后结果会变化，需要重新计算已保存的盲索引，建议使用不更换密钥的单独密钥环
+func (c *Keyring) BlindIndex(data []byte) ([]byte, error) {
+	c.lock.RLock()
+	macKey, ok := c.macKeys[c.active]
+	c.lock.RUnlock()
+	if !ok {
+		return nil, ErrNoActiveKey
+	}
+	return HmacSHA256(macKey, data), nil
+}
+
+// EncryptToBase64 使用当前密钥加密并返回URL安全的base64
+func (c *Keyring) EncryptToBase64(plain, ad []byte) (string, error) {
+	data, err := c.Encrypt(plain, ad)
+	if err != nil {
+		return "", err
+	}
+	return EncodeBase64(data), nil
+}
+
+// DecryptBase64 解密EncryptToBase64的结果
+func (c *Keyring) DecryptBase64(str string, ad []byte) ([]byte, error) {
+	data, err := DecodeBase64(str)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return c.Decrypt(data, ad)
+}
+
+// KeyID 返回Encrypt或SealEnvelope的密文使用的密钥ID，信封加密时为加密数据密钥的主密钥ID
+func KeyID(data []byte) (string, error) {
+	if len(data) > 0 && data[0] == EnvelopeVersion {
+		wrapped, _, err := splitEnvelope(data)
+		if err != nil {
+			return "", err
+		}
+		data = wrapped
+	}
+	header, err := ParseHeader(data)
+	if err != nil {
+		return "", err
+	}
+	return header.KeyID, nil
+}
+
+// NeedsRotation 密文不是使用当前密钥加密时返回true，用于逐步将旧数据更换为新密钥
+func (c *Keyring) NeedsRotation(data []byte) bool {
+	id, err := KeyID(data)
+	return err != nil || id != c.Active()
+}
+
+// Rotate 使用当前密钥重新加密，信封加密的数据只重新加密数据密钥
+func (c *Keyring) Rotate(data, ad []byte) ([]byte, error) {
+	if len(data) > 0 && data[0] == EnvelopeVersion {
+		return c.RewrapEnvelope(data)
+	}
+	plain, err := c.Decrypt(data, ad)
+	if err != nil {
+		return nil, err
+	}
+	return c.Encrypt(plain, ad)
+}
+
+// SealEnvelope 信封加密，每条数据生成随机数据密钥加密数据，再用当前密钥加密数据密钥，
+// 格式为：EnvelopeVersion(1字节)|加密后数据密钥长度(2字节)|加密后数据密钥|加密后数据
+func (c *Keyring) SealEnvelope(plain, ad []byte) ([]byte, error) {
+	master, err := c.activeKey()
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err = rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := NewAEAD(master.Algorithm(), "", dataKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := master.Encrypt(append([]byte{byte(master.Algorithm())}, dataKey...), envelopeAD)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := aead.Encrypt(plain, ad)
+	if err != nil {
+		return nil, err
+	}
+	return joinEnvelope(wrapped, sealed), nil
+}
+
+// OpenEnvelope 解密SealEnvelope的结果
+func (c *Keyring) OpenEnvelope(data, ad []byte) ([]byte, error) {
+	wrapped, sealed, err := splitEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := c.Decrypt(wrapped, envelopeAD)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) != 1+dataKeySize {
+		return nil, ErrInvalidCiphertext
+	}
+	aead, err := NewAEAD(Algorithm(buf[0]), "", buf[1:])
+	if err != nil {
+		return nil, err
+	}
+	return aead.Decrypt(sealed, ad)
+}
+
+// RewrapEnvelope 使用当前密钥重新加密数据密钥，数据本身不需要重新加密
+func (c *Keyring) RewrapEnvelope(data []byte) ([]byte, error) {
+	wrapped, sealed, err := splitEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := c.Decrypt(wrapped, envelopeAD)
+	if err != nil {
+		return nil, err
+	}
+	master, err := c.activeKey()
+	if err != nil {
+		return nil, err
+	}
+	if wrapped, err = master.Encrypt(buf, envelopeAD); err != nil {
+		return nil, err
+	}
+	return joinEnvelope(wrapped, sealed), nil
+}
+
+func joinEnvelope(wrapped, sealed []byte) []byte {
+	out := make([]byte, 3, 3+len(wrapped)+len(sealed))
+	out[0] = EnvelopeVersion
+	binary.BigEndian.PutUint16(out[1:], uint16(len(wrapped)))
+	out = append(out, wrapped...)
+	return append(out, sealed...)
+}
+
+func splitEnvelope(data []byte) ([]byte, []byte, error) {
+	if len(data) < 3 {
+		return nil, nil, ErrInvalidCiphertext
+	}
+	if data[0] != EnvelopeVersion {
+		return nil, nil, ErrUnsupportedVersion
+	}
+	n := 3 + int(binary.BigEndian.Uint16(data[1:]))
+	if len(data) < n {
+		return nil, nil, ErrInvalidCiphertext
+	}
+	return data[3:n], data[n:], nil
+}
+
+var (
+	keyrings    = map[string]*Keyring{}
+	keyringLock sync.RWMutex
+)
+
+// InitKeyring 按配置加载所有密钥环
+func InitKeyring() error {
+	opts, err := GetKeyringOptionFromConfig()
+	if err != nil {
+		return err
+	}
+	for name, opt := range opts {
+		k, err := NewKeyringFromOption(name, opt)
+		if err != nil {
+			return err
+		}
+		SetKeyring(k)
+	}
+	return nil
+}
+
+// SetKeyring 注册密钥环，同名的密钥环会被替换
+func SetKeyring(k *Keyring) {
+	keyringLock.Lock()
+	defer keyringLock.Unlock()
+	keyrings[k.name] = k
+}
+
+// GetKeyring 获取密钥环，没有配置时返回nil
+func GetKeyring(name string) *Keyring {
+	keyringLock.RLock()
+	defer keyringLock.RUnlock()
+	return keyrings[name]
+}
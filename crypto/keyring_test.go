@@ -0,0 +1,214 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/config"
+)
+
+func TestKeyring(t *testing.T) {
+	k := NewKeyring("default")
+	_, err := k.Encrypt([]byte("hello"), nil)
+	assert.Equal(t, ErrNoActiveKey, err)
+	_, err = k.SealEnvelope([]byte("hello"), nil)
+	assert.Equal(t, ErrNoActiveKey, err)
+
+	assert.Nil(t, k.Add("v1", AESGCM, bytes.Repeat([]byte{1}, 32)))
+	assert.NotNil(t, k.Add("v1", AESGCM, bytes.Repeat([]byte{1}, 32)))
+	assert.NotNil(t, k.Add("", AESGCM, bytes.Repeat([]byte{1}, 32)))
+	assert.NotNil(t, k.Add("v0", AESGCM, []byte("123")))
+	assert.Equal(t, "v1", k.Active())
+
+	old, err := k.Encrypt([]byte("hello"), []byte("ad"))
+	assert.Nil(t, err)
+	id, _ := KeyID(old)
+	assert.Equal(t, "v1", id)
+	assert.False(t, k.NeedsRotation(old))
+
+	// 更换密钥，旧数据仍可解密
+	assert.Nil(t, k.Add("v2", ChaCha20Poly1305, bytes.Repeat([]byte{2}, 32)))
+	assert.Equal(t, "v1", k.Active())
+	assert.Equal(t, ErrKeyNotFound, k.SetActive("v3"))
+	assert.Nil(t, k.SetActive("v2"))
+	assert.Equal(t, []string{"v1", "v2"}, k.IDs())
+
+	data, err := k.Encrypt([]byte("world"), []byte("ad"))
+	assert.Nil(t, err)
+	id, _ = KeyID(data)
+	assert.Equal(t, "v2", id)
+	for value, one := range map[string][]byte{"hello": old, "world": data} {
+		buf, err := k.Decrypt(one, []byte("ad"))
+		assert.Nil(t, err)
+		assert.Equal(t, value, string(buf))
+	}
+	_, err = k.Decrypt(old, nil)
+	assert.Equal(t, ErrDecrypt, err)
+
+	// 逐步重新加密旧数据
+	assert.True(t, k.NeedsRotation(old))
+	assert.True(t, k.NeedsRotation([]byte("garbage")))
+	rotated, err := k.Rotate(old, []byte("ad"))
+	assert.Nil(t, err)
+	assert.False(t, k.NeedsRotation(rotated))
+	_, err = k.Rotate(old, nil)
+	assert.Equal(t, ErrDecrypt, err)
+
+	// 删除旧密钥后旧数据无法解密
+	assert.NotNil(t, k.Remove("v2"))
+	assert.Equal(t, ErrKeyNotFound, k.Remove("v3"))
+	assert.Nil(t, k.Remove("v1"))
+	assert.Equal(t, []string{"v2"}, k.IDs())
+	_, err = k.Decrypt(old, []byte("ad"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	buf, err := k.Decrypt(rotated, []byte("ad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	str, err := k.EncryptToBase64([]byte("hello"), nil)
+	assert.Nil(t, err)
+	buf, err = k.DecryptBase64(str, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf))
+	_, err = k.DecryptBase64("!!!", nil)
+	assert.Equal(t, ErrInvalidCiphertext, err)
+	_, err = k.Decrypt(nil, nil)
+	assert.Equal(t, ErrInvalidCiphertext, err)
+}
+
+func TestEnvelope(t *testing.T) {
+	k := NewKeyring("default")
+	assert.Nil(t, k.Add("v1", AESGCM, bytes.Repeat([]byte{1}, 32)))
+
+	plain := bytes.Repeat([]byte("hello world."), 100)
+	data, err := k.SealEnvelope(plain, []byte("ad"))
+	assert.Nil(t, err)
+	assert.Equal(t, EnvelopeVersion, data[0])
+	id, err := KeyID(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", id)
+
+	buf, err := k.OpenEnvelope(data, []byte("ad"))
+	assert.Nil(t, err)
+	assert.Equal(t, plain, buf)
+	_, err = k.OpenEnvelope(data, nil)
+	assert.Equal(t, ErrDecrypt, err)
+
+	// 每条数据使用不同的数据密钥
+	other, _ := k.SealEnvelope(plain, []byte("ad"))
+	assert.NotEqual(t, data[3:40], other[3:40])
+
+	// 更换主密钥后只重新加密数据密钥，数据部分不变
+	assert.Nil(t, k.Add("v2", ChaCha20Poly1305, bytes.Repeat([]byte{2}, 32)))
+	assert.Nil(t, k.SetActive("v2"))
+	assert.True(t, k.NeedsRotation(data))
+	rewrapped, err := k.Rotate(data, nil)
+	assert.Nil(t, err)
+	assert.False(t, k.NeedsRotation(rewrapped))
+	assert.True(t, bytes.HasSuffix(rewrapped, data[len(data)-len(plain)-16:]))
+	buf, err = k.OpenEnvelope(rewrapped, []byte("ad"))
+	assert.Nil(t, err)
+	assert.Equal(t, plain, buf)
+
+	// 新数据使用新主密钥的算法
+	data, _ = k.SealEnvelope(plain, nil)
+	assert.Nil(t, k.Remove("v1"))
+	buf, err = k.OpenEnvelope(data, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, buf)
+
+	// 格式错误
+	for _, one := range [][]byte{nil, {EnvelopeVersion, 0}, {EnvelopeVersion, 0, 10, 1}, {Version, 0, 0}} {
+		_, err = k.OpenEnvelope(one, nil)
+		assert.NotNil(t, err)
+		_, err = k.RewrapEnvelope(one)
+		assert.NotNil(t, err)
+	}
+	_, err = KeyID([]byte{EnvelopeVersion})
+	assert.Equal(t, ErrInvalidCiphertext, err)
+}
+
+func TestKeyringFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	v1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	v2 := base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte{2}, 16))
+	v3 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "v3.key"), []byte(v3+"\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "v4.key"), []byte(v3), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "readme.txt"), []byte("ignored"), 0600))
+	os.Setenv("CHAOS_TEST_KEY_V2", v2)
+	defer os.Unsetenv("CHAOS_TEST_KEY_V2")
+
+	_ = config.LoadMemory(`{"keyring": {
+		"default": {"active": "v2", "dir": "`+dir+`", "keys": [
+			{"id": "v1", "key": "`+v1+`", "algorithm": "chacha20-poly1305"},
+			{"id": "v2", "env": "CHAOS_TEST_KEY_V2"}
+		]},
+		"orders": {"keys": [{"id": "a", "key": "`+v1+`"}, {"id": "b", "key": "`+v3+`"}]}
+	}}`, "json")
+	opts, err := GetKeyringOptionFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(opts))
+	assert.Equal(t, 2, len(opts["default"].Keys))
+
+	assert.Nil(t, InitKeyring())
+	k := GetKeyring("default")
+	assert.NotNil(t, k)
+	assert.Equal(t, "default", k.Name())
+	assert.Equal(t, "v2", k.Active())
+	assert.Equal(t, []string{"v1", "v2", "v3", "v4"}, k.IDs())
+	assert.Equal(t, 2, len(opts["default"].Keys))
+	assert.Equal(t, "b", GetKeyring("orders").Active()) // 没有配置active时使用最后一个
+	assert.Nil(t, GetKeyring("none"))
+
+	data, _ := k.Encrypt([]byte("hello"), nil)
+	header, _ := ParseHeader(data)
+	assert.Equal(t, AESGCM, header.Algorithm)
+
+	// 配置错误
+	for _, opt := range []*KeyringOption{
+		{Algorithm: "des"},
+		{Keys: []KeyOption{{ID: "v1", Key: v1, Algorithm: "des"}}},
+		{Keys: []KeyOption{{ID: "v1"}}},
+		{Keys: []KeyOption{{ID: "v1", Key: "!!!"}}},
+		{Keys: []KeyOption{{ID: "v1", Key: "YWJj"}}},
+		{Keys: []KeyOption{{ID: "v1", File: filepath.Join(dir, "none.key")}}},
+		{Keys: []KeyOption{{ID: "v1", Key: v1}, {ID: "v1", Key: v1}}},
+		{Active: "v2", Keys: []KeyOption{{ID: "v1", Key: v1}}},
+		{Dir: "["},
+	} {
+		_, err = NewKeyringFromOption("bad", opt)
+		assert.NotNil(t, err)
+	}
+
+	_ = config.LoadMemory(`{"keyring": {"bad": {"keys": [{"id": "v1"}]}}}`, "json")
+	assert.NotNil(t, InitKeyring())
+}
+
+func TestBlindIndex(t *testing.T) {
+	k := NewKeyring("blind_index")
+	_, err := k.BlindIndex([]byte("13800138000"))
+	assert.Equal(t, ErrNoActiveKey, err)
+
+	assert.Nil(t, k.Add("v1", AESGCM, bytes.Repeat([]byte{1}, 32)))
+	one, err := k.BlindIndex([]byte("13800138000"))
+	assert.Nil(t, err)
+	assert.Equal(t, 32, len(one))
+	two, _ := k.BlindIndex([]byte("13800138000"))
+	assert.Equal(t, one, two)
+	other, _ := k.BlindIndex([]byte("13800138001"))
+	assert.NotEqual(t, one, other)
+
+	// 派生的密钥与加密密钥不同
+	assert.NotEqual(t, HmacSHA256(bytes.Repeat([]byte{1}, 32), []byte("13800138000")), one)
+
+	assert.Nil(t, k.Add("v2", AESGCM, bytes.Repeat([]byte{2}, 32)))
+	assert.Nil(t, k.SetActive("v2"))
+	two, _ = k.BlindIndex([]byte("13800138000"))
+	assert.NotEqual(t, one, two)
+}
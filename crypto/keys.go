@@ -0,0 +1,163 @@
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ErrInvalidKey 密钥格式错误，不是PEM或DER编码的PKCS#1、PKCS#8、SEC1、PKIX密钥或证书
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrUnsupportedKey 不支持的密钥类型，只支持RSA、ECDSA与Ed25519
+	ErrUnsupportedKey = errors.New("unsupported key type")
+)
+
+// der PEM编码时返回第一个块的内容，否则按DER处理
+func der(data []byte) []byte {
+	if block, _ := pem.Decode(data); block != nil {
+		return block.Bytes
+	}
+	return data
+}
+
+// ParsePrivateKey 解析私钥，支持PEM或DER编码的PKCS#1、PKCS#8与SEC1格式，不依赖PEM类型名称
+func ParsePrivateKey(data []byte) (stdcrypto.Signer, error) {
+	buf := der(data)
+	if key, err := x509.ParsePKCS1PrivateKey(buf); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(buf); err == nil {
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return key, nil
+		case *ecdsa.PrivateKey:
+			return key, nil
+		case ed25519.PrivateKey:
+			return key, nil
+		}
+		return nil, ErrUnsupportedKey
+	}
+	if key, err := x509.ParseECPrivateKey(buf); err == nil {
+		return key, nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// ParsePublicKey 解析公钥，支持PEM或DER编码的PKIX、PKCS#1公钥与X.509证书
+func ParsePublicKey(data []byte) (stdcrypto.PublicKey, error) {
+	buf := der(data)
+	if key, err := x509.ParsePKIXPublicKey(buf); err == nil {
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+			return key, nil
+		}
+		return nil, ErrUnsupportedKey
+	}
+	if key, err := x509.ParsePKCS1PublicKey(buf); err == nil {
+		return key, nil
+	}
+	if cert, err := x509.ParseCertificate(buf); err == nil {
+		return cert.PublicKey, nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// LoadPrivateKey 从文件读取私钥
+func LoadPrivateKey(path string) (stdcrypto.Signer, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(buf)
+}
+
+// LoadPublicKey 从文件读取公钥
+func LoadPublicKey(path string) (stdcrypto.PublicKey, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKey(buf)
+}
+
+// rsaPublicKey 解析RSA公钥
+func rsaPublicKey(data []byte) (*rsa.PublicKey, error) {
+	key, err := ParsePublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	return pub, nil
+}
+
+// rsaPrivateKey 解析RSA私钥
+func rsaPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	return priv, nil
+}
+
+// MarshalPrivateKey 私钥编码为PEM，RSA使用PKCS#1（RSA PRIVATE KEY），其它使用PKCS#8（PRIVATE KEY）
+func MarshalPrivateKey(key stdcrypto.Signer) ([]byte, error) {
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), nil
+	}
+	buf, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: buf}), nil
+}
+
+// MarshalPublicKey 公钥编码为PKIX格式的PEM（PUBLIC KEY）
+func MarshalPublicKey(key stdcrypto.PublicKey) ([]byte, error) {
+	buf, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: buf}), nil
+}
+
+// WriteKeyFiles 将私钥与公钥保存到dir下的private.pem与public.pem，私钥文件权限为0600
+func WriteKeyFiles(key stdcrypto.Signer, dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	private, err := MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	public, err := MarshalPublicKey(key.Public())
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, "private.pem"), private, 0600); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, "public.pem"), public, 0644)
+}
+
+// writeFile 写入文件，文件已存在时同样修改权限
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := ioutil.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	return os.Chmod(path, perm)
+}
@@ -0,0 +1,259 @@
+package crypto
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yuanzhangcai/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// PasswordArgon2id argon2id，推荐使用
+	PasswordArgon2id = "argon2id"
+	// PasswordBcrypt bcrypt，密码只有前72字节有效
+	PasswordBcrypt = "bcrypt"
+	// PasswordScrypt scrypt
+	PasswordScrypt = "scrypt"
+)
+
+// ErrInvalidHash 密码哈希格式错误
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// PasswordOption 密码哈希配置，验证时算法或参数与配置不同的哈希会重新生成
+type PasswordOption struct {
+	Algorithm     string `json:"algorithm"`      // 哈希算法：argon2id/bcrypt/scrypt
+	Argon2Time    uint32 `json:"argon2_time"`    // argon2id迭代次数
+	Argon2Memory  uint32 `json:"argon2_memory"`  // argon2id内存，单位KiB
+	Argon2Threads uint8  `json:"argon2_threads"` // argon2id并行度
+	BcryptCost    int    `json:"bcrypt_cost"`    // bcrypt cost，4-31
+	ScryptN       int    `json:"scrypt_n"`       // scrypt N，2的幂
+	ScryptR       int    `json:"scrypt_r"`       // scrypt r
+	ScryptP       int    `json:"scrypt_p"`       // scrypt p
+	SaltLength    int    `json:"salt_length"`    // argon2id、scrypt盐长度，单位字节
+	KeyLength     int    `json:"key_length"`     // argon2id、scrypt哈希长度，单位字节
+}
+
+// DefaultPasswordOption 返回默认密码哈希配置
+func DefaultPasswordOption() *PasswordOption {
+	return &PasswordOption{
+		Algorithm:     PasswordArgon2id,
+		Argon2Time:    1,
+		Argon2Memory:  64 * 1024,
+		Argon2Threads: 4,
+		BcryptCost:    12,
+		ScryptN:       32768,
+		ScryptR:       8,
+		ScryptP:       1,
+		SaltLength:    16,
+		KeyLength:     32,
+	}
+}
+
+// GetPasswordOptionFromConfig 读取密码哈希配置
+func GetPasswordOptionFromConfig() (*PasswordOption, error) {
+	opt := DefaultPasswordOption()
+	err := config.Scan([]string{"password"}, opt)
+	if err != nil {
+		return nil, err
+	}
+	return opt, nil
+}
+
+// PasswordHasher 密码哈希，生成自描述的哈希字符串：
+// argon2id为$argon2id$v=19$m=65536,t=1,p=4$salt$hash，scrypt为$scrypt$ln=15,r=8,p=1$salt$hash，bcrypt为$2a$12$...
+type PasswordHasher struct {
+	option *PasswordOption
+}
+
+// NewPasswordHasher 创建密码哈希，opt为nil时使用默认配置
+func NewPasswordHasher(opt *PasswordOption) (*PasswordHasher, error) {
+	if opt == nil {
+		opt = DefaultPasswordOption()
+	}
+	switch opt.Algorithm {
+	case PasswordArgon2id:
+		if opt.Argon2Time == 0 || opt.Argon2Memory == 0 || opt.Argon2Threads == 0 {
+			return nil, errors.New("argon2id time, memory and threads must be positive")
+		}
+	case PasswordBcrypt:
+		if opt.BcryptCost < bcrypt.MinCost || opt.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case PasswordScrypt:
+		if opt.ScryptN <= 1 || opt.ScryptN&(opt.ScryptN-1) != 0 || opt.ScryptR <= 0 || opt.ScryptP <= 0 {
+			return nil, errors.New("scrypt n must be a power of 2, r and p must be positive")
+		}
+	default:
+		return nil, errors.New("unsupported password algorithm (" + opt.Algorithm + ")")
+	}
+	if opt.Algorithm != PasswordBcrypt && (opt.SaltLength < 8 || opt.KeyLength < 16) {
+		return nil, errors.New("password salt length must be at least 8 and key length at least 16")
+	}
+	return &PasswordHasher{option: opt}, nil
+}
+
+// Hash 生成密码哈希，每次使用随机盐
+func (c *PasswordHasher) Hash(password string) (string, error) {
+	if c.option.Algorithm == PasswordBcrypt {
+		buf, err := bcrypt.GenerateFromPassword([]byte(password), c.option.BcryptCost)
+		return string(buf), err
+	}
+
+	salt, err := RandomBytes(c.option.SaltLength)
+	if err != nil {
+		return "", err
+	}
+	h := &passwordHash{algorithm: c.option.Algorithm, salt: salt}
+	if h.algorithm == PasswordArgon2id {
+		h.time, h.memory, h.threads = c.option.Argon2Time, c.option.Argon2Memory, c.option.Argon2Threads
+	} else {
+		h.n, h.r, h.p = c.option.ScryptN, c.option.ScryptR, c.option.ScryptP
+	}
+	if h.key, err = h.derive(password, c.option.KeyLength); err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
+
+// Verify 校验密码，哈希格式错误时返回ErrInvalidHash
+func (c *PasswordHasher) Verify(password, encoded string) (bool, error) {
+	if isBcrypt(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		if err != nil {
+			return false, ErrInvalidHash
+		}
+		return true, nil
+	}
+
+	h, err := parsePasswordHash(encoded)
+	if err != nil {
+		return false, err
+	}
+	key, err := h.derive(password, len(h.key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, h.key) == 1, nil
+}
+
+// VerifyAndUpgrade 校验密码，密码正确且哈希的算法或参数与当前配置不同时返回新的哈希，调用方保存后完成升级
+func (c *PasswordHasher) VerifyAndUpgrade(password, encoded string) (bool, string, error) {
+	ok, err := c.Verify(password, encoded)
+	if err != nil || !ok || !c.NeedsRehash(encoded) {
+		return ok, "", err
+	}
+	upgraded, err := c.Hash(password)
+	if err != nil {
+		return true, "", err
+	}
+	return true, upgraded, nil
+}
+
+// NeedsRehash 哈希的算法或参数与当前配置不同时返回true
+func (c *PasswordHasher) NeedsRehash(encoded string) bool {
+	opt := c.option
+	if isBcrypt(encoded) {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || opt.Algorithm != PasswordBcrypt || cost != opt.BcryptCost
+	}
+
+	h, err := parsePasswordHash(encoded)
+	if err != nil || h.algorithm != opt.Algorithm || len(h.salt) != opt.SaltLength || len(h.key) != opt.KeyLength {
+		return true
+	}
+	if h.algorithm == PasswordArgon2id {
+		return h.time != opt.Argon2Time || h.memory != opt.Argon2Memory || h.threads != opt.Argon2Threads
+	}
+	return h.n != opt.ScryptN || h.r != opt.ScryptR || h.p != opt.ScryptP
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// passwordHash argon2id或scrypt哈希的PHC格式
+type passwordHash struct {
+	algorithm string
+	time      uint32 // argon2id
+	memory    uint32
+	threads   uint8
+	n, r, p   int // scrypt
+	salt      []byte
+	key       []byte
+}
+
+func (c *passwordHash) derive(password string, keyLen int) ([]byte, error) {
+	if c.algorithm == PasswordArgon2id {
+		return argon2.IDKey([]byte(password), c.salt, c.time, c.memory, c.threads, uint32(keyLen)), nil
+	}
+	return scrypt.Key([]byte(password), c.salt, c.n, c.r, c.p, keyLen)
+}
+
+// String 编码为PHC格式，盐与哈希使用不带填充的标准base64
+func (c *passwordHash) String() string {
+	var params string
+	if c.algorithm == PasswordArgon2id {
+		params = fmt.Sprintf("v=%d$m=%d,t=%d,p=%d", argon2.Version, c.memory, c.time, c.threads)
+	} else {
+		params = fmt.Sprintf("ln=%d,r=%d,p=%d", log2(c.n), c.r, c.p)
+	}
+	return "$" + c.algorithm + "$" + params + "$" +
+		base64.RawStdEncoding.EncodeToString(c.salt) + "$" + base64.RawStdEncoding.EncodeToString(c.key)
+}
+
+func parsePasswordHash(encoded string) (*passwordHash, error) {
+	parts := strings.Split(encoded, "$")
+	h := &passwordHash{}
+	var err error
+	switch {
+	case len(parts) == 6 && parts[1] == PasswordArgon2id:
+		h.algorithm = PasswordArgon2id
+		var version int
+		if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return nil, ErrInvalidHash
+		}
+		if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+			return nil, ErrInvalidHash
+		}
+		if h.memory == 0 || h.time == 0 || h.threads == 0 {
+			return nil, ErrInvalidHash
+		}
+		parts = parts[4:]
+	case len(parts) == 5 && parts[1] == PasswordScrypt:
+		h.algorithm = PasswordScrypt
+		var ln uint
+		if _, err = fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &ln, &h.r, &h.p); err != nil || ln < 1 || ln > 31 {
+			return nil, ErrInvalidHash
+		}
+		h.n = 1 << ln
+		parts = parts[3:]
+	default:
+		return nil, ErrInvalidHash
+	}
+
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[0]); err != nil || len(h.salt) == 0 {
+		return nil, ErrInvalidHash
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[1]); err != nil || len(h.key) == 0 {
+		return nil, ErrInvalidHash
+	}
+	return h, nil
+}
+
+func log2(n int) int {
+	ln := 0
+	for n > 1 {
+		n >>= 1
+		ln++
+	}
+	return ln
+}
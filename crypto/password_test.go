@@ -0,0 +1,151 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/config"
+)
+
+// fastPasswordOption 测试使用较小的参数
+func fastPasswordOption(alg string) *PasswordOption {
+	opt := DefaultPasswordOption()
+	opt.Algorithm = alg
+	opt.Argon2Memory = 1024
+	opt.BcryptCost = 4
+	opt.ScryptN = 1024
+	return opt
+}
+
+func TestPasswordHasher(t *testing.T) {
+	for _, alg := range []string{PasswordArgon2id, PasswordBcrypt, PasswordScrypt} {
+		h, err := NewPasswordHasher(fastPasswordOption(alg))
+		assert.Nil(t, err)
+
+		encoded, err := h.Hash("secret")
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(encoded, map[string]string{
+			PasswordArgon2id: "$argon2id$v=19$m=1024,t=1,p=4$",
+			PasswordBcrypt:   "$2a$04$",
+			PasswordScrypt:   "$scrypt$ln=10,r=8,p=1$",
+		}[alg]))
+
+		// 相同密码每次使用不同的盐
+		other, _ := h.Hash("secret")
+		assert.NotEqual(t, encoded, other)
+
+		ok, err := h.Verify("secret", encoded)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ok, err = h.Verify("Secret", encoded)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		assert.False(t, h.NeedsRehash(encoded))
+
+		ok, upgraded, err := h.VerifyAndUpgrade("secret", encoded)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "", upgraded)
+	}
+}
+
+func TestPasswordUpgrade(t *testing.T) {
+	bcryptHasher, _ := NewPasswordHasher(fastPasswordOption(PasswordBcrypt))
+	old, _ := bcryptHasher.Hash("secret")
+
+	// 算法变化
+	h, _ := NewPasswordHasher(fastPasswordOption(PasswordArgon2id))
+	assert.True(t, h.NeedsRehash(old))
+	ok, upgraded, err := h.VerifyAndUpgrade("wrong", old)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "", upgraded)
+	ok, upgraded, err = h.VerifyAndUpgrade("secret", old)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(upgraded, "$argon2id$"))
+	ok, _ = h.Verify("secret", upgraded)
+	assert.True(t, ok)
+
+	// 参数变化
+	opt := fastPasswordOption(PasswordArgon2id)
+	opt.Argon2Time = 2
+	stronger, _ := NewPasswordHasher(opt)
+	assert.True(t, stronger.NeedsRehash(upgraded))
+	ok, _ = stronger.Verify("secret", upgraded) // 按哈希中的参数校验
+	assert.True(t, ok)
+	_, upgraded, _ = stronger.VerifyAndUpgrade("secret", upgraded)
+	assert.True(t, strings.HasPrefix(upgraded, "$argon2id$v=19$m=1024,t=2,p=4$"))
+
+	opt = fastPasswordOption(PasswordBcrypt)
+	opt.BcryptCost = 5
+	stronger, _ = NewPasswordHasher(opt)
+	assert.True(t, stronger.NeedsRehash(old))
+
+	opt = fastPasswordOption(PasswordScrypt)
+	scryptHasher, _ := NewPasswordHasher(opt)
+	encoded, _ := scryptHasher.Hash("secret")
+	opt = fastPasswordOption(PasswordScrypt)
+	opt.ScryptN = 2048
+	stronger, _ = NewPasswordHasher(opt)
+	assert.True(t, stronger.NeedsRehash(encoded))
+	opt = fastPasswordOption(PasswordScrypt)
+	opt.KeyLength = 64
+	stronger, _ = NewPasswordHasher(opt)
+	assert.True(t, stronger.NeedsRehash(encoded))
+}
+
+func TestPasswordInvalid(t *testing.T) {
+	h, _ := NewPasswordHasher(fastPasswordOption(PasswordArgon2id))
+	for _, encoded := range []string{
+		"",
+		"secret",
+		"$2a$04$short",
+		"$md5$abc$def",
+		"$argon2id$v=18$m=1024,t=1,p=4$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=0,t=1,p=4$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=1024,t=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=1024,t=1,p=4$!!!$aGFzaGhhc2g",
+		"$argon2id$v=19$m=1024,t=1,p=4$c2FsdHNhbHQ$",
+		"$scrypt$ln=40,r=8,p=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$scrypt$n=1024$c2FsdHNhbHQ$aGFzaGhhc2g",
+	} {
+		ok, err := h.Verify("secret", encoded)
+		assert.False(t, ok)
+		assert.Equal(t, ErrInvalidHash, err, encoded)
+		assert.True(t, h.NeedsRehash(encoded))
+		_, upgraded, err := h.VerifyAndUpgrade("secret", encoded)
+		assert.NotNil(t, err)
+		assert.Equal(t, "", upgraded)
+	}
+
+	for _, opt := range []*PasswordOption{
+		{Algorithm: "md5"},
+		{Algorithm: PasswordArgon2id, SaltLength: 16, KeyLength: 32},
+		{Algorithm: PasswordBcrypt, BcryptCost: 3},
+		{Algorithm: PasswordScrypt, ScryptN: 1000, ScryptR: 8, ScryptP: 1, SaltLength: 16, KeyLength: 32},
+		{Algorithm: PasswordScrypt, ScryptN: 1024, ScryptR: 8, ScryptP: 1, SaltLength: 4, KeyLength: 32},
+	} {
+		_, err := NewPasswordHasher(opt)
+		assert.NotNil(t, err)
+	}
+
+	// 默认配置
+	h, err := NewPasswordHasher(nil)
+	assert.Nil(t, err)
+	encoded, err := h.Hash("secret")
+	assert.Nil(t, err)
+	ok, err := h.Verify("secret", encoded)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestGetPasswordOptionFromConfig(t *testing.T) {
+	_ = config.LoadMemory(`{"password": {"algorithm": "bcrypt", "bcrypt_cost": 10}}`, "json")
+	opt, err := GetPasswordOptionFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, PasswordBcrypt, opt.Algorithm)
+	assert.Equal(t, 10, opt.BcryptCost)
+	assert.Equal(t, uint32(64*1024), opt.Argon2Memory)
+}
@@ -3,62 +3,20 @@ package crypto
 import (
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
+	"crypto/sha256"
+	"errors"
 	"io/ioutil"
-	"os"
 )
 
-// GenerateRSAKey 生成RSA私钥和公钥，保存到文件中
+// GenerateRSAKey 生成RSA私钥和公钥，保存到dir下的private.pem（PKCS#1，权限0600）与public.pem（PKIX）
 func GenerateRSAKey(bits int, dir string) error {
-	if dir == "" {
-		dir = "."
-	}
-
 	//GenerateKey函数使用随机数据生成器random生成一对具有指定字位数的RSA密钥
 	//Reader是一个全局、共享的密码用强随机数生成器
 	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
 		return err
 	}
-	//保存私钥
-	//通过x509标准将得到的ras私钥序列化为ASN.1 的 DER编码字符串
-	X509PrivateKey := x509.MarshalPKCS1PrivateKey(privateKey)
-	//使用pem格式对x509输出的内容进行编码
-	//创建文件保存私钥
-	privateFile, err := os.Create(dir + "/private.pem")
-	if err != nil {
-		return err
-	}
-	defer privateFile.Close()
-	//构建一个pem.Block结构体对象
-	privateBlock := pem.Block{Type: "RSA Private Key", Bytes: X509PrivateKey}
-	//将数据保存到文件
-	err = pem.Encode(privateFile, &privateBlock)
-	if err != nil {
-		return err
-	}
-
-	//保存公钥
-	//获取公钥的数据
-	publicKey := privateKey.PublicKey
-	//X509对公钥编码
-	X509PublicKey, err := x509.MarshalPKIXPublicKey(&publicKey)
-	if err != nil {
-		return err
-	}
-	//pem格式编码
-	//创建用于保存公钥的文件
-	publicFile, err := os.Create(dir + "/public.pem")
-	if err != nil {
-		return err
-	}
-	defer publicFile.Close()
-	//创建一个pem.Block结构体对象
-	publicBlock := pem.Block{Type: "RSA Public Key", Bytes: X509PublicKey}
-	//保存到文件
-	err = pem.Encode(publicFile, &publicBlock)
-	return err
+	return WriteKeyFiles(privateKey, dir)
 }
 
 // RsaEncryptByFile RSA加密
@@ -71,25 +29,15 @@ func RsaEncryptByFile(plainText []byte, path string) ([]byte, error) {
 	return RsaEncrypt(plainText, buf)
 }
 
-// RsaEncrypt RSA加密
+// RsaEncrypt RSA加密，PKCS#1 v1.5填充，明文超过密钥长度时分段加密。新数据推荐使用RsaEncryptOAEP
 func RsaEncrypt(plainText, key []byte) ([]byte, error) {
-	//pem解码
-	block, _ := pem.Decode(key)
-	//x509解码
-
-	publicKeyInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
+	publicKey, err := rsaPublicKey(key)
 	if err != nil {
 		return nil, err
 	}
-	//类型断言
-	publicKey := publicKeyInterface.(*rsa.PublicKey)
-	//对明文进行加密
-	cipherText, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, plainText)
-	if err != nil {
-		return nil, err
-	}
-	//返回密文
-	return cipherText, nil
+	return chunked(plainText, publicKey.Size()-11, func(chunk []byte) ([]byte, error) {
+		return rsa.EncryptPKCS1v15(rand.Reader, publicKey, chunk)
+	})
 }
 
 // RsaDecryptByFile 指定密钥文件 解密
@@ -102,17 +50,82 @@ func RsaDecryptByFile(cipherText []byte, path string) ([]byte, error) {
 	return RsaDecrypt(cipherText, buf)
 }
 
-// RsaDecrypt RSA解密
+// RsaDecrypt RSA解密，与RsaEncrypt对应
 func RsaDecrypt(cipherText, key []byte) ([]byte, error) {
-	//pem解码
-	block, _ := pem.Decode(key)
-	//X509解码
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	privateKey, err := rsaPrivateKey(key)
 	if err != nil {
 		return nil, err
 	}
-	//对密文进行解密
-	plainText, _ := rsa.DecryptPKCS1v15(rand.Reader, privateKey, cipherText)
-	//返回明文
-	return plainText, nil
+	return blocks(cipherText, privateKey.Size(), func(block []byte) ([]byte, error) {
+		return rsa.DecryptPKCS1v15(rand.Reader, privateKey, block)
+	})
+}
+
+// RsaEncryptOAEP RSA-OAEP(SHA-256)加密，明文超过密钥长度时分段加密
+func RsaEncryptOAEP(plainText, key []byte) ([]byte, error) {
+	publicKey, err := rsaPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptOAEP(publicKey, plainText, nil)
+}
+
+// RsaDecryptOAEP RSA-OAEP(SHA-256)解密，与RsaEncryptOAEP对应
+func RsaDecryptOAEP(cipherText, key []byte) ([]byte, error) {
+	privateKey, err := rsaPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptOAEP(privateKey, cipherText, nil)
+}
+
+// EncryptOAEP RSA-OAEP(SHA-256)加密，label需要在解密时传入相同的值，可以为nil
+func EncryptOAEP(key *rsa.PublicKey, plainText, label []byte) ([]byte, error) {
+	size := key.Size() - 2*sha256.Size - 2
+	return chunked(plainText, size, func(chunk []byte) ([]byte, error) {
+		return rsa.EncryptOAEP(sha256.New(), rand.Reader, key, chunk, label)
+	})
+}
+
+// DecryptOAEP RSA-OAEP(SHA-256)解密
+func DecryptOAEP(key *rsa.PrivateKey, cipherText, label []byte) ([]byte, error) {
+	return blocks(cipherText, key.Size(), func(block []byte) ([]byte, error) {
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, key, block, label)
+	})
+}
+
+// chunked 将明文按size分段加密，每段密文长度等于密钥长度，空明文加密为一段
+func chunked(plainText []byte, size int, encrypt func([]byte) ([]byte, error)) ([]byte, error) {
+	if size <= 0 {
+		return nil, errors.New("rsa key is too small")
+	}
+	var out []byte
+	for start := 0; start == 0 || start < len(plainText); start += size {
+		end := start + size
+		if end > len(plainText) {
+			end = len(plainText)
+		}
+		buf, err := encrypt(plainText[start:end])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, buf...)
+	}
+	return out, nil
+}
+
+// blocks 将密文按密钥长度分段解密
+func blocks(cipherText []byte, size int, decrypt func([]byte) ([]byte, error)) ([]byte, error) {
+	if len(cipherText) == 0 || len(cipherText)%size != 0 {
+		return nil, errors.New("rsa ciphertext length is not a multiple of the key size")
+	}
+	var out []byte
+	for start := 0; start < len(cipherText); start += size {
+		buf, err := decrypt(cipherText[start : start+size])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, buf...)
+	}
+	return out, nil
 }
@@ -0,0 +1,96 @@
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+)
+
+// ErrVerification 签名校验失败
+var ErrVerification = errors.New("signature verification failed")
+
+// signHash 签名使用的摘要算法，ECDSA按曲线选择，Ed25519直接对原文签名
+func signHash(key stdcrypto.PublicKey) (stdcrypto.Hash, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return stdcrypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P384():
+			return stdcrypto.SHA384, nil
+		case elliptic.P521():
+			return stdcrypto.SHA512, nil
+		}
+		return stdcrypto.SHA256, nil
+	case ed25519.PublicKey:
+		return 0, nil
+	}
+	return 0, ErrUnsupportedKey
+}
+
+func digest(hash stdcrypto.Hash, data []byte) []byte {
+	if hash == 0 {
+		return data
+	}
+	h := hash.New()
+	_, _ = h.Write(data)
+	return h.Sum(nil)
+}
+
+// Sign 签名，RSA使用PSS(SHA-256)，ECDSA使用ASN.1编码（P-256为SHA-256，P-384为SHA-384，P-521为SHA-512），Ed25519对原文签名
+func Sign(key stdcrypto.Signer, data []byte) ([]byte, error) {
+	hash, err := signHash(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	var opts stdcrypto.SignerOpts = hash
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	}
+	return key.Sign(rand.Reader, digest(hash, data), opts)
+}
+
+// Verify 校验Sign生成的签名，失败时返回ErrVerification
+func Verify(key stdcrypto.PublicKey, data, sig []byte) error {
+	hash, err := signHash(key)
+	if err != nil {
+		return err
+	}
+
+	ok := false
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPSS(key, hash, digest(hash, data), sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: hash}) == nil
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, digest(hash, data), sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	}
+	if !ok {
+		return ErrVerification
+	}
+	return nil
+}
+
+// SignByFile 使用私钥文件签名
+func SignByFile(data []byte, path string) ([]byte, error) {
+	key, err := LoadPrivateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return Sign(key, data)
+}
+
+// VerifyByFile 使用公钥文件校验签名
+func VerifyByFile(data, sig []byte, path string) error {
+	key, err := LoadPublicKey(path)
+	if err != nil {
+		return err
+	}
+	return Verify(key, data, sig)
+}
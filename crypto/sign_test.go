@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p521, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	data := []byte("hello world.")
+	for _, key := range []stdcrypto.Signer{rsaKey, p256, p384, p521, edKey} {
+		sig, err := Sign(key, data)
+		assert.Nil(t, err)
+		assert.Nil(t, Verify(key.Public(), data, sig))
+		assert.Equal(t, ErrVerification, Verify(key.Public(), []byte("hello world!"), sig))
+
+		sig[0] ^= 1
+		assert.Equal(t, ErrVerification, Verify(key.Public(), data, sig))
+		assert.Equal(t, ErrVerification, Verify(key.Public(), data, nil))
+	}
+
+	// 公钥与私钥不匹配
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sig, _ := Sign(p256, data)
+	assert.Equal(t, ErrVerification, Verify(other.Public(), data, sig))
+	assert.Equal(t, ErrVerification, Verify(edKey.Public(), data, sig))
+
+	// RSA使用PSS，不能用PKCS#1 v1.5校验
+	sig, _ = Sign(rsaKey, data)
+	assert.NotNil(t, rsa.VerifyPKCS1v15(&rsaKey.PublicKey, stdcrypto.SHA256, digest(stdcrypto.SHA256, data), sig))
+
+	assert.Equal(t, ErrUnsupportedKey, Verify("key", data, sig))
+}
+
+func TestSignByFile(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, GenerateRSAKey(1024, dir))
+
+	data := []byte("hello world.")
+	sig, err := SignByFile(data, filepath.Join(dir, "private.pem"))
+	assert.Nil(t, err)
+	assert.Nil(t, VerifyByFile(data, sig, filepath.Join(dir, "public.pem")))
+	assert.Equal(t, ErrVerification, VerifyByFile([]byte("other"), sig, filepath.Join(dir, "public.pem")))
+
+	_, err = SignByFile(data, filepath.Join(dir, "none.pem"))
+	assert.NotNil(t, err)
+	assert.NotNil(t, VerifyByFile(data, sig, filepath.Join(dir, "none.pem")))
+}
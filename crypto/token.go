@@ -0,0 +1,148 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits 数字
+	Digits = "0123456789"
+	// Alphanumeric 大小写字母与数字
+	Alphanumeric = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+	crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ" // ULID使用的base32字符集
+)
+
+// RandomBytes 生成n字节的密码学安全随机数
+func RandomBytes(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errors.New("random length must not be negative")
+	}
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// RandomToken 生成n字节随机数并编码为URL安全的base64，适合作为会话、重置密码等token
+func RandomToken(n int) (string, error) {
+	buf, err := RandomBytes(n)
+	if err != nil {
+		return "", err
+	}
+	return EncodeBase64(buf), nil
+}
+
+// RandomString 从alphabet中随机选择n个字符，每个字符的概率相同，alphabet最多256个字节
+func RandomString(n int, alphabet string) (string, error) {
+	size := len(alphabet)
+	if size == 0 || size > 256 {
+		return "", errors.New("alphabet must be 1 to 256 bytes")
+	}
+	if n < 0 {
+		return "", errors.New("random length must not be negative")
+	}
+
+	limit := 256 - 256%size // 丢弃大于limit的随机数，避免取模后分布不均匀
+	out := make([]byte, 0, n)
+	buf := make([]byte, n+n/4+1)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit {
+				out = append(out, alphabet[int(b)%size])
+				if len(out) == n {
+					break
+				}
+			}
+		}
+	}
+	return string(out), nil
+}
+
+// NumericOTP 生成digits位数字验证码，可能以0开头
+func NumericOTP(digits int) (string, error) {
+	if digits <= 0 {
+		return "", errors.New("otp digits must be positive")
+	}
+	return RandomString(digits, Digits)
+}
+
+// UUIDv4 生成随机UUID
+func UUIDv4() (string, error) {
+	buf, err := RandomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	buf[6] = buf[6]&0x0f | 0x40
+	buf[8] = buf[8]&0x3f | 0x80
+	return formatUUID(buf), nil
+}
+
+// UUIDv7 生成以毫秒时间戳开头的UUID，按时间排序，同一毫秒内的顺序随机，适合作为数据库主键
+func UUIDv7() (string, error) {
+	buf, err := RandomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	putMillis(buf, time.Now())
+	buf[6] = buf[6]&0x0f | 0x70
+	buf[8] = buf[8]&0x3f | 0x80
+	return formatUUID(buf), nil
+}
+
+func formatUUID(buf []byte) string {
+	str := hex.EncodeToString(buf)
+	return str[:8] + "-" + str[8:12] + "-" + str[12:16] + "-" + str[16:20] + "-" + str[20:]
+}
+
+// putMillis 前6字节写入毫秒时间戳
+func putMillis(buf []byte, t time.Time) {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(t.UnixNano()/int64(time.Millisecond)))
+	copy(buf, ts[2:])
+}
+
+// ULID 生成26个字符的ULID，48位毫秒时间戳加80位随机数，按时间排序，同一毫秒内的顺序随机
+func ULID() (string, error) {
+	buf, err := RandomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	putMillis(buf, time.Now())
+
+	// 128位按5位一组编码，第一个字符只有3位
+	out := make([]byte, 26)
+	hi, lo := binary.BigEndian.Uint64(buf[:8]), binary.BigEndian.Uint64(buf[8:])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out), nil
+}
+
+// ULIDTime 返回ULID中的时间
+func ULIDTime(id string) (time.Time, error) {
+	if len(id) != 26 || id[0] > '7' {
+		return time.Time{}, errors.New("invalid ulid")
+	}
+	id = strings.ToUpper(id)
+	var ms uint64
+	for i := 0; i < 10; i++ {
+		idx := strings.IndexByte(crockford, id[i])
+		if idx < 0 {
+			return time.Time{}, errors.New("invalid ulid")
+		}
+		ms = ms<<5 | uint64(idx)
+	}
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)), nil
+}
@@ -0,0 +1,95 @@
+package crypto
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRandomToken(t *testing.T) {
+	buf, err := RandomBytes(32)
+	assert.Nil(t, err)
+	assert.Equal(t, 32, len(buf))
+	_, err = RandomBytes(-1)
+	assert.NotNil(t, err)
+
+	token, err := RandomToken(32)
+	assert.Nil(t, err)
+	assert.Equal(t, 43, len(token))
+	assert.Regexp(t, `^[A-Za-z0-9_-]+$`, token)
+	other, _ := RandomToken(32)
+	assert.NotEqual(t, token, other)
+}
+
+func TestRandomString(t *testing.T) {
+	str, err := RandomString(1000, "abc")
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(str))
+	for _, c := range "abc" {
+		assert.True(t, strings.Count(str, string(c)) > 200)
+	}
+	assert.Equal(t, 0, len(strings.Trim(str, "abc")))
+
+	str, _ = RandomString(0, Alphanumeric)
+	assert.Equal(t, "", str)
+	_, err = RandomString(10, "")
+	assert.NotNil(t, err)
+	_, err = RandomString(10, strings.Repeat("a", 257))
+	assert.NotNil(t, err)
+	_, err = RandomString(-1, Alphanumeric)
+	assert.NotNil(t, err)
+
+	otp, err := NumericOTP(6)
+	assert.Nil(t, err)
+	assert.Regexp(t, `^[0-9]{6}$`, otp)
+	_, err = NumericOTP(0)
+	assert.NotNil(t, err)
+}
+
+func TestUUID(t *testing.T) {
+	v4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	v7 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	id, err := UUIDv4()
+	assert.Nil(t, err)
+	assert.Regexp(t, v4, id)
+	other, _ := UUIDv4()
+	assert.NotEqual(t, id, other)
+
+	id, err = UUIDv7()
+	assert.Nil(t, err)
+	assert.Regexp(t, v7, id)
+	time.Sleep(2 * time.Millisecond)
+	other, _ = UUIDv7()
+	assert.True(t, other > id) // 按时间排序
+}
+
+func TestULID(t *testing.T) {
+	now := time.Now()
+	id, err := ULID()
+	assert.Nil(t, err)
+	assert.Regexp(t, `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`, id)
+
+	ts, err := ULIDTime(id)
+	assert.Nil(t, err)
+	assert.True(t, ts.Sub(now) < 10*time.Millisecond && ts.Sub(now) > -10*time.Millisecond)
+	ts, _ = ULIDTime(strings.ToLower(id))
+	assert.True(t, ts.Sub(now) < 10*time.Millisecond)
+
+	time.Sleep(2 * time.Millisecond)
+	other, _ := ULID()
+	assert.True(t, other > id)
+
+	// 已知值：时间戳1469918176385
+	ts, err = ULIDTime("01ARYZ6S41TSV4RRFFQ69G5FAV")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1469918176385), ts.UnixNano()/int64(time.Millisecond))
+
+	for _, one := range []string{"", "01ARYZ6S41", "81ARYZ6S41TSV4RRFFQ69G5FAV", "01ARYZ6SU1TSV4RRFFQ69G5FAV"} {
+		_, err = ULIDTime(one)
+		assert.NotNil(t, err)
+	}
+}
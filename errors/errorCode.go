@@ -102,4 +102,10 @@ var (
 
 	// ErrSystem 系统错误
 	ErrSystem = New(-9999, "系统错误")
+
+	// ErrUnauthorized 认证失败
+	ErrUnauthorized = New(-401, "认证失败")
+
+	// ErrCSRFToken CSRF token无效
+	ErrCSRFToken = New(-403, "CSRF token无效")
 )
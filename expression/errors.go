@@ -0,0 +1,224 @@
+package expression
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Knetic/govaluate"
+)
+
+// ErrorKind 表达式错误类型
+type ErrorKind string
+
+const (
+	// KindSyntax 语法错误
+	KindSyntax ErrorKind = "syntax"
+	// KindLimit 超过长度或嵌套层数限制
+	KindLimit ErrorKind = "limit"
+	// KindFunction 函数不存在、不允许使用或执行出错
+	KindFunction ErrorKind = "function"
+	// KindVariable 变量不存在
+	KindVariable ErrorKind = "variable"
+	// KindType 运算的类型错误
+	KindType ErrorKind = "type"
+	// KindTimeout 执行超时
+	KindTimeout ErrorKind = "timeout"
+)
+
+// Error 沙箱中编译或执行表达式的错误，Pos为出错位置的字符下标（从0开始，按字符而不是字节计算），无法确定位置时为-1
+type Error struct {
+	Kind ErrorKind `json:"kind"`
+	Pos  int       `json:"pos"`
+	Msg  string    `json:"msg"`
+	Err  error     `json:"-"` // 原始错误
+}
+
+func (c *Error) Error() string {
+	if c.Pos < 0 {
+		return string(c.Kind) + " error: " + c.Msg
+	}
+	return string(c.Kind) + " error at position " + strconv.Itoa(c.Pos) + ": " + c.Msg
+}
+
+// Unwrap 返回原始错误
+func (c *Error) Unwrap() error {
+	return c.Err
+}
+
+type tokenKind int
+
+const (
+	tokenNumber tokenKind = iota
+	tokenString
+	tokenIdent    // 变量、函数、true/false、in
+	tokenVariable // [name]形式的变量
+	tokenSymbol   // 运算符
+	tokenOpen
+	tokenClose
+	tokenComma
+)
+
+// token 表达式中的词，与govaluate的分词规则一致，用于定位错误
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // 开始位置，字符下标
+	end  int // 结束位置，字符下标
+}
+
+// scan 分词，只检查未闭合的字符串与[]变量，其余错误由govaluate检查
+func scan(runes []rune) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '0' && i+1 < len(runes) && runes[i+1] == 'x':
+			for i += 2; i < len(runes) && isHexDigit(runes[i]); i++ {
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i]), start, i})
+		case unicode.IsDigit(r) || r == '.':
+			for ; i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.'); i++ {
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i]), start, i})
+		case r == ',':
+			i++
+			tokens = append(tokens, token{tokenComma, ",", start, i})
+		case r == '(':
+			i++
+			tokens = append(tokens, token{tokenOpen, "(", start, i})
+		case r == ')':
+			i++
+			tokens = append(tokens, token{tokenClose, ")", start, i})
+		case r == '[':
+			text, end, ok := readUntil(runes, i+1, func(r rune) bool { return r == ']' })
+			if !ok {
+				return nil, &Error{Kind: KindSyntax, Pos: start, Msg: "unclosed parameter bracket"}
+			}
+			i = end + 1
+			tokens = append(tokens, token{tokenVariable, text, start, i})
+		case r == '\'' || r == '"':
+			// govaluate中单引号与双引号可以混用
+			text, end, ok := readUntil(runes, i+1, func(r rune) bool { return r == '\'' || r == '"' })
+			if !ok {
+				return nil, &Error{Kind: KindSyntax, Pos: start, Msg: "unclosed string literal"}
+			}
+			i = end + 1
+			tokens = append(tokens, token{tokenString, text, start, i})
+		case unicode.IsLetter(r):
+			for ; i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.'); i++ {
+			}
+			tokens = append(tokens, token{tokenIdent, string(runes[start:i]), start, i})
+		default:
+			for ; i < len(runes) && isSymbol(runes[i]) && !unicode.IsSpace(runes[i]); i++ {
+			}
+			tokens = append(tokens, token{tokenSymbol, string(runes[start:i]), start, i})
+		}
+	}
+	return tokens, nil
+}
+
+// readUntil 读取到结束字符，支持反斜杠转义，返回内容与结束字符的位置
+func readUntil(runes []rune, i int, stop func(rune) bool) (string, int, bool) {
+	var buf strings.Builder
+	for ; i < len(runes); i++ {
+		if runes[i] == '\\' && i+1 < len(runes) {
+			i++
+			buf.WriteRune(runes[i])
+			continue
+		}
+		if stop(runes[i]) {
+			return buf.String(), i, true
+		}
+		buf.WriteRune(runes[i])
+	}
+	return "", i, false
+}
+
+func isHexDigit(r rune) bool {
+	return unicode.IsDigit(r) || ('a' <= unicode.ToLower(r) && unicode.ToLower(r) <= 'f')
+}
+
+func isSymbol(r rune) bool {
+	return !(unicode.IsDigit(r) || unicode.IsLetter(r) || r == '(' || r == ')' || r == '[' || r == ']' || r == '\'' || r == '"')
+}
+
+const unexpectedEnd = "Unexpected end of expression"
+
+// syntaxError 定位govaluate的编译错误：依次编译到每个词为止的前缀（补齐右括号），第一个出错的词即为错误位置
+func syntaxError(runes []rune, tokens []token, fns map[string]Function, err error) *Error {
+	e := &Error{Kind: KindSyntax, Pos: -1, Msg: strings.TrimSpace(err.Error()), Err: err}
+	if err.Error() == unexpectedEnd {
+		e.Pos = len(runes)
+		return e
+	}
+
+	depth := 0
+	for _, tok := range tokens {
+		switch tok.kind {
+		case tokenOpen:
+			depth++
+		case tokenClose:
+			depth--
+		}
+		prefix := string(runes[:tok.end]) + strings.Repeat(")", depth)
+		_, perr := govaluate.NewEvaluableExpressionWithFunctions(prefix, fns)
+		if perr == nil || perr.Error() == unexpectedEnd {
+			continue
+		}
+		// 补齐的右括号导致的错误，如"a +)"
+		if tok.kind != tokenClose && strings.HasSuffix(perr.Error(), "to CLAUSE_CLOSE [41]") {
+			continue
+		}
+		e.Pos = tok.pos
+		break
+	}
+	return e
+}
+
+var (
+	operatorError = regexp.MustCompile(`cannot be used with the (?:logical operator|modifier|comparator|ternary operator|prefix) '([^']*)'`)
+	functionError = regexp.MustCompile(`^([\pL_][\pL\pN_]*)\(\)`)
+)
+
+// runtimeError 定位执行错误，同一个变量、函数或运算符出现多次时指向第一次出现的位置
+func runtimeError(tokens []token, err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	e = &Error{Kind: KindType, Pos: -1, Msg: strings.TrimSpace(err.Error()), Err: err}
+	var unknown *UnknownVariableError
+	if errors.As(err, &unknown) {
+		e.Kind = KindVariable
+		for _, tok := range tokens {
+			if (tok.kind == tokenIdent || tok.kind == tokenVariable) && tok.text == unknown.Name {
+				e.Pos = tok.pos
+				break
+			}
+		}
+	} else if m := functionError.FindStringSubmatch(e.Msg); m != nil {
+		e.Kind = KindFunction
+		for i, tok := range tokens {
+			if tok.kind == tokenIdent && tok.text == m[1] && i+1 < len(tokens) && tokens[i+1].kind == tokenOpen {
+				e.Pos = tok.pos
+				break
+			}
+		}
+	} else if m := operatorError.FindStringSubmatch(e.Msg); m != nil {
+		for _, tok := range tokens {
+			if (tok.kind == tokenSymbol || tok.kind == tokenIdent) && strings.EqualFold(tok.text, m[1]) {
+				e.Pos = tok.pos
+				break
+			}
+		}
+	}
+	return e
+}
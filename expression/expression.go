@@ -1,28 +1,132 @@
+// 表达式计算，基于govaluate，支持变量与可扩展的函数库
+
 package expression
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
+	"unicode"
 
 	"github.com/Knetic/govaluate"
 	"github.com/yuanzhangcai/chaos/common"
 )
 
-var functions = map[string]govaluate.ExpressionFunction{
-	"strlen": Strlen,
-	"intval": Intval,
+// Function 表达式函数，参数个数或类型错误时返回错误，不要panic
+type Function = govaluate.ExpressionFunction
+
+var (
+	functions    = map[string]Function{}
+	functionLock sync.RWMutex
+
+	// 表达式中的关键字，不能作为函数名
+	reserved = map[string]bool{"in": true, "IN": true, "true": true, "false": true}
+)
+
+func init() {
+	for name, one := range builtins {
+		functions[name] = checked(name, one)
+	}
+	for name, one := range clockBuiltins {
+		functions[name] = checked(name, one(time.Now))
+	}
+}
+
+// Register 注册表达式函数，函数名为字母开头的字母、数字或下划线，不能与已有函数重名
+func Register(name string, fn Function) error {
+	if !isFunctionName(name) || reserved[name] {
+		return errors.New("invalid function name '" + name + "'")
+	}
+	if fn == nil {
+		return errors.New("function " + name + " is nil")
+	}
+
+	functionLock.Lock()
+	defer functionLock.Unlock()
+	if _, ok := functions[name]; ok {
+		return errors.New("function " + name + " is already registered")
+	}
+	// 写时复制，已解析的表达式继续使用旧的函数表
+	next := make(map[string]Function, len(functions)+1)
+	for k, v := range functions {
+		next[k] = v
+	}
+	next[name] = recovered(name, fn)
+	functions = next
+	return nil
+}
+
+// FunctionNames 返回所有函数名，按字母排序
+func FunctionNames() []string {
+	functionLock.RLock()
+	defer functionLock.RUnlock()
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func currentFunctions() map[string]Function {
+	functionLock.RLock()
+	defer functionLock.RUnlock()
+	return functions
+}
+
+func isFunctionName(name string) bool {
+	for i, r := range name {
+		if !(unicode.IsLetter(r) || r == '_' || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// recovered 函数panic时返回错误
+func recovered(name string, fn Function) Function {
+	return func(args ...interface{}) (result interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				result, err = nil, fmt.Errorf("%s(): %v", name, r)
+			}
+		}()
+		return fn(args...)
+	}
 }
 
 // Strlen 求字符串长度
 func Strlen(args ...interface{}) (interface{}, error) {
-	length := len(args[0].(string))
-	return float64(length), nil
+	if len(args) != 1 {
+		return nil, arityError("strlen", 1, 1, len(args))
+	}
+	str, err := stringArg("strlen", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return float64(len(str)), nil
 }
 
-// Intval 将字符串转成数字
+// Intval 将字符串转成数字，不能转换时返回0
 func Intval(args ...interface{}) (interface{}, error) {
-	val := common.ParseInt64(args[0].(string))
-	return float64(val), nil
+	if len(args) != 1 {
+		return nil, arityError("intval", 1, 1, len(args))
+	}
+	switch value := args[0].(type) {
+	case string:
+		return float64(common.ParseInt64(value)), nil
+	case float64:
+		return float64(int64(value)), nil
+	case bool:
+		if value {
+			return float64(1), nil
+		}
+		return float64(0), nil
+	}
+	return nil, typeError("intval", 0, "a string or number", args[0])
 }
 
 // Date 返回当前日期
@@ -37,9 +141,19 @@ func Time() string {
 
 // Eval 执行表达式
 func Eval(exp string) (interface{}, error) {
-	expr, err := govaluate.NewEvaluableExpressionWithFunctions(exp, functions)
+	return EvalWithParameters(exp, nil)
+}
+
+// EvalWithParams 使用变量执行表达式，如EvalWithParams("nid in (1, 2) && level >= 3", map[string]interface{}{"nid": 1, "level": 5})
+func EvalWithParams(exp string, params map[string]interface{}) (interface{}, error) {
+	return EvalWithParameters(exp, Params(params))
+}
+
+// EvalWithParameters 使用自定义的变量来源执行表达式，见Values、Chain
+func EvalWithParameters(exp string, params Parameters) (interface{}, error) {
+	p, err := Compile(exp)
 	if err != nil {
 		return nil, err
 	}
-	return expr.Evaluate(nil)
+	return p.Eval(params)
 }
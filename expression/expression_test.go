@@ -1,6 +1,7 @@
 package expression
 
 import (
+	"net/url"
 	"testing"
 	"time"
 
@@ -86,3 +87,78 @@ func TestEval(t *testing.T) {
 	_, err = Eval(str)
 	assert.NotNil(t, err)
 }
+
+func TestEvalWithParams(t *testing.T) {
+	params := map[string]interface{}{
+		"nid":     1707357,
+		"act_id":  int64(12),
+		"level":   uint8(3),
+		"name":    "chaos",
+		"tags":    []string{"vip", "new"},
+		"enabled": true,
+	}
+	tmp, err := EvalWithParams(`nid == 1707357 && act_id in (11, 12) && level >= 3 && name == "chaos" && enabled`, params)
+	assert.Nil(t, err)
+	assert.Equal(t, true, tmp)
+
+	tmp, err = EvalWithParams(`in_array("vip", tags) && len(tags) == 2`, params)
+	assert.Nil(t, err)
+	assert.Equal(t, true, tmp)
+
+	_, err = EvalWithParams(`uid > 0`, params)
+	assert.EqualError(t, err, "unknown variable 'uid'")
+	_, err = EvalWithParams(`uid > 0`, nil)
+	assert.NotNil(t, err)
+
+	// 函数错误不会panic
+	_, err = EvalWithParams(`strlen(nid)`, params)
+	assert.EqualError(t, err, "strlen() argument 1 must be a string, got float64")
+	_, err = Eval(`strlen()`)
+	assert.NotNil(t, err)
+}
+
+func TestParameters(t *testing.T) {
+	values := url.Values{"nid": {"1707357", "1"}, "act_id": {"12"}}
+	tmp, err := EvalWithParameters(`nid == "1707357" && intval(act_id) == 12`, Values(values))
+	assert.Nil(t, err)
+	assert.Equal(t, true, tmp)
+	_, err = EvalWithParameters(`flow_id == ""`, Values(values))
+	assert.EqualError(t, err, "unknown variable 'flow_id'")
+
+	// 按顺序查找变量
+	params := Chain(nil, Params{"nid": 1}, Values(values))
+	tmp, err = EvalWithParameters(`nid == 1 && act_id == "12"`, params)
+	assert.Nil(t, err)
+	assert.Equal(t, true, tmp)
+	_, err = EvalWithParameters(`flow_id`, params)
+	assert.NotNil(t, err)
+}
+
+func TestRegister(t *testing.T) {
+	assert.NotNil(t, Register("", Strlen))
+	assert.NotNil(t, Register("1abc", Strlen))
+	assert.NotNil(t, Register("a-b", Strlen))
+	assert.NotNil(t, Register("in", Strlen))
+	assert.NotNil(t, Register("strlen", Strlen))
+	assert.NotNil(t, Register("test_nil", nil))
+
+	defer func() {
+		functionLock.Lock()
+		delete(functions, "test_score")
+		functionLock.Unlock()
+	}()
+	assert.Nil(t, Register("test_score", func(args ...interface{}) (interface{}, error) {
+		return args[0].(float64) * 10, nil
+	}))
+	assert.Contains(t, FunctionNames(), "test_score")
+	assert.Contains(t, FunctionNames(), "in_array")
+
+	tmp, err := EvalWithParams(`test_score(level) > 20`, map[string]interface{}{"level": 3})
+	assert.Nil(t, err)
+	assert.Equal(t, true, tmp)
+
+	// 注册的函数panic时返回错误
+	_, err = Eval(`test_score("abc")`)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "test_score()")
+}
@@ -0,0 +1,501 @@
+package expression
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuanzhangcai/chaos/common"
+)
+
+// builtin 内置函数，调用前检查参数个数，max为-1时不限制
+type builtin struct {
+	min, max int
+	fn       func(args []interface{}) (interface{}, error)
+}
+
+// builtins 内置函数，数字统一为float64，时间参数可以是时间戳、日期字符串或time.Time。
+// in是govaluate的运算符（如 nid in (1, 2)），集合判断函数命名为in_array
+var builtins = map[string]builtin{
+	// 兼容旧函数
+	"strlen": {1, 1, func(args []interface{}) (interface{}, error) { return Strlen(args...) }},
+	"intval": {1, 1, func(args []interface{}) (interface{}, error) { return Intval(args...) }},
+
+	// 日期时间，now、date、time见clockBuiltins
+	"format": {2, 2, fnFormat},
+	"diff":   {2, 3, fnDiff},
+
+	// 字符串
+	"contains":    {2, 2, fnContains},
+	"starts_with": {2, 2, fnStartsWith},
+	"ends_with":   {2, 2, fnEndsWith},
+	"substr":      {2, 3, fnSubstr},
+	"lower":       {1, 1, fnLower},
+	"upper":       {1, 1, fnUpper},
+	"trim":        {1, 1, fnTrim},
+	"match":       {2, 2, fnMatch},
+
+	// 数学
+	"min":   {1, -1, fnMin},
+	"max":   {1, -1, fnMax},
+	"abs":   {1, 1, fnAbs},
+	"round": {1, 2, fnRound},
+	"floor": {1, 1, fnFloor},
+	"ceil":  {1, 1, fnCeil},
+
+	// 集合
+	"in_array": {2, -1, fnInArray},
+	"len":      {1, 1, fnLen},
+
+	// 条件
+	"if":       {3, 3, fnIf},
+	"coalesce": {1, -1, fnCoalesce},
+}
+
+// clockBuiltins 使用当前时间的内置函数，沙箱中可以指定时钟
+var clockBuiltins = map[string]func(now func() time.Time) builtin{
+	"now":  func(now func() time.Time) builtin { return builtin{0, 0, fnNow(now)} },
+	"date": func(now func() time.Time) builtin { return builtin{0, 1, fnDate(now)} },
+	"time": func(now func() time.Time) builtin { return builtin{0, 1, fnTime(now)} },
+}
+
+// checked 检查参数个数，并将panic转换为错误
+func checked(name string, one builtin) Function {
+	return recovered(name, func(args ...interface{}) (interface{}, error) {
+		if len(args) < one.min || (one.max >= 0 && len(args) > one.max) {
+			return nil, arityError(name, one.min, one.max, len(args))
+		}
+		return one.fn(args)
+	})
+}
+
+func arityError(name string, min, max, got int) error {
+	switch {
+	case min == max:
+		return fmt.Errorf("%s() takes %d arguments, got %d", name, min, got)
+	case max < 0:
+		return fmt.Errorf("%s() takes at least %d arguments, got %d", name, min, got)
+	}
+	return fmt.Errorf("%s() takes %d to %d arguments, got %d", name, min, max, got)
+}
+
+func typeError(name string, i int, expect string, got interface{}) error {
+	return fmt.Errorf("%s() argument %d must be %s, got %T", name, i+1, expect, got)
+}
+
+func stringArg(name string, args []interface{}, i int) (string, error) {
+	str, ok := args[i].(string)
+	if !ok {
+		return "", typeError(name, i, "a string", args[i])
+	}
+	return str, nil
+}
+
+func numberArg(name string, args []interface{}, i int) (float64, error) {
+	num, ok := args[i].(float64)
+	if !ok {
+		return 0, typeError(name, i, "a number", args[i])
+	}
+	return num, nil
+}
+
+// timeLayouts 字符串时间参数支持的格式
+var timeLayouts = []string{common.YMDHIS, "2006-01-02 15:04", common.YMD, time.RFC3339}
+
+func timeArg(name string, args []interface{}, i int) (time.Time, error) {
+	switch value := args[i].(type) {
+	case float64:
+		sec, frac := math.Modf(value)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	case time.Time:
+		return value, nil
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("%s() argument %d is not a valid time: %q", name, i+1, value)
+	}
+	return time.Time{}, typeError(name, i, "a time", args[i])
+}
+
+// now() 当前时间，格式为2006-01-02 15:04:05
+func fnNow(now func() time.Time) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		return now().Format(common.YMDHIS), nil
+	}
+}
+
+// date([t]) 日期，格式为2006-01-02，没有参数时为当天
+func fnDate(now func() time.Time) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		t := now()
+		if len(args) == 1 {
+			var err error
+			if t, err = timeArg("date", args, 0); err != nil {
+				return nil, err
+			}
+		}
+		return t.Format(common.YMD), nil
+	}
+}
+
+// time([t]) 时间戳，单位秒，没有参数时为当前时间
+func fnTime(now func() time.Time) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		t := now()
+		if len(args) == 1 {
+			var err error
+			if t, err = timeArg("time", args, 0); err != nil {
+				return nil, err
+			}
+		}
+		return float64(t.Unix()), nil
+	}
+}
+
+// format(t, layout) 按Go的时间格式格式化，如format(time(), "2006-01-02")
+func fnFormat(args []interface{}) (interface{}, error) {
+	t, err := timeArg("format", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := stringArg("format", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return t.Format(layout), nil
+}
+
+var diffUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// diff(a, b[, unit]) a-b的时间差，unit为second（默认）、minute、hour、day
+func fnDiff(args []interface{}) (interface{}, error) {
+	a, err := timeArg("diff", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := timeArg("diff", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	unit := time.Second
+	if len(args) == 3 {
+		name, err := stringArg("diff", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if unit, ok = diffUnits[name]; !ok {
+			return nil, fmt.Errorf("diff() unknown unit %q", name)
+		}
+	}
+	return float64(a.Sub(b)) / float64(unit), nil
+}
+
+// twoStrings 读取两个字符串参数
+func twoStrings(name string, args []interface{}) (string, string, error) {
+	a, err := stringArg(name, args, 0)
+	if err != nil {
+		return "", "", err
+	}
+	b, err := stringArg(name, args, 1)
+	return a, b, err
+}
+
+// contains(s, sub) 是否包含子串
+func fnContains(args []interface{}) (interface{}, error) {
+	s, sub, err := twoStrings("contains", args)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Contains(s, sub), nil
+}
+
+// starts_with(s, prefix) 是否以prefix开头
+func fnStartsWith(args []interface{}) (interface{}, error) {
+	s, prefix, err := twoStrings("starts_with", args)
+	if err != nil {
+		return nil, err
+	}
+	return strings.HasPrefix(s, prefix), nil
+}
+
+// ends_with(s, suffix) 是否以suffix结尾
+func fnEndsWith(args []interface{}) (interface{}, error) {
+	s, suffix, err := twoStrings("ends_with", args)
+	if err != nil {
+		return nil, err
+	}
+	return strings.HasSuffix(s, suffix), nil
+}
+
+// substr(s, start[, length]) 按字符截取，start为负数时从末尾开始，超出范围时截取到边界
+func fnSubstr(args []interface{}) (interface{}, error) {
+	s, err := stringArg("substr", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	start, err := numberArg("substr", args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	runes := []rune(s)
+	begin := int(start)
+	if begin < 0 {
+		begin += len(runes)
+	}
+	if begin < 0 {
+		begin = 0
+	}
+	if begin > len(runes) {
+		begin = len(runes)
+	}
+	end := len(runes)
+	if len(args) == 3 {
+		length, err := numberArg("substr", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, fmt.Errorf("substr() length must not be negative")
+		}
+		if begin+int(length) < end {
+			end = begin + int(length)
+		}
+	}
+	return string(runes[begin:end]), nil
+}
+
+// lower(s) 转小写
+func fnLower(args []interface{}) (interface{}, error) {
+	s, err := stringArg("lower", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return strings.ToLower(s), nil
+}
+
+// upper(s) 转大写
+func fnUpper(args []interface{}) (interface{}, error) {
+	s, err := stringArg("upper", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return strings.ToUpper(s), nil
+}
+
+// trim(s) 去掉首尾空白
+func fnTrim(args []interface{}) (interface{}, error) {
+	s, err := stringArg("trim", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// regexps 编译后的正则表达式，超过上限后清空
+var (
+	regexps    = map[string]*regexp.Regexp{}
+	regexpLock sync.Mutex
+)
+
+const maxRegexps = 1000
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpLock.Lock()
+	defer regexpLock.Unlock()
+	if re, ok := regexps[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(regexps) >= maxRegexps {
+		regexps = map[string]*regexp.Regexp{}
+	}
+	regexps[pattern] = re
+	return re, nil
+}
+
+// match(s, pattern) 是否匹配正则表达式，与govaluate的=~运算符相同，但会缓存编译结果
+func fnMatch(args []interface{}) (interface{}, error) {
+	s, pattern, err := twoStrings("match", args)
+	if err != nil {
+		return nil, err
+	}
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("match() invalid pattern: %s", err.Error())
+	}
+	return re.MatchString(s), nil
+}
+
+// numbers 读取所有数字参数
+func numbers(name string, args []interface{}) ([]float64, error) {
+	list := make([]float64, len(args))
+	for i := range args {
+		num, err := numberArg(name, args, i)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = num
+	}
+	return list, nil
+}
+
+// min(a, ...) 最小值
+func fnMin(args []interface{}) (interface{}, error) {
+	list, err := numbers("min", args)
+	if err != nil {
+		return nil, err
+	}
+	result := list[0]
+	for _, one := range list[1:] {
+		result = math.Min(result, one)
+	}
+	return result, nil
+}
+
+// max(a, ...) 最大值
+func fnMax(args []interface{}) (interface{}, error) {
+	list, err := numbers("max", args)
+	if err != nil {
+		return nil, err
+	}
+	result := list[0]
+	for _, one := range list[1:] {
+		result = math.Max(result, one)
+	}
+	return result, nil
+}
+
+// abs(x) 绝对值
+func fnAbs(args []interface{}) (interface{}, error) {
+	x, err := numberArg("abs", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return math.Abs(x), nil
+}
+
+// round(x[, places]) 四舍五入，places为保留的小数位数
+func fnRound(args []interface{}) (interface{}, error) {
+	x, err := numberArg("round", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		return math.Round(x), nil
+	}
+	places, err := numberArg("round", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if places < 0 || places > 15 {
+		return nil, fmt.Errorf("round() places must be between 0 and 15")
+	}
+	pow := math.Pow(10, math.Floor(places))
+	return math.Round(x*pow) / pow, nil
+}
+
+// floor(x) 向下取整
+func fnFloor(args []interface{}) (interface{}, error) {
+	x, err := numberArg("floor", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return math.Floor(x), nil
+}
+
+// ceil(x) 向上取整
+func fnCeil(args []interface{}) (interface{}, error) {
+	x, err := numberArg("ceil", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return math.Ceil(x), nil
+}
+
+// normalize 整数转换为float64后比较，与govaluate的变量处理一致
+func normalize(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32:
+		return v.Float()
+	}
+	return value
+}
+
+// in_array(v, list) 或 in_array(v, a, b, ...) 是否在集合中，list可以是任意类型的切片或数组
+func fnInArray(args []interface{}) (interface{}, error) {
+	needle := normalize(args[0])
+	if len(args) == 2 {
+		v := reflect.ValueOf(args[1])
+		if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < v.Len(); i++ {
+				if reflect.DeepEqual(needle, normalize(v.Index(i).Interface())) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+	}
+	for _, one := range args[1:] {
+		if reflect.DeepEqual(needle, normalize(one)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// len(v) 字符串的字符数，切片、数组或map的元素个数
+func fnLen(args []interface{}) (interface{}, error) {
+	if s, ok := args[0].(string); ok {
+		return float64(len([]rune(s))), nil
+	}
+	v := reflect.ValueOf(args[0])
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), nil
+	}
+	return nil, typeError("len", 0, "a string, slice or map", args[0])
+}
+
+// if(cond, a, b) cond为true时返回a，否则返回b，参数都会先计算
+func fnIf(args []interface{}) (interface{}, error) {
+	cond, ok := args[0].(bool)
+	if !ok {
+		return nil, typeError("if", 0, "a bool", args[0])
+	}
+	if cond {
+		return args[1], nil
+	}
+	return args[2], nil
+}
+
+// coalesce(a, ...) 返回第一个不为nil且不为空字符串的参数，都为空时返回nil
+func fnCoalesce(args []interface{}) (interface{}, error) {
+	for _, one := range args {
+		if one != nil && one != "" {
+			return one, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,131 @@
+package expression
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/chaos/common"
+)
+
+func TestFunctions(t *testing.T) {
+	now := time.Now()
+	params := map[string]interface{}{
+		"name":    "Chaos 框架",
+		"score":   85.5,
+		"ids":     []int{1, 2, 3},
+		"names":   []string{"a", "b"},
+		"attrs":   map[string]interface{}{"a": 1},
+		"empty":   "",
+		"nothing": nil,
+		"start":   "2021-06-01 10:00:00",
+		"moment":  now,
+	}
+
+	cases := map[string]interface{}{
+		// 日期时间
+		`date()`:                      now.Format(common.YMD),
+		`date(start)`:                 "2021-06-01",
+		`date("2021-06-01 23:59:59")`: "2021-06-01",
+		`time("2021-06-01 10:00:00") == time(start)`:   true,
+		`time() >= time(moment)`:                       true,
+		`format(start, "01/02 15:04")`:                 "06/01 10:00",
+		`format(1622512800, "2006")`:                   "2021",
+		`diff("2021-06-03", "2021-06-01", "day")`:      float64(2),
+		`diff("2021-06-01 10:30:00", start, "minute")`: float64(30),
+		`diff(start, "2021-06-01 09:00:00")`:           float64(3600),
+		`diff("2021-06-01 12:00:00", start, "hour")`:   float64(2),
+		`len(now()) == 19`:                             true,
+
+		// 字符串
+		`contains(name, "框架")`:                     true,
+		`starts_with(name, "Ch")`:                  true,
+		`ends_with(name, "Ch")`:                    false,
+		`substr(name, 0, 5)`:                       "Chaos",
+		`substr(name, -2)`:                         "框架",
+		`substr(name, 6, 100)`:                     "框架",
+		`substr(name, 100)`:                        "",
+		`substr(name, -100, 2)`:                    "Ch",
+		`lower(name)`:                              "chaos 框架",
+		`upper("abc")`:                             "ABC",
+		`trim("  abc ")`:                           "abc",
+		`match("13800138000", "^1[3-9][0-9]{9}$")`: true,
+		`match("abc", "^[0-9]+$")`:                 false,
+		`strlen("框架")`:                             float64(6),
+		`len("框架")`:                                float64(2),
+		`intval("12") + intval(3.7)`:               float64(15),
+		`intval(true)`:                             float64(1),
+
+		// 数学
+		`min(3, 1, 2)`:      float64(1),
+		`max(score, 90)`:    float64(90),
+		`abs(-2.5)`:         2.5,
+		`round(score)`:      float64(86),
+		`round(3.14159, 2)`: 3.14,
+		`floor(score)`:      float64(85),
+		`ceil(score)`:       float64(86),
+
+		// 集合
+		`in_array(2, ids)`:        true,
+		`in_array(4, ids)`:        false,
+		`in_array("b", names)`:    true,
+		`in_array("b", "a", "b")`: true,
+		`in_array(1, 2)`:          false,
+		`len(ids)`:                float64(3),
+		`len(attrs)`:              float64(1),
+
+		// 条件
+		`if(score >= 60, "pass", "fail")`:     "pass",
+		`if(score >= 90, "A", "B")`:           "B",
+		`coalesce(empty, nothing, "default")`: "default",
+		`coalesce(nothing, empty)`:            nil,
+	}
+	for exp, expect := range cases {
+		tmp, err := EvalWithParams(exp, params)
+		assert.Nil(t, err, exp)
+		assert.Equal(t, expect, tmp, exp)
+	}
+}
+
+func TestFunctionErrors(t *testing.T) {
+	params := map[string]interface{}{"ids": []int{1}, "moment": struct{}{}}
+	errs := map[string]string{
+		`date(true)`:            "date() argument 1 must be a time, got bool",
+		`date("tomorrow")`:      `date() argument 1 is not a valid time: "tomorrow"`,
+		`time(moment)`:          "time() argument 1 must be a time, got struct {}",
+		`format(1, 2)`:          "format() argument 2 must be a string, got float64",
+		`format("2021-06-01")`:  "format() takes 2 arguments, got 1",
+		`diff("2021-06-01")`:    "diff() takes 2 to 3 arguments, got 1",
+		`diff(1, 2, "week")`:    `diff() unknown unit "week"`,
+		`diff(1, 2, 3)`:         "diff() argument 3 must be a string, got float64",
+		`diff(1, true)`:         "diff() argument 2 must be a time, got bool",
+		`contains(1, "a")`:      "contains() argument 1 must be a string, got float64",
+		`starts_with("a", 1)`:   "starts_with() argument 2 must be a string, got float64",
+		`ends_with(1, "a")`:     "ends_with() argument 1 must be a string, got float64",
+		`substr("abc", "1")`:    "substr() argument 2 must be a number, got string",
+		`substr(1, 1)`:          "substr() argument 1 must be a string, got float64",
+		`substr("abc", 1, -1)`:  "substr() length must not be negative",
+		`substr("abc", 1, "2")`: "substr() argument 3 must be a number, got string",
+		`lower(1)`:              "lower() argument 1 must be a string, got float64",
+		`upper(1)`:              "upper() argument 1 must be a string, got float64",
+		`trim(1)`:               "trim() argument 1 must be a string, got float64",
+		`match("a", "[")`:       "match() invalid pattern: error parsing regexp: missing closing ]: `[`",
+		`min()`:                 "min() takes at least 1 arguments, got 0",
+		`min(1, "2")`:           "min() argument 2 must be a number, got string",
+		`max("1")`:              "max() argument 1 must be a number, got string",
+		`abs("1")`:              "abs() argument 1 must be a number, got string",
+		`round("1")`:            "round() argument 1 must be a number, got string",
+		`round(1, "2")`:         "round() argument 2 must be a number, got string",
+		`round(1, 20)`:          "round() places must be between 0 and 15",
+		`floor("1")`:            "floor() argument 1 must be a number, got string",
+		`ceil("1")`:             "ceil() argument 1 must be a number, got string",
+		`len(1)`:                "len() argument 1 must be a string, slice or map, got float64",
+		`if(1, 2, 3)`:           "if() argument 1 must be a bool, got float64",
+		`intval(ids)`:           "intval() argument 1 must be a string or number, got []int",
+		`in_array(1)`:           "in_array() takes at least 2 arguments, got 1",
+	}
+	for exp, expect := range errs {
+		_, err := EvalWithParams(exp, params)
+		assert.EqualError(t, err, expect, exp)
+	}
+}
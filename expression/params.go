@@ -0,0 +1,64 @@
+package expression
+
+import (
+	"net/url"
+
+	"github.com/Knetic/govaluate"
+)
+
+// Parameters 表达式变量来源，Get在变量不存在时返回错误
+type Parameters = govaluate.Parameters
+
+// Params map形式的表达式变量，整数会转换为float64后参与运算
+type Params map[string]interface{}
+
+// Get 实现Parameters
+func (c Params) Get(name string) (interface{}, error) {
+	value, ok := c[name]
+	if !ok {
+		return nil, &UnknownVariableError{Name: name}
+	}
+	return value, nil
+}
+
+// UnknownVariableError 表达式引用了不存在的变量
+type UnknownVariableError struct {
+	Name string
+}
+
+func (c *UnknownVariableError) Error() string {
+	return "unknown variable '" + c.Name + "'"
+}
+
+// Values 将请求参数绑定为表达式变量，每个参数取第一个值，值为字符串，数字比较前需要使用intval转换
+func Values(values url.Values) Parameters {
+	return valuesParams(values)
+}
+
+type valuesParams url.Values
+
+func (c valuesParams) Get(name string) (interface{}, error) {
+	if list, ok := c[name]; ok && len(list) > 0 {
+		return list[0], nil
+	}
+	return nil, &UnknownVariableError{Name: name}
+}
+
+// Chain 按顺序从多个来源查找变量，如Chain(Params{"user_level": 3}, Values(ctl.Params))
+func Chain(params ...Parameters) Parameters {
+	return chainParams(params)
+}
+
+type chainParams []Parameters
+
+func (c chainParams) Get(name string) (interface{}, error) {
+	for _, one := range c {
+		if one == nil {
+			continue
+		}
+		if value, err := one.Get(name); err == nil {
+			return value, nil
+		}
+	}
+	return nil, &UnknownVariableError{Name: name}
+}
@@ -0,0 +1,172 @@
+package expression
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Knetic/govaluate"
+)
+
+// DefaultCacheSize 默认缓存的编译结果个数
+const DefaultCacheSize = 1024
+
+// Program 编译后的表达式，可以并发执行
+type Program struct {
+	text string
+	expr *govaluate.EvaluableExpression
+	vars []string
+
+	tokens  []token       // 沙箱中编译时记录，用于定位执行错误
+	timeout time.Duration // 执行超时时间，0表示不限制
+}
+
+// Compile 编译表达式，相同的表达式从缓存中获取，编译失败时不缓存
+func Compile(exp string) (*Program, error) {
+	if p := programs.get(exp); p != nil {
+		return p, nil
+	}
+	p, err := compile(exp)
+	if err != nil {
+		return nil, err
+	}
+	programs.add(p)
+	return p, nil
+}
+
+func compile(exp string) (*Program, error) {
+	expr, err := govaluate.NewEvaluableExpressionWithFunctions(exp, currentFunctions())
+	if err != nil {
+		return nil, err
+	}
+	return newProgram(exp, expr), nil
+}
+
+func newProgram(exp string, expr *govaluate.EvaluableExpression) *Program {
+	var vars []string
+	seen := map[string]bool{}
+	for _, name := range expr.Vars() {
+		if !seen[name] {
+			seen[name] = true
+			vars = append(vars, name)
+		}
+	}
+	sort.Strings(vars)
+	return &Program{text: exp, expr: expr, vars: vars}
+}
+
+// String 返回表达式原文
+func (c *Program) String() string {
+	return c.text
+}
+
+// Vars 返回表达式引用的变量名，按字母排序，可用于加载配置时检查变量是否都能提供
+func (c *Program) Vars() []string {
+	return append([]string(nil), c.vars...)
+}
+
+// Eval 执行表达式
+func (c *Program) Eval(params Parameters) (interface{}, error) {
+	if params == nil {
+		params = Params{}
+	}
+
+	var value interface{}
+	var err error
+	if c.timeout > 0 {
+		value, err = c.evalTimeout(params)
+	} else {
+		value, err = c.expr.Eval(params)
+	}
+	if err != nil && c.tokens != nil {
+		return nil, runtimeError(c.tokens, err)
+	}
+	return value, err
+}
+
+// EvalWithParams 使用map中的变量执行表达式
+func (c *Program) EvalWithParams(params map[string]interface{}) (interface{}, error) {
+	return c.Eval(Params(params))
+}
+
+// SetCacheSize 设置缓存的编译结果个数，超出时淘汰最久未使用的，为0时不缓存
+func SetCacheSize(size int) {
+	programs.resize(size)
+}
+
+// CacheLen 返回当前缓存的编译结果个数
+func CacheLen() int {
+	return programs.len()
+}
+
+// ResetCache 清空缓存
+func ResetCache() {
+	programs.reset()
+}
+
+var programs = newProgramCache(DefaultCacheSize)
+
+// programCache 以表达式原文为key的LRU缓存
+type programCache struct {
+	lock  sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List // 最近使用的在前面
+}
+
+func newProgramCache(size int) *programCache {
+	return &programCache{size: size, items: map[string]*list.Element{}, order: list.New()}
+}
+
+func (c *programCache) get(exp string) *Program {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.items[exp]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*Program)
+	}
+	return nil
+}
+
+func (c *programCache) add(p *Program) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.size <= 0 {
+		return
+	}
+	if elem, ok := c.items[p.text]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[p.text] = c.order.PushFront(p)
+	c.evict()
+}
+
+func (c *programCache) resize(size int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.size = size
+	c.evict()
+}
+
+func (c *programCache) evict() {
+	for c.order.Len() > 0 && c.order.Len() > c.size {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		delete(c.items, elem.Value.(*Program).text)
+	}
+}
+
+func (c *programCache) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+func (c *programCache) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.items = map[string]*list.Element{}
+	c.order.Init()
+}
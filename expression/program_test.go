@@ -0,0 +1,151 @@
+package expression
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const benchExpression = `nid in (1707357, 1707358) && level >= 3 && starts_with(name, "ch") && score * 2 > 100`
+
+var benchParams = map[string]interface{}{"nid": 1707357, "level": 5, "name": "chaos", "score": 85.5}
+
+func TestCompile(t *testing.T) {
+	ResetCache()
+	defer ResetCache()
+
+	p, err := Compile(`level >= 3 && in_array(nid, ids) && level < [max level] && nid > 0`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ids", "level", "max level", "nid"}, p.Vars())
+	assert.Equal(t, `level >= 3 && in_array(nid, ids) && level < [max level] && nid > 0`, p.String())
+
+	tmp, err := p.EvalWithParams(map[string]interface{}{"level": 3, "nid": 2, "ids": []int{1, 2}, "max level": 10})
+	assert.Nil(t, err)
+	assert.Equal(t, true, tmp)
+	_, err = p.Eval(nil)
+	assert.NotNil(t, err)
+
+	// 相同的表达式使用缓存
+	p2, err := Compile(`level >= 3 && in_array(nid, ids) && level < [max level] && nid > 0`)
+	assert.Nil(t, err)
+	assert.True(t, p == p2)
+	assert.Equal(t, 1, CacheLen())
+
+	// 编译失败不缓存
+	_, err = Compile(`level >=`)
+	assert.NotNil(t, err)
+	_, err = Compile(`unknown_func(1)`)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, CacheLen())
+
+	p, err = Compile(`1 + 2`)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Vars())
+}
+
+func TestCompileCache(t *testing.T) {
+	ResetCache()
+	SetCacheSize(2)
+	defer func() {
+		SetCacheSize(DefaultCacheSize)
+		ResetCache()
+	}()
+
+	a, _ := Compile("1")
+	_, _ = Compile("2")
+	a2, _ := Compile("1") // 1变为最近使用
+	assert.True(t, a == a2)
+	_, _ = Compile("3") // 淘汰2
+	assert.Equal(t, 2, CacheLen())
+	assert.Nil(t, programs.get("2"))
+	assert.NotNil(t, programs.get("1"))
+	assert.NotNil(t, programs.get("3"))
+
+	SetCacheSize(1)
+	assert.Equal(t, 1, CacheLen())
+	SetCacheSize(0)
+	assert.Equal(t, 0, CacheLen())
+	_, err := Compile("1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, CacheLen())
+}
+
+func TestCompileConcurrent(t *testing.T) {
+	ResetCache()
+	SetCacheSize(8)
+	defer func() {
+		SetCacheSize(DefaultCacheSize)
+		ResetCache()
+	}()
+
+	done := make(chan bool)
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			defer func() { done <- true }()
+			for j := 0; j < 100; j++ {
+				tmp, err := EvalWithParams(fmt.Sprintf("level + %d", j%16), map[string]interface{}{"level": i})
+				assert.Nil(t, err)
+				assert.Equal(t, float64(i+j%16), tmp)
+			}
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+	assert.Equal(t, 8, CacheLen())
+}
+
+// BenchmarkParseEval 每次都解析表达式
+func BenchmarkParseEval(b *testing.B) {
+	params := Params(benchParams)
+	for i := 0; i < b.N; i++ {
+		p, err := compile(benchExpression)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = p.Eval(params); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEvalCached 通过缓存获取编译结果
+func BenchmarkEvalCached(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := EvalWithParams(benchExpression, benchParams); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkProgramEval 直接执行编译好的表达式
+func BenchmarkProgramEval(b *testing.B) {
+	p, err := Compile(benchExpression)
+	if err != nil {
+		b.Fatal(err)
+	}
+	params := Params(benchParams)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = p.Eval(params); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProgramEvalParallel(b *testing.B) {
+	p, err := Compile(benchExpression)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		params := Params(benchParams)
+		for pb.Next() {
+			if _, err := p.Eval(params); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package expression
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Knetic/govaluate"
+	"github.com/yuanzhangcai/config"
+)
+
+// SandboxOption 沙箱配置，用于执行运营人员在后台配置的表达式
+type SandboxOption struct {
+	MaxLength int      `json:"max_length"` // 表达式最大字符数，0表示不限制
+	MaxDepth  int      `json:"max_depth"`  // 括号与函数调用的最大嵌套层数，0表示不限制
+	Timeout   int      `json:"timeout"`    // 执行超时时间，单位毫秒，0表示不限制
+	Functions []string `json:"functions"`  // 允许使用的函数，为空时允许所有函数
+}
+
+// DefaultSandboxOption 默认沙箱配置
+func DefaultSandboxOption() *SandboxOption {
+	return &SandboxOption{
+		MaxLength: 1000,
+		MaxDepth:  16,
+		Timeout:   100,
+	}
+}
+
+// GetSandboxOptionFromConfig 读取expression.sandbox.name的配置
+func GetSandboxOptionFromConfig(name string) (*SandboxOption, error) {
+	opt := DefaultSandboxOption()
+	err := config.Scan([]string{"expression", "sandbox", name}, opt)
+	if err != nil {
+		return nil, err
+	}
+	return opt, nil
+}
+
+// Sandbox 表达式沙箱，限制表达式长度、嵌套层数、执行时间与可用函数，禁止访问参数的字段与方法，错误为带位置的*Error。
+// 编译结果缓存在沙箱中，可以并发使用
+type Sandbox struct {
+	option   *SandboxOption
+	allowed  map[string]bool // 为nil时允许所有函数
+	now      func() time.Time
+	programs *programCache
+}
+
+// NewSandbox 创建沙箱，opt为nil时使用默认配置
+func NewSandbox(opt *SandboxOption) *Sandbox {
+	if opt == nil {
+		opt = DefaultSandboxOption()
+	}
+	c := &Sandbox{option: opt, now: time.Now, programs: newProgramCache(DefaultCacheSize)}
+	if len(opt.Functions) > 0 {
+		c.allowed = make(map[string]bool, len(opt.Functions))
+		for _, name := range opt.Functions {
+			c.allowed[name] = true
+		}
+	}
+	return c
+}
+
+// WithClock 返回使用指定时钟的沙箱，now、date、time等函数的当前时间从now获取，用于测试与回放
+func (c *Sandbox) WithClock(now func() time.Time) *Sandbox {
+	return &Sandbox{option: c.option, allowed: c.allowed, now: now, programs: newProgramCache(DefaultCacheSize)}
+}
+
+// FixedClock 返回固定时间的时钟
+func FixedClock(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
+
+// Functions 返回沙箱中可以使用的函数名，按字母排序
+func (c *Sandbox) Functions() []string {
+	var names []string
+	for _, name := range FunctionNames() {
+		if c.allowed == nil || c.allowed[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Compile 检查并编译表达式，后台保存表达式前调用可以提示错误位置
+func (c *Sandbox) Compile(exp string) (*Program, error) {
+	if p := c.programs.get(exp); p != nil {
+		return p, nil
+	}
+	p, err := c.compile(exp)
+	if err != nil {
+		return nil, err
+	}
+	c.programs.add(p)
+	return p, nil
+}
+
+// Eval 执行表达式
+func (c *Sandbox) Eval(exp string, params Parameters) (interface{}, error) {
+	p, err := c.Compile(exp)
+	if err != nil {
+		return nil, err
+	}
+	return p.Eval(params)
+}
+
+// EvalWithParams 使用map中的变量执行表达式
+func (c *Sandbox) EvalWithParams(exp string, params map[string]interface{}) (interface{}, error) {
+	return c.Eval(exp, Params(params))
+}
+
+func (c *Sandbox) compile(exp string) (*Program, error) {
+	if c.option.MaxLength > 0 && utf8.RuneCountInString(exp) > c.option.MaxLength {
+		return nil, &Error{Kind: KindLimit, Pos: c.option.MaxLength, Msg: "expression is longer than " + strconv.Itoa(c.option.MaxLength) + " characters"}
+	}
+
+	runes := []rune(exp)
+	tokens, err := scan(runes)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.check(tokens); err != nil {
+		return nil, err
+	}
+
+	fns := c.functions()
+	expr, err := govaluate.NewEvaluableExpressionWithFunctions(exp, fns)
+	if err != nil {
+		return nil, syntaxError(runes, tokens, fns, err)
+	}
+	p := newProgram(exp, expr)
+	p.tokens = tokens
+	p.timeout = time.Duration(c.option.Timeout) * time.Millisecond
+	return p, nil
+}
+
+// check 检查括号、嵌套层数、函数与字段访问
+func (c *Sandbox) check(tokens []token) error {
+	var opens []int
+	for i, tok := range tokens {
+		switch tok.kind {
+		case tokenOpen:
+			opens = append(opens, tok.pos)
+			if c.option.MaxDepth > 0 && len(opens) > c.option.MaxDepth {
+				return &Error{Kind: KindLimit, Pos: tok.pos, Msg: "expression is nested deeper than " + strconv.Itoa(c.option.MaxDepth) + " levels"}
+			}
+		case tokenClose:
+			if len(opens) == 0 {
+				return &Error{Kind: KindSyntax, Pos: tok.pos, Msg: "unexpected ')'"}
+			}
+			opens = opens[:len(opens)-1]
+		case tokenIdent:
+			if strings.Contains(tok.text, ".") {
+				return &Error{Kind: KindSyntax, Pos: tok.pos, Msg: fmt.Sprintf("accessor '%s' is not allowed", tok.text)}
+			}
+			if i+1 < len(tokens) && tokens[i+1].kind == tokenOpen && !reserved[tok.text] {
+				if err := c.checkFunction(tok); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if len(opens) > 0 {
+		return &Error{Kind: KindSyntax, Pos: opens[len(opens)-1], Msg: "unclosed '('"}
+	}
+	return nil
+}
+
+func (c *Sandbox) checkFunction(tok token) error {
+	if _, ok := currentFunctions()[tok.text]; !ok {
+		return &Error{Kind: KindFunction, Pos: tok.pos, Msg: fmt.Sprintf("unknown function '%s'", tok.text)}
+	}
+	if c.allowed != nil && !c.allowed[tok.text] {
+		return &Error{Kind: KindFunction, Pos: tok.pos, Msg: fmt.Sprintf("function '%s' is not allowed", tok.text)}
+	}
+	return nil
+}
+
+// functions 沙箱中可用的函数，使用当前时间的内置函数改为使用沙箱的时钟
+func (c *Sandbox) functions() map[string]Function {
+	fns := map[string]Function{}
+	for name, fn := range currentFunctions() {
+		if c.allowed != nil && !c.allowed[name] {
+			continue
+		}
+		if one, ok := clockBuiltins[name]; ok {
+			fn = checked(name, one(c.now))
+		}
+		fns[name] = fn
+	}
+	return fns
+}
+
+// evalTimeout 在新的goroutine中执行，超时后直接返回错误，表达式会继续执行到结束
+func (c *Program) evalTimeout(params Parameters) (interface{}, error) {
+	type result struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{nil, fmt.Errorf("%v", r)}
+			}
+		}()
+		value, err := c.expr.Eval(params)
+		done <- result{value, err}
+	}()
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		return nil, &Error{Kind: KindTimeout, Pos: -1, Msg: "evaluation timed out after " + c.timeout.String()}
+	}
+}
@@ -0,0 +1,163 @@
+package expression
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/config"
+)
+
+func TestSandboxOption(t *testing.T) {
+	err := config.LoadMemory(`{"expression": {"sandbox": {"admin": {"max_length": 200, "functions": ["strlen", "in_array"]}}}}`, "json")
+	assert.Nil(t, err)
+
+	opt, err := GetSandboxOptionFromConfig("admin")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, opt.MaxLength)
+	assert.Equal(t, 16, opt.MaxDepth)
+	assert.Equal(t, 100, opt.Timeout)
+	assert.Equal(t, []string{"strlen", "in_array"}, opt.Functions)
+
+	s := NewSandbox(opt)
+	assert.Equal(t, []string{"in_array", "strlen"}, s.Functions())
+	assert.Contains(t, NewSandbox(nil).Functions(), "now")
+}
+
+func TestSandboxCompileErrors(t *testing.T) {
+	s := NewSandbox(&SandboxOption{MaxLength: 40, MaxDepth: 3, Functions: []string{"strlen", "now"}})
+
+	errs := []struct {
+		exp  string
+		kind ErrorKind
+		pos  int
+		msg  string
+	}{
+		{`a + `, KindSyntax, 4, "Unexpected end of expression"},
+		{`a + + b`, KindSyntax, 4, "Cannot transition token types from MODIFIER [+] to MODIFIER [+]"},
+		{`a b`, KindSyntax, 2, "Cannot transition token types from VARIABLE [a] to VARIABLE [b]"},
+		{`名称 == "a" && b >< 3`, KindSyntax, 15, "Invalid token: '><'"},
+		{`a in (1, 2,)`, KindSyntax, 11, "Cannot transition token types from SEPARATOR [,] to CLAUSE_CLOSE [41]"},
+		{`1..2 > 0`, KindSyntax, 0, "Unable to parse numeric value '1..2' to float64"},
+		{`(a + 1`, KindSyntax, 0, "unclosed '('"},
+		{`a + 1)`, KindSyntax, 5, "unexpected ')'"},
+		{`a == "abc`, KindSyntax, 5, "unclosed string literal"},
+		{`[x > 1`, KindSyntax, 0, "unclosed parameter bracket"},
+		{`user.Name == "a"`, KindSyntax, 0, "accessor 'user.Name' is not allowed"},
+		{`a > 1 && foo(1)`, KindFunction, 9, "unknown function 'foo'"},
+		{`strlen(a) > upper(b)`, KindFunction, 12, "function 'upper' is not allowed"},
+		{`((((1))))`, KindLimit, 3, "expression is nested deeper than 3 levels"},
+		{`a == "0123456789012345678901234567890123456789"`, KindLimit, 40, "expression is longer than 40 characters"},
+	}
+	for _, one := range errs {
+		_, err := s.Compile(one.exp)
+		e := &Error{}
+		if assert.True(t, errors.As(err, &e), one.exp) {
+			assert.Equal(t, one.kind, e.Kind, one.exp)
+			assert.Equal(t, one.pos, e.Pos, one.exp)
+			assert.Equal(t, one.msg, e.Msg, one.exp)
+		}
+	}
+
+	_, err := s.Compile(`(a + 1`)
+	assert.EqualError(t, err, "syntax error at position 0: unclosed '('")
+	data, _ := json.Marshal(err)
+	assert.Equal(t, `{"kind":"syntax","pos":0,"msg":"unclosed '('"}`, string(data))
+
+	// 不在沙箱中时不限制
+	_, err = Compile(`((((1))))`)
+	assert.Nil(t, err)
+}
+
+func TestSandboxEval(t *testing.T) {
+	s := NewSandbox(nil)
+	params := map[string]interface{}{"a": 1, "b": true, "s": "x", "名称": "chaos"}
+
+	tmp, err := s.EvalWithParams(`名称 == "chaos" && in_array(a, 1, 2) && b`, params)
+	assert.Nil(t, err)
+	assert.Equal(t, true, tmp)
+
+	p, err := s.Compile(`名称 == "chaos" && in_array(a, 1, 2) && b`)
+	assert.Nil(t, err)
+	p2, _ := s.Compile(`名称 == "chaos" && in_array(a, 1, 2) && b`)
+	assert.True(t, p == p2)
+
+	errs := []struct {
+		exp  string
+		kind ErrorKind
+		pos  int
+	}{
+		{`a > 0 && x > 1`, KindVariable, 9},
+		{`a > 0 && [x y] > 1`, KindVariable, 9},
+		{`a + "s" > 1 && b`, KindType, 8},
+		{`b && strlen(a) > 1`, KindFunction, 5},
+		{`!a`, KindType, 0},
+		{`a && b`, KindType, 2},
+	}
+	for _, one := range errs {
+		_, err := s.EvalWithParams(one.exp, params)
+		e := &Error{}
+		if assert.True(t, errors.As(err, &e), one.exp) {
+			assert.Equal(t, one.kind, e.Kind, one.exp)
+			assert.Equal(t, one.pos, e.Pos, one.exp)
+		}
+	}
+
+	_, err = s.EvalWithParams(`a > 0 && x > 1`, params)
+	unknown := &UnknownVariableError{}
+	assert.True(t, errors.As(err, &unknown))
+	assert.Equal(t, "x", unknown.Name)
+}
+
+func TestSandboxClock(t *testing.T) {
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.Local)
+	s := NewSandbox(nil).WithClock(FixedClock(now))
+
+	// govaluate会把日期格式的字符串常量转换为时间戳，所以分别比较
+	tmp, err := s.Eval(`now()`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "2021-06-01 10:00:00", tmp)
+	tmp, err = s.Eval(`date()`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "2021-06-01", tmp)
+	tmp, err = s.Eval(`time() == "2021-06-01 10:00:00" && diff(now(), "2021-06-01") == 36000`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, true, tmp)
+
+	// 其它沙箱与全局函数不受影响
+	tmp, err = NewSandbox(nil).Eval(`date()`, nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "2021-06-01", tmp)
+	tmp, err = Eval(`date()`)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "2021-06-01", tmp)
+}
+
+func TestSandboxTimeout(t *testing.T) {
+	defer func() {
+		functionLock.Lock()
+		delete(functions, "test_sleep")
+		functionLock.Unlock()
+	}()
+	assert.Nil(t, Register("test_sleep", func(args ...interface{}) (interface{}, error) {
+		time.Sleep(time.Duration(args[0].(float64)) * time.Millisecond)
+		return true, nil
+	}))
+
+	s := NewSandbox(&SandboxOption{Timeout: 20})
+	start := time.Now()
+	_, err := s.Eval(`test_sleep(200)`, nil)
+	assert.True(t, time.Since(start) < 150*time.Millisecond)
+	e := &Error{}
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, KindTimeout, e.Kind)
+		assert.Equal(t, -1, e.Pos)
+	}
+	assert.EqualError(t, err, "timeout error: evaluation timed out after 20ms")
+
+	tmp, err := s.Eval(`test_sleep(1)`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, true, tmp)
+}
@@ -0,0 +1,361 @@
+// 平滑重启，收到重启信号时将监听的文件描述符传递给新启动的子进程，子进程就绪后当前进程停止服务并退出，重启过程中不断开连接
+
+package graceful
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yuanzhangcai/config"
+)
+
+const (
+	// EnvListeners 子进程继承的监听，格式为network:addr，多个以逗号分隔，依次对应文件描述符3、4...
+	EnvListeners = "CHAOS_LISTENERS"
+	// EnvReadyFD 子进程通知父进程已就绪的管道文件描述符
+	EnvReadyFD = "CHAOS_READY_FD"
+)
+
+// settle 停止接受新连接后等待已接受连接读取请求的时间
+var settle = 100 * time.Millisecond
+
+// ErrRestarting 重启正在进行中
+var ErrRestarting = errors.New("graceful restart is in progress")
+
+// Option 平滑重启配置
+type Option struct {
+	PidFile string `json:"pid_file"` // pid文件路径，为空时不写入
+	Timeout int64  `json:"timeout"`  // 等待子进程就绪的超时时间，单位秒，超时后终止子进程，当前进程继续服务
+}
+
+// gracefulListener 记录当前进程的监听，关闭时移除
+type gracefulListener struct {
+	net.Listener
+	key     string
+	once    sync.Once
+	closed  chan struct{}
+	stopped int32 // 已交给子进程，不再接受新连接
+}
+
+// Accept 接受连接，交给子进程后阻塞到监听关闭，由子进程接受新连接
+func (l *gracefulListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil && atomic.LoadInt32(&l.stopped) == 1 {
+		<-l.closed
+		return l.Listener.Accept()
+	}
+	return conn, err
+}
+
+// stop 停止接受新连接，但不关闭监听，避免服务把监听关闭当作异常退出
+func (l *gracefulListener) stop() {
+	atomic.StoreInt32(&l.stopped, 1)
+	if ln, ok := l.Listener.(interface{ SetDeadline(time.Time) error }); ok {
+		// 唤醒阻塞中的Accept
+		_ = ln.SetDeadline(time.Now())
+	}
+}
+
+func (l *gracefulListener) Close() error {
+	l.once.Do(func() {
+		remove(l)
+		close(l.closed)
+	})
+	return l.Listener.Close()
+}
+
+var (
+	lock       sync.Mutex
+	active     []*gracefulListener       // 当前进程的监听
+	inherited  map[string][]net.Listener // 从父进程继承且未使用的监听
+	readyFD    int                       // 通知父进程的管道，0表示不是由平滑重启启动
+	parsed     bool
+	restarting bool
+)
+
+// GetOptionFromConfig 读取平滑重启配置
+func GetOptionFromConfig() (*Option, error) {
+	opt := Option{
+		Timeout: 30,
+	}
+	err := config.Scan([]string{"graceful"}, &opt)
+	if err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
+// parse 读取从父进程继承的监听，调用时需持有lock
+func parse() error {
+	if parsed {
+		return nil
+	}
+	parsed = true
+	inherited = make(map[string][]net.Listener)
+
+	keys := os.Getenv(EnvListeners)
+	fd := os.Getenv(EnvReadyFD)
+	// 子进程再次重启时重新设置，不传递给孙进程
+	_ = os.Unsetenv(EnvListeners)
+	_ = os.Unsetenv(EnvReadyFD)
+
+	if fd != "" {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return fmt.Errorf("invalid %s (%s)", EnvReadyFD, fd)
+		}
+		readyFD = n
+	}
+	if keys == "" {
+		return nil
+	}
+
+	for i, key := range strings.Split(keys, ",") {
+		f := os.NewFile(uintptr(3+i), key)
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("inherit listener %s: %s", key, err)
+		}
+		if unix, ok := ln.(*net.UnixListener); ok {
+			// 继承的unix socket默认关闭时不删除文件，与直接监听保持一致
+			unix.SetUnlinkOnClose(true)
+		}
+		inherited[key] = append(inherited[key], ln)
+	}
+	return nil
+}
+
+func remove(l *gracefulListener) {
+	lock.Lock()
+	defer lock.Unlock()
+	for i, one := range active {
+		if one == l {
+			active = append(active[:i], active[i+1:]...)
+			return
+		}
+	}
+}
+
+// Listen 监听地址，由平滑重启启动时优先使用父进程传递的监听，unix socket会先删除残留的socket文件
+func Listen(network, addr string) (net.Listener, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	if err := parse(); err != nil {
+		return nil, err
+	}
+
+	key := network + ":" + addr
+	var ln net.Listener
+	if list := inherited[key]; len(list) > 0 {
+		ln = list[0]
+		inherited[key] = list[1:]
+	} else {
+		if network == "unix" {
+			// 删除上次异常退出时残留的socket文件
+			if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		var err error
+		if ln, err = net.Listen(network, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	l := &gracefulListener{Listener: ln, key: key, closed: make(chan struct{})}
+	active = append(active, l)
+	return l, nil
+}
+
+// Inherited 当前进程是否由平滑重启启动
+func Inherited() bool {
+	lock.Lock()
+	defer lock.Unlock()
+	_ = parse()
+	return readyFD != 0
+}
+
+// Ready 通知父进程当前进程已就绪，并关闭没有使用的继承监听，不是由平滑重启启动时不做任何处理
+func Ready() error {
+	lock.Lock()
+	defer lock.Unlock()
+	if err := parse(); err != nil {
+		return err
+	}
+
+	for key, list := range inherited {
+		// 配置变化后不再监听的地址
+		for _, ln := range list {
+			_ = ln.Close()
+		}
+		delete(inherited, key)
+	}
+
+	if readyFD == 0 {
+		return nil
+	}
+	f := os.NewFile(uintptr(readyFD), "ready")
+	readyFD = 0
+	defer f.Close()
+	_, err := f.Write([]byte{1})
+	return err
+}
+
+// files 复制当前进程的监听文件描述符，调用时需持有lock
+func files() ([]string, []*os.File, error) {
+	var keys []string
+	var fds []*os.File
+	for _, l := range active {
+		ln, ok := l.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		f, err := ln.File()
+		if err != nil {
+			closeFiles(fds)
+			return nil, nil, fmt.Errorf("listener %s: %s", l.key, err)
+		}
+		keys = append(keys, l.key)
+		fds = append(fds, f)
+	}
+	return keys, fds, nil
+}
+
+func closeFiles(fds []*os.File) {
+	for _, f := range fds {
+		_ = f.Close()
+	}
+}
+
+// Restart 启动新进程并传递当前所有监听，等待新进程就绪，超时或新进程退出时返回错误，当前进程继续服务
+// 返回nil后调用方应停止服务并退出，已建立的连接处理完成后再关闭
+func Restart(timeout time.Duration) error {
+	lock.Lock()
+	if restarting {
+		lock.Unlock()
+		return ErrRestarting
+	}
+	restarting = true
+	keys, fds, err := files()
+	lock.Unlock()
+
+	defer func() {
+		lock.Lock()
+		restarting = false
+		lock.Unlock()
+	}()
+	if err != nil {
+		return err
+	}
+	defer closeFiles(fds)
+
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, one := range os.Environ() {
+		if !strings.HasPrefix(one, EnvListeners+"=") && !strings.HasPrefix(one, EnvReadyFD+"=") {
+			env = append(env, one)
+		}
+	}
+	env = append(env,
+		EnvListeners+"="+strings.Join(keys, ","),
+		EnvReadyFD+"="+strconv.Itoa(3+len(fds)))
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(fds, w)
+	err = cmd.Start()
+	_ = w.Close()
+	if err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-ready:
+		if err == nil {
+			handoff()
+			return nil
+		}
+		err = fmt.Errorf("child process %d exited before ready", cmd.Process.Pid)
+	case err = <-exited:
+		return fmt.Errorf("child process %d exited before ready: %v", cmd.Process.Pid, err)
+	case <-timer.C:
+		err = fmt.Errorf("child process %d not ready in %s", cmd.Process.Pid, timeout)
+	}
+
+	_ = cmd.Process.Kill()
+	<-exited
+	return err
+}
+
+// handoff 监听已交给子进程，当前进程不再接受新连接，关闭时不删除unix socket文件
+func handoff() {
+	lock.Lock()
+	for _, l := range active {
+		l.stop()
+		if unix, ok := l.Listener.(*net.UnixListener); ok {
+			unix.SetUnlinkOnClose(false)
+		}
+	}
+	lock.Unlock()
+
+	// 等待已接受的连接读取请求，http.Server停止时会直接关闭还没有读取到请求的连接
+	time.Sleep(settle)
+}
+
+// WritePidFile 将当前进程pid写入文件，平滑重启后由新进程覆盖
+func WritePidFile(file string) error {
+	if file == "" {
+		return nil
+	}
+	return ioutil.WriteFile(file, []byte(strconv.Itoa(os.Getpid())), 0644)
+}
+
+// RemovePidFile 删除pid文件，文件中不是当前进程pid时(已由新进程覆盖)不删除
+func RemovePidFile(file string) error {
+	if file == "" {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(string(buf)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	return os.Remove(file)
+}
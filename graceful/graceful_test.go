@@ -0,0 +1,312 @@
+package graceful
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/config"
+)
+
+const (
+	envHelper = "GRACEFUL_HELPER" // 以测试服务进程运行
+	envAddr   = "GRACEFUL_ADDR"
+	envSock   = "GRACEFUL_SOCK"
+	envPid    = "GRACEFUL_PID"
+	envFail   = "GRACEFUL_FAIL" // 该文件存在时子进程启动失败
+)
+
+func TestGetOptionFromConfig(t *testing.T) {
+	_ = config.LoadMemory(`{"graceful": {"pid_file": "/tmp/chaos.pid"}}`, "json")
+	opt, err := GetOptionFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/chaos.pid", opt.PidFile)
+	assert.Equal(t, int64(30), opt.Timeout)
+}
+
+func TestListen(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "test.sock")
+	assert.Nil(t, ioutil.WriteFile(sock, nil, 0644)) // 残留的socket文件
+
+	ln1, err := Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	ln2, err := Listen("unix", sock)
+	assert.Nil(t, err)
+	_, err = Listen("tcp", ln1.Addr().String())
+	assert.NotNil(t, err)
+
+	lock.Lock()
+	assert.Equal(t, 2, len(active))
+	keys, fds, err := files()
+	lock.Unlock()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp:127.0.0.1:0", "unix:" + sock}, keys)
+	closeFiles(fds)
+
+	// 关闭后不再传递给子进程
+	assert.Nil(t, ln1.Close())
+	assert.NotNil(t, ln1.Close())
+	assert.Nil(t, ln2.Close())
+	lock.Lock()
+	assert.Equal(t, 0, len(active))
+	lock.Unlock()
+	_, err = os.Stat(sock)
+	assert.True(t, os.IsNotExist(err))
+
+	// 不是由平滑重启启动
+	assert.False(t, Inherited())
+	assert.Nil(t, Ready())
+}
+
+func TestPidFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "chaos.pid")
+
+	assert.Nil(t, WritePidFile(""))
+	assert.Nil(t, RemovePidFile(""))
+	assert.Nil(t, RemovePidFile(file))
+
+	assert.Nil(t, WritePidFile(file))
+	buf, _ := ioutil.ReadFile(file)
+	assert.Equal(t, strconv.Itoa(os.Getpid()), string(buf))
+	assert.Nil(t, RemovePidFile(file))
+	_, err := os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+
+	// 已被新进程覆盖时不删除
+	assert.Nil(t, ioutil.WriteFile(file, []byte("1"), 0644))
+	assert.Nil(t, RemovePidFile(file))
+	_, err = os.Stat(file)
+	assert.Nil(t, err)
+}
+
+// TestHelperProcess 测试服务进程，由TestRestart启动，平滑重启时再次启动
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(envHelper) == "" {
+		return
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+	if _, err := os.Stat(os.Getenv(envFail)); err == nil && Inherited() {
+		os.Exit(1)
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(500 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte(pid))
+	})}
+	for _, one := range [][2]string{{"tcp", os.Getenv(envAddr)}, {"unix", os.Getenv(envSock)}} {
+		ln, err := Listen(one[0], one[1])
+		if err != nil {
+			fmt.Println("error", err)
+			os.Exit(1)
+		}
+		go func() { _ = srv.Serve(ln) }()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR2, syscall.SIGTERM)
+	_ = WritePidFile(os.Getenv(envPid))
+	_ = Ready()
+	fmt.Println("ready", pid)
+
+	for s := range sig {
+		if s == syscall.SIGTERM {
+			break
+		}
+		if err := Restart(5 * time.Second); err != nil {
+			fmt.Println("restart failed", pid)
+			continue
+		}
+		break
+	}
+
+	_ = srv.Shutdown(context.Background())
+	_ = RemovePidFile(os.Getenv(envPid))
+	fmt.Println("exit", pid)
+	os.Exit(0)
+}
+
+// helper 测试服务进程及其子进程的输出
+type helper struct {
+	cmd   *exec.Cmd
+	lines chan string
+}
+
+func startHelper(t *testing.T, env ...string) *helper {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(append(os.Environ(), envHelper+"=1"), env...)
+	cmd.Stderr = os.Stderr
+	// 不使用StdoutPipe，Wait时会关闭管道，无法读取子进程的输出
+	out, w, err := os.Pipe()
+	assert.Nil(t, err)
+	cmd.Stdout = w
+	assert.Nil(t, cmd.Start())
+	_ = w.Close()
+
+	h := &helper{cmd: cmd, lines: make(chan string, 100)}
+	go func() {
+		// 子进程继承标准输出，旧进程退出后继续读取
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			h.lines <- scanner.Text()
+		}
+		close(h.lines)
+	}()
+	return h
+}
+
+// expect 等待指定前缀的输出，返回其余部分
+func (h *helper) expect(t *testing.T, prefix string) string {
+	timer := time.NewTimer(10 * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-h.lines:
+			if !ok {
+				t.Fatalf("output closed, expect %s", prefix)
+			}
+			if strings.HasPrefix(line, prefix+" ") {
+				return strings.TrimPrefix(line, prefix+" ")
+			}
+		case <-timer.C:
+			t.Fatalf("timeout, expect %s", prefix)
+		}
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func get(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	return string(buf), err
+}
+
+func TestRestart(t *testing.T) {
+	if os.Getenv(envHelper) != "" {
+		return
+	}
+
+	dir := t.TempDir()
+	addr, sock := freeAddr(t), filepath.Join(dir, "test.sock")
+	pidFile, failFile := filepath.Join(dir, "test.pid"), filepath.Join(dir, "fail")
+	h := startHelper(t, envAddr+"="+addr, envSock+"="+sock, envPid+"="+pidFile, envFail+"="+failFile)
+	defer func() { _ = h.cmd.Process.Kill() }()
+
+	url := "http://" + addr + "/"
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	unixClient := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", sock)
+		},
+	}}
+
+	oldPid := h.expect(t, "ready")
+	assert.Equal(t, strconv.Itoa(h.cmd.Process.Pid), oldPid)
+	body, err := get(client, url)
+	assert.Nil(t, err)
+	assert.Equal(t, oldPid, body)
+	buf, _ := ioutil.ReadFile(pidFile)
+	assert.Equal(t, oldPid, string(buf))
+
+	// 新进程启动失败时旧进程继续服务
+	assert.Nil(t, ioutil.WriteFile(failFile, nil, 0644))
+	assert.Nil(t, h.cmd.Process.Signal(syscall.SIGUSR2))
+	assert.Equal(t, oldPid, h.expect(t, "restart failed"))
+	body, err = get(client, url)
+	assert.Nil(t, err)
+	assert.Equal(t, oldPid, body)
+	assert.Nil(t, os.Remove(failFile))
+
+	// 重启过程中持续请求，不能有失败的请求
+	var failed, total int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := get(client, url); err != nil {
+				atomic.AddInt64(&failed, 1)
+			}
+			atomic.AddInt64(&total, 1)
+		}
+	}()
+
+	// 旧进程处理完进行中的请求后才退出
+	slow := make(chan string, 1)
+	go func() {
+		body, err := get(client, url+"slow")
+		assert.Nil(t, err)
+		slow <- body
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Nil(t, h.cmd.Process.Signal(syscall.SIGUSR2))
+	newPid := h.expect(t, "ready")
+	assert.NotEqual(t, oldPid, newPid)
+	assert.Equal(t, oldPid, h.expect(t, "exit"))
+	assert.Nil(t, h.cmd.Wait())
+	assert.Equal(t, oldPid, <-slow)
+
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	assert.Equal(t, int64(0), atomic.LoadInt64(&failed))
+	assert.True(t, atomic.LoadInt64(&total) > 0)
+
+	// 新进程使用继承的TCP与unix socket监听
+	body, err = get(client, url)
+	assert.Nil(t, err)
+	assert.Equal(t, newPid, body)
+	body, err = get(unixClient, "http://unix/")
+	assert.Nil(t, err)
+	assert.Equal(t, newPid, body)
+	buf, _ = ioutil.ReadFile(pidFile)
+	assert.Equal(t, newPid, string(buf))
+
+	// 新进程正常退出
+	pid, _ := strconv.Atoi(newPid)
+	assert.Nil(t, syscall.Kill(pid, syscall.SIGTERM))
+	assert.Equal(t, newPid, h.expect(t, "exit"))
+	_, err = os.Stat(pidFile)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(sock)
+	assert.True(t, os.IsNotExist(err))
+	_, err = get(client, url)
+	assert.NotNil(t, err)
+}
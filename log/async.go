@@ -0,0 +1,228 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// OverflowBlock 缓冲区满时阻塞等待
+	OverflowBlock = "block"
+	// OverflowDropLow 缓冲区满时丢弃debug/info日志，warn及以上等级的日志阻塞等待
+	OverflowDropLow = "drop_low"
+	// OverflowDropOldest 缓冲区满时丢弃最早的日志
+	OverflowDropOldest = "drop_oldest"
+)
+
+var (
+	jsonLevelKey = []byte(`"level":"`)
+	textLevelKey = []byte("level=")
+)
+
+// asyncEntry 缓冲区中的一条日志
+type asyncEntry struct {
+	level logrus.Level
+	buf   []byte
+}
+
+// asyncWriter 异步写日志，日志先写入环形缓冲区，由后台协程按大小或时间间隔批量写入文件
+type asyncWriter struct {
+	lock      sync.Mutex
+	notFull   *sync.Cond
+	flushLock sync.Mutex // 保证批量写入的顺序
+
+	writer    io.Writer
+	entries   []asyncEntry // 环形缓冲区
+	head      int          // 最早一条日志的位置
+	count     int          // 缓冲区中的日志条数
+	size      int          // 缓冲区中的日志字节数
+	flushSize int          // 缓冲字节数达到该值时立即写入
+	overflow  string       // 缓冲区满时的处理策略
+	closed    bool
+
+	flush chan struct{}
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+func newAsyncWriter(writer io.Writer, opt *Option) (*asyncWriter, error) {
+	switch opt.AsyncOverflow {
+	case "":
+		opt.AsyncOverflow = OverflowBlock
+	case OverflowBlock, OverflowDropLow, OverflowDropOldest:
+	default:
+		return nil, fmt.Errorf("unknown log async overflow (%s)", opt.AsyncOverflow)
+	}
+
+	buffer := opt.AsyncBuffer
+	if buffer <= 0 {
+		buffer = 8192
+	}
+	flushSize := opt.AsyncFlushSize * 1024
+	if flushSize <= 0 {
+		flushSize = 256 * 1024
+	}
+	interval := time.Duration(opt.AsyncFlushInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	w := &asyncWriter{
+		writer:    writer,
+		entries:   make([]asyncEntry, buffer),
+		flushSize: flushSize,
+		overflow:  opt.AsyncOverflow,
+		flush:     make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	w.notFull = sync.NewCond(&w.lock)
+
+	go w.loop(interval)
+	return w, nil
+}
+
+// Write 实现io.Writer，logrus会复用p，需要复制后再放入缓冲区
+func (w *asyncWriter) Write(p []byte) (int, error) {
+	level := detectLevel(p)
+
+	w.lock.Lock()
+	for !w.closed && w.count == len(w.entries) {
+		switch {
+		case w.overflow == OverflowDropOldest:
+			w.drop(w.entries[w.head].level)
+			w.size -= len(w.entries[w.head].buf)
+			w.entries[w.head] = asyncEntry{}
+			w.head = (w.head + 1) % len(w.entries)
+			w.count--
+			continue
+		case w.overflow == OverflowDropLow && level >= logrus.InfoLevel:
+			w.lock.Unlock()
+			w.drop(level)
+			return len(p), nil
+		}
+
+		w.signal()
+		w.notFull.Wait()
+	}
+
+	// 关闭后直接同步写入
+	if w.closed {
+		w.lock.Unlock()
+		return w.writer.Write(p)
+	}
+
+	buf := make([]byte, len(p))
+	copy(buf, p)
+	w.entries[(w.head+w.count)%len(w.entries)] = asyncEntry{level: level, buf: buf}
+	w.count++
+	w.size += len(buf)
+	if w.size >= w.flushSize {
+		w.signal()
+	}
+	w.lock.Unlock()
+	return len(p), nil
+}
+
+// Flush 将缓冲区中的日志同步写入文件
+func (w *asyncWriter) Flush() {
+	w.flushLock.Lock()
+	defer w.flushLock.Unlock()
+
+	w.lock.Lock()
+	if w.count == 0 {
+		w.lock.Unlock()
+		return
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, w.size))
+	for i := 0; i < w.count; i++ {
+		idx := (w.head + i) % len(w.entries)
+		buf.Write(w.entries[idx].buf)
+		w.entries[idx] = asyncEntry{}
+	}
+	w.head = 0
+	w.count = 0
+	w.size = 0
+	w.notFull.Broadcast()
+	w.lock.Unlock()
+
+	if _, err := w.writer.Write(buf.Bytes()); err != nil {
+		fmt.Println("write log file error:", err.Error())
+	}
+}
+
+// Close 写入缓冲区中剩余的日志并停止后台协程，之后的日志直接同步写入
+func (w *asyncWriter) Close() {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return
+	}
+	w.closed = true
+	w.notFull.Broadcast()
+	w.lock.Unlock()
+
+	close(w.quit)
+	<-w.done
+}
+
+func (w *asyncWriter) loop(interval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.flush:
+			w.Flush()
+		case <-ticker.C:
+			w.Flush()
+		case <-w.quit:
+			w.Flush()
+			return
+		}
+	}
+}
+
+// signal 通知后台协程立即写入
+func (w *asyncWriter) signal() {
+	select {
+	case w.flush <- struct{}{}:
+	default:
+	}
+}
+
+// drop 记录丢弃的日志数
+func (w *asyncWriter) drop(level logrus.Level) {
+	if handler, ok := dropHandler.Load().(func(level string)); ok && handler != nil {
+		handler(level.String())
+	}
+}
+
+// detectLevel 从格式化后的日志中识别日志等级，无法识别时按最高等级处理，不会被丢弃
+func detectLevel(p []byte) logrus.Level {
+	for _, key := range [][]byte{jsonLevelKey, textLevelKey} {
+		i := bytes.Index(p, key)
+		if i < 0 {
+			continue
+		}
+
+		rest := p[i+len(key):]
+		end := bytes.IndexAny(rest, "\" \n")
+		if end <= 0 {
+			continue
+		}
+
+		if level, err := logrus.ParseLevel(string(rest[:end])); err == nil {
+			return level
+		}
+	}
+	return logrus.PanicLevel
+}
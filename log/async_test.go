@@ -0,0 +1,83 @@
+package log
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer 并发安全的bytes.Buffer，测试用
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestDetectLevel(t *testing.T) {
+	assert.Equal(t, logrus.InfoLevel, detectLevel([]byte(`{"atime":"","level":"info","msg":"level=error"}`)))
+	assert.Equal(t, logrus.WarnLevel, detectLevel([]byte(`time="" level=warning msg=test`)))
+	assert.Equal(t, logrus.PanicLevel, detectLevel([]byte("plain text")))
+}
+
+func TestAsyncWriter(t *testing.T) {
+	_, err := newAsyncWriter(&syncBuffer{}, &Option{AsyncOverflow: "unknown"})
+	assert.NotNil(t, err)
+
+	// 缓冲区只有2条，且不会按大小与时间自动写入
+	out := &syncBuffer{}
+	w, err := newAsyncWriter(out, &Option{AsyncBuffer: 2, AsyncFlushSize: 1024, AsyncFlushInterval: 3600 * 1000, AsyncOverflow: OverflowBlock})
+	assert.Nil(t, err)
+	p := []byte("level=info a\n")
+	_, _ = w.Write(p)
+	p[len(p)-2] = 'b' // logrus会复用buf，写入后修改不影响缓冲区中的日志
+	assert.Equal(t, "", out.String())
+
+	w.Flush()
+	assert.Equal(t, "level=info a\n", out.String())
+
+	// 缓冲区满时阻塞，由后台协程写入后继续
+	for _, one := range []string{"b", "c", "d"} {
+		_, _ = w.Write([]byte("level=info " + one + "\n"))
+	}
+	w.Close()
+	assert.Equal(t, "level=info a\nlevel=info b\nlevel=info c\nlevel=info d\n", out.String())
+
+	// 关闭后同步写入
+	_, _ = w.Write([]byte("level=info e\n"))
+	assert.Contains(t, out.String(), "level=info e\n")
+}
+
+func TestAsyncOverflow(t *testing.T) {
+	out := &syncBuffer{}
+	w, err := newAsyncWriter(out, &Option{AsyncBuffer: 2, AsyncFlushSize: 1024, AsyncFlushInterval: 3600 * 1000, AsyncOverflow: OverflowDropOldest})
+	assert.Nil(t, err)
+	for _, one := range []string{"a", "b", "c"} {
+		_, _ = w.Write([]byte("level=error " + one + "\n"))
+	}
+	w.Close()
+	assert.Equal(t, "level=error b\nlevel=error c\n", out.String())
+
+	out = &syncBuffer{}
+	w, err = newAsyncWriter(out, &Option{AsyncBuffer: 2, AsyncFlushSize: 1024, AsyncFlushInterval: 3600 * 1000, AsyncOverflow: OverflowDropLow})
+	assert.Nil(t, err)
+	_, _ = w.Write([]byte("level=info a\n"))
+	_, _ = w.Write([]byte("level=debug b\n"))
+	_, _ = w.Write([]byte("level=info c\n"))
+	_, _ = w.Write([]byte("level=error d\n"))
+	w.Close()
+	assert.Equal(t, "level=info a\nlevel=debug b\nlevel=error d\n", out.String())
+}
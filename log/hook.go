@@ -0,0 +1,102 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// writerHook 将日志以指定格式另外写入一个输出
+type writerHook struct {
+	lock      sync.Mutex
+	writer    io.Writer
+	formatter logrus.Formatter // 为nil时使用logger的格式
+	levels    []logrus.Level
+}
+
+// Fire 写入日志
+func (c *writerHook) Fire(entry *logrus.Entry) error {
+	var buf []byte
+	var err error
+	if c.formatter != nil {
+		buf, err = c.formatter.Format(entry)
+	} else {
+		buf, err = entry.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err = c.writer.Write(buf)
+	return err
+}
+
+// Levels hook等级
+func (c *writerHook) Levels() []logrus.Level {
+	return c.levels
+}
+
+// addConsoleHook 添加控制台输出hook，便于容器采集日志
+func addConsoleHook(opt *Option) error {
+	var writer io.Writer
+	switch opt.Console {
+	case "":
+		return nil
+	case "stdout":
+		writer = os.Stdout
+	case "stderr":
+		writer = os.Stderr
+	default:
+		return fmt.Errorf("unknown log console (%s)", opt.Console)
+	}
+
+	var formatter logrus.Formatter
+	switch opt.ConsoleFormat {
+	case "", "json":
+	case "text":
+		formatter = &logrus.TextFormatter{
+			FullTimestamp:    true,
+			TimestampFormat:  logTimeFormat,
+			CallerPrettyfier: callerPrettyfier,
+		}
+	default:
+		return fmt.Errorf("unknown log console format (%s)", opt.ConsoleFormat)
+	}
+
+	logrus.AddHook(&writerHook{writer: writer, formatter: formatter, levels: logrus.AllLevels})
+	return nil
+}
+
+// addLevelHooks 添加按等级单独输出到文件的hook，文件名为 程序名.等级.时间.log
+func addLevelHooks(opt *Option) error {
+	for _, one := range opt.SplitLevels {
+		level, err := logrus.ParseLevel(one)
+		if err != nil {
+			return err
+		}
+
+		w, err := newRotateWriter(baseLogPath+level.String()+".", opt, nil, clearAllHistory)
+		if err != nil {
+			return err
+		}
+
+		lock.Lock()
+		levelWriters = append(levelWriters, w)
+		lock.Unlock()
+
+		// 输出该等级及以上等级的日志
+		var levels []logrus.Level
+		for _, l := range logrus.AllLevels {
+			if l <= level {
+				levels = append(levels, l)
+			}
+		}
+		logrus.AddHook(&writerHook{writer: w, levels: levels})
+	}
+	return nil
+}
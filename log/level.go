@@ -0,0 +1,351 @@
+package log
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ContextKey gin.Context中保存请求日志对象的key
+	ContextKey = "chaos:logger"
+	// DebugHeader 开启单个请求debug日志的签名请求头，值为 过期时间戳.签名
+	DebugHeader = "X-Chaos-Debug"
+
+	defaultDebugTTL = 600 // 临时调整日志等级的默认有效期，单位秒
+)
+
+var (
+	levelLock    sync.Mutex
+	baseLevel    = logrus.InfoLevel                  // 配置的日志等级，临时调整到期后恢复为该等级
+	revertTimer  *time.Timer                         // 临时调整日志等级到期恢复的定时器
+	revertAt     time.Time                           // 临时调整日志等级的到期时间
+	modules      = map[string]*logrus.Logger{}       // 各模块的日志对象
+	moduleLevels = map[string]logrus.Level{}         // 单独设置了等级的模块
+	debugLogger  = newChildLogger(logrus.DebugLevel) // 开启了debug的请求使用的日志对象
+	debugNids    = map[string]bool{}                 // 开启debug日志的用户
+	debugSecret  string                              // debug请求头签名密钥
+	debugTTL     = defaultDebugTTL * time.Second     // 临时调整日志等级的默认有效期
+)
+
+// stdOutput 将子日志对象的输出转发到标准日志对象，日志文件切换后也能跟随
+type stdOutput struct{}
+
+func (stdOutput) Write(p []byte) (int, error) {
+	return logrus.StandardLogger().Out.Write(p)
+}
+
+// stdFormatter 使用标准日志对象的格式
+type stdFormatter struct{}
+
+func (stdFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	return logrus.StandardLogger().Formatter.Format(entry)
+}
+
+// newChildLogger 创建与标准日志对象共用输出、格式与hook，只有等级不同的日志对象
+func newChildLogger(level logrus.Level) *logrus.Logger {
+	std := logrus.StandardLogger()
+	return &logrus.Logger{
+		Out:          stdOutput{},
+		Hooks:        std.Hooks,
+		Formatter:    stdFormatter{},
+		ReportCaller: std.ReportCaller,
+		Level:        level,
+	}
+}
+
+// initLevel 使用配置初始化日志等级、模块等级与debug用户
+func initLevel(opt *Option) error {
+	levels := make(map[string]logrus.Level)
+	for name, one := range opt.Modules {
+		level, err := logrus.ParseLevel(one)
+		if err != nil {
+			return err
+		}
+		levels[name] = level
+	}
+
+	levelLock.Lock()
+	baseLevel = logrus.Level(opt.Level)
+	moduleLevels = levels
+	debugSecret = opt.DebugSecret
+	if opt.DebugTTL > 0 {
+		debugTTL = time.Duration(opt.DebugTTL) * time.Second
+	}
+	debugLogger.ReportCaller = opt.ReportCaller
+	for _, logger := range modules {
+		logger.ReportCaller = opt.ReportCaller
+	}
+	levelLock.Unlock()
+
+	logrus.SetLevel(baseLevel)
+	applyModuleLevels()
+	SetDebugNids(opt.DebugNids...)
+
+	if opt.LevelSignal {
+		watchLevelSignal()
+	}
+	return nil
+}
+
+// GetLevel 返回当前的全局日志等级
+func GetLevel() logrus.Level {
+	return logrus.GetLevel()
+}
+
+// SetLevel 调整全局日志等级，ttl大于0时到期后自动恢复为配置的等级，ttl为0时永久生效
+func SetLevel(level logrus.Level, ttl time.Duration) {
+	levelLock.Lock()
+	if revertTimer != nil {
+		revertTimer.Stop()
+		revertTimer = nil
+		revertAt = time.Time{}
+	}
+	if ttl > 0 {
+		revertAt = time.Now().Add(ttl)
+		revertTimer = time.AfterFunc(ttl, ResetLevel)
+	} else {
+		baseLevel = level
+	}
+	levelLock.Unlock()
+
+	logrus.SetLevel(level)
+	applyModuleLevels()
+	logrus.Warn("日志等级已调整为" + level.String())
+}
+
+// ResetLevel 恢复为配置的日志等级
+func ResetLevel() {
+	levelLock.Lock()
+	if revertTimer != nil {
+		revertTimer.Stop()
+		revertTimer = nil
+	}
+	revertAt = time.Time{}
+	level := baseLevel
+	levelLock.Unlock()
+
+	logrus.SetLevel(level)
+	applyModuleLevels()
+	logrus.Warn("日志等级已恢复为" + level.String())
+}
+
+// SetModuleLevel 单独设置模块的日志等级
+func SetModuleLevel(name string, level logrus.Level) {
+	levelLock.Lock()
+	moduleLevels[name] = level
+	levelLock.Unlock()
+	applyModuleLevels()
+}
+
+// ResetModuleLevel 取消模块单独设置的日志等级，恢复跟随全局等级
+func ResetModuleLevel(name string) {
+	levelLock.Lock()
+	delete(moduleLevels, name)
+	levelLock.Unlock()
+	applyModuleLevels()
+}
+
+// Module 返回模块的日志对象，模块单独设置了等级时按模块等级输出，否则跟随全局等级
+func Module(name string) Logger {
+	levelLock.Lock()
+	logger, ok := modules[name]
+	if !ok {
+		logger = newChildLogger(moduleLevel(name))
+		modules[name] = logger
+	}
+	levelLock.Unlock()
+
+	level := func() Level {
+		levelLock.Lock()
+		defer levelLock.Unlock()
+		return moduleLevel(name)
+	}
+	return withLevel(L(), logger, level).WithField("module", name)
+}
+
+// moduleLevel 返回模块的日志等级，调用方需持有levelLock
+func moduleLevel(name string) logrus.Level {
+	if level, ok := moduleLevels[name]; ok {
+		return level
+	}
+	return logrus.GetLevel()
+}
+
+// applyModuleLevels 全局或模块等级变化后更新各模块日志对象的等级
+func applyModuleLevels() {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	for name, logger := range modules {
+		logger.SetLevel(moduleLevel(name))
+	}
+}
+
+func defaultTTL() time.Duration {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	return debugTTL
+}
+
+// DebugLogger 返回开启了debug的请求使用的日志对象
+func DebugLogger() Logger {
+	return withLevel(L(), debugLogger, func() Level { return logrus.DebugLevel }).WithField("debug", true)
+}
+
+// SetDebugNids 设置输出debug日志的用户
+func SetDebugNids(nids ...string) {
+	m := make(map[string]bool, len(nids))
+	for _, nid := range nids {
+		m[nid] = true
+	}
+
+	levelLock.Lock()
+	debugNids = m
+	levelLock.Unlock()
+}
+
+// IsDebugRequest 判断请求是否需要输出debug日志：用户在debug名单中，或带有有效的debug签名请求头
+func IsDebugRequest(nid, token string) bool {
+	levelLock.Lock()
+	secret := debugSecret
+	ok := nid != "" && debugNids[nid]
+	levelLock.Unlock()
+
+	if ok {
+		return true
+	}
+	return token != "" && secret != "" && VerifyDebugToken(secret, token, time.Now())
+}
+
+// SignDebugToken 生成debug请求头的值，格式为 过期时间戳.HmacSHA256(过期时间戳)
+func SignDebugToken(secret string, expire time.Time) string {
+	timestamp := strconv.FormatInt(expire.Unix(), 10)
+	return timestamp + "." + debugSign(secret, timestamp)
+}
+
+// VerifyDebugToken 校验debug请求头的签名与有效期
+func VerifyDebugToken(secret, token string, now time.Time) bool {
+	idx := strings.IndexByte(token, '.')
+	if idx <= 0 {
+		return false
+	}
+
+	timestamp := token[:idx]
+	expire, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Unix() > expire {
+		return false
+	}
+	return hmac.Equal([]byte(token[idx+1:]), []byte(debugSign(secret, timestamp)))
+}
+
+func debugSign(secret, timestamp string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	_, _ = h.Write([]byte(timestamp))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// FromContext 返回请求的日志对象，请求没有开启debug时使用默认日志对象
+func FromContext(c *gin.Context) Logger {
+	if c != nil {
+		if value, ok := c.Get(ContextKey); ok {
+			if logger, ok := value.(Logger); ok {
+				return logger
+			}
+		}
+	}
+	return L()
+}
+
+// levelInfo 日志等级状态
+type levelInfo struct {
+	Level    string            `json:"level"`               // 当前全局等级
+	Base     string            `json:"base"`                // 配置的等级
+	RevertAt string            `json:"revert_at,omitempty"` // 临时调整的到期时间
+	Modules  map[string]string `json:"modules"`             // 单独设置了等级的模块
+}
+
+// LevelHandler 日志等级管理接口
+// GET 查询当前等级
+// POST/PUT 调整等级，参数：level 等级名称，为reset时恢复配置的等级；ttl 有效期，单位秒，0为永久，不传时使用debug_ttl配置；module 只调整该模块的等级
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			if err := changeLevel(r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json;charset=utf-8")
+		buf, _ := json.Marshal(currentLevelInfo())
+		_, _ = w.Write(buf)
+	})
+}
+
+func changeLevel(r *http.Request) error {
+	name := r.FormValue("level")
+	module := r.FormValue("module")
+
+	if name == "reset" {
+		if module != "" {
+			ResetModuleLevel(module)
+		} else {
+			ResetLevel()
+		}
+		return nil
+	}
+
+	level, err := logrus.ParseLevel(name)
+	if err != nil {
+		return err
+	}
+
+	if module != "" {
+		SetModuleLevel(module, level)
+		return nil
+	}
+
+	ttl := defaultTTL()
+	if sTTL := r.FormValue("ttl"); sTTL != "" {
+		seconds, err := strconv.ParseInt(sTTL, 10, 64)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("invalid ttl (%s)", sTTL)
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	SetLevel(level, ttl)
+	return nil
+}
+
+func currentLevelInfo() *levelInfo {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+
+	info := &levelInfo{
+		Level:   logrus.GetLevel().String(),
+		Base:    baseLevel.String(),
+		Modules: make(map[string]string),
+	}
+	if !revertAt.IsZero() {
+		info.RevertAt = revertAt.Format(logTimeFormat)
+	}
+	for name, level := range moduleLevels {
+		info.Modules[name] = level.String()
+	}
+	return info
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package log
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// watchLevelSignal 收到SIGUSR1时在debug与配置的等级之间切换，debug等级ttl到期后自动恢复
+func watchLevelSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		for range ch {
+			if GetLevel() == logrus.DebugLevel {
+				ResetLevel()
+			} else {
+				SetLevel(logrus.DebugLevel, defaultTTL())
+			}
+		}
+	}()
+}
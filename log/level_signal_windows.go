@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package log
+
+// watchLevelSignal windows没有SIGUSR1，不支持通过信号切换日志等级
+func watchLevelSignal() {
+	L().Warn("log level_signal is not supported on windows")
+}
@@ -0,0 +1,96 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLevel(t *testing.T) {
+	defer logrus.SetLevel(logrus.GetLevel())
+	assert.Nil(t, initLevel(&Option{Level: uint32(logrus.InfoLevel), Modules: map[string]string{"models": "debug"}}))
+	assert.NotNil(t, initLevel(&Option{Modules: map[string]string{"models": "unknown"}}))
+
+	models := Module("models")
+	tools := Module("tools")
+	assert.True(t, models.Enabled(logrus.DebugLevel))
+	assert.False(t, tools.Enabled(logrus.DebugLevel))
+
+	// 临时调整，到期后自动恢复
+	SetLevel(logrus.DebugLevel, 50*time.Millisecond)
+	assert.Equal(t, logrus.DebugLevel, GetLevel())
+	assert.True(t, tools.Enabled(logrus.DebugLevel))
+	assert.Eventually(t, func() bool { return GetLevel() == logrus.InfoLevel }, time.Second, 10*time.Millisecond)
+	assert.False(t, tools.Enabled(logrus.DebugLevel))
+
+	SetModuleLevel("tools", logrus.ErrorLevel)
+	assert.False(t, tools.Enabled(logrus.InfoLevel))
+	ResetModuleLevel("tools")
+	assert.True(t, tools.Enabled(logrus.InfoLevel))
+
+	// 子日志对象与标准日志对象使用相同的输出
+	out := &bytes.Buffer{}
+	std := logrus.StandardLogger().Out
+	logrus.SetOutput(out)
+	models.Debug("debug")
+	logrus.SetOutput(std)
+	assert.Contains(t, out.String(), "models")
+}
+
+func TestDebugToken(t *testing.T) {
+	now := time.Now()
+	token := SignDebugToken("secret", now.Add(time.Minute))
+	assert.True(t, VerifyDebugToken("secret", token, now))
+	assert.False(t, VerifyDebugToken("other", token, now))
+	assert.False(t, VerifyDebugToken("secret", token, now.Add(2*time.Minute)))
+	assert.False(t, VerifyDebugToken("secret", "invalid", now))
+
+	assert.Nil(t, initLevel(&Option{Level: uint32(logrus.InfoLevel), DebugNids: []string{"10001"}, DebugSecret: "secret"}))
+	assert.True(t, IsDebugRequest("10001", ""))
+	assert.False(t, IsDebugRequest("10002", ""))
+	assert.True(t, IsDebugRequest("10002", token))
+}
+
+func TestLevelHandler(t *testing.T) {
+	defer logrus.SetLevel(logrus.GetLevel())
+	assert.Nil(t, initLevel(&Option{Level: uint32(logrus.InfoLevel)}))
+	handler := LevelHandler()
+
+	request := func(method string, values url.Values) (int, *levelInfo) {
+		req := httptest.NewRequest(method, "/debug/log/level", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		info := &levelInfo{}
+		_ = json.Unmarshal(w.Body.Bytes(), info)
+		return w.Code, info
+	}
+
+	code, info := request(http.MethodPost, url.Values{"level": {"debug"}, "ttl": {"60"}})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "debug", info.Level)
+	assert.Equal(t, "info", info.Base)
+	assert.NotEmpty(t, info.RevertAt)
+
+	_, info = request(http.MethodPut, url.Values{"level": {"warn"}, "module": {"models"}})
+	assert.Equal(t, "warning", info.Modules["models"])
+
+	_, info = request(http.MethodPost, url.Values{"level": {"reset"}})
+	assert.Equal(t, "info", info.Level)
+	assert.Empty(t, info.RevertAt)
+
+	code, _ = request(http.MethodPost, url.Values{"level": {"unknown"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = request(http.MethodDelete, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
@@ -3,7 +3,6 @@ package log
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -11,33 +10,56 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	cron "github.com/robfig/cron"
 	"github.com/sirupsen/logrus"
+	"github.com/yuanzhangcai/chaos/alert"
 	"github.com/yuanzhangcai/chaos/common"
 	"github.com/yuanzhangcai/config"
 )
 
 // Option Log初始化参数
 type Option struct {
-	Dir          string `json:"filedir"`
-	Level        uint32 `json:"level"`
-	MaxDays      int64  `json:"maxdays"`
-	ReportCaller bool   `json:"report_caller"`
+	Adapter       string   `json:"adapter"` // 日志实现：logrus/slog，默认logrus
+	Dir           string   `json:"filedir"`
+	Level         uint32   `json:"level"`
+	MaxDays       int64    `json:"maxdays"`
+	ReportCaller  bool     `json:"report_caller"`
+	RotateTime    string   `json:"rotate_time"`    // 按时间切换日志文件：daily/hourly/none，默认daily
+	MaxSize       int64    `json:"max_size"`       // 单个日志文件最大大小，单位MB，超过时切换文件，0表示不按大小切换
+	Compress      bool     `json:"compress"`       // 是否gzip压缩切换下来的日志文件
+	MaxBackups    int      `json:"max_backups"`    // 历史日志文件最多保留个数，0表示不限制
+	MaxTotalSize  int64    `json:"max_total_size"` // 历史日志文件总大小上限，单位MB，0表示不限制
+	Console       string   `json:"console"`        // 同时输出到控制台：stdout/stderr，为空时不输出
+	ConsoleFormat string   `json:"console_format"` // 控制台输出格式：json/text，默认json
+	SplitLevels   []string `json:"split_levels"`   // 另外单独输出到文件的日志等级，如error会将error及以上等级的日志同时写入*.error.*.log
+
+	Async              bool   `json:"async"`                // 是否异步写日志文件
+	AsyncBuffer        int    `json:"async_buffer"`         // 异步写日志缓冲区大小，单位条，默认8192
+	AsyncFlushSize     int    `json:"async_flush_size"`     // 缓冲日志达到该大小时立即写入，单位KB，默认256
+	AsyncFlushInterval int64  `json:"async_flush_interval"` // 缓冲日志写入间隔，单位毫秒，默认1000
+	AsyncOverflow      string `json:"async_overflow"`       // 缓冲区满时的处理策略：block/drop_low/drop_oldest，默认block
+
+	LevelSignal bool              `json:"level_signal"` // 是否允许通过SIGUSR1信号临时开启debug日志
+	DebugTTL    int64             `json:"debug_ttl"`    // 通过信号开启debug日志的有效期，单位秒，默认600
+	Modules     map[string]string `json:"modules"`      // 单独设置日志等级的模块，如 models = "debug"
+	DebugNids   []string          `json:"debug_nids"`   // 输出debug日志的用户
+	DebugSecret string            `json:"debug_secret"` // debug请求头签名密钥，为空时不支持通过请求头开启debug日志
 }
 
 var (
 	once             sync.Once
-	baseLogPath      string                                    // log文件路径
-	currLogFile      *os.File                                  // 用于记录前log文件
-	currLogFileName  string                                    // 用于记录当前log文件名
-	lock             sync.Mutex                                // 切换文件时需要加锁
-	option           *Option                                   // log初始化参数
-	logTimeFormat    string     = "2006-01-02 15:04:05.000000" //日志时间输入出格式
-	changeFileSpec   string     = "0 0 0 */1 * ?"              // 切换日志文件定时任务配置，每天零晨切换
-	clearHistorySpec string     = "0 0 1 */1 * ?"              // 清除历史日志定时任务配置，每天1点清理
-	lofFileFormat    string     = "2006-01-02"                 // log文件名时间格式，每天一个文件
+	baseLogPath      string                                         // log文件路径
+	currWriter       *rotateWriter                                  // 当前log文件
+	currLogFileName  string                                         // 用于记录当前log文件名
+	levelWriters     []*rotateWriter                                // 按等级单独输出的log文件
+	currAsync        *asyncWriter                                   // 异步写日志时的缓冲writer
+	lock             sync.Mutex                                     // 切换文件时需要加锁
+	option           *Option                                        // log初始化参数
+	logTimeFormat    string          = "2006-01-02 15:04:05.000000" //日志时间输入出格式
+	changeFileSpec   string          = "0 0 0 */1 * ?"              // 切换日志文件定时任务配置，每天零晨切换
+	clearHistorySpec string          = "0 0 1 */1 * ?"              // 清除历史日志定时任务配置，每天1点清理
+	lofFileFormat    string          = "2006-01-02"                 // log文件名时间格式，每天一个文件
 )
 
 // SendRobotTxtMsg 给钉钉机器人发送消息
@@ -104,6 +126,7 @@ func SendRobotTxtMsg(msg string) error {
 }
 
 // SendRobotTxtMsgHook 发送钉钉消息hook
+// Deprecated: 同步发送会阻塞日志调用，InitLogrus已改为使用alert组件的异步告警hook
 type SendRobotTxtMsgHook struct {
 }
 
@@ -166,6 +189,9 @@ func InitLogrus(opt *Option) error {
 		// 设置日志文件
 		setLogFile()
 
+		// Fatal退出前写入缓冲区中的日志
+		logrus.RegisterExitHandler(Flush)
+
 		// 定时清理历史日志
 		clearHistoryLog()
 
@@ -182,10 +208,40 @@ func InitLogrus(opt *Option) error {
 		})
 
 		//设置日志等级
-		logrus.SetLevel(logrus.Level(opt.Level))
+		if err := initLevel(opt); err != nil {
+			logrus.SetLevel(logrus.Level(opt.Level))
+			logrus.Error("日志等级初始化失败：", err)
+		}
+
+		// 同时输出到控制台
+		if err := addConsoleHook(opt); err != nil {
+			logrus.Error("控制台日志初始化失败：", err)
+		}
 
-		// 日志添加发钉钉消息hook
-		logrus.AddHook(&SendRobotTxtMsgHook{})
+		// 按等级单独输出到文件
+		if err := addLevelHooks(opt); err != nil {
+			logrus.Error("按等级输出日志初始化失败：", err)
+		}
+
+		// 设置默认日志对象
+		if logger, err := newAdapter(opt); err != nil {
+			logrus.Error("日志适配器初始化失败：", err)
+		} else {
+			SetLogger(logger)
+		}
+
+		// 日志添加告警hook，告警异步发送，不阻塞日志调用
+		if err := alert.Init(); err != nil {
+			logrus.Error("告警初始化失败：", err)
+		} else if alerter := alert.GetAlerter(); alerter != nil {
+			logrus.AddHook(alert.NewHook(alerter))
+		}
+
+		if opt.Adapter == AdapterSlog {
+			if list := slogUnsupported(opt, alert.GetAlerter() != nil); len(list) > 0 {
+				L().Warnf("log adapter %s does not support %s, they only take effect with %s", AdapterSlog, strings.Join(list, ", "), AdapterLogrus)
+			}
+		}
 	})
 	return nil
 }
@@ -200,74 +256,120 @@ func NewEngineLog(actID, serial, nid string) *logrus.Entry {
 }
 
 func setLogFile() {
-	var changeFile = func() {
+	lock.Lock()
+	opt := option
+	prefix := baseLogPath
+	lock.Unlock()
+	if opt == nil {
+		return
+	}
+
+	w, err := newRotateWriter(prefix, opt, func(name string) {
 		lock.Lock()
-		defer lock.Unlock()
+		currLogFileName = name
+		lock.Unlock()
+	}, clearAllHistory)
+	if err != nil {
+		fmt.Println("open log file error:", err.Error())
+		return
+	}
 
-		logFile := baseLogPath + time.Now().Format(lofFileFormat) + ".log"
-		if currLogFileName != logFile {
-			file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-			if err != nil {
-				fmt.Println("open log file error:", err.Error())
-				return
-			}
-			logrus.SetOutput(file)
-			if currLogFile != nil {
-				currLogFile.Close()
-			}
-			currLogFileName = logFile
-			currLogFile = file
+	var async *asyncWriter
+	if opt.Async {
+		async, err = newAsyncWriter(w, opt)
+		if err != nil {
+			fmt.Println("init async log writer error:", err.Error())
 		}
 	}
+	if async != nil {
+		logrus.SetOutput(async)
+	} else {
+		logrus.SetOutput(w)
+	}
 
-	// 程序启动时设置一次日志文件
-	changeFile()
+	lock.Lock()
+	old, oldAsync := currWriter, currAsync
+	currWriter, currAsync = w, async
+	lock.Unlock()
+	if oldAsync != nil {
+		oldAsync.Close()
+	}
+	if old != nil {
+		old.Close()
+	}
 
-	// 定时切换日志文件
+	// 定时检查切换日志文件，写入日志时也会检查
+	spec := changeFileSpec
+	if opt.RotateTime == RotateHourly {
+		spec = hourlyChangeSpec
+	}
 	c := cron.New()
-	_ = c.AddFunc(changeFileSpec, changeFile)
+	_ = c.AddFunc(spec, w.Check)
 	c.Start()
 }
 
-//  定时清空历史log
-func clearHistoryLog() {
-	c := cron.New()
-	logfile := filepath.Base(os.Args[0])
-
-	var clear = func() {
-		lock.Lock()
-		defer lock.Unlock()
-
-		dir, err := ioutil.ReadDir(option.Dir)
-		if err != nil {
-			return
-		}
+// clearAllHistory 清理所有日志文件的历史文件
+func clearAllHistory() {
+	lock.Lock()
+	opt := option
+	writers := append([]*rotateWriter{currWriter}, levelWriters...)
+	lock.Unlock()
+	if opt == nil {
+		return
+	}
 
-		for _, fi := range dir {
-			name := fi.Name()
-			if !fi.IsDir() &&
-				strings.HasPrefix(name, logfile) &&
-				strings.HasSuffix(name, ".log") &&
-				fi.ModTime().Unix() <= time.Now().Add(-time.Hour*24*time.Duration(option.MaxDays)).Unix() {
-				os.Remove(option.Dir + name) // 删除历史文件
-			}
+	for _, w := range writers {
+		if w != nil {
+			clearHistory(w.prefix, w.Name(), opt)
 		}
 	}
+}
+
+//  定时清空历史log
+func clearHistoryLog() {
+	c := cron.New()
 
 	// 启动时清理一次
-	clear()
+	clearAllHistory()
 
-	_ = c.AddFunc(clearHistorySpec, clear)
+	_ = c.AddFunc(clearHistorySpec, clearAllHistory)
 	c.Start()
 }
 
-// callerPrettyfier 格式化log文件名与函数名
+// callerPrettyfier 格式化log文件名与函数名，通过Logger接口输出时使用调用接口的位置
 func callerPrettyfier(caller *runtime.Frame) (function string, file string) {
+	if strings.HasPrefix(caller.Function, logrusAdapterPrefix) {
+		if frame, ok := adapterCaller(); ok {
+			caller = frame
+		}
+	}
 	fileName := filepath.Base(caller.File) + ":" + strconv.Itoa(caller.Line)
 	funcName := filepath.Base(caller.Function)
 	return funcName, fileName
 }
 
+// Flush 异步写日志时，将缓冲区中的日志立即写入文件
+func Flush() {
+	lock.Lock()
+	async := currAsync
+	lock.Unlock()
+
+	if async != nil {
+		async.Flush()
+	}
+}
+
+// Close 服务退出时调用，写入缓冲区中的日志并停止异步写入，之后的日志同步写入文件
+func Close() {
+	lock.Lock()
+	async := currAsync
+	lock.Unlock()
+
+	if async != nil {
+		async.Close()
+	}
+}
+
 // CurrLogFileName 返回当前log文件名
 func CurrLogFileName() string {
 	lock.Lock()
@@ -0,0 +1,110 @@
+package log
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AdapterLogrus 使用logrus输出日志
+	AdapterLogrus = "logrus"
+	// AdapterSlog 使用标准库log/slog输出日志
+	AdapterSlog = "slog"
+)
+
+// Level 日志等级，取值与logrus一致，配置中的level可以直接使用
+type Level = logrus.Level
+
+// Fields 日志字段
+type Fields map[string]interface{}
+
+// Logger 日志接口，业务代码通过该接口输出日志，底层可以是logrus、slog或测试用的内存日志
+type Logger interface {
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+	Fatal(args ...interface{})
+	Panic(args ...interface{})
+
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+	Panicf(format string, args ...interface{})
+
+	// WithField 返回带有字段的日志对象
+	WithField(key string, value interface{}) Logger
+	// WithFields 返回带有多个字段的日志对象
+	WithFields(fields Fields) Logger
+	// WithError 返回带有error字段的日志对象
+	WithError(err error) Logger
+	// Enabled 判断等级是否会输出，用于避免构造不会输出的日志内容
+	Enabled(level Level) bool
+}
+
+// loggerHolder atomic.Value要求每次存入的类型相同
+type loggerHolder struct {
+	logger Logger
+}
+
+var (
+	defaultLogger atomic.Value
+	dropHandler   atomic.Value // 异步写日志丢弃日志时的回调
+)
+
+func init() {
+	defaultLogger.Store(loggerHolder{NewLogrus(logrus.StandardLogger())})
+}
+
+// L 返回默认日志对象，InitLogrus之前为标准logrus日志对象
+func L() Logger {
+	return defaultLogger.Load().(loggerHolder).logger
+}
+
+// SetLogger 替换默认日志对象，测试中可替换为内存日志
+func SetLogger(logger Logger) {
+	defaultLogger.Store(loggerHolder{logger})
+}
+
+// SetDropHandler 设置异步写日志丢弃日志时的回调，用于上报监控
+func SetDropHandler(handler func(level string)) {
+	dropHandler.Store(handler)
+}
+
+// newAdapter 根据配置创建默认日志对象
+func newAdapter(opt *Option) (Logger, error) {
+	switch opt.Adapter {
+	case "", AdapterLogrus:
+		return NewLogrus(logrus.StandardLogger()), nil
+	case AdapterSlog:
+		return newSlogLogger(stdOutput{}, opt.ReportCaller, GetLevel)
+	}
+	return nil, fmt.Errorf("unknown log adapter (%s)", opt.Adapter)
+}
+
+// slogUnsupported 返回已配置但slog适配器不支持的功能，这些功能通过logrus hook实现，slog适配器的日志不经过logrus
+func slogUnsupported(opt *Option, alerting bool) []string {
+	var list []string
+	if opt.Console != "" {
+		list = append(list, "console")
+	}
+	if len(opt.SplitLevels) > 0 {
+		list = append(list, "split_levels")
+	}
+	if alerting {
+		list = append(list, "alert")
+	}
+	return list
+}
+
+// withLevel 返回按指定等级输出的日志对象，用于模块等级与单个请求的debug日志
+func withLevel(logger Logger, child *logrus.Logger, level func() Level) Logger {
+	if l, ok := logger.(*slogLogger); ok {
+		return l.withLevel(level)
+	}
+	return NewLogrus(child)
+}
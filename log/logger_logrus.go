@@ -0,0 +1,80 @@
+package log
+
+import (
+	"runtime"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logrusAdapterPrefix logrus适配器方法的函数名前缀，获取调用位置时需要跳过
+const logrusAdapterPrefix = "github.com/yuanzhangcai/chaos/log.(*logrusLogger)."
+
+// logrusLogger logrus适配器
+type logrusLogger struct {
+	entry *logrus.Entry
+}
+
+// NewLogrus 使用logrus日志对象创建Logger
+func NewLogrus(logger *logrus.Logger) Logger {
+	return &logrusLogger{entry: logrus.NewEntry(logger)}
+}
+
+// Entry 返回底层的logrus.Entry，用于需要直接使用logrus的场景
+func (c *logrusLogger) Entry() *logrus.Entry {
+	return c.entry
+}
+
+func (c *logrusLogger) Debug(args ...interface{}) { c.entry.Debug(args...) }
+func (c *logrusLogger) Info(args ...interface{})  { c.entry.Info(args...) }
+func (c *logrusLogger) Warn(args ...interface{})  { c.entry.Warn(args...) }
+func (c *logrusLogger) Error(args ...interface{}) { c.entry.Error(args...) }
+func (c *logrusLogger) Fatal(args ...interface{}) { c.entry.Fatal(args...) }
+func (c *logrusLogger) Panic(args ...interface{}) { c.entry.Panic(args...) }
+
+func (c *logrusLogger) Debugf(format string, args ...interface{}) { c.entry.Debugf(format, args...) }
+func (c *logrusLogger) Infof(format string, args ...interface{})  { c.entry.Infof(format, args...) }
+func (c *logrusLogger) Warnf(format string, args ...interface{})  { c.entry.Warnf(format, args...) }
+func (c *logrusLogger) Errorf(format string, args ...interface{}) { c.entry.Errorf(format, args...) }
+func (c *logrusLogger) Fatalf(format string, args ...interface{}) { c.entry.Fatalf(format, args...) }
+func (c *logrusLogger) Panicf(format string, args ...interface{}) { c.entry.Panicf(format, args...) }
+
+// WithField 返回带有字段的日志对象
+func (c *logrusLogger) WithField(key string, value interface{}) Logger {
+	return &logrusLogger{entry: c.entry.WithField(key, value)}
+}
+
+// WithFields 返回带有多个字段的日志对象
+func (c *logrusLogger) WithFields(fields Fields) Logger {
+	return &logrusLogger{entry: c.entry.WithFields(logrus.Fields(fields))}
+}
+
+// WithError 返回带有error字段的日志对象
+func (c *logrusLogger) WithError(err error) Logger {
+	return &logrusLogger{entry: c.entry.WithError(err)}
+}
+
+// Enabled 判断等级是否会输出
+func (c *logrusLogger) Enabled(level Level) bool {
+	return c.entry.Logger.IsLevelEnabled(level)
+}
+
+// adapterCaller logrus记录的调用位置是适配器方法，从调用栈中找到调用适配器的位置
+func adapterCaller() (*runtime.Frame, bool) {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	found := false
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, logrusAdapterPrefix) {
+			found = true
+		} else if found {
+			return &frame, true
+		}
+		if !more {
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !go1.21
+// +build !go1.21
+
+package log
+
+import (
+	"fmt"
+	"io"
+)
+
+// slogLogger go1.21之前没有log/slog，不支持slog适配器
+type slogLogger struct {
+	Logger
+}
+
+func newSlogLogger(w io.Writer, reportCaller bool, level func() Level) (Logger, error) {
+	return nil, fmt.Errorf("log adapter %s requires go1.21", AdapterSlog)
+}
+
+func (c *slogLogger) withLevel(level func() Level) Logger {
+	return c
+}
@@ -0,0 +1,215 @@
+//go:build go1.21
+// +build go1.21
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// slog中没有的等级
+const (
+	slogLevelTrace = slog.Level(-8)
+	slogLevelFatal = slog.Level(12)
+	slogLevelPanic = slog.Level(16)
+)
+
+// slogLogger slog适配器，输出格式与logrus的json格式一致，日志等级由level决定，可以跟随运行时的等级调整
+// 注意：console、split_levels与告警是logrus的hook，使用slog时不生效
+type slogLogger struct {
+	handler slog.Handler
+	level   func() Level
+}
+
+// NewSlog 使用slog.Handler创建Logger，level返回当前日志等级
+func NewSlog(handler slog.Handler, level func() Level) Logger {
+	return &slogLogger{handler: handler, level: level}
+}
+
+// newSlogLogger 创建输出到w的slog json日志对象
+func newSlogLogger(w io.Writer, reportCaller bool, level func() Level) (Logger, error) {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		AddSource:   reportCaller,
+		Level:       slogLevelTrace, // 由Enabled判断等级
+		ReplaceAttr: replaceSlogAttr,
+	})
+	return NewSlog(handler, level), nil
+}
+
+// replaceSlogAttr 将slog的内置字段转换为logrus的字段名与格式
+func replaceSlogAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+
+	switch a.Key {
+	case slog.TimeKey:
+		return slog.String("atime", a.Value.Time().Format(logTimeFormat))
+	case slog.LevelKey:
+		return slog.String("level", fromSlogLevel(a.Value.Any().(slog.Level)).String())
+	case slog.SourceKey:
+		if source, ok := a.Value.Any().(*slog.Source); ok {
+			return slog.Group("",
+				slog.String("file", filepath.Base(source.File)+":"+strconv.Itoa(source.Line)),
+				slog.String("func", filepath.Base(source.Function)),
+			)
+		}
+	}
+	return a
+}
+
+func toSlogLevel(level Level) slog.Level {
+	switch level {
+	case logrus.PanicLevel:
+		return slogLevelPanic
+	case logrus.FatalLevel:
+		return slogLevelFatal
+	case logrus.ErrorLevel:
+		return slog.LevelError
+	case logrus.WarnLevel:
+		return slog.LevelWarn
+	case logrus.InfoLevel:
+		return slog.LevelInfo
+	case logrus.DebugLevel:
+		return slog.LevelDebug
+	}
+	return slogLevelTrace
+}
+
+func fromSlogLevel(level slog.Level) Level {
+	switch {
+	case level >= slogLevelPanic:
+		return logrus.PanicLevel
+	case level >= slogLevelFatal:
+		return logrus.FatalLevel
+	case level >= slog.LevelError:
+		return logrus.ErrorLevel
+	case level >= slog.LevelWarn:
+		return logrus.WarnLevel
+	case level >= slog.LevelInfo:
+		return logrus.InfoLevel
+	case level >= slog.LevelDebug:
+		return logrus.DebugLevel
+	}
+	return logrus.TraceLevel
+}
+
+// log 输出日志，调用位置跳过适配器方法
+func (c *slogLogger) log(level Level, msg string) {
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	record := slog.NewRecord(time.Now(), toSlogLevel(level), msg, pcs[0])
+	if err := c.handler.Handle(context.Background(), record); err != nil {
+		fmt.Fprintln(os.Stderr, "write log error:", err.Error())
+	}
+
+	switch level {
+	case logrus.FatalLevel:
+		logrus.Exit(1)
+	case logrus.PanicLevel:
+		panic(msg)
+	}
+}
+
+func (c *slogLogger) Debug(args ...interface{}) {
+	if c.Enabled(logrus.DebugLevel) {
+		c.log(logrus.DebugLevel, fmt.Sprint(args...))
+	}
+}
+
+func (c *slogLogger) Info(args ...interface{}) {
+	if c.Enabled(logrus.InfoLevel) {
+		c.log(logrus.InfoLevel, fmt.Sprint(args...))
+	}
+}
+
+func (c *slogLogger) Warn(args ...interface{}) {
+	if c.Enabled(logrus.WarnLevel) {
+		c.log(logrus.WarnLevel, fmt.Sprint(args...))
+	}
+}
+
+func (c *slogLogger) Error(args ...interface{}) {
+	if c.Enabled(logrus.ErrorLevel) {
+		c.log(logrus.ErrorLevel, fmt.Sprint(args...))
+	}
+}
+
+func (c *slogLogger) Fatal(args ...interface{}) {
+	c.log(logrus.FatalLevel, fmt.Sprint(args...))
+}
+
+func (c *slogLogger) Panic(args ...interface{}) {
+	c.log(logrus.PanicLevel, fmt.Sprint(args...))
+}
+
+func (c *slogLogger) Debugf(format string, args ...interface{}) {
+	if c.Enabled(logrus.DebugLevel) {
+		c.log(logrus.DebugLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+func (c *slogLogger) Infof(format string, args ...interface{}) {
+	if c.Enabled(logrus.InfoLevel) {
+		c.log(logrus.InfoLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+func (c *slogLogger) Warnf(format string, args ...interface{}) {
+	if c.Enabled(logrus.WarnLevel) {
+		c.log(logrus.WarnLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+func (c *slogLogger) Errorf(format string, args ...interface{}) {
+	if c.Enabled(logrus.ErrorLevel) {
+		c.log(logrus.ErrorLevel, fmt.Sprintf(format, args...))
+	}
+}
+
+func (c *slogLogger) Fatalf(format string, args ...interface{}) {
+	c.log(logrus.FatalLevel, fmt.Sprintf(format, args...))
+}
+
+func (c *slogLogger) Panicf(format string, args ...interface{}) {
+	c.log(logrus.PanicLevel, fmt.Sprintf(format, args...))
+}
+
+// WithField 返回带有字段的日志对象
+func (c *slogLogger) WithField(key string, value interface{}) Logger {
+	return &slogLogger{handler: c.handler.WithAttrs([]slog.Attr{slog.Any(key, value)}), level: c.level}
+}
+
+// WithFields 返回带有多个字段的日志对象
+func (c *slogLogger) WithFields(fields Fields) Logger {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for key, value := range fields {
+		attrs = append(attrs, slog.Any(key, value))
+	}
+	return &slogLogger{handler: c.handler.WithAttrs(attrs), level: c.level}
+}
+
+// WithError 返回带有error字段的日志对象
+func (c *slogLogger) WithError(err error) Logger {
+	return c.WithField(logrus.ErrorKey, err)
+}
+
+// Enabled 判断等级是否会输出
+func (c *slogLogger) Enabled(level Level) bool {
+	return c.level() >= level
+}
+
+// withLevel 返回按指定等级输出的日志对象
+func (c *slogLogger) withLevel(level func() Level) Logger {
+	return &slogLogger{handler: c.handler, level: level}
+}
@@ -0,0 +1,108 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLogrus(out *bytes.Buffer) *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(out)
+	logger.SetReportCaller(true)
+	logger.SetFormatter(&logrus.JSONFormatter{
+		TimestampFormat:  logTimeFormat,
+		FieldMap:         logrus.FieldMap{logrus.FieldKeyTime: "atime"},
+		CallerPrettyfier: callerPrettyfier,
+	})
+	return logger
+}
+
+func decodeLine(t *testing.T, out *bytes.Buffer) map[string]interface{} {
+	data := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &data))
+	out.Reset()
+	return data
+}
+
+func TestLogrusAdapter(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := NewLogrus(newTestLogrus(out))
+
+	logger.WithField("act_id", "19").WithError(errors.New("failed")).Info("info")
+	data := decodeLine(t, out)
+	assert.Equal(t, "info", data["msg"])
+	assert.Equal(t, "19", data["act_id"])
+	assert.Equal(t, "failed", data["error"])
+	assert.NotEmpty(t, data["atime"])
+	// 调用位置为调用Logger接口的位置，而不是适配器
+	assert.Contains(t, data["file"], "logger_test.go:")
+	assert.Equal(t, "log.TestLogrusAdapter", data["func"])
+
+	logger.Debugf("debug %d", 1)
+	assert.Equal(t, 0, out.Len())
+	assert.False(t, logger.Enabled(logrus.DebugLevel))
+}
+
+func TestSlogAdapter(t *testing.T) {
+	out := &bytes.Buffer{}
+	level := logrus.InfoLevel
+	logger, err := newSlogLogger(out, true, func() Level { return level })
+	if err != nil {
+		t.Skip(err)
+	}
+
+	logger.WithFields(Fields{"act_id": "19"}).Warnf("warn %d", 1)
+	data := decodeLine(t, out)
+	assert.Equal(t, "warn 1", data["msg"])
+	assert.Equal(t, "warning", data["level"])
+	assert.Equal(t, "19", data["act_id"])
+	assert.NotEmpty(t, data["atime"])
+	assert.Contains(t, data["file"], "logger_test.go:")
+	assert.Equal(t, "log.TestSlogAdapter", data["func"])
+
+	// 等级跟随level变化
+	logger.Debug("debug")
+	assert.Equal(t, 0, out.Len())
+	level = logrus.DebugLevel
+	logger.Debug("debug")
+	assert.Equal(t, "debug", decodeLine(t, out)["level"])
+}
+
+func TestMemoryLogger(t *testing.T) {
+	logger := NewMemoryLogger(logrus.InfoLevel)
+	logger.WithField("nid", "10001").Error("query failed")
+	logger.Debug("debug")
+	logger.Fatal("fatal")
+
+	entries := logger.Entries()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "10001", entries[0].Fields["nid"])
+	assert.True(t, logger.Contains(logrus.ErrorLevel, "failed"))
+	assert.True(t, logger.Contains(logrus.FatalLevel, "fatal"))
+	assert.False(t, logger.Contains(logrus.DebugLevel, "debug"))
+
+	logger.Reset()
+	assert.Empty(t, logger.Entries())
+}
+
+func TestSetLogger(t *testing.T) {
+	old := L()
+	defer SetLogger(old)
+
+	logger := NewMemoryLogger(logrus.InfoLevel)
+	SetLogger(logger)
+	FromContext(&gin.Context{}).Info("request")
+	assert.True(t, logger.Contains(logrus.InfoLevel, "request"))
+
+	_, err := newAdapter(&Option{Adapter: "unknown"})
+	assert.NotNil(t, err)
+
+	assert.Empty(t, slogUnsupported(&Option{Adapter: AdapterSlog}, false))
+	assert.Equal(t, []string{"console", "split_levels", "alert"}, slogUnsupported(&Option{Adapter: AdapterSlog, Console: "stdout", SplitLevels: []string{"error"}}, true))
+}
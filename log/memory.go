@@ -0,0 +1,132 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryEntry 内存日志中的一条日志
+type MemoryEntry struct {
+	Level   Level
+	Message string
+	Fields  Fields
+}
+
+// memoryStore 内存日志的存储，WithField返回的日志对象共用同一存储
+type memoryStore struct {
+	lock    sync.Mutex
+	level   Level
+	entries []MemoryEntry
+}
+
+// MemoryLogger 将日志保存在内存中的Logger，用于测试中断言输出的日志
+// Fatal与Panic只记录日志，不退出也不panic
+type MemoryLogger struct {
+	store  *memoryStore
+	fields Fields
+}
+
+// NewMemoryLogger 创建内存日志，只保存level及以上等级的日志
+func NewMemoryLogger(level Level) *MemoryLogger {
+	return &MemoryLogger{store: &memoryStore{level: level}, fields: Fields{}}
+}
+
+// Entries 返回已保存的日志
+func (c *MemoryLogger) Entries() []MemoryEntry {
+	c.store.lock.Lock()
+	defer c.store.lock.Unlock()
+	return append([]MemoryEntry(nil), c.store.entries...)
+}
+
+// Contains 判断是否有指定等级且内容包含msg的日志
+func (c *MemoryLogger) Contains(level Level, msg string) bool {
+	for _, entry := range c.Entries() {
+		if entry.Level == level && strings.Contains(entry.Message, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reset 清空已保存的日志
+func (c *MemoryLogger) Reset() {
+	c.store.lock.Lock()
+	defer c.store.lock.Unlock()
+	c.store.entries = nil
+}
+
+func (c *MemoryLogger) log(level Level, msg string) {
+	if !c.Enabled(level) {
+		return
+	}
+
+	fields := make(Fields, len(c.fields))
+	for key, value := range c.fields {
+		fields[key] = value
+	}
+
+	c.store.lock.Lock()
+	defer c.store.lock.Unlock()
+	c.store.entries = append(c.store.entries, MemoryEntry{Level: level, Message: msg, Fields: fields})
+}
+
+func (c *MemoryLogger) Debug(args ...interface{}) { c.log(logrus.DebugLevel, fmt.Sprint(args...)) }
+func (c *MemoryLogger) Info(args ...interface{})  { c.log(logrus.InfoLevel, fmt.Sprint(args...)) }
+func (c *MemoryLogger) Warn(args ...interface{})  { c.log(logrus.WarnLevel, fmt.Sprint(args...)) }
+func (c *MemoryLogger) Error(args ...interface{}) { c.log(logrus.ErrorLevel, fmt.Sprint(args...)) }
+func (c *MemoryLogger) Fatal(args ...interface{}) { c.log(logrus.FatalLevel, fmt.Sprint(args...)) }
+func (c *MemoryLogger) Panic(args ...interface{}) { c.log(logrus.PanicLevel, fmt.Sprint(args...)) }
+
+func (c *MemoryLogger) Debugf(format string, args ...interface{}) {
+	c.log(logrus.DebugLevel, fmt.Sprintf(format, args...))
+}
+
+func (c *MemoryLogger) Infof(format string, args ...interface{}) {
+	c.log(logrus.InfoLevel, fmt.Sprintf(format, args...))
+}
+
+func (c *MemoryLogger) Warnf(format string, args ...interface{}) {
+	c.log(logrus.WarnLevel, fmt.Sprintf(format, args...))
+}
+
+func (c *MemoryLogger) Errorf(format string, args ...interface{}) {
+	c.log(logrus.ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+func (c *MemoryLogger) Fatalf(format string, args ...interface{}) {
+	c.log(logrus.FatalLevel, fmt.Sprintf(format, args...))
+}
+
+func (c *MemoryLogger) Panicf(format string, args ...interface{}) {
+	c.log(logrus.PanicLevel, fmt.Sprintf(format, args...))
+}
+
+// WithField 返回带有字段的日志对象
+func (c *MemoryLogger) WithField(key string, value interface{}) Logger {
+	return c.WithFields(Fields{key: value})
+}
+
+// WithFields 返回带有多个字段的日志对象
+func (c *MemoryLogger) WithFields(fields Fields) Logger {
+	all := make(Fields, len(c.fields)+len(fields))
+	for key, value := range c.fields {
+		all[key] = value
+	}
+	for key, value := range fields {
+		all[key] = value
+	}
+	return &MemoryLogger{store: c.store, fields: all}
+}
+
+// WithError 返回带有error字段的日志对象
+func (c *MemoryLogger) WithError(err error) Logger {
+	return c.WithField(logrus.ErrorKey, err)
+}
+
+// Enabled 判断等级是否会输出
+func (c *MemoryLogger) Enabled(level Level) bool {
+	return c.store.level >= level
+}
@@ -0,0 +1,297 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RotateDaily 每天切换日志文件
+	RotateDaily = "daily"
+	// RotateHourly 每小时切换日志文件
+	RotateHourly = "hourly"
+	// RotateNone 不按时间切换日志文件
+	RotateNone = "none"
+
+	hourlyFileFormat = "2006-01-02_15" // 按小时切换时的log文件名时间格式
+	hourlyChangeSpec = "0 0 * * * ?"   // 按小时切换时的定时任务配置
+)
+
+// rotateWriter 支持按时间与大小切换的日志文件
+// 当前文件名为 前缀+时间+.log，按大小切换时将当前文件重命名为 前缀+时间+.序号+.log
+type rotateWriter struct {
+	lock     sync.Mutex
+	prefix   string            // 文件名前缀(含路径)，如 /data/logs/chaos.
+	format   string            // 文件名时间格式，为空时不按时间切换
+	maxSize  int64             // 单个文件最大字节数，0表示不按大小切换
+	compress bool              // 是否压缩切换后的文件
+	onOpen   func(name string) // 打开新文件后的回调
+	onRotate func()            // 切换完成(含压缩)后的回调，用于清理历史文件
+	file     *os.File          // 当前文件
+	name     string            // 当前文件名
+	size     int64             // 当前文件大小
+	next     time.Time         // 下次按时间切换的时间
+	wg       sync.WaitGroup    // 等待压缩完成
+	timeNow  func() time.Time  // 获取当前时间，测试时替换
+}
+
+func newRotateWriter(prefix string, opt *Option, onOpen func(name string), onRotate func()) (*rotateWriter, error) {
+	w := &rotateWriter{
+		prefix:   prefix,
+		maxSize:  opt.MaxSize * 1024 * 1024,
+		compress: opt.Compress,
+		onOpen:   onOpen,
+		onRotate: onRotate,
+		timeNow:  time.Now,
+	}
+
+	switch opt.RotateTime {
+	case "", RotateDaily:
+		w.format = lofFileFormat
+	case RotateHourly:
+		w.format = hourlyFileFormat
+	case RotateNone:
+	default:
+		return nil, fmt.Errorf("unknown log rotate time (%s)", opt.RotateTime)
+	}
+
+	if err := w.open(w.timeNow()); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write 实现io.Writer，写入前检查是否需要切换文件
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	now := w.timeNow()
+	if !w.next.IsZero() && !now.Before(w.next) {
+		w.rotateTime(now)
+	} else if w.maxSize > 0 && w.size+int64(len(p)) > w.maxSize && w.size > 0 {
+		w.rotateSize(now)
+	}
+
+	if w.file == nil {
+		return 0, fmt.Errorf("log file is not open")
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Check 检查是否需要按时间切换文件，由定时任务调用，保证没有日志写入时也能按时切换
+func (w *rotateWriter) Check() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	now := w.timeNow()
+	if !w.next.IsZero() && !now.Before(w.next) {
+		w.rotateTime(now)
+	}
+}
+
+// Name 返回当前文件名
+func (w *rotateWriter) Name() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.name
+}
+
+// Close 关闭当前文件，并等待压缩完成
+func (w *rotateWriter) Close() error {
+	w.lock.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.lock.Unlock()
+
+	w.wg.Wait()
+	return err
+}
+
+// open 打开当前时间对应的日志文件
+func (w *rotateWriter) open(now time.Time) error {
+	name := w.prefix
+	if w.format != "" {
+		name += now.Format(w.format) + "."
+	}
+	name += "log"
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file = file
+	w.name = name
+	w.size = size
+	w.next = w.nextRotateTime(now)
+
+	if w.onOpen != nil {
+		w.onOpen(name)
+	}
+	return nil
+}
+
+// nextRotateTime 计算下次按时间切换的时间
+func (w *rotateWriter) nextRotateTime(now time.Time) time.Time {
+	switch w.format {
+	case lofFileFormat:
+		y, m, d := now.Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+	case hourlyFileFormat:
+		return now.Truncate(time.Hour).Add(time.Hour)
+	}
+	return time.Time{}
+}
+
+// rotateTime 按时间切换，旧文件保留原文件名
+func (w *rotateWriter) rotateTime(now time.Time) {
+	old := w.name
+	if err := w.open(now); err != nil {
+		fmt.Println("open log file error:", err.Error())
+		return
+	}
+	w.rotated(old)
+}
+
+// rotateSize 按大小切换，将当前文件重命名为带序号的文件名
+func (w *rotateWriter) rotateSize(now time.Time) {
+	base := strings.TrimSuffix(w.name, ".log")
+	var backup string
+	for i := 1; ; i++ {
+		backup = base + "." + strconv.Itoa(i) + ".log"
+		if !exists(backup) && !exists(backup+".gz") {
+			break
+		}
+	}
+
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	if err := os.Rename(w.name, backup); err != nil {
+		fmt.Println("rename log file error:", err.Error())
+	}
+
+	if err := w.open(now); err != nil {
+		fmt.Println("open log file error:", err.Error())
+		return
+	}
+	w.rotated(backup)
+}
+
+// rotated 压缩切换下来的文件，并清理历史文件
+func (w *rotateWriter) rotated(name string) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		if w.compress {
+			if err := compressFile(name); err != nil {
+				fmt.Println("compress log file error:", err.Error())
+			}
+		}
+		if w.onRotate != nil {
+			w.onRotate()
+		}
+	}()
+}
+
+// compressFile gzip压缩文件，成功后删除原文件
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+
+	src.Close()
+	return os.Remove(name)
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// clearHistory 按保留天数、文件数与总大小清理历史日志，不清理当前文件
+func clearHistory(prefix, current string, opt *Option) {
+	dir := filepath.Dir(prefix)
+	base := filepath.Base(prefix)
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	var files []os.FileInfo
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasPrefix(name, base) || filepath.Join(dir, name) == filepath.Clean(current) {
+			continue
+		}
+
+		// 前缀之后必须是时间，以免把按等级输出的文件(如chaos.error.*)当成主日志文件
+		rest := name[len(base):]
+		if rest == "" || rest[0] < '0' || rest[0] > '9' {
+			continue
+		}
+		if strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz") {
+			files = append(files, fi)
+		}
+	}
+
+	// 按修改时间从新到旧排序
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+
+	expire := time.Now().Add(-time.Hour * 24 * time.Duration(opt.MaxDays))
+	maxTotal := opt.MaxTotalSize * 1024 * 1024
+	var total int64
+	for i, fi := range files {
+		total += fi.Size()
+		if (opt.MaxDays > 0 && fi.ModTime().Unix() <= expire.Unix()) ||
+			(opt.MaxBackups > 0 && i >= opt.MaxBackups) ||
+			(maxTotal > 0 && total > maxTotal) {
+			os.Remove(filepath.Join(dir, fi.Name())) // 删除历史文件
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func listFiles(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	opt := &Option{MaxSize: 1, Compress: true, RotateTime: RotateNone}
+
+	w, err := newRotateWriter(dir+"/chaos.", opt, nil, nil)
+	assert.Nil(t, err)
+
+	line := bytes.Repeat([]byte("a"), 600*1024)
+	for i := 0; i < 3; i++ {
+		_, err = w.Write(line)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+
+	assert.Equal(t, []string{"chaos.1.log.gz", "chaos.2.log.gz", "chaos.log"}, listFiles(t, dir))
+}
+
+func TestRotateByTime(t *testing.T) {
+	dir := t.TempDir()
+	opt := &Option{RotateTime: RotateHourly}
+
+	var opened []string
+	w, err := newRotateWriter(dir+"/chaos.", opt, func(name string) { opened = append(opened, filepath.Base(name)) }, nil)
+	assert.Nil(t, err)
+
+	now := time.Now().Truncate(time.Hour).Add(time.Hour)
+	w.timeNow = func() time.Time { return now }
+	_, err = w.Write([]byte("next hour\n"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	assert.Equal(t, 2, len(opened))
+	assert.Equal(t, "chaos."+now.Format(hourlyFileFormat)+".log", opened[1])
+	assert.Equal(t, filepath.Join(dir, opened[1]), w.Name())
+
+	_, err = newRotateWriter(dir+"/chaos.", &Option{RotateTime: "weekly"}, nil, nil)
+	assert.NotNil(t, err)
+}
+
+func TestClearHistory(t *testing.T) {
+	dir := t.TempDir()
+	prefix := dir + "/chaos."
+	files := []string{
+		"chaos.2020-08-01.log.gz",
+		"chaos.2020-08-02.log",
+		"chaos.2020-08-03.1.log",
+		"chaos.2020-08-03.log",
+		"chaos.error.2020-08-01.log",
+		"other.2020-08-01.log",
+	}
+	for i, name := range files {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, ioutil.WriteFile(path, bytes.Repeat([]byte("a"), 1024*1024), 0644))
+		mtime := time.Now().Add(-time.Duration(len(files)-i) * time.Hour)
+		assert.Nil(t, os.Chtimes(path, mtime, mtime))
+	}
+
+	// 只保留1个历史文件，当前文件与其它前缀的文件不受影响
+	clearHistory(prefix, prefix+"2020-08-03.log", &Option{MaxBackups: 1})
+	assert.Equal(t, []string{
+		"chaos.2020-08-03.1.log",
+		"chaos.2020-08-03.log",
+		"chaos.error.2020-08-01.log",
+		"other.2020-08-01.log",
+	}, listFiles(t, dir))
+
+	// 总大小超过上限
+	clearHistory(prefix, prefix+"2020-08-03.log", &Option{MaxTotalSize: 1})
+	assert.Equal(t, 4, len(listFiles(t, dir)))
+	clearHistory(prefix, prefix+"2020-08-04.log", &Option{MaxTotalSize: 1})
+	assert.Equal(t, 3, len(listFiles(t, dir)))
+}
+
+func TestWriterHook(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	logger.AddHook(&writerHook{
+		writer:    buf,
+		formatter: &logrus.TextFormatter{DisableTimestamp: true},
+		levels:    []logrus.Level{logrus.ErrorLevel},
+	})
+
+	logger.Info("info")
+	logger.Error("error")
+	assert.Equal(t, "level=error msg=error\n", buf.String())
+
+	assert.NotNil(t, addConsoleHook(&Option{Console: "file"}))
+	assert.NotNil(t, addConsoleHook(&Option{Console: "stdout", ConsoleFormat: "xml"}))
+	assert.NotNil(t, addLevelHooks(&Option{SplitLevels: []string{"unknown"}}))
+}
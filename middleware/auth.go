@@ -0,0 +1,68 @@
+// 接口认证中间件
+
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuanzhangcai/chaos/auth"
+	"github.com/yuanzhangcai/chaos/errors"
+	"github.com/yuanzhangcai/chaos/log"
+)
+
+// Auth 生成认证中间件，按顺序尝试各认证方式，请求中带有某种认证方式的凭证时只使用该方式校验，
+// 认证通过后保存调用方身份，控制器通过Controller.Principal读取，失败时返回401
+func Auth(authenticators ...auth.Authenticator) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		err := auth.ErrNoCredentials
+		for _, one := range authenticators {
+			var p *auth.Principal
+			p, err = one.Authenticate(c.Request)
+			if err == nil {
+				auth.SetPrincipal(c, p)
+				c.Next()
+				return
+			}
+			if err != auth.ErrNoCredentials {
+				break
+			}
+		}
+
+		log.FromContext(c).WithField("path", c.Request.URL.Path).Warn("认证失败：", err)
+		result := map[string]interface{}{
+			"ret": errors.ErrUnauthorized.Code(),
+			"msg": errors.ErrUnauthorized.Msg(),
+		}
+		c.Set("response", result)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, result)
+	}
+}
+
+// JWT 生成JWT认证中间件
+func JWT(v *auth.JWTVerifier) func(c *gin.Context) {
+	return Auth(v)
+}
+
+// HMAC 生成HMAC请求签名认证中间件，用于服务间调用
+func HMAC(v *auth.HMACVerifier) func(c *gin.Context) {
+	return Auth(v)
+}
+
+// APIKey 生成API Key认证中间件
+func APIKey(v *auth.APIKeyVerifier) func(c *gin.Context) {
+	return Auth(v)
+}
+
+// AuthFromConfig 按auth配置生成认证中间件，同时支持配置了密钥的所有认证方式
+func AuthFromConfig() (func(c *gin.Context), error) {
+	opt, err := auth.GetOptionFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	authenticators, err := auth.New(opt)
+	if err != nil {
+		return nil, err
+	}
+	return Auth(authenticators...), nil
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/chaos/auth"
+	"github.com/yuanzhangcai/chaos/controllers"
+	"github.com/yuanzhangcai/chaos/errors"
+	"github.com/yuanzhangcai/config"
+)
+
+func newAuthRouter(ware func(c *gin.Context)) *gin.Engine {
+	r := gin.New()
+	r.Use(ware)
+	r.Any("/principal", func(ctx *gin.Context) {
+		ctl := &controllers.Controller{}
+		ctl.Init(ctx)
+		ctx.String(200, ctl.Principal.Type+":"+ctl.Principal.Subject+":"+ctl.Params.Get("nid"))
+	})
+	return r
+}
+
+func TestAuth(t *testing.T) {
+	jwtVerifier, err := auth.NewJWTVerifier(&auth.JWTOption{Secret: "secret", Algorithms: []string{"HS256"}, Header: "Authorization"})
+	assert.Nil(t, err)
+	keyVerifier, err := auth.NewAPIKeyVerifier(&auth.APIKeyOption{Header: "X-API-Key", Keys: []auth.APIKey{{Name: "ops", Key: "k1"}}})
+	assert.Nil(t, err)
+	hmacVerifier := auth.NewHMACVerifier(&auth.HMACOption{Keys: map[string]string{"order": "secret"}, MaxSkew: 300}, auth.NewMemoryNonceStore())
+	r := newAuthRouter(Auth(jwtVerifier, hmacVerifier, keyVerifier))
+
+	// 没有凭证
+	w := performRequest(r, http.MethodGet, "/principal")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	result := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, float64(errors.ErrUnauthorized.Code()), result["ret"])
+
+	// JWT
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1707357"}).SignedString([]byte("secret"))
+	req := httptest.NewRequest(http.MethodGet, "/principal", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "jwt:1707357:", w.Body.String())
+
+	// token无效时不再尝试其它认证方式
+	req.Header.Set("Authorization", "Bearer abc")
+	req.Header.Set("X-API-Key", "k1")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// HMAC签名，控制器仍然可以读取请求参数
+	req = httptest.NewRequest(http.MethodPost, "/principal", strings.NewReader("nid=1707357"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.Nil(t, auth.Sign(req, "order", "secret"))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hmac:order:1707357", w.Body.String())
+
+	// API Key
+	req = httptest.NewRequest(http.MethodGet, "/principal", nil)
+	req.Header.Set("X-API-Key", "k1")
+	w = httptest.NewRecorder()
+	newAuthRouter(APIKey(keyVerifier)).ServeHTTP(w, req)
+	assert.Equal(t, "api_key:ops:", w.Body.String())
+	w = httptest.NewRecorder()
+	newAuthRouter(JWT(jwtVerifier)).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	newAuthRouter(HMAC(hmacVerifier)).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthFromConfig(t *testing.T) {
+	_ = config.LoadMemory(`{"auth": {"api_key": {"keys": [{"name": "ops", "key": "k1"}]}}}`, "json")
+	ware, err := AuthFromConfig()
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/principal", nil)
+	req.Header.Set("X-API-Key", "k1")
+	w := httptest.NewRecorder()
+	newAuthRouter(ware).ServeHTTP(w, req)
+	assert.Equal(t, "api_key:ops:", w.Body.String())
+
+	_ = config.LoadMemory(`{"auth": {"api_key": {"keys": [{"name": "ops"}]}}}`, "json")
+	_, err = AuthFromConfig()
+	assert.NotNil(t, err)
+}
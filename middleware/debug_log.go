@@ -0,0 +1,19 @@
+// 单个请求debug日志中间件
+
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yuanzhangcai/chaos/log"
+)
+
+// DebugLog 生成单个请求debug日志中间件，用户在debug名单中或带有有效的debug签名请求头时，
+// 请求使用debug等级的日志对象，控制器通过log.FromContext获取
+func DebugLog() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if log.IsDebugRequest(c.Request.FormValue("nid"), c.GetHeader(log.DebugHeader)) {
+			c.Set(log.ContextKey, log.DebugLogger().WithField("nid", c.Request.FormValue("nid")))
+		}
+		c.Next()
+	}
+}
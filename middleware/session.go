@@ -0,0 +1,99 @@
+// 会话与CSRF中间件
+
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuanzhangcai/chaos/errors"
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/chaos/session"
+)
+
+// saveWriter 在写入响应头之前保存会话，保证Set-Cookie能够发送
+type saveWriter struct {
+	gin.ResponseWriter
+	save func()
+}
+
+func (c *saveWriter) WriteHeader(code int) {
+	c.save()
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *saveWriter) WriteHeaderNow() {
+	c.save()
+	c.ResponseWriter.WriteHeaderNow()
+}
+
+func (c *saveWriter) Write(data []byte) (int, error) {
+	c.save()
+	return c.ResponseWriter.Write(data)
+}
+
+func (c *saveWriter) WriteString(s string) (int, error) {
+	c.save()
+	return c.ResponseWriter.WriteString(s)
+}
+
+// Session 生成会话中间件，请求开始时读取会话，控制器通过Controller.Session读写，写入响应之前保存会话
+func Session(m *session.Manager) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		s, err := m.Load(c.Request)
+		if err != nil {
+			// 存储不可用时使用新会话，不影响不依赖会话的接口
+			log.FromContext(c).Error("读取会话失败：", err)
+			s = m.New()
+		}
+		c.Set(session.ContextKey, s)
+
+		saved := false
+		w := c.Writer
+		save := func() {
+			if saved {
+				return
+			}
+			saved = true
+			if err := m.Save(w, s); err != nil {
+				log.FromContext(c).Error("保存会话失败：", err)
+			}
+		}
+		c.Writer = &saveWriter{ResponseWriter: w, save: save}
+		c.Next()
+		save()
+		c.Writer = w
+	}
+}
+
+// SessionFromConfig 按session配置生成会话中间件
+func SessionFromConfig() (*session.Manager, func(c *gin.Context), error) {
+	opt, err := session.GetOptionFromConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := session.NewManager(opt, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, Session(m), nil
+}
+
+// CSRF 生成CSRF校验中间件，需在会话中间件之后使用，POST等修改类请求需在请求头或表单中带上Session.CSRFToken()
+func CSRF(m *session.Manager) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		s := session.FromContext(c)
+		if s != nil && m.CheckCSRF(c.Request, s) {
+			c.Next()
+			return
+		}
+
+		log.FromContext(c).WithField("path", c.Request.URL.Path).Warn("CSRF token校验失败")
+		result := map[string]interface{}{
+			"ret": errors.ErrCSRFToken.Code(),
+			"msg": errors.ErrCSRFToken.Msg(),
+		}
+		c.Set("response", result)
+		c.AbortWithStatusJSON(http.StatusForbidden, result)
+	}
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/chaos/controllers"
+	"github.com/yuanzhangcai/config"
+)
+
+func TestSession(t *testing.T) {
+	_ = config.LoadMemory(`{"session": {"hash_key": "hash", "block_key": "1234567890123456"}}`, "json")
+	m, ware, err := SessionFromConfig()
+	assert.Nil(t, err)
+
+	r := gin.New()
+	r.Use(ware)
+	r.GET("/login", func(ctx *gin.Context) {
+		ctl := &controllers.Controller{}
+		ctl.Init(ctx)
+		ctl.Session.Rotate()
+		ctl.Session.Set("nid", ctl.Params.Get("nid"))
+		ctx.String(200, ctl.Session.CSRFToken())
+	})
+	r.GET("/user", func(ctx *gin.Context) {
+		ctl := &controllers.Controller{}
+		ctl.Init(ctx)
+		ctx.String(200, ctl.Session.GetString("nid"))
+	})
+	r.POST("/logout", CSRF(m), func(ctx *gin.Context) {
+		ctl := &controllers.Controller{}
+		ctl.Init(ctx)
+		ctl.Session.Destroy()
+		ctx.AbortWithStatus(http.StatusNoContent)
+	})
+
+	do := func(req *http.Request, cookie *http.Cookie) *httptest.ResponseRecorder {
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	cookieOf := func(w *httptest.ResponseRecorder) *http.Cookie {
+		for _, one := range w.Result().Cookies() {
+			if one.Name == "chaos_session" {
+				return one
+			}
+		}
+		return nil
+	}
+
+	// 没有会话数据时不设置cookie
+	w := do(httptest.NewRequest(http.MethodGet, "/user", nil), nil)
+	assert.Equal(t, "", w.Body.String())
+	assert.Nil(t, cookieOf(w))
+
+	// 登录，响应写入前设置cookie
+	w = do(httptest.NewRequest(http.MethodGet, "/login?nid=1707357", nil), nil)
+	cookie := cookieOf(w)
+	assert.NotNil(t, cookie)
+	token := w.Body.String()
+
+	w = do(httptest.NewRequest(http.MethodGet, "/user", nil), cookie)
+	assert.Equal(t, "1707357", w.Body.String())
+	assert.NotNil(t, cookieOf(w)) // 滑动过期，每次请求刷新cookie
+
+	// CSRF校验失败
+	form := url.Values{"csrf_token": {"abc"}}
+	req := httptest.NewRequest(http.MethodPost, "/logout", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = do(req, cookie)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// 退出登录
+	form.Set("csrf_token", token)
+	req = httptest.NewRequest(http.MethodPost, "/logout", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = do(req, cookie)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.True(t, cookieOf(w).MaxAge < 0)
+
+	w = do(httptest.NewRequest(http.MethodGet, "/user", nil), cookie)
+	assert.Equal(t, "", w.Body.String())
+
+	_ = config.LoadMemory(`{"session": {"hash_key": ""}}`, "json")
+	_, _, err = SessionFromConfig()
+	assert.NotNil(t, err)
+}
@@ -8,7 +8,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
+	"github.com/yuanzhangcai/chaos/log"
 	"github.com/yuanzhangcai/chaos/monitor"
 )
 
@@ -43,7 +43,7 @@ func UsedTime() func(c *gin.Context) {
 		monitor.SummaryChaosCostTime(float64(param.Latency))
 		monitor.AddURICount(param.Path)
 
-		logrus.Info(fmt.Sprintf("UsedTime: %3d| %13v |%s %-7s %s body[%s] resp[%v] err[%s] size[%d]",
+		log.L().Info(fmt.Sprintf("UsedTime: %3d| %13v |%s %-7s %s body[%s] resp[%v] err[%s] size[%d]",
 			param.StatusCode,
 			param.Latency,
 			param.ClientIP,
@@ -5,9 +5,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/yuanzhangcai/chaos/common"
 	"github.com/yuanzhangcai/chaos/log"
@@ -69,3 +71,19 @@ func TestUsedTime(t *testing.T) {
 	logStr := string(buf)
 	assert.Contains(t, logStr, "UsedTime")
 }
+
+func TestDebugLog(t *testing.T) {
+	initConfig()
+
+	r := gin.New()
+	r.Use(DebugLog())
+	r.GET("/test", func(c *gin.Context) {
+		c.String(200, strconv.FormatBool(log.FromContext(c).Enabled(logrus.DebugLevel)))
+	})
+
+	log.SetDebugNids("10001")
+	defer log.SetDebugNids()
+
+	assert.Equal(t, "true", performRequest(r, "GET", "/test?nid=10001").Body.String())
+	assert.Equal(t, "false", performRequest(r, "GET", "/test?nid=10002").Body.String())
+}
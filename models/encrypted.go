@@ -0,0 +1,144 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/yuanzhangcai/chaos/crypto"
+)
+
+var (
+	// EncryptKeyring 加密字段使用的密钥环名称，对应配置db.encrypt_keyring
+	EncryptKeyring = "default"
+	// BlindIndexKeyring 盲索引使用的密钥环名称，对应配置db.blind_index_keyring，更换其当前密钥后需要重新计算所有盲索引
+	BlindIndexKeyring = "blind_index"
+
+	// LegacyDecrypt 解密旧数据，字段值不是密钥环加密的格式或头部的密钥ID不在密钥环中时调用，如兼容之前使用crypto.AesEncrypt手动加密的数据，为nil时返回错误
+	LegacyDecrypt func(value string) (string, error)
+)
+
+// keyring 获取密钥环，没有配置时返回错误
+func keyring(name string) (*crypto.Keyring, error) {
+	k := crypto.GetKeyring(name)
+	if k == nil {
+		return nil, errors.New("keyring " + name + " is not configured")
+	}
+	return k, nil
+}
+
+// EncryptedString 加密字符串字段，写入时使用密钥环的当前密钥加密并保存为base64，读取时按密文中的密钥ID解密。
+// 数据库字段使用varchar或text，长度约为明文的4/3加60字节；空字符串不加密；
+// 密文没有与行或字段绑定，不能防止在数据库中交换两个密文
+type EncryptedString string
+
+// Value 加密后写入数据库
+func (c EncryptedString) Value() (driver.Value, error) {
+	if c == "" {
+		return "", nil
+	}
+	k, err := keyring(EncryptKeyring)
+	if err != nil {
+		return nil, err
+	}
+	return k.EncryptToBase64([]byte(c), nil)
+}
+
+// Scan 读取后解密
+func (c *EncryptedString) Scan(v interface{}) error {
+	var value string
+	switch v := v.(type) {
+	case nil:
+		*c = ""
+		return nil
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("can not convert %v to encrypted string", v)
+	}
+	if value == "" {
+		*c = ""
+		return nil
+	}
+
+	k, err := keyring(EncryptKeyring)
+	if err != nil {
+		return err
+	}
+	plain, err := k.DecryptBase64(value, nil)
+	if err != nil && LegacyDecrypt != nil && !isKeyringCiphertext(k, value) {
+		str, legacyErr := LegacyDecrypt(value)
+		if legacyErr != nil {
+			return legacyErr
+		}
+		*c = EncryptedString(str)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	*c = EncryptedString(plain)
+	return nil
+}
+
+// String 返回明文
+func (c EncryptedString) String() string {
+	return string(c)
+}
+
+// isKeyringCiphertext 是否为密钥环加密的格式，头部的密钥ID需要在密钥环中，避免旧数据恰好能解析出头部时被误判
+func isKeyringCiphertext(k *crypto.Keyring, value string) bool {
+	data, err := crypto.DecodeBase64(value)
+	if err != nil {
+		return false
+	}
+	header, err := crypto.ParseHeader(data)
+	if err != nil {
+		return false
+	}
+	for _, id := range k.IDs() {
+		if id == header.KeyID {
+			return true
+		}
+	}
+	return false
+}
+
+// BlindIndex 盲索引字段，保存明文的HMAC，与EncryptedString字段配合用于等值查询，不能用于范围或模糊查询。
+// 数据库字段使用varchar(64)并建立索引，写入与查询时都使用NewBlindIndex计算，明文需要先统一格式，如去掉空格、统一大小写
+type BlindIndex string
+
+// NewBlindIndex 计算盲索引
+func NewBlindIndex(value string) (BlindIndex, error) {
+	k, err := keyring(BlindIndexKeyring)
+	if err != nil {
+		return "", err
+	}
+	mac, err := k.BlindIndex([]byte(value))
+	if err != nil {
+		return "", err
+	}
+	return BlindIndex(crypto.EncodeBase64(mac)), nil
+}
+
+// Value 写入数据库
+func (c BlindIndex) Value() (driver.Value, error) {
+	return string(c), nil
+}
+
+// Scan 读取盲索引
+func (c *BlindIndex) Scan(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		*c = ""
+	case string:
+		*c = BlindIndex(v)
+	case []byte:
+		*c = BlindIndex(v)
+	default:
+		return fmt.Errorf("can not convert %v to blind index", v)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/chaos/crypto"
+)
+
+func TestEncryptedString(t *testing.T) {
+	defer func(name string) { EncryptKeyring = name }(EncryptKeyring)
+	EncryptKeyring = "test_encrypt_none"
+	_, err := EncryptedString("13800138000").Value()
+	assert.NotNil(t, err)
+
+	EncryptKeyring = "test_encrypt"
+	k := crypto.NewKeyring(EncryptKeyring)
+	assert.Nil(t, k.Add("v1", crypto.AESGCM, bytes.Repeat([]byte{1}, 32)))
+	crypto.SetKeyring(k)
+
+	value, err := EncryptedString("13800138000").Value()
+	assert.Nil(t, err)
+	str := value.(string)
+	assert.NotContains(t, str, "13800138000")
+	other, _ := EncryptedString("13800138000").Value()
+	assert.NotEqual(t, str, other)
+
+	var phone EncryptedString
+	assert.Nil(t, phone.Scan(str))
+	assert.Equal(t, "13800138000", phone.String())
+	assert.Nil(t, phone.Scan([]byte(str)))
+	assert.Equal(t, EncryptedString("13800138000"), phone)
+
+	// 更换密钥后旧数据仍可读取，写入时使用新密钥
+	assert.Nil(t, k.Add("v2", crypto.ChaCha20Poly1305, bytes.Repeat([]byte{2}, 32)))
+	assert.Nil(t, k.SetActive("v2"))
+	assert.Nil(t, phone.Scan(str))
+	assert.Equal(t, "13800138000", phone.String())
+	value, _ = phone.Value()
+	data, _ := crypto.DecodeBase64(value.(string))
+	id, _ := crypto.KeyID(data)
+	assert.Equal(t, "v2", id)
+
+	// 空值
+	value, err = EncryptedString("").Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "", value)
+	assert.Nil(t, phone.Scan(nil))
+	assert.Equal(t, EncryptedString(""), phone)
+	assert.Nil(t, phone.Scan(""))
+	assert.Equal(t, EncryptedString(""), phone)
+
+	// 格式错误或被篡改
+	assert.NotNil(t, phone.Scan(123))
+	assert.NotNil(t, phone.Scan("13800138000"))
+	data[len(data)-1] ^= 1
+	assert.Equal(t, crypto.ErrDecrypt, phone.Scan(crypto.EncodeBase64(data)))
+}
+
+func TestEncryptedStringLegacy(t *testing.T) {
+	defer func(name string) { EncryptKeyring = name }(EncryptKeyring)
+	EncryptKeyring = "test_encrypt_legacy"
+	k := crypto.NewKeyring(EncryptKeyring)
+	assert.Nil(t, k.Add("v1", crypto.AESGCM, bytes.Repeat([]byte{1}, 32)))
+	crypto.SetKeyring(k)
+	defer func() { LegacyDecrypt = nil }()
+
+	key := []byte("1234567890123456")
+	legacy, _ := crypto.AesEncrypt([]byte("110101199003077777"), key)
+	LegacyDecrypt = func(value string) (string, error) {
+		data, err := crypto.DecodeBase64(value)
+		if err != nil {
+			return "", err
+		}
+		plain, err := crypto.AesDecrypt(data, key)
+		return string(plain), err
+	}
+
+	var card EncryptedString
+	assert.Nil(t, card.Scan(crypto.EncodeBase64(legacy)))
+	assert.Equal(t, "110101199003077777", card.String())
+
+	// 保存时使用新格式
+	value, _ := card.Value()
+	assert.Nil(t, card.Scan(value))
+	assert.Equal(t, "110101199003077777", card.String())
+
+	// 旧数据恰好能解析出密钥环格式的头部时仍然调用LegacyDecrypt，该密文第一个字节为0x01
+	legacy, _ = crypto.AesEncrypt([]byte("110101199000834800"), key)
+	_, err := crypto.ParseHeader(legacy)
+	assert.Nil(t, err)
+	assert.Nil(t, card.Scan(crypto.EncodeBase64(legacy)))
+	assert.Equal(t, "110101199000834800", card.String())
+
+	LegacyDecrypt = func(value string) (string, error) { return "", errors.New("legacy") }
+	assert.EqualError(t, card.Scan("abc"), "legacy")
+
+	// 新格式的数据解密失败时不调用LegacyDecrypt
+	data, _ := crypto.DecodeBase64(value.(string))
+	data[len(data)-1] ^= 1
+	assert.Equal(t, crypto.ErrDecrypt, card.Scan(crypto.EncodeBase64(data)))
+}
+
+func TestBlindIndex(t *testing.T) {
+	defer func(name string) { BlindIndexKeyring = name }(BlindIndexKeyring)
+	BlindIndexKeyring = "test_blind_index_none"
+	_, err := NewBlindIndex("13800138000")
+	assert.NotNil(t, err)
+
+	BlindIndexKeyring = "test_blind_index"
+	k := crypto.NewKeyring(BlindIndexKeyring)
+	assert.Nil(t, k.Add("v1", crypto.AESGCM, bytes.Repeat([]byte{1}, 32)))
+	crypto.SetKeyring(k)
+	one, err := NewBlindIndex("13800138000")
+	assert.Nil(t, err)
+	assert.Equal(t, 43, len(one))
+	two, _ := NewBlindIndex("13800138000")
+	assert.Equal(t, one, two)
+	other, _ := NewBlindIndex("13800138001")
+	assert.NotEqual(t, one, other)
+
+	value, err := one.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, string(one), value)
+
+	var index BlindIndex
+	assert.Nil(t, index.Scan(value))
+	assert.Equal(t, one, index)
+	assert.Nil(t, index.Scan([]byte(value.(string))))
+	assert.Equal(t, one, index)
+	assert.Nil(t, index.Scan(nil))
+	assert.Equal(t, BlindIndex(""), index)
+	assert.NotNil(t, index.Scan(1))
+}
@@ -1,13 +1,15 @@
 package models
 
 import (
+	"context"
 	"database/sql/driver"
 	"fmt"
 	"time"
 
 	"github.com/jinzhu/gorm"
-	"github.com/sirupsen/logrus"
 	"github.com/yuanzhangcai/chaos/common"
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/chaos/monitor"
 	"github.com/yuanzhangcai/config"
 )
 
@@ -17,28 +19,43 @@ var (
 
 // Model 数据库操作组件基类
 type Model struct {
-	DB  *gorm.DB
-	Log *logrus.Entry
+	DB   *gorm.DB
+	Log  log.Logger
+	node string // 当前使用的数据库节点
 }
 
 // SetDB 设置所使用数据库
 func (c *Model) SetDB(node string) {
+	c.node = node
 	c.DB = dbMap[node]
 }
 
+// SetContext 设置请求上下文，之后的数据库操作会作为上下文中span的子span
+func (c *Model) SetContext(ctx context.Context) {
+	if c.DB == nil || ctx == nil {
+		return
+	}
+	c.DB = c.DB.Set(ctxKey, ctx)
+}
+
 // Exec 执行sql语句
 func (c *Model) Exec(sql string, values ...interface{}) *gorm.DB {
 	if c.DB == nil {
 		return nil
 	}
-	return c.DB.Exec(sql, values...)
+
+	// gorm的Exec不会触发回调，需要单独跟踪
+	t := startQueryTrace(c.node, "exec", contextOf(c.DB))
+	db := c.DB.Exec(sql, values...)
+	t.finish(sql, values, db.Error)
+	return db
 }
 
 type dbLogger struct {
 }
 
 func (c *dbLogger) Print(v ...interface{}) {
-	logrus.Info(v...)
+	log.L().Info(v...)
 }
 
 // ConnectDB 连接db
@@ -48,6 +65,7 @@ func ConnectDB(node string) error {
 	if _, ok := dbMap[node]; ok {
 		dbMap[node].Close()
 		delete(dbMap, node)
+		monitor.UnregisterDBStats(node)
 	}
 
 	dbInfo := config.GetString("db", node)
@@ -58,7 +76,7 @@ func ConnectDB(node string) error {
 	// 初始化连接
 	db, err := gorm.Open("mysql", dbInfo)
 	if err != nil {
-		logrus.Error("数据库初始化失败。错误信息：" + err.Error())
+		log.L().Error("数据库初始化失败。错误信息：" + err.Error())
 		return err
 	}
 
@@ -72,6 +90,13 @@ func ConnectDB(node string) error {
 		db.SetLogger(logger)
 	}
 
+	// 慢查询阈值，单位毫秒
+	slowThreshold = time.Duration(config.GetInt64("db", "slow_threshold")) * time.Millisecond
+
+	// 跟踪数据库操作，上报耗时、错误数与连接池状态
+	registerCallbacks(db, node)
+	monitor.RegisterDBStats(node, db.DB().Stats)
+
 	dbMap[node] = db
 	return nil
 }
@@ -79,6 +104,15 @@ func ConnectDB(node string) error {
 // Init 初始化顾
 func Init() error {
 	var err error
+
+	// 加密字段与盲索引使用的密钥环
+	if name := config.GetString("db", "encrypt_keyring"); name != "" {
+		EncryptKeyring = name
+	}
+	if name := config.GetString("db", "blind_index_keyring"); name != "" {
+		BlindIndexKeyring = name
+	}
+
 	list := config.GetStringArray("db", "list")
 	if len(list) == 0 {
 		// return fmt.Errorf("没有获取到数据库配置")
@@ -88,7 +122,7 @@ func Init() error {
 	for _, one := range list {
 		err := ConnectDB(one)
 		if err != nil {
-			logrus.Error("数据库连接失败。", err)
+			log.L().Error("数据库连接失败。", err)
 			return err
 		}
 	}
@@ -96,6 +130,16 @@ func Init() error {
 	return err
 }
 
+// Ping 检查所有数据库连接，用于健康检查
+func Ping() error {
+	for node, db := range dbMap {
+		if err := db.DB().Ping(); err != nil {
+			return fmt.Errorf("%s: %s", node, err.Error())
+		}
+	}
+	return nil
+}
+
 // GormTime Grom datetime类型
 type GormTime struct {
 	time.Time
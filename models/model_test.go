@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -8,6 +9,8 @@ import (
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/stretchr/testify/assert"
 	"github.com/yuanzhangcai/chaos/common"
 	"github.com/yuanzhangcai/chaos/tools"
@@ -124,3 +127,23 @@ func TestExec(t *testing.T) {
 	assert.Equal(t, params.Time.Format(common.YMDHIS), one2.Time.Format(common.YMDHIS))
 
 }
+
+func TestQueryTrace(t *testing.T) {
+	tracer := mocktracer.New()
+	parent := tracer.StartSpan("request")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+
+	trace := startQueryTrace("db1", "query", ctx)
+	trace.finish("select 1", nil, fmt.Errorf("error"))
+
+	spans := tracer.FinishedSpans()
+	assert.Equal(t, 1, len(spans))
+	assert.Equal(t, "db.query", spans[0].OperationName)
+	assert.Equal(t, "select 1", spans[0].Tag("db.statement"))
+	assert.Equal(t, true, spans[0].Tag("error"))
+
+	// 没有父span时不创建span
+	trace = startQueryTrace("db1", "query", nil)
+	assert.Nil(t, trace.span)
+	trace.finish("select 1", nil, nil)
+}
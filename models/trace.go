@@ -0,0 +1,111 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/chaos/monitor"
+)
+
+const (
+	ctxKey   = "chaos:ctx"   // 保存请求上下文的gorm变量名
+	traceKey = "chaos:trace" // 保存操作跟踪信息的gorm变量名
+)
+
+var (
+	slowThreshold time.Duration // 慢查询阈值，为0时不记录慢查询日志
+)
+
+// queryTrace 单次数据库操作的跟踪信息
+type queryTrace struct {
+	node      string
+	operation string
+	start     time.Time
+	span      opentracing.Span
+}
+
+// startQueryTrace 开始跟踪一次数据库操作，ctx中有span时创建子span
+func startQueryTrace(node, operation string, ctx context.Context) *queryTrace {
+	return &queryTrace{
+		node:      node,
+		operation: operation,
+		start:     time.Now(),
+		span: monitor.StartChildSpan(ctx, "db."+operation, map[string]interface{}{
+			string(ext.DBType):     "sql",
+			string(ext.DBInstance): node,
+		}),
+	}
+}
+
+// finish 结束跟踪，上报耗时与错误，并记录慢查询日志
+func (t *queryTrace) finish(sql string, vars []interface{}, err error) {
+	cost := time.Since(t.start)
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+
+	monitor.ObserveDBQuery(t.node, t.operation, cost, err)
+
+	if t.span != nil {
+		t.span.SetTag(string(ext.DBStatement), sql)
+		if err != nil {
+			ext.Error.Set(t.span, true)
+			t.span.SetTag("error.message", err.Error())
+		}
+		t.span.Finish()
+	}
+
+	if slowThreshold > 0 && cost >= slowThreshold {
+		log.L().WithFields(log.Fields{
+			"node":      t.node,
+			"operation": t.operation,
+			"cost":      cost.String(),
+			"sql":       sql,
+			"vars":      fmt.Sprint(vars...),
+		}).Warn("慢查询")
+	}
+}
+
+// contextOf 获取gorm对象上绑定的请求上下文
+func contextOf(db *gorm.DB) context.Context {
+	if value, ok := db.Get(ctxKey); ok {
+		if ctx, ok := value.(context.Context); ok {
+			return ctx
+		}
+	}
+	return nil
+}
+
+// registerCallbacks 注册gorm回调，跟踪增删改查操作
+func registerCallbacks(db *gorm.DB, node string) {
+	before := func(operation string) func(scope *gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			scope.Set(traceKey, startQueryTrace(node, operation, contextOf(scope.DB())))
+		}
+	}
+
+	after := func(scope *gorm.Scope) {
+		if value, ok := scope.Get(traceKey); ok {
+			if t, ok := value.(*queryTrace); ok {
+				t.finish(scope.SQL, scope.SQLVars, scope.DB().Error)
+			}
+		}
+	}
+
+	callback := db.Callback()
+	callback.Create().Before("gorm:create").Register("chaos:before_create", before("create"))
+	callback.Create().After("gorm:create").Register("chaos:after_create", after)
+	callback.Query().Before("gorm:query").Register("chaos:before_query", before("query"))
+	callback.Query().After("gorm:query").Register("chaos:after_query", after)
+	callback.Update().Before("gorm:update").Register("chaos:before_update", before("update"))
+	callback.Update().After("gorm:update").Register("chaos:after_update", after)
+	callback.Delete().Before("gorm:delete").Register("chaos:before_delete", before("delete"))
+	callback.Delete().After("gorm:delete").Register("chaos:after_delete", after)
+	callback.RowQuery().Before("gorm:row_query").Register("chaos:before_row_query", before("row_query"))
+	callback.RowQuery().After("gorm:row_query").Register("chaos:after_row_query", after)
+}
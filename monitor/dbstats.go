@@ -0,0 +1,78 @@
+package monitor
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dbStatsLock  sync.RWMutex
+	dbStatsFuncs = make(map[string]func() sql.DBStats) // 各数据库节点连接池状态获取函数
+)
+
+// RegisterDBStats 注册数据库节点连接池状态，重复注册同一节点时覆盖之前的注册
+func RegisterDBStats(node string, stats func() sql.DBStats) {
+	dbStatsLock.Lock()
+	defer dbStatsLock.Unlock()
+	dbStatsFuncs[node] = stats
+}
+
+// UnregisterDBStats 取消数据库节点连接池状态注册
+func UnregisterDBStats(node string) {
+	dbStatsLock.Lock()
+	defer dbStatsLock.Unlock()
+	delete(dbStatsFuncs, node)
+}
+
+// dbStatsCollector 在采集时读取各数据库节点的连接池状态
+type dbStatsCollector struct {
+	maxOpen      *prometheus.Desc
+	open         *prometheus.Desc
+	inUse        *prometheus.Desc
+	idle         *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+func newDBStatsCollector(env string) *dbStatsCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(Namespace, Subsystem, name+env), help, []string{"ip", "node"}, nil)
+	}
+
+	return &dbStatsCollector{
+		maxOpen:      desc("db_max_open_connections", "db max open connections."),
+		open:         desc("db_open_connections", "db open connections."),
+		inUse:        desc("db_in_use_connections", "db in use connections."),
+		idle:         desc("db_idle_connections", "db idle connections."),
+		waitCount:    desc("db_wait_count", "db total number of connections waited for."),
+		waitDuration: desc("db_wait_duration_seconds", "db total time blocked waiting for a new connection."),
+	}
+}
+
+// Describe 实现prometheus.Collector
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+// Collect 实现prometheus.Collector
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	dbStatsLock.RLock()
+	defer dbStatsLock.RUnlock()
+
+	for node, fn := range dbStatsFuncs {
+		stats := fn()
+		ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections), IP, node)
+		ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections), IP, node)
+		ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse), IP, node)
+		ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle), IP, node)
+		ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount), IP, node)
+		ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds(), IP, node)
+	}
+}
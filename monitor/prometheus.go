@@ -1,16 +1,15 @@
 package monitor
 
 import (
-	"context"
-	"net/http"
+	"math"
 	"sync"
 	"time"
 	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/sirupsen/logrus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/yuanzhangcai/chaos/common"
+	"github.com/yuanzhangcai/chaos/log"
 	"github.com/yuanzhangcai/config"
 )
 
@@ -25,7 +24,6 @@ var (
 	// IP 当前机器IP
 	IP   string
 	once sync.Once
-	srv  *http.Server
 
 	// actVisitCount 活动访问量
 	actVisitCount *prometheus.CounterVec
@@ -35,6 +33,39 @@ var (
 
 	// uriCount 各uri调用资数
 	uriCount *prometheus.CounterVec
+
+	// dbQueryDuration 数据库操作耗时分布
+	dbQueryDuration *prometheus.HistogramVec
+
+	// dbQueryErrors 数据库操作错误数
+	dbQueryErrors *prometheus.CounterVec
+
+	// redisCmdDuration redis命令耗时分布
+	redisCmdDuration *prometheus.HistogramVec
+
+	// redisCmdErrors redis命令错误数
+	redisCmdErrors *prometheus.CounterVec
+
+	// dbStats 数据库连接池状态
+	dbStats *dbStatsCollector
+
+	// logDropped 异步写日志时缓冲区满丢弃的日志数
+	logDropped *prometheus.CounterVec
+
+	// grpcDuration gRPC接口耗时分布
+	grpcDuration *prometheus.HistogramVec
+
+	// grpcHandled gRPC接口调用数，按状态码统计
+	grpcHandled *prometheus.CounterVec
+
+	// cacheRequests 缓存读取次数，按结果统计
+	cacheRequests *prometheus.CounterVec
+
+	// cacheLoadDuration 缓存未命中时加载数据的耗时分布
+	cacheLoadDuration *prometheus.HistogramVec
+
+	// cacheLoadErrors 缓存加载数据的错误数
+	cacheLoadErrors *prometheus.CounterVec
 )
 
 // SetMetrics 设置监控指标
@@ -81,56 +112,157 @@ func SetMetrics() {
 			[]string{"ip", "uri"},
 		)
 
+		// dbQueryDuration 数据库操作耗时分布
+		dbQueryDuration = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Subsystem: Subsystem,
+				Name:      "db_query_duration_seconds" + env,
+				Help:      "db query duration.",
+				Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+			},
+			[]string{"ip", "node", "operation"},
+		)
+
+		// dbQueryErrors 数据库操作错误数
+		dbQueryErrors = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: Subsystem,
+				Name:      "db_query_errors" + env,
+				Help:      "db query errors.",
+			},
+			[]string{"ip", "node", "operation"},
+		)
+
+		// redisCmdDuration redis命令耗时分布
+		redisCmdDuration = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Subsystem: Subsystem,
+				Name:      "redis_cmd_duration_seconds" + env,
+				Help:      "redis command duration.",
+				Buckets:   []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+			},
+			[]string{"ip", "command"},
+		)
+
+		// redisCmdErrors redis命令错误数
+		redisCmdErrors = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: Subsystem,
+				Name:      "redis_cmd_errors" + env,
+				Help:      "redis command errors.",
+			},
+			[]string{"ip", "command"},
+		)
+
+		// dbStats 数据库连接池状态
+		dbStats = newDBStatsCollector(env)
+
+		// logDropped 异步写日志时缓冲区满丢弃的日志数
+		logDropped = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: Subsystem,
+				Name:      "log_dropped" + env,
+				Help:      "log entries dropped by async writer.",
+			},
+			[]string{"ip", "level"},
+		)
+
+		// grpcDuration gRPC接口耗时分布
+		grpcDuration = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Subsystem: Subsystem,
+				Name:      "grpc_duration_seconds" + env,
+				Help:      "grpc method duration.",
+				Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+			},
+			[]string{"ip", "method"},
+		)
+
+		// grpcHandled gRPC接口调用数
+		grpcHandled = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: Subsystem,
+				Name:      "grpc_handled" + env,
+				Help:      "grpc method handled count by code.",
+			},
+			[]string{"ip", "method", "code"},
+		)
+
+		// cacheRequests 缓存读取次数
+		cacheRequests = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: Subsystem,
+				Name:      "cache_requests" + env,
+				Help:      "cache requests by result.",
+			},
+			[]string{"ip", "cache", "result"},
+		)
+
+		// cacheLoadDuration 缓存加载数据的耗时分布
+		cacheLoadDuration = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Subsystem: Subsystem,
+				Name:      "cache_load_duration_seconds" + env,
+				Help:      "cache load duration.",
+				Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+			},
+			[]string{"ip", "cache"},
+		)
+
+		// cacheLoadErrors 缓存加载数据的错误数
+		cacheLoadErrors = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: Subsystem,
+				Name:      "cache_load_errors" + env,
+				Help:      "cache load errors.",
+			},
+			[]string{"ip", "cache"},
+		)
+
 		// 注册监控指标
 		prometheus.MustRegister(
 			actVisitCount,
 			chaosCostTime,
 			uriCount,
+			dbQueryDuration,
+			dbQueryErrors,
+			redisCmdDuration,
+			redisCmdErrors,
+			dbStats,
+			logDropped,
+			grpcDuration,
+			grpcHandled,
+			cacheRequests,
+			cacheLoadDuration,
+			cacheLoadErrors,
 		)
 	})
 }
 
-// Init 初始化prometheus监控
+// Init 初始化prometheus监控，监控指标由admin管理服务的/metrics接口曝露
 func Init() {
 	Namespace = config.GetString("monitor", "namespace")
 	Subsystem = config.GetString("monitor", "subsystem")
 
-	if srv != nil {
-		return
-	}
-
 	// 设置监控指标
 	SetMetrics()
-
-	addr := config.GetString("monitor", "server")
-	if addr != "" { // 开启prometheus监控
-		mux := http.NewServeMux()
-		mux.Handle("/metrics", promhttp.Handler())
-
-		srv = &http.Server{}
-		srv.Addr = addr
-		srv.Handler = mux
-
-		go func() {
-			// http.Handle("/metrics", promhttp.Handler())
-			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				logrus.Fatalf("listen: %s\n", err)
-			}
-		}()
-	}
 }
 
 // Stop 停止监控上报
 func Stop() {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	logrus.Info("Monitor Shutdown Server ...")
-	if srv != nil {
-		if err := srv.Shutdown(ctx); err != nil {
-			logrus.Error("Server Shutdown:", err)
-		}
-	}
-	logrus.Info("Monitor Server exiting")
+	// 关闭tracer，上报剩余的span
+	CloseTracer()
+	log.L().Info("Monitor exiting")
 }
 
 // AddActVisitCount 总访问量加1
@@ -143,6 +275,33 @@ func SummaryChaosCostTime(v float64) {
 	chaosCostTime.WithLabelValues(IP).Observe(v)
 }
 
+// CostTimeQuantile 返回最近一段时间接口耗时的分位数，q需要是cost_time_seconds配置的分位数，如0.99，没有数据时返回0
+func CostTimeQuantile(q float64) time.Duration {
+	if chaosCostTime == nil {
+		return 0
+	}
+
+	observer, err := chaosCostTime.GetMetricWithLabelValues(IP)
+	if err != nil {
+		return 0
+	}
+	metric, ok := observer.(prometheus.Metric)
+	if !ok {
+		return 0
+	}
+
+	m := &dto.Metric{}
+	if err = metric.Write(m); err != nil {
+		return 0
+	}
+	for _, one := range m.GetSummary().GetQuantile() {
+		if one.GetQuantile() == q && !math.IsNaN(one.GetValue()) {
+			return time.Duration(one.GetValue()) // 耗时以纳秒上报
+		}
+	}
+	return 0
+}
+
 // AddURICount uri访问量加1
 func AddURICount(uri string) {
 	if !utf8.ValidString(uri) {
@@ -151,3 +310,67 @@ func AddURICount(uri string) {
 
 	uriCount.WithLabelValues(IP, uri).Inc()
 }
+
+// ObserveDBQuery 统计数据库操作耗时与错误数
+func ObserveDBQuery(node, operation string, cost time.Duration, err error) {
+	if dbQueryDuration == nil {
+		return
+	}
+
+	dbQueryDuration.WithLabelValues(IP, node, operation).Observe(cost.Seconds())
+	if err != nil {
+		dbQueryErrors.WithLabelValues(IP, node, operation).Inc()
+	}
+}
+
+// ObserveRedisCmd 统计redis命令耗时与错误数
+func ObserveRedisCmd(command string, cost time.Duration, err error) {
+	if redisCmdDuration == nil {
+		return
+	}
+
+	redisCmdDuration.WithLabelValues(IP, command).Observe(cost.Seconds())
+	if err != nil {
+		redisCmdErrors.WithLabelValues(IP, command).Inc()
+	}
+}
+
+// AddLogDropped 异步写日志丢弃的日志数加1
+func AddLogDropped(level string) {
+	if logDropped == nil {
+		return
+	}
+
+	logDropped.WithLabelValues(IP, level).Inc()
+}
+
+// ObserveGRPC 统计gRPC接口耗时与调用数
+func ObserveGRPC(method, code string, cost time.Duration) {
+	if grpcDuration == nil {
+		return
+	}
+
+	grpcDuration.WithLabelValues(IP, method).Observe(cost.Seconds())
+	grpcHandled.WithLabelValues(IP, method, code).Inc()
+}
+
+// AddCacheRequest 缓存读取次数加1，result为local_hit/hit/negative_hit/miss/error
+func AddCacheRequest(cache, result string) {
+	if cacheRequests == nil {
+		return
+	}
+
+	cacheRequests.WithLabelValues(IP, cache, result).Inc()
+}
+
+// ObserveCacheLoad 统计缓存加载数据的耗时与错误数
+func ObserveCacheLoad(cache string, cost time.Duration, err error) {
+	if cacheLoadDuration == nil {
+		return
+	}
+
+	cacheLoadDuration.WithLabelValues(IP, cache).Observe(cost.Seconds())
+	if err != nil {
+		cacheLoadErrors.WithLabelValues(IP, cache).Inc()
+	}
+}
@@ -1,10 +1,13 @@
 package monitor
 
 import (
+	"database/sql"
+	"fmt"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/yuanzhangcai/chaos/common"
 	"github.com/yuanzhangcai/config"
@@ -36,7 +39,7 @@ func init() {
 
 func TestInit(t *testing.T) {
 	Init()
-	assert.NotNil(t, srv)
+	assert.NotNil(t, actVisitCount)
 }
 
 func TestStop(t *testing.T) {
@@ -54,3 +57,41 @@ func TestMetrics(t *testing.T) {
 
 	AddURICount("/engine")
 }
+
+func TestStorageMetrics(t *testing.T) {
+	Init()
+
+	ObserveDBQuery("db1", "query", 10*time.Millisecond, nil)
+	ObserveDBQuery("db1", "query", 10*time.Millisecond, fmt.Errorf("error"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(dbQueryErrors.WithLabelValues(IP, "db1", "query")))
+
+	ObserveRedisCmd("get", time.Millisecond, nil)
+	ObserveRedisCmd("get", time.Millisecond, fmt.Errorf("error"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(redisCmdErrors.WithLabelValues(IP, "get")))
+
+	AddCacheRequest("user", "hit")
+	AddCacheRequest("user", "hit")
+	assert.Equal(t, float64(2), testutil.ToFloat64(cacheRequests.WithLabelValues(IP, "user", "hit")))
+	ObserveCacheLoad("user", time.Millisecond, nil)
+	ObserveCacheLoad("user", time.Millisecond, fmt.Errorf("error"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(cacheLoadErrors.WithLabelValues(IP, "user")))
+
+	RegisterDBStats("db1", func() sql.DBStats {
+		return sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 1, Idle: 2}
+	})
+	assert.Equal(t, 6, testutil.CollectAndCount(dbStats))
+
+	UnregisterDBStats("db1")
+	assert.Equal(t, 0, testutil.CollectAndCount(dbStats))
+
+	assert.Equal(t, time.Duration(0), CostTimeQuantile(0.95))
+	SummaryChaosCostTime(float64(100 * time.Millisecond))
+	assert.True(t, CostTimeQuantile(0.99) >= 100*time.Millisecond)
+
+	AddLogDropped("info")
+	assert.Equal(t, float64(1), testutil.ToFloat64(logDropped.WithLabelValues(IP, "info")))
+
+	ObserveGRPC("/chaos.User/Get", "OK", time.Millisecond)
+	ObserveGRPC("/chaos.User/Get", "Internal", time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(grpcHandled.WithLabelValues(IP, "/chaos.User/Get", "Internal")))
+}
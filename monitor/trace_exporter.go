@@ -0,0 +1,706 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+	"github.com/yuanzhangcai/chaos/common"
+	"github.com/yuanzhangcai/chaos/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// ExporterJaeger 通过jaeger agent/collector上报
+	ExporterJaeger = "jaeger"
+	// ExporterOTLPHTTP 通过OTLP/HTTP上报
+	ExporterOTLPHTTP = "otlp_http"
+	// ExporterOTLPGRPC 通过OTLP/gRPC上报
+	ExporterOTLPGRPC = "otlp_grpc"
+	// ExporterStdout 以OTLP JSON格式输出到标准输出，本地调试用
+	ExporterStdout = "stdout"
+	// ExporterFile 以OTLP JSON格式写入文件，本地调试用
+	ExporterFile = "file"
+	// ExporterNone 不上报
+	ExporterNone = "none"
+
+	otlpTracePath   = "/v1/traces"
+	otlpTraceMethod = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+
+	reportQueueSize     = 2048            // 待上报span队列长度，队列满时丢弃
+	reportBatchSize     = 512             // 单次上报的最大span数
+	reportFlushInterval = 1 * time.Second // 上报间隔
+	reportTimeout       = 5 * time.Second // 单次上报超时时间
+)
+
+// spanExporter span上报接口
+type spanExporter interface {
+	Export(ctx context.Context, req *otlpRequest) error
+	Close() error
+}
+
+// newSpanExporter 根据配置创建span上报器
+func newSpanExporter(opt *TraceOption) (spanExporter, error) {
+	switch opt.Exporter {
+	case ExporterOTLPHTTP:
+		return newOTLPHTTPExporter(opt)
+	case ExporterOTLPGRPC:
+		return newOTLPGRPCExporter(opt)
+	case ExporterStdout:
+		return &writerExporter{w: os.Stdout}, nil
+	case ExporterFile:
+		if opt.File == "" {
+			return nil, fmt.Errorf("trace file is empty")
+		}
+		file, err := os.OpenFile(opt.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &writerExporter{w: file, closer: file}, nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter (%s)", opt.Exporter)
+}
+
+// otlpReporter 实现jaeger.Reporter，将span转换为OTLP格式后批量异步上报
+type otlpReporter struct {
+	exporter spanExporter
+	resource otlpResource
+	queue    chan *otlpSpan
+	quit     chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+func newOTLPReporter(exporter spanExporter, serviceName string) *otlpReporter {
+	ip := IP
+	if ip == "" {
+		ip = common.GetIntranetIP()
+	}
+
+	r := &otlpReporter{
+		exporter: exporter,
+		resource: otlpResource{Attributes: []otlpKeyValue{
+			newKeyValue("service.name", serviceName),
+			newKeyValue("service.version", common.Version),
+			newKeyValue("deployment.environment", common.Env),
+			newKeyValue("host.ip", ip),
+		}},
+		queue: make(chan *otlpSpan, reportQueueSize),
+		quit:  make(chan struct{}),
+	}
+
+	r.wg.Add(1)
+	go r.loop()
+	return r
+}
+
+// Report 实现jaeger.Reporter，在调用方协程中完成转换，避免持有span对象
+func (r *otlpReporter) Report(span *jaeger.Span) {
+	select {
+	case r.queue <- convertSpan(span):
+	default:
+		log.L().Warn("trace report queue is full, span dropped")
+	}
+}
+
+// Close 实现jaeger.Reporter，上报所有剩余span后关闭
+func (r *otlpReporter) Close() {
+	r.once.Do(func() {
+		close(r.quit)
+		r.wg.Wait()
+		if err := r.exporter.Close(); err != nil {
+			log.L().Error("trace exporter close failed: ", err)
+		}
+	})
+}
+
+func (r *otlpReporter) loop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(reportFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*otlpSpan, 0, reportBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		r.export(batch)
+		batch = make([]*otlpSpan, 0, reportBatchSize)
+	}
+
+	for {
+		select {
+		case span := <-r.queue:
+			batch = append(batch, span)
+			if len(batch) >= reportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-r.quit:
+			for {
+				select {
+				case span := <-r.queue:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *otlpReporter) export(spans []*otlpSpan) {
+	req := &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: r.resource,
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/yuanzhangcai/chaos/monitor", Version: common.Version},
+			Spans: spans,
+		}},
+	}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+	if err := r.exporter.Export(ctx, req); err != nil {
+		log.L().Error("trace export failed: ", err)
+	}
+}
+
+// otlpHTTPExporter 通过OTLP/HTTP上报，使用protobuf编码
+type otlpHTTPExporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// newOTLPHTTPExporter endpoint没有scheme时使用http://，https://时按ca_file、cert_file校验证书
+func newOTLPHTTPExporter(opt *TraceOption) (*otlpHTTPExporter, error) {
+	url := opt.Endpoint
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "http://" + url
+	}
+	if !strings.HasSuffix(url, otlpTracePath) {
+		url = strings.TrimRight(url, "/") + otlpTracePath
+	}
+
+	tlsConfig, err := exporterTLSConfig(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &otlpHTTPExporter{
+		url:     url,
+		headers: opt.Headers,
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConnsPerHost: 10,
+		}},
+	}, nil
+}
+
+// Export 上报span
+func (c *otlpHTTPExporter) Export(ctx context.Context, req *otlpRequest) error {
+	request, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(req.marshalProto()))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	for key, value := range c.headers {
+		request.Header.Set(key, value)
+	}
+	request.Header.Set("Content-Type", "application/x-protobuf")
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("otlp http status code is %d", response.StatusCode)
+	}
+	return nil
+}
+
+// Close 关闭上报器
+func (c *otlpHTTPExporter) Close() error {
+	return nil
+}
+
+// otlpGRPCExporter 通过OTLP/gRPC上报
+type otlpGRPCExporter struct {
+	conn *grpc.ClientConn
+	md   metadata.MD
+}
+
+// newOTLPGRPCExporter endpoint为https://时使用TLS连接，否则使用明文连接
+func newOTLPGRPCExporter(opt *TraceOption) (*otlpGRPCExporter, error) {
+	endpoint := opt.Endpoint
+	dialOption := grpc.WithInsecure()
+	if strings.HasPrefix(endpoint, "https://") {
+		tlsConfig, err := exporterTLSConfig(opt)
+		if err != nil {
+			return nil, err
+		}
+		dialOption = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+	endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
+
+	conn, err := grpc.Dial(endpoint, dialOption)
+	if err != nil {
+		return nil, err
+	}
+	return &otlpGRPCExporter{conn: conn, md: metadata.New(opt.Headers)}, nil
+}
+
+// Export 上报span
+func (c *otlpGRPCExporter) Export(ctx context.Context, req *otlpRequest) error {
+	if len(c.md) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, c.md)
+	}
+	var resp []byte
+	return c.conn.Invoke(ctx, otlpTraceMethod, req.marshalProto(), &resp, grpc.ForceCodec(rawCodec{}))
+}
+
+// Close 关闭上报器
+func (c *otlpGRPCExporter) Close() error {
+	return c.conn.Close()
+}
+
+// exporterTLSConfig 生成OTLP上报使用的TLS配置，ca_file为空时使用系统CA
+func exporterTLSConfig(opt *TraceOption) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opt.CAFile != "" {
+		buf, err := ioutil.ReadFile(opt.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("no certificate found in trace ca file (%s)", opt.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opt.CertFile != "" || opt.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opt.CertFile, opt.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// rawCodec 直接收发已编码好的protobuf数据
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	buf, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec: unexpected type %T", v)
+	}
+	return buf, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	buf, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawCodec: unexpected type %T", v)
+	}
+	*buf = append((*buf)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// writerExporter 以OTLP JSON格式逐行写入
+type writerExporter struct {
+	lock   sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// Export 上报span
+func (c *writerExporter) Export(ctx context.Context, req *otlpRequest) error {
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err = c.w.Write(append(buf, '\n'))
+	return err
+}
+
+// Close 关闭上报器
+func (c *writerExporter) Close() error {
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+// 以下为OTLP数据结构，json标签与OTLP JSON格式一致，protobuf字段号与opentelemetry-proto一致
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           hexBytes       `json:"traceId"`
+	SpanID            hexBytes       `json:"spanId"`
+	ParentSpanID      hexBytes       `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,string"`
+	EndTimeUnixNano   uint64         `json:"endTimeUnixNano,string"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano uint64         `json:"timeUnixNano,string"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue OTLP AnyValue，只支持标量类型
+type otlpValue struct {
+	str    *string
+	boolv  *bool
+	intv   *int64
+	double *float64
+}
+
+// MarshalJSON 按OTLP JSON格式输出，int64以字符串表示
+func (v otlpValue) MarshalJSON() ([]byte, error) {
+	switch {
+	case v.boolv != nil:
+		return json.Marshal(map[string]bool{"boolValue": *v.boolv})
+	case v.intv != nil:
+		return json.Marshal(map[string]string{"intValue": fmt.Sprint(*v.intv)})
+	case v.double != nil:
+		return json.Marshal(map[string]float64{"doubleValue": *v.double})
+	case v.str != nil:
+		return json.Marshal(map[string]string{"stringValue": *v.str})
+	}
+	return []byte("{}"), nil
+}
+
+// hexBytes 在json中以十六进制字符串表示的字节数组
+type hexBytes []byte
+
+func (b hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+func newKeyValue(key string, value interface{}) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	switch v := value.(type) {
+	case bool:
+		kv.Value.boolv = &v
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		i := toInt64(v)
+		kv.Value.intv = &i
+	case uint, uint64:
+		u := toUint64(v)
+		if u > math.MaxInt64 {
+			s := fmt.Sprint(u)
+			kv.Value.str = &s
+		} else {
+			i := int64(u)
+			kv.Value.intv = &i
+		}
+	case float32:
+		f := float64(v)
+		kv.Value.double = &f
+	case float64:
+		kv.Value.double = &v
+	case string:
+		kv.Value.str = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.str = &s
+	}
+	return kv
+}
+
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	}
+	return 0
+}
+
+func toUint64(value interface{}) uint64 {
+	switch v := value.(type) {
+	case uint:
+		return uint64(v)
+	case uint64:
+		return v
+	}
+	return 0
+}
+
+// convertSpan 将jaeger span转换为OTLP span
+func convertSpan(span *jaeger.Span) *otlpSpan {
+	sc := span.SpanContext()
+	start := span.StartTime()
+
+	ret := &otlpSpan{
+		TraceID:           traceIDBytes(sc.TraceID()),
+		SpanID:            spanIDBytes(sc.SpanID()),
+		Name:              span.OperationName(),
+		Kind:              1, // SPAN_KIND_INTERNAL
+		StartTimeUnixNano: uint64(start.UnixNano()),
+		EndTimeUnixNano:   uint64(start.Add(span.Duration()).UnixNano()),
+	}
+	if sc.ParentID() != 0 {
+		ret.ParentSpanID = spanIDBytes(sc.ParentID())
+	}
+
+	for key, value := range span.Tags() {
+		switch key {
+		case string(ext.SpanKind):
+			ret.Kind = spanKind(value)
+			continue
+		case string(ext.Error):
+			if isErr, _ := value.(bool); isErr {
+				ret.Status.Code = 2 // STATUS_CODE_ERROR
+			}
+		case "error.message":
+			ret.Status.Message = fmt.Sprint(value)
+		}
+		ret.Attributes = append(ret.Attributes, newKeyValue(key, value))
+	}
+
+	for _, record := range span.Logs() {
+		ret.Events = append(ret.Events, convertLog(record))
+	}
+	return ret
+}
+
+func convertLog(record opentracing.LogRecord) otlpEvent {
+	event := otlpEvent{TimeUnixNano: uint64(record.Timestamp.UnixNano()), Name: "log"}
+	for _, field := range record.Fields {
+		if field.Key() == "event" {
+			event.Name = fmt.Sprint(field.Value())
+			continue
+		}
+		event.Attributes = append(event.Attributes, newKeyValue(field.Key(), field.Value()))
+	}
+	return event
+}
+
+func spanKind(value interface{}) int {
+	kind := fmt.Sprint(value)
+	switch ext.SpanKindEnum(kind) {
+	case ext.SpanKindRPCServerEnum:
+		return 2
+	case ext.SpanKindRPCClientEnum:
+		return 3
+	case ext.SpanKindProducerEnum:
+		return 4
+	case ext.SpanKindConsumerEnum:
+		return 5
+	}
+	return 1
+}
+
+func traceIDBytes(id jaeger.TraceID) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[:8], id.High)
+	binary.BigEndian.PutUint64(buf[8:], id.Low)
+	return buf
+}
+
+func spanIDBytes(id jaeger.SpanID) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(id))
+	return buf
+}
+
+// marshalProto 编码为ExportTraceServiceRequest
+func (r *otlpRequest) marshalProto() []byte {
+	var b []byte
+	for i := range r.ResourceSpans {
+		b = appendMessage(b, 1, r.ResourceSpans[i].marshalProto())
+	}
+	return b
+}
+
+func (r *otlpResourceSpans) marshalProto() []byte {
+	var resource []byte
+	for _, kv := range r.Resource.Attributes {
+		resource = appendMessage(resource, 1, kv.marshalProto())
+	}
+
+	b := appendMessage(nil, 1, resource)
+	for i := range r.ScopeSpans {
+		b = appendMessage(b, 2, r.ScopeSpans[i].marshalProto())
+	}
+	return b
+}
+
+func (s *otlpScopeSpans) marshalProto() []byte {
+	var scope []byte
+	scope = appendString(scope, 1, s.Scope.Name)
+	scope = appendString(scope, 2, s.Scope.Version)
+
+	b := appendMessage(nil, 1, scope)
+	for _, span := range s.Spans {
+		b = appendMessage(b, 2, span.marshalProto())
+	}
+	return b
+}
+
+func (s *otlpSpan) marshalProto() []byte {
+	var b []byte
+	b = appendBytes(b, 1, s.TraceID)
+	b = appendBytes(b, 2, s.SpanID)
+	b = appendBytes(b, 4, s.ParentSpanID)
+	b = appendString(b, 5, s.Name)
+	b = protowire.AppendTag(b, 6, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.Kind))
+	b = protowire.AppendTag(b, 7, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, s.StartTimeUnixNano)
+	b = protowire.AppendTag(b, 8, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, s.EndTimeUnixNano)
+	for _, kv := range s.Attributes {
+		b = appendMessage(b, 9, kv.marshalProto())
+	}
+	for _, event := range s.Events {
+		b = appendMessage(b, 11, event.marshalProto())
+	}
+
+	var status []byte
+	status = appendString(status, 2, s.Status.Message)
+	if s.Status.Code != 0 {
+		status = protowire.AppendTag(status, 3, protowire.VarintType)
+		status = protowire.AppendVarint(status, uint64(s.Status.Code))
+	}
+	return appendMessage(b, 15, status)
+}
+
+func (e *otlpEvent) marshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, e.TimeUnixNano)
+	b = appendString(b, 2, e.Name)
+	for _, kv := range e.Attributes {
+		b = appendMessage(b, 3, kv.marshalProto())
+	}
+	return b
+}
+
+func (kv *otlpKeyValue) marshalProto() []byte {
+	var value []byte
+	switch v := kv.Value; {
+	case v.boolv != nil:
+		value = protowire.AppendTag(value, 2, protowire.VarintType)
+		value = protowire.AppendVarint(value, protowire.EncodeBool(*v.boolv))
+	case v.intv != nil:
+		value = protowire.AppendTag(value, 3, protowire.VarintType)
+		value = protowire.AppendVarint(value, uint64(*v.intv))
+	case v.double != nil:
+		value = protowire.AppendTag(value, 4, protowire.Fixed64Type)
+		value = protowire.AppendFixed64(value, math.Float64bits(*v.double))
+	case v.str != nil:
+		value = protowire.AppendTag(value, 1, protowire.BytesType)
+		value = protowire.AppendString(value, *v.str)
+	}
+
+	b := appendString(nil, 1, kv.Key)
+	return appendMessage(b, 2, value)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendBytes(b []byte, num protowire.Number, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	return appendMessage(b, num, value)
+}
+
+func appendString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
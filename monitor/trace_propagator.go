@@ -0,0 +1,141 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+)
+
+const (
+	// PropagationJaeger jaeger的uber-trace-id请求头
+	PropagationJaeger = "jaeger"
+	// PropagationW3C W3C Trace Context的traceparent请求头
+	PropagationW3C = "w3c"
+
+	traceparentHeader = "traceparent"
+)
+
+// tracePropagator 同时支持jaeger与W3C请求头的传播器
+// 注入时写入所有启用的请求头，提取时优先使用jaeger请求头
+type tracePropagator struct {
+	jaeger *jaeger.TextMapPropagator // 为nil时不使用jaeger请求头
+	w3c    bool                      // 是否使用traceparent请求头
+}
+
+func newTracePropagator(types []string) (*tracePropagator, error) {
+	p := &tracePropagator{}
+	for _, one := range types {
+		switch strings.ToLower(one) {
+		case PropagationJaeger:
+			p.jaeger = jaeger.NewHTTPHeaderPropagator((&jaeger.HeadersConfig{}).ApplyDefaults(), *jaeger.NewNullMetrics())
+		case PropagationW3C:
+			p.w3c = true
+		default:
+			return nil, fmt.Errorf("unknown propagation type (%s)", one)
+		}
+	}
+	return p, nil
+}
+
+// Inject 实现jaeger.Injector
+func (p *tracePropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	if p.jaeger != nil {
+		if err := p.jaeger.Inject(sc, carrier); err != nil {
+			return err
+		}
+	}
+
+	if p.w3c {
+		writer, ok := carrier.(opentracing.TextMapWriter)
+		if !ok {
+			return opentracing.ErrInvalidCarrier
+		}
+		writer.Set(traceparentHeader, formatTraceparent(sc))
+	}
+	return nil
+}
+
+// Extract 实现jaeger.Extractor
+func (p *tracePropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	if p.jaeger != nil {
+		sc, err := p.jaeger.Extract(carrier)
+		if err != opentracing.ErrSpanContextNotFound || !p.w3c {
+			return sc, err
+		}
+	}
+
+	if !p.w3c {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return jaeger.SpanContext{}, opentracing.ErrInvalidCarrier
+	}
+
+	value := ""
+	err := reader.ForeachKey(func(key, val string) error {
+		if strings.ToLower(key) == traceparentHeader {
+			value = val
+		}
+		return nil
+	})
+	if err != nil {
+		return jaeger.SpanContext{}, err
+	}
+	if value == "" {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+	return parseTraceparent(value)
+}
+
+// formatTraceparent 生成traceparent请求头，格式：00-{trace-id}-{parent-id}-{flags}
+func formatTraceparent(sc jaeger.SpanContext) string {
+	flags := "00"
+	if sc.IsSampled() {
+		flags = "01"
+	}
+	traceID := sc.TraceID()
+	return fmt.Sprintf("00-%016x%016x-%016x-%s", traceID.High, traceID.Low, uint64(sc.SpanID()), flags)
+}
+
+// parseTraceparent 解析traceparent请求头
+func parseTraceparent(value string) (jaeger.SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+
+	// 版本号ff不合法，00版本只能有4段
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+
+	traceBytes, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	spanBytes, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+
+	traceID := jaeger.TraceID{
+		High: binary.BigEndian.Uint64(traceBytes[:8]),
+		Low:  binary.BigEndian.Uint64(traceBytes[8:]),
+	}
+	spanID := jaeger.SpanID(binary.BigEndian.Uint64(spanBytes))
+	if !traceID.IsValid() || spanID == 0 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+
+	return jaeger.NewSpanContext(traceID, spanID, 0, flags[0]&0x01 == 0x01, nil), nil
+}
@@ -4,18 +4,21 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
-	"github.com/sirupsen/logrus"
+	"github.com/uber/jaeger-client-go"
 	jaegercfg "github.com/uber/jaeger-client-go/config"
 	"github.com/yuanzhangcai/chaos/common"
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/config"
 )
 
 var (
@@ -31,40 +34,201 @@ func init() {
 	}
 }
 
-// Tracer 链路跟踪中间件
-func Tracer(serviceName string, jaegerHostPort string) func(c *gin.Context) {
+// TraceOption 链路跟踪配置
+type TraceOption struct {
+	ServiceName  string   `json:"service_name"`  // 服务名称
+	Exporter     string   `json:"exporter"`      // 上报方式：jaeger/otlp_http/otlp_grpc/stdout/file/none
+	Endpoint     string   `json:"endpoint"`      // jaeger agent地址(host:port)、jaeger collector地址(http://...)或OTLP collector地址
+	File         string   `json:"file"`          // exporter为file时的输出文件
+	Sampler      string   `json:"sampler"`       // 采样策略：const/probabilistic/ratelimiting，加parentbased_前缀时优先沿用上游的采样决定
+	SamplerParam float64  `json:"sampler_param"` // 采样参数：const为0或1，probabilistic为采样率，ratelimiting为每秒采样数
+	LogSpans     bool     `json:"log_spans"`     // 是否将span写入日志
+	Tags         []string `json:"tags"`          // 从请求参数中提取为span tag的字段，header:前缀表示从请求头中提取
+	Propagation  []string `json:"propagation"`   // 上下文传播方式：jaeger/w3c
+
+	CAFile   string            `json:"ca_file"`   // OTLP endpoint为https://时校验服务端证书的CA文件，为空时使用系统CA
+	CertFile string            `json:"cert_file"` // OTLP endpoint为https://时的客户端证书，用于mTLS
+	KeyFile  string            `json:"key_file"`  // 客户端证书私钥
+	Headers  map[string]string `json:"headers"`   // OTLP上报时附加的请求头(gRPC为metadata)，如鉴权token
+}
+
+var (
+	tracerCloser io.Closer // 当前全局tracer，退出时需关闭以上报剩余的span
+	tracerLock   sync.Mutex
+)
+
+// GetTraceOptionFormConfig 读取链路跟踪配置，未配置的项使用默认值
+func GetTraceOptionFormConfig() (*TraceOption, error) {
+	opt := TraceOption{
+		ServiceName:  config.GetString("common", "server_name"),
+		Exporter:     ExporterJaeger,
+		Sampler:      "parentbased_const",
+		SamplerParam: 1,
+		Tags:         []string{"nid", "act_id", "flow_id"},
+		Propagation:  []string{PropagationJaeger},
+	}
+	err := config.Scan([]string{"trace"}, &opt)
+	if err != nil {
+		log.L().Errorf("读取trace配置失败")
+		return nil, err
+	}
+	return &opt, nil
+}
+
+// parseSampler 解析采样策略，返回jaeger采样配置及是否沿用上游采样决定
+func parseSampler(opt *TraceOption) (*jaegercfg.SamplerConfig, bool, error) {
+	sampler := strings.ToLower(opt.Sampler)
+	parentBased := strings.HasPrefix(sampler, "parentbased_")
+	sampler = strings.TrimPrefix(sampler, "parentbased_")
+
+	switch sampler {
+	case jaeger.SamplerTypeConst, jaeger.SamplerTypeProbabilistic, jaeger.SamplerTypeRateLimiting:
+	default:
+		return nil, false, fmt.Errorf("unknown sampler type (%s)", opt.Sampler)
+	}
+
+	return &jaegercfg.SamplerConfig{Type: sampler, Param: opt.SamplerParam}, parentBased, nil
+}
+
+// NewTracer 根据配置创建tracer
+func NewTracer(opt *TraceOption) (opentracing.Tracer, io.Closer, error) {
+	sampler, _, err := parseSampler(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	propagator, err := newTracePropagator(opt.Propagation)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	cfg := &jaegercfg.Configuration{
-		Sampler: &jaegercfg.SamplerConfig{
-			Type:  "const", //固定采样
-			Param: 1,       //1=全采样、0=不采样
-		},
-		Reporter: &jaegercfg.ReporterConfig{
-			LogSpans:           true,
-			LocalAgentHostPort: jaegerHostPort,
-		},
-		ServiceName: serviceName,
-	}
-	tracer, _, err := cfg.NewTracer()
+		ServiceName: opt.ServiceName,
+		Disabled:    opt.Exporter == ExporterNone,
+		Sampler:     sampler,
+		Reporter:    &jaegercfg.ReporterConfig{LogSpans: opt.LogSpans},
+	}
+
+	options := []jaegercfg.Option{
+		jaegercfg.Logger(&jaegerLogger{}),
+		jaegercfg.Gen128Bit(true), // W3C与OTLP都要求128位trace id
+		jaegercfg.Injector(opentracing.HTTPHeaders, propagator),
+		jaegercfg.Extractor(opentracing.HTTPHeaders, propagator),
+		jaegercfg.Injector(opentracing.TextMap, propagator),
+		jaegercfg.Extractor(opentracing.TextMap, propagator),
+	}
+
+	switch opt.Exporter {
+	case ExporterJaeger:
+		if strings.HasPrefix(opt.Endpoint, "http://") || strings.HasPrefix(opt.Endpoint, "https://") {
+			cfg.Reporter.CollectorEndpoint = opt.Endpoint
+		} else {
+			cfg.Reporter.LocalAgentHostPort = opt.Endpoint
+		}
+	case ExporterNone:
+	default:
+		exporter, err := newSpanExporter(opt)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var reporter jaeger.Reporter = newOTLPReporter(exporter, opt.ServiceName)
+		if opt.LogSpans {
+			reporter = jaeger.NewCompositeReporter(jaeger.NewLoggingReporter(&jaegerLogger{}), reporter)
+		}
+		options = append(options, jaegercfg.Reporter(reporter))
+	}
+
+	return cfg.NewTracer(options...)
+}
+
+// InitTracer 初始化全局tracer，会关闭之前初始化的tracer
+func InitTracer(opt *TraceOption) error {
+	tracer, closer, err := NewTracer(opt)
 	if err != nil {
-		panic(fmt.Sprintf("ERROR: cannot init Jaeger: %v\n", err))
+		return err
 	}
+
+	tracerLock.Lock()
+	defer tracerLock.Unlock()
+	if tracerCloser != nil {
+		_ = tracerCloser.Close()
+	}
+	tracerCloser = closer
 	opentracing.SetGlobalTracer(tracer)
+	return nil
+}
+
+// CloseTracer 关闭全局tracer，上报剩余的span
+func CloseTracer() {
+	tracerLock.Lock()
+	defer tracerLock.Unlock()
+	if tracerCloser != nil {
+		_ = tracerCloser.Close()
+		tracerCloser = nil
+	}
+}
+
+// Tracer 链路跟踪中间件，读取trace配置，serviceName与jaegerHostPort不为空时覆盖配置中的值
+// 初始化失败时只记录日志，使用空tracer继续提供服务
+func Tracer(serviceName string, jaegerHostPort string) func(c *gin.Context) {
+	opt, err := GetTraceOptionFormConfig()
+	if err != nil {
+		opt = &TraceOption{Exporter: ExporterNone, Sampler: "const"}
+	}
+	if serviceName != "" {
+		opt.ServiceName = serviceName
+	}
+	if jaegerHostPort != "" && opt.Exporter == ExporterJaeger {
+		opt.Endpoint = jaegerHostPort
+	}
+
+	if err := InitTracer(opt); err != nil {
+		log.L().Error("链路跟踪初始化失败：", err)
+	}
+
+	return TraceMiddleware(opt)
+}
+
+// TraceMiddleware 链路跟踪中间件，使用全局tracer为每个请求创建span
+func TraceMiddleware(opt *TraceOption) func(c *gin.Context) {
+	samplerCfg, parentBased, err := parseSampler(opt)
+	var sampler jaeger.Sampler
+	if err == nil && !parentBased {
+		sampler, _ = samplerCfg.NewSampler(opt.ServiceName, nil)
+	}
 
 	return func(c *gin.Context) {
 		err := c.Request.ParseForm()
 		if err != nil {
-			logrus.Panic("parse from failed")
+			log.L().Panic("parse from failed")
 		}
 
 		var opts = []opentracing.StartSpanOption{
 			opentracing.Tag{Key: string(ext.Component), Value: "HTTP"},
-			opentracing.Tag{Key: "nid", Value: c.Request.Form.Get("nid")},
-			opentracing.Tag{Key: "act_id", Value: c.Request.Form.Get("act_id")},
-			opentracing.Tag{Key: "flow_id", Value: c.Request.Form.Get("flow_id")},
+			opentracing.Tag{Key: string(ext.HTTPMethod), Value: c.Request.Method},
 			ext.SpanKindRPCServer,
 		}
+
+		for _, field := range opt.Tags {
+			var value string
+			if strings.HasPrefix(field, "header:") {
+				field = strings.TrimPrefix(field, "header:")
+				value = c.Request.Header.Get(field)
+			} else {
+				value = c.Request.Form.Get(field)
+			}
+			if value != "" {
+				opts = append(opts, opentracing.Tag{Key: field, Value: value})
+			}
+		}
+
 		spCtx, err := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(c.Request.Header))
 		if err == nil {
+			// 总是作为上游span的子span，采样器不带parentbased_前缀时由本服务的采样器重新决定是否采样
+			if sc, ok := spCtx.(jaeger.SpanContext); ok && sampler != nil && sc.IsValid() && !sc.IsDebug() {
+				spCtx = resample(sc, sampler, c.Request.URL.Path)
+			}
 			opts = append(opts, opentracing.ChildOf(spCtx))
 		}
 
@@ -73,9 +237,35 @@ func Tracer(serviceName string, jaegerHostPort string) func(c *gin.Context) {
 		c.Set("span", span)
 		c.Set("spanCtx", opentracing.ContextWithSpan(context.Background(), span))
 		c.Next()
+
+		ext.HTTPStatusCode.Set(span, uint16(c.Writer.Status()))
 	}
 }
 
+// resample 使用sampler重新决定上游span的采样标记，保留trace id、span id与baggage
+func resample(sc jaeger.SpanContext, sampler jaeger.Sampler, operation string) jaeger.SpanContext {
+	sampled, _ := sampler.IsSampled(sc.TraceID(), operation)
+	baggage := map[string]string{}
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		baggage[k] = v
+		return true
+	})
+	return jaeger.NewSpanContext(sc.TraceID(), sc.SpanID(), sc.ParentID(), sampled, baggage)
+}
+
+// jaegerLogger 将jaeger日志输出到默认日志对象
+type jaegerLogger struct{}
+
+// Error 实现jaeger.Logger
+func (c *jaegerLogger) Error(msg string) {
+	log.L().Error(msg)
+}
+
+// Infof 实现jaeger.Logger
+func (c *jaegerLogger) Infof(msg string, args ...interface{}) {
+	log.L().Infof(msg, args...)
+}
+
 // StartSpan 创建跟踪span
 func StartSpan(ctx context.Context, name string, tags map[string]interface{}) (opentracing.Span, context.Context) {
 	if ctx == nil {
@@ -94,6 +284,39 @@ func StartSpan(ctx context.Context, name string, tags map[string]interface{}) (o
 	return opentracing.StartSpanFromContext(ctx, name, opts...)
 }
 
+// StartChildSpan 在ctx已有span的情况下创建客户端子span，ctx中没有span时返回nil，避免产生大量孤立的span
+func StartChildSpan(ctx context.Context, name string, tags map[string]interface{}) opentracing.Span {
+	if ctx == nil {
+		return nil
+	}
+
+	parent := opentracing.SpanFromContext(ctx)
+	if parent == nil {
+		return nil
+	}
+
+	var opts = []opentracing.StartSpanOption{
+		opentracing.ChildOf(parent.Context()),
+		ext.SpanKindRPCClient,
+	}
+
+	for key, value := range tags {
+		opts = append(opts, opentracing.Tag{Key: key, Value: value})
+	}
+
+	return parent.Tracer().StartSpan(name, opts...)
+}
+
+// SpanContext 获取Tracer中间件保存在gin上下文中的span上下文
+func SpanContext(c *gin.Context) context.Context {
+	if value, ok := c.Get("spanCtx"); ok {
+		if ctx, ok := value.(context.Context); ok {
+			return ctx
+		}
+	}
+	return context.Background()
+}
+
 // SpanHTTP 发送http请求
 func SpanHTTP(ctx context.Context, params *common.HTTPParam) ([]byte, int, error) {
 	t := defaultTransport
@@ -145,7 +368,7 @@ func SpanHTTP(ctx context.Context, params *common.HTTPParam) ([]byte, int, error
 	tracer := opentracing.GlobalTracer()
 	injectErr := tracer.(opentracing.Tracer).Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(request.Header))
 	if injectErr != nil {
-		log.Fatalf("%s: Couldn't inject headers", err)
+		log.L().Fatalf("%s: Couldn't inject headers", err)
 	}
 
 	response, err := client.Do(request)
@@ -0,0 +1,379 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-client-go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestParseSampler(t *testing.T) {
+	sampler, parentBased, err := parseSampler(&TraceOption{Sampler: "parentbased_probabilistic", SamplerParam: 0.1})
+	assert.Nil(t, err)
+	assert.True(t, parentBased)
+	assert.Equal(t, "probabilistic", sampler.Type)
+
+	_, parentBased, err = parseSampler(&TraceOption{Sampler: "ratelimiting", SamplerParam: 10})
+	assert.Nil(t, err)
+	assert.False(t, parentBased)
+
+	_, _, err = parseSampler(&TraceOption{Sampler: "remote"})
+	assert.NotNil(t, err)
+
+	_, _, err = NewTracer(&TraceOption{ServiceName: "chaos", Exporter: ExporterStdout, Sampler: "probabilistic", SamplerParam: 2})
+	assert.NotNil(t, err)
+
+	_, _, err = NewTracer(&TraceOption{ServiceName: "chaos", Exporter: "unknown", Sampler: "const"})
+	assert.NotNil(t, err)
+}
+
+func TestTracePropagator(t *testing.T) {
+	p, err := newTracePropagator([]string{PropagationJaeger, PropagationW3C})
+	assert.Nil(t, err)
+
+	traceID := jaeger.TraceID{High: 0x4bf92f3577b34da6, Low: 0xa3ce929d0e0e4736}
+	sc := jaeger.NewSpanContext(traceID, 0x00f067aa0ba902b7, 0, true, nil)
+
+	header := http.Header{}
+	assert.Nil(t, p.Inject(sc, opentracing.HTTPHeadersCarrier(header)))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", header.Get("traceparent"))
+	assert.NotEmpty(t, header.Get("uber-trace-id"))
+
+	// 只有traceparent时从traceparent中提取
+	header.Del("uber-trace-id")
+	ret, err := p.Extract(opentracing.HTTPHeadersCarrier(header))
+	assert.Nil(t, err)
+	assert.Equal(t, traceID, ret.TraceID())
+	assert.Equal(t, jaeger.SpanID(0x00f067aa0ba902b7), ret.SpanID())
+	assert.True(t, ret.IsSampled())
+
+	header.Set("traceparent", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	_, err = p.Extract(opentracing.HTTPHeadersCarrier(header))
+	assert.Equal(t, opentracing.ErrSpanContextCorrupted, err)
+
+	header.Del("traceparent")
+	_, err = p.Extract(opentracing.HTTPHeadersCarrier(header))
+	assert.Equal(t, opentracing.ErrSpanContextNotFound, err)
+
+	_, err = newTracePropagator([]string{"b3"})
+	assert.NotNil(t, err)
+}
+
+func TestFileExporter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "trace.json")
+	opt := &TraceOption{
+		ServiceName:  "chaos",
+		Exporter:     ExporterFile,
+		File:         file,
+		Sampler:      "parentbased_const",
+		SamplerParam: 1,
+		Tags:         []string{"nid", "header:X-Request-Id"},
+		Propagation:  []string{PropagationJaeger, PropagationW3C},
+	}
+	assert.Nil(t, InitTracer(opt))
+
+	router := gin.New()
+	router.Use(TraceMiddleware(opt))
+	router.GET("/engine", func(c *gin.Context) {
+		span := StartChildSpan(SpanContext(c), "redis.get", nil)
+		span.Finish()
+		c.String(http.StatusOK, "OK")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/engine?nid=123", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	CloseTracer()
+	opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	buf, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	assert.Equal(t, 1, len(lines))
+
+	req2 := struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Kind         int    `json:"kind"`
+					Attributes   []struct {
+						Key string `json:"key"`
+					} `json:"attributes"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &req2))
+
+	spans := req2.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "redis.get", spans[0].Name)
+	assert.Equal(t, 3, spans[0].Kind)
+	assert.Equal(t, "/engine", spans[1].Name)
+	assert.Equal(t, 2, spans[1].Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[1].TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", spans[1].ParentSpanID)
+
+	keys := []string{}
+	for _, attr := range spans[1].Attributes {
+		keys = append(keys, attr.Key)
+	}
+	assert.Contains(t, keys, "nid")
+	assert.Contains(t, keys, "X-Request-Id")
+}
+
+func TestTraceMiddlewareResample(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "trace.json")
+	opt := &TraceOption{
+		ServiceName:  "chaos",
+		Exporter:     ExporterFile,
+		File:         file,
+		Sampler:      "const",
+		SamplerParam: 1,
+		Propagation:  []string{PropagationW3C},
+	}
+	assert.Nil(t, InitTracer(opt))
+
+	router := gin.New()
+	router.Use(TraceMiddleware(opt))
+	router.GET("/engine", func(c *gin.Context) {
+		c.String(http.StatusOK, "OK")
+	})
+
+	// 上游未采样，本服务的采样器决定采样，仍然关联上游的trace
+	req := httptest.NewRequest(http.MethodGet, "/engine", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	CloseTracer()
+	opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	buf, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Contains(t, string(buf), `"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`)
+	assert.Contains(t, string(buf), `"parentSpanId":"00f067aa0ba902b7"`)
+}
+
+func TestMarshalProto(t *testing.T) {
+	req := &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{newKeyValue("service.name", "chaos")}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "chaos", Version: "v1"},
+			Spans: []*otlpSpan{{
+				TraceID:           make([]byte, 16),
+				SpanID:            make([]byte, 8),
+				ParentSpanID:      make([]byte, 8),
+				Name:              "test",
+				Kind:              2,
+				StartTimeUnixNano: 1,
+				EndTimeUnixNano:   2,
+				Attributes: []otlpKeyValue{
+					newKeyValue("int", 1),
+					newKeyValue("bool", true),
+					newKeyValue("float", 1.5),
+				},
+				Events: []otlpEvent{{TimeUnixNano: 1, Name: "log", Attributes: []otlpKeyValue{newKeyValue("event", "error")}}},
+				Status: otlpStatus{Code: 2, Message: "failed"},
+			}},
+		}},
+	}}}
+
+	// 按opentelemetry-proto的字段定义解析，不能有未知字段
+	msg := dynamicpb.NewMessage(otlpTestDescriptor(t))
+	assert.Nil(t, proto.Unmarshal(req.marshalProto(), msg))
+	assertNoUnknown(t, msg)
+
+	resourceSpans := msg.Get(msg.Descriptor().Fields().ByName("resource_spans")).List().Get(0).Message()
+	scopeSpans := resourceSpans.Get(resourceSpans.Descriptor().Fields().ByName("scope_spans")).List().Get(0).Message()
+	span := scopeSpans.Get(scopeSpans.Descriptor().Fields().ByName("spans")).List().Get(0).Message()
+	fields := span.Descriptor().Fields()
+	assert.Equal(t, "test", span.Get(fields.ByName("name")).String())
+	assert.Equal(t, int64(2), span.Get(fields.ByName("kind")).Int())
+	assert.Equal(t, uint64(2), span.Get(fields.ByName("end_time_unix_nano")).Uint())
+	assert.Equal(t, 3, span.Get(fields.ByName("attributes")).List().Len())
+	assert.Equal(t, 1, span.Get(fields.ByName("events")).List().Len())
+	assert.Equal(t, "failed", span.Get(fields.ByName("status")).Message().Get(fields.ByName("status").Message().Fields().ByName("message")).String())
+}
+
+// otlpTestDescriptor 按opentelemetry-proto v1定义ExportTraceServiceRequest中用到的消息
+func otlpTestDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(num),
+			Type:   typ.Enum(),
+			Label:  label.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(".otlp." + typeName)
+		}
+		return f
+	}
+	message := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+	const (
+		typeMessage = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		typeString  = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		typeBytes   = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+		typeFixed64 = descriptorpb.FieldDescriptorProto_TYPE_FIXED64
+		typeInt32   = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		typeInt64   = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		typeUint32  = descriptorpb.FieldDescriptorProto_TYPE_UINT32
+		typeBool    = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+		typeDouble  = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	)
+
+	anyValue := message("AnyValue",
+		field("string_value", 1, typeString, "", false),
+		field("bool_value", 2, typeBool, "", false),
+		field("int_value", 3, typeInt64, "", false),
+		field("double_value", 4, typeDouble, "", false),
+		field("bytes_value", 7, typeBytes, "", false),
+	)
+	for _, f := range anyValue.Field {
+		f.OneofIndex = proto.Int32(0)
+	}
+	anyValue.OneofDecl = []*descriptorpb.OneofDescriptorProto{{Name: proto.String("value")}}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("otlp_test.proto"),
+		Package: proto.String("otlp"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			anyValue,
+			message("KeyValue",
+				field("key", 1, typeString, "", false),
+				field("value", 2, typeMessage, "AnyValue", false),
+			),
+			message("Resource",
+				field("attributes", 1, typeMessage, "KeyValue", true),
+				field("dropped_attributes_count", 2, typeUint32, "", false),
+			),
+			message("InstrumentationScope",
+				field("name", 1, typeString, "", false),
+				field("version", 2, typeString, "", false),
+			),
+			message("Event",
+				field("time_unix_nano", 1, typeFixed64, "", false),
+				field("name", 2, typeString, "", false),
+				field("attributes", 3, typeMessage, "KeyValue", true),
+			),
+			message("Status",
+				field("message", 2, typeString, "", false),
+				field("code", 3, typeInt32, "", false),
+			),
+			message("Span",
+				field("trace_id", 1, typeBytes, "", false),
+				field("span_id", 2, typeBytes, "", false),
+				field("trace_state", 3, typeString, "", false),
+				field("parent_span_id", 4, typeBytes, "", false),
+				field("name", 5, typeString, "", false),
+				field("kind", 6, typeInt32, "", false),
+				field("start_time_unix_nano", 7, typeFixed64, "", false),
+				field("end_time_unix_nano", 8, typeFixed64, "", false),
+				field("attributes", 9, typeMessage, "KeyValue", true),
+				field("events", 11, typeMessage, "Event", true),
+				field("status", 15, typeMessage, "Status", false),
+			),
+			message("ScopeSpans",
+				field("scope", 1, typeMessage, "InstrumentationScope", false),
+				field("spans", 2, typeMessage, "Span", true),
+			),
+			message("ResourceSpans",
+				field("resource", 1, typeMessage, "Resource", false),
+				field("scope_spans", 2, typeMessage, "ScopeSpans", true),
+			),
+			message("ExportTraceServiceRequest",
+				field("resource_spans", 1, typeMessage, "ResourceSpans", true),
+			),
+		},
+	}
+
+	fd, err := protodesc.NewFile(file, new(protoregistry.Files))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return fd.Messages().ByName("ExportTraceServiceRequest")
+}
+
+// assertNoUnknown 递归检查消息中没有未知字段
+func assertNoUnknown(t *testing.T, msg protoreflect.Message) {
+	assert.Empty(t, msg.GetUnknown(), string(msg.Descriptor().FullName()))
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Message() == nil {
+			return true
+		}
+		if fd.IsList() {
+			for i := 0; i < v.List().Len(); i++ {
+				assertNoUnknown(t, v.List().Get(i).Message())
+			}
+			return true
+		}
+		assertNoUnknown(t, v.Message())
+		return true
+	})
+}
+
+func TestOTLPHTTPExporterTLS(t *testing.T) {
+	var header http.Header
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		assert.Equal(t, otlpTracePath, r.URL.Path)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.Nil(t, ioutil.WriteFile(caFile, ca, 0600))
+
+	// 未指定CA时不信任自签名证书
+	exporter, err := newOTLPHTTPExporter(&TraceOption{Endpoint: server.URL})
+	assert.Nil(t, err)
+	assert.NotNil(t, exporter.Export(context.Background(), &otlpRequest{}))
+
+	exporter, err = newOTLPHTTPExporter(&TraceOption{
+		Endpoint: server.URL,
+		CAFile:   caFile,
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, exporter.Export(context.Background(), &otlpRequest{}))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+	assert.Equal(t, "application/x-protobuf", header.Get("Content-Type"))
+
+	_, err = newOTLPHTTPExporter(&TraceOption{Endpoint: server.URL, CAFile: filepath.Join(t.TempDir(), "none.pem")})
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(caFile, []byte("invalid"), 0600))
+	_, err = newOTLPHTTPExporter(&TraceOption{Endpoint: server.URL, CAFile: caFile})
+	assert.NotNil(t, err)
+
+	_, err = newOTLPGRPCExporter(&TraceOption{Endpoint: "https://127.0.0.1:4317", CertFile: caFile})
+	assert.NotNil(t, err)
+}
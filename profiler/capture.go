@@ -0,0 +1,178 @@
+package profiler
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yuanzhangcai/chaos/common"
+	"github.com/yuanzhangcai/chaos/log"
+)
+
+const (
+	fileSuffix = ".pprof"
+	timeFormat = "20060102-150405"
+)
+
+var unsafeChars = regexp.MustCompile(`[^0-9A-Za-z._-]+`)
+
+// Capture 立即采集配置的所有profile，返回保存的文件名
+func (p *Profiler) Capture(reason string) ([]string, error) {
+	return p.capture(reason)
+}
+
+// capture 依次采集各类型的profile，同一时间只有一次采集
+func (p *Profiler) capture(reason string) ([]string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	var files []string
+	var errs []string
+	for _, one := range p.option.Profiles {
+		name := filepath.Join(p.option.Dir, fileName(now, reason, one))
+		if err := p.write(name, one); err != nil {
+			os.Remove(name)
+			errs = append(errs, one+": "+err.Error())
+			continue
+		}
+		files = append(files, name)
+	}
+
+	p.clearHistory()
+
+	if len(errs) > 0 {
+		err := errors.New(strings.Join(errs, "; "))
+		log.L().WithField("reason", reason).Error("性能分析采集失败：", err)
+		return files, err
+	}
+	return files, nil
+}
+
+// write 采集单个profile写入文件
+func (p *Profiler) write(name, profile string) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if profile != ProfileCPU {
+		return pprof.Lookup(profile).WriteTo(f, 0)
+	}
+
+	// 其它地方(如pprof接口)正在采集cpu时会返回错误
+	if err = pprof.StartCPUProfile(f); err != nil {
+		return err
+	}
+	timer := time.NewTimer(time.Duration(p.option.CPUDuration) * time.Second)
+	select {
+	case <-timer.C:
+	case <-p.quit:
+		timer.Stop()
+	}
+	pprof.StopCPUProfile()
+	return nil
+}
+
+// fileName profile文件名：程序名.时间.原因.类型.版本-commit.pprof
+func fileName(now time.Time, reason, profile string) string {
+	version := common.Version
+	if version == "" {
+		version = "unknown"
+	}
+	commit := common.Commit
+	if len(commit) > 8 {
+		commit = commit[:8]
+	}
+	if commit != "" {
+		version += "-" + commit
+	}
+
+	parts := []string{filepath.Base(os.Args[0]), now.Format(timeFormat), reason, profile, version}
+	for i, one := range parts {
+		parts[i] = unsafeChars.ReplaceAllString(one, "_")
+	}
+	return strings.Join(parts, ".") + fileSuffix
+}
+
+// clearHistory 按保留个数、天数与总大小清理历史profile文件
+func (p *Profiler) clearHistory() {
+	infos, err := ioutil.ReadDir(p.option.Dir)
+	if err != nil {
+		return
+	}
+
+	var files []os.FileInfo
+	for _, fi := range infos {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), fileSuffix) {
+			files = append(files, fi)
+		}
+	}
+
+	// 按修改时间从新到旧排序
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+
+	opt := p.option
+	expire := time.Now().Add(-time.Hour * 24 * time.Duration(opt.MaxDays))
+	maxTotal := opt.MaxTotalSize * 1024 * 1024
+	var total int64
+	for i, fi := range files {
+		total += fi.Size()
+		if (opt.MaxDays > 0 && fi.ModTime().Before(expire)) ||
+			(opt.MaxFiles > 0 && i >= opt.MaxFiles) ||
+			(maxTotal > 0 && total > maxTotal) {
+			os.Remove(filepath.Join(opt.Dir, fi.Name()))
+		}
+	}
+}
+
+// Files 返回已保存的profile文件名，从新到旧排序
+func (p *Profiler) Files() []string {
+	infos, err := ioutil.ReadDir(p.option.Dir)
+	if err != nil {
+		return nil
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().After(infos[j].ModTime()) })
+	var files []string
+	for _, fi := range infos {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), fileSuffix) {
+			files = append(files, fi.Name())
+		}
+	}
+	return files
+}
+
+// Handler 性能分析管理接口，GET 返回已保存的profile文件，POST 立即采集
+func (p *Profiler) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data interface{}
+		switch r.Method {
+		case http.MethodGet:
+			data = p.Files()
+		case http.MethodPost:
+			files, err := p.Capture(ReasonManual)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			data = files
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		buf, _ := json.Marshal(data)
+		w.Header().Set("Content-Type", "application/json;charset=utf-8")
+		_, _ = w.Write(buf)
+	})
+}
@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package profiler
+
+import "time"
+
+// cpuTime 非unix平台不支持获取进程CPU时间，始终返回0，CPU使用率触发条件不会生效
+func cpuTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,301 @@
+// 持续性能分析，定时或在CPU、协程数、内存、接口耗时超过阈值时自动保存profile
+
+package profiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/chaos/monitor"
+	"github.com/yuanzhangcai/config"
+)
+
+const (
+	// ProfileCPU cpu profile
+	ProfileCPU = "cpu"
+	// ProfileHeap 内存profile
+	ProfileHeap = "heap"
+	// ProfileGoroutine 协程profile
+	ProfileGoroutine = "goroutine"
+	// ProfileMutex 锁竞争profile
+	ProfileMutex = "mutex"
+	// ProfileBlock 阻塞profile
+	ProfileBlock = "block"
+
+	// ReasonInterval 定时采集
+	ReasonInterval = "interval"
+	// ReasonCPU CPU使用率超过阈值
+	ReasonCPU = "cpu_high"
+	// ReasonGoroutine 协程数超过阈值或突增
+	ReasonGoroutine = "goroutine_spike"
+	// ReasonHeap 内存超过阈值或增长过快
+	ReasonHeap = "heap_growth"
+	// ReasonLatency 接口耗时p99超过阈值
+	ReasonLatency = "latency_high"
+	// ReasonManual 手动采集
+	ReasonManual = "manual"
+)
+
+// Option 性能分析配置
+type Option struct {
+	Enable             bool     `json:"enable"`              // 是否开启
+	Dir                string   `json:"dir"`                 // profile保存目录，为空时为日志目录下的profiles目录
+	Profiles           []string `json:"profiles"`            // 采集的profile类型：cpu/heap/goroutine/mutex/block
+	Interval           int64    `json:"interval"`            // 定时采集间隔，单位秒，0表示不定时采集
+	CheckInterval      int64    `json:"check_interval"`      // 检查触发条件的间隔，单位秒
+	Cooldown           int64    `json:"cooldown"`            // 触发采集后的冷却时间，单位秒，冷却期内不再触发
+	CPUDuration        int64    `json:"cpu_duration"`        // cpu profile采集时长，单位秒
+	CPUThreshold       float64  `json:"cpu_threshold"`       // CPU使用率阈值，单位%，按所有核计算，0表示不检查
+	GoroutineThreshold int      `json:"goroutine_threshold"` // 协程数阈值，0表示不检查
+	GoroutineGrowth    float64  `json:"goroutine_growth"`    // 协程数相对上次检查的增长比例阈值，单位%，0表示不检查
+	HeapThreshold      int64    `json:"heap_threshold"`      // 堆内存阈值，单位MB，0表示不检查
+	HeapGrowth         float64  `json:"heap_growth"`         // 堆内存相对上次检查的增长比例阈值，单位%，0表示不检查
+	LatencyThreshold   int64    `json:"latency_threshold"`   // 接口耗时p99阈值，单位毫秒，0表示不检查
+	MutexFraction      int      `json:"mutex_fraction"`      // 锁竞争采样比例，采集mutex时生效
+	BlockRate          int      `json:"block_rate"`          // 阻塞采样率，单位纳秒，采集block时生效
+	MaxFiles           int      `json:"max_files"`           // 最多保留的profile文件数，0表示不限制
+	MaxDays            int64    `json:"max_days"`            // profile文件最多保留天数，0表示不限制
+	MaxTotalSize       int64    `json:"max_total_size"`      // profile文件总大小上限，单位MB，0表示不限制
+}
+
+// Stats 触发条件检查时的运行状态
+type Stats struct {
+	CPU        float64       // CPU使用率，单位%
+	Goroutines int           // 协程数
+	HeapInuse  uint64        // 堆内存，单位字节
+	Latency    time.Duration // 接口耗时p99
+}
+
+// Profiler 性能分析器
+type Profiler struct {
+	option *Option
+	stats  func() *Stats // 获取运行状态，测试时替换
+	last   *Stats        // 上次检查时的运行状态
+	next   time.Time     // 冷却结束时间
+	lock   sync.Mutex    // 保证同一时间只有一次采集
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+var (
+	defaultProfiler *Profiler
+	defaultLock     sync.Mutex
+)
+
+// GetOptionFromConfig 读取性能分析配置
+func GetOptionFromConfig() (*Option, error) {
+	opt := Option{
+		Profiles:      []string{ProfileCPU, ProfileHeap, ProfileGoroutine},
+		CheckInterval: 10,
+		Cooldown:      300,
+		CPUDuration:   10,
+		MutexFraction: 5,
+		BlockRate:     int(time.Millisecond),
+		MaxFiles:      200,
+		MaxDays:       7,
+	}
+	err := config.Scan([]string{"profiler"}, &opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.Dir == "" {
+		opt.Dir = filepath.Join(config.GetString("log", "filedir"), "profiles")
+	}
+	return &opt, nil
+}
+
+// New 创建性能分析器
+func New(opt *Option) (*Profiler, error) {
+	for _, one := range opt.Profiles {
+		switch one {
+		case ProfileCPU, ProfileHeap, ProfileGoroutine, ProfileMutex, ProfileBlock:
+		default:
+			return nil, fmt.Errorf("unknown profile type (%s)", one)
+		}
+	}
+
+	if opt.Dir == "" {
+		return nil, fmt.Errorf("profile dir is empty")
+	}
+	if err := os.MkdirAll(opt.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if opt.CheckInterval <= 0 {
+		opt.CheckInterval = 10
+	}
+	if opt.CPUDuration <= 0 {
+		opt.CPUDuration = 10
+	}
+
+	return &Profiler{
+		option: opt,
+		stats:  newStatsReader().read,
+		quit:   make(chan struct{}),
+	}, nil
+}
+
+// Init 使用配置启动默认性能分析器，没有开启时不启动
+func Init() error {
+	opt, err := GetOptionFromConfig()
+	if err != nil {
+		return err
+	}
+	if !opt.Enable {
+		return nil
+	}
+
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	if defaultProfiler != nil {
+		return nil
+	}
+
+	p, err := New(opt)
+	if err != nil {
+		return err
+	}
+	p.Start()
+	defaultProfiler = p
+	return nil
+}
+
+// GetProfiler 获取默认性能分析器，未启动时返回nil
+func GetProfiler() *Profiler {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	return defaultProfiler
+}
+
+// Stop 停止默认性能分析器
+func Stop() {
+	defaultLock.Lock()
+	p := defaultProfiler
+	defaultProfiler = nil
+	defaultLock.Unlock()
+
+	if p != nil {
+		p.Close()
+	}
+}
+
+// Start 开始定时采集与触发条件检查
+func (p *Profiler) Start() {
+	for _, one := range p.option.Profiles {
+		switch one {
+		case ProfileMutex:
+			runtime.SetMutexProfileFraction(p.option.MutexFraction)
+		case ProfileBlock:
+			runtime.SetBlockProfileRate(p.option.BlockRate)
+		}
+	}
+
+	p.wg.Add(1)
+	go p.loop()
+}
+
+// Close 停止采集，等待正在进行的采集完成
+func (p *Profiler) Close() {
+	p.once.Do(func() {
+		close(p.quit)
+		p.wg.Wait()
+	})
+}
+
+func (p *Profiler) loop() {
+	defer p.wg.Done()
+
+	check := time.NewTicker(time.Duration(p.option.CheckInterval) * time.Second)
+	defer check.Stop()
+
+	var interval <-chan time.Time
+	if p.option.Interval > 0 {
+		ticker := time.NewTicker(time.Duration(p.option.Interval) * time.Second)
+		defer ticker.Stop()
+		interval = ticker.C
+	}
+
+	for {
+		select {
+		case <-interval:
+			_, _ = p.capture(ReasonInterval)
+		case now := <-check.C:
+			if reason := p.check(); reason != "" && !now.Before(p.next) {
+				p.next = now.Add(time.Duration(p.option.Cooldown) * time.Second)
+				log.L().WithField("reason", reason).Warn("触发性能分析采集")
+				_, _ = p.capture(reason)
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// check 检查触发条件，返回触发原因，没有触发时返回空
+func (p *Profiler) check() string {
+	stats := p.stats()
+	last := p.last
+	p.last = stats
+
+	opt := p.option
+	switch {
+	case opt.CPUThreshold > 0 && stats.CPU >= opt.CPUThreshold:
+		return ReasonCPU
+	case opt.GoroutineThreshold > 0 && stats.Goroutines >= opt.GoroutineThreshold:
+		return ReasonGoroutine
+	case opt.GoroutineGrowth > 0 && last != nil && last.Goroutines > 0 &&
+		growth(float64(last.Goroutines), float64(stats.Goroutines)) >= opt.GoroutineGrowth:
+		return ReasonGoroutine
+	case opt.HeapThreshold > 0 && stats.HeapInuse >= uint64(opt.HeapThreshold)*1024*1024:
+		return ReasonHeap
+	case opt.HeapGrowth > 0 && last != nil && last.HeapInuse > 0 &&
+		growth(float64(last.HeapInuse), float64(stats.HeapInuse)) >= opt.HeapGrowth:
+		return ReasonHeap
+	case opt.LatencyThreshold > 0 && stats.Latency >= time.Duration(opt.LatencyThreshold)*time.Millisecond:
+		return ReasonLatency
+	}
+	return ""
+}
+
+// growth 计算增长比例，单位%
+func growth(last, curr float64) float64 {
+	return (curr - last) / last * 100
+}
+
+// statsReader 读取运行状态，CPU使用率根据两次读取之间的CPU时间计算
+type statsReader struct {
+	lastTime time.Time
+	lastCPU  time.Duration
+}
+
+func newStatsReader() *statsReader {
+	return &statsReader{lastTime: time.Now(), lastCPU: cpuTime()}
+}
+
+func (r *statsReader) read() *Stats {
+	now := time.Now()
+	cpu := cpuTime()
+
+	stats := &Stats{
+		Goroutines: runtime.NumGoroutine(),
+		Latency:    monitor.CostTimeQuantile(0.99),
+	}
+	if wall := now.Sub(r.lastTime); wall > 0 {
+		stats.CPU = float64(cpu-r.lastCPU) / float64(wall) / float64(runtime.NumCPU()) * 100
+	}
+	r.lastTime = now
+	r.lastCPU = cpu
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats.HeapInuse = m.HeapInuse
+	return stats
+}
@@ -0,0 +1,176 @@
+package profiler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/chaos/common"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(&Option{Dir: os.TempDir(), Profiles: []string{"threadcreate"}})
+	assert.NotNil(t, err)
+
+	_, err = New(&Option{Profiles: []string{ProfileHeap}})
+	assert.NotNil(t, err)
+
+	p, err := New(&Option{Dir: t.TempDir(), Profiles: []string{ProfileHeap}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), p.option.CheckInterval)
+	assert.Equal(t, int64(10), p.option.CPUDuration)
+}
+
+func TestCheck(t *testing.T) {
+	p, err := New(&Option{
+		Dir:                t.TempDir(),
+		CPUThreshold:       80,
+		GoroutineThreshold: 1000,
+		GoroutineGrowth:    100,
+		HeapThreshold:      1,
+		HeapGrowth:         50,
+		LatencyThreshold:   500,
+	})
+	assert.Nil(t, err)
+
+	var stats *Stats
+	p.stats = func() *Stats { return stats }
+
+	stats = &Stats{CPU: 10, Goroutines: 10, HeapInuse: 1000, Latency: time.Millisecond}
+	assert.Equal(t, "", p.check())
+
+	stats = &Stats{CPU: 90, Goroutines: 10, HeapInuse: 1000}
+	assert.Equal(t, ReasonCPU, p.check())
+
+	stats = &Stats{Goroutines: 15, HeapInuse: 1000}
+	assert.Equal(t, "", p.check())
+
+	stats = &Stats{Goroutines: 40, HeapInuse: 1000}
+	assert.Equal(t, ReasonGoroutine, p.check())
+
+	stats = &Stats{Goroutines: 2000, HeapInuse: 1000}
+	assert.Equal(t, ReasonGoroutine, p.check())
+
+	stats = &Stats{Goroutines: 10, HeapInuse: 1000}
+	assert.Equal(t, "", p.check())
+
+	stats = &Stats{Goroutines: 10, HeapInuse: 1600}
+	assert.Equal(t, ReasonHeap, p.check())
+
+	stats = &Stats{Goroutines: 10, HeapInuse: 2 * 1024 * 1024}
+	assert.Equal(t, ReasonHeap, p.check())
+
+	p.option.HeapThreshold = 0
+	stats = &Stats{Goroutines: 10, HeapInuse: 2 * 1024 * 1024, Latency: time.Second}
+	assert.Equal(t, ReasonLatency, p.check())
+}
+
+func TestStatsReader(t *testing.T) {
+	r := newStatsReader()
+	stats := r.read()
+	assert.True(t, stats.Goroutines > 0)
+	assert.True(t, stats.HeapInuse > 0)
+	assert.True(t, stats.CPU >= 0)
+}
+
+func TestCapture(t *testing.T) {
+	oldVersion, oldCommit := common.Version, common.Commit
+	common.Version, common.Commit = "v1.0.0", "0123456789abcdef"
+	defer func() { common.Version, common.Commit = oldVersion, oldCommit }()
+
+	p, err := New(&Option{
+		Dir:         t.TempDir(),
+		Profiles:    []string{ProfileCPU, ProfileHeap, ProfileGoroutine, ProfileMutex, ProfileBlock},
+		CPUDuration: 1,
+	})
+	assert.Nil(t, err)
+	close(p.quit) // 关闭后cpu profile立即结束采集
+
+	files, err := p.Capture(ReasonManual)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(files))
+	for _, one := range files {
+		name := filepath.Base(one)
+		assert.True(t, strings.Contains(name, ".manual."))
+		assert.True(t, strings.HasSuffix(name, ".v1.0.0-01234567.pprof"))
+
+		fi, err := os.Stat(one)
+		assert.Nil(t, err)
+		assert.True(t, fi.Size() > 0)
+	}
+	assert.Equal(t, 5, len(p.Files()))
+}
+
+func TestClearHistory(t *testing.T) {
+	p, err := New(&Option{Dir: t.TempDir(), Profiles: []string{ProfileHeap}, MaxFiles: 2, MaxDays: 1})
+	assert.Nil(t, err)
+
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		name := filepath.Join(p.option.Dir, fileName(now.Add(time.Duration(i)*time.Second), ReasonInterval, ProfileHeap))
+		assert.Nil(t, ioutil.WriteFile(name, []byte("test"), 0644))
+		mtime := now.Add(time.Duration(i-4) * time.Minute)
+		assert.Nil(t, os.Chtimes(name, mtime, mtime))
+	}
+	expired := filepath.Join(p.option.Dir, "expired"+fileSuffix)
+	assert.Nil(t, ioutil.WriteFile(expired, []byte("test"), 0644))
+	mtime := now.Add(-48 * time.Hour)
+	assert.Nil(t, os.Chtimes(expired, mtime, mtime))
+	other := filepath.Join(p.option.Dir, "other.txt")
+	assert.Nil(t, ioutil.WriteFile(other, []byte("test"), 0644))
+
+	p.clearHistory()
+	assert.Equal(t, 2, len(p.Files()))
+	_, err = os.Stat(expired)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(other)
+	assert.Nil(t, err)
+
+	p.option.MaxFiles = 0
+	p.option.MaxTotalSize = 1
+	big := filepath.Join(p.option.Dir, "big"+fileSuffix)
+	assert.Nil(t, ioutil.WriteFile(big, make([]byte, 2*1024*1024), 0644))
+	p.clearHistory()
+	_, err = os.Stat(big)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestHandler(t *testing.T) {
+	p, err := New(&Option{Dir: t.TempDir(), Profiles: []string{ProfileGoroutine}})
+	assert.Nil(t, err)
+	handler := p.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/debug/profiler", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/profiler", nil))
+	files := []string{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &files))
+	assert.Equal(t, 1, len(files))
+	assert.True(t, strings.Contains(files[0], ".goroutine."))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/debug/profiler", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestStartClose(t *testing.T) {
+	p, err := New(&Option{Dir: t.TempDir(), Profiles: []string{ProfileMutex, ProfileBlock}, MutexFraction: 1, BlockRate: 1})
+	assert.Nil(t, err)
+	p.Start()
+	p.Close()
+	p.Close()
+
+	runtime.SetMutexProfileFraction(0)
+	runtime.SetBlockProfileRate(0)
+}
@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// BalancerRoundRobin 轮询
+	BalancerRoundRobin = "round_robin"
+	// BalancerWeighted 加权轮询
+	BalancerWeighted = "weighted"
+	// BalancerLeastPending 最少进行中请求
+	BalancerLeastPending = "least_pending"
+)
+
+// Balancer 负载均衡器
+type Balancer interface {
+	// Pick 从实例列表中选择一个实例，请求结束后需调用返回的done函数
+	Pick(list []*Instance) (*Instance, func(), error)
+}
+
+// NewBalancer 根据名称创建负载均衡器，名称错误时使用轮询
+func NewBalancer(name string) Balancer {
+	switch name {
+	case BalancerWeighted:
+		return NewWeighted()
+	case BalancerLeastPending:
+		return NewLeastPending()
+	}
+	return NewRoundRobin()
+}
+
+func noop() {}
+
+// RoundRobin 轮询负载均衡器
+type RoundRobin struct {
+	index uint64
+}
+
+// NewRoundRobin 创建轮询负载均衡器
+func NewRoundRobin() *RoundRobin {
+	return &RoundRobin{}
+}
+
+// Pick 依次选择实例
+func (c *RoundRobin) Pick(list []*Instance) (*Instance, func(), error) {
+	if len(list) == 0 {
+		return nil, noop, ErrNoInstance
+	}
+	index := atomic.AddUint64(&c.index, 1) - 1
+	return list[index%uint64(len(list))], noop, nil
+}
+
+// Weighted 平滑加权轮询负载均衡器，权重为0的实例不会被选中
+type Weighted struct {
+	lock    sync.Mutex
+	current map[string]int
+}
+
+// NewWeighted 创建加权轮询负载均衡器
+func NewWeighted() *Weighted {
+	return &Weighted{current: make(map[string]int)}
+}
+
+// Pick 按权重选择实例
+func (c *Weighted) Pick(list []*Instance) (*Instance, func(), error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var best *Instance
+	total := 0
+	for _, one := range list {
+		weight := one.Weight()
+		if weight == 0 {
+			continue
+		}
+		total += weight
+		c.current[one.ID] += weight
+		if best == nil || c.current[one.ID] > c.current[best.ID] {
+			best = one
+		}
+	}
+	if best == nil {
+		return nil, noop, ErrNoInstance
+	}
+	c.current[best.ID] -= total
+
+	// 清理已下线实例的状态
+	if len(c.current) > 2*len(list) {
+		alive := make(map[string]int, len(list))
+		for _, one := range list {
+			if v, ok := c.current[one.ID]; ok {
+				alive[one.ID] = v
+			}
+		}
+		c.current = alive
+	}
+	return best, noop, nil
+}
+
+// LeastPending 最少进行中请求负载均衡器，进行中请求数相同时轮询
+type LeastPending struct {
+	lock    sync.Mutex
+	index   int
+	pending map[string]int
+}
+
+// NewLeastPending 创建最少进行中请求负载均衡器
+func NewLeastPending() *LeastPending {
+	return &LeastPending{pending: make(map[string]int)}
+}
+
+// Pick 选择进行中请求数最少的实例
+func (c *LeastPending) Pick(list []*Instance) (*Instance, func(), error) {
+	if len(list) == 0 {
+		return nil, noop, ErrNoInstance
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.index++
+	var best *Instance
+	for i := range list {
+		one := list[(c.index+i)%len(list)]
+		if best == nil || c.pending[one.ID] < c.pending[best.ID] {
+			best = one
+		}
+	}
+
+	id := best.ID
+	c.pending[id]++
+	var once sync.Once
+	done := func() {
+		once.Do(func() {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			if c.pending[id]--; c.pending[id] <= 0 {
+				delete(c.pending, id)
+			}
+		})
+	}
+	return best, done, nil
+}
+
+// Pending 实例进行中的请求数
+func (c *LeastPending) Pending(id string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.pending[id]
+}
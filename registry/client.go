@@ -0,0 +1,174 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver 服务实例解析器，监听服务实例变化并缓存最新的实例列表
+type Resolver struct {
+	name   string
+	lock   sync.RWMutex
+	list   []*Instance
+	cancel func()
+}
+
+// NewResolver 创建服务实例解析器
+func NewResolver(reg Registry, name string) (*Resolver, error) {
+	r := &Resolver{name: name}
+	cancel, err := reg.Watch(name, r.update)
+	if err != nil {
+		return nil, err
+	}
+	r.cancel = cancel
+	return r, nil
+}
+
+func (r *Resolver) update(list []*Instance) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.list = list
+}
+
+// Name 服务名称
+func (r *Resolver) Name() string {
+	return r.name
+}
+
+// Instances 获取服务当前的所有实例
+func (r *Resolver) Instances() []*Instance {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.list
+}
+
+// Close 停止监听服务实例变化
+func (r *Resolver) Close() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// Client 基于服务名的负载均衡HTTP客户端，请求地址中只需要指定路径
+type Client struct {
+	resolver *Resolver
+	balancer Balancer
+	scheme   string
+	client   *http.Client
+}
+
+// NewClient 创建负载均衡HTTP客户端，balancer为空时使用轮询
+func NewClient(reg Registry, name string, balancer Balancer) (*Client, error) {
+	resolver, err := NewResolver(reg, name)
+	if err != nil {
+		return nil, err
+	}
+	if balancer == nil {
+		balancer = NewRoundRobin()
+	}
+
+	return &Client{
+		resolver: resolver,
+		balancer: balancer,
+		scheme:   "http",
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Discover 使用默认服务注册与配置的负载均衡策略创建服务的HTTP客户端
+func Discover(name string) (*Client, error) {
+	reg := GetRegistry()
+	if reg == nil {
+		return nil, errors.New("registry is not initialized")
+	}
+
+	opt, err := GetOptionFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(reg, name, NewBalancer(opt.Balancer))
+}
+
+// SetHTTPClient 设置底层HTTP客户端，用于设置超时时间、Transport等
+func (c *Client) SetHTTPClient(client *http.Client) {
+	c.client = client
+}
+
+// SetScheme 设置请求协议，默认为http
+func (c *Client) SetScheme(scheme string) {
+	c.scheme = scheme
+}
+
+// Resolver 获取服务实例解析器
+func (c *Client) Resolver() *Resolver {
+	return c.resolver
+}
+
+// Do 选择一个服务实例发送请求，请求地址的协议与主机会被替换成所选实例的地址
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ins, done, err := c.balancer.Pick(c.resolver.Instances())
+	if err != nil {
+		return nil, err
+	}
+
+	req.URL.Scheme = c.scheme
+	req.URL.Host = ins.Host
+	req.Host = ins.Host
+	resp, err := c.client.Do(req)
+	if err != nil {
+		done()
+		return nil, err
+	}
+
+	// 响应内容读取完毕关闭后，才算请求结束
+	resp.Body = &doneBody{ReadCloser: resp.Body, done: done}
+	return resp, nil
+}
+
+// Get 发送GET请求
+func (c *Client) Get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post 发送POST请求
+func (c *Client) Post(ctx context.Context, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(path), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
+}
+
+// Close 停止监听服务实例变化
+func (c *Client) Close() {
+	c.resolver.Close()
+}
+
+func (c *Client) url(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return c.scheme + "://" + c.resolver.Name() + path
+}
+
+// doneBody 响应内容关闭时通知负载均衡器请求结束
+type doneBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (c *doneBody) Close() error {
+	err := c.ReadCloser.Close()
+	c.done()
+	return err
+}
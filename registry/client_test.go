@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBalancer(t *testing.T) {
+	assert.IsType(t, &RoundRobin{}, NewBalancer(""))
+	assert.IsType(t, &Weighted{}, NewBalancer(BalancerWeighted))
+	assert.IsType(t, &LeastPending{}, NewBalancer(BalancerLeastPending))
+
+	for _, one := range []Balancer{NewRoundRobin(), NewWeighted(), NewLeastPending()} {
+		_, _, err := one.Pick(nil)
+		assert.Equal(t, ErrNoInstance, err)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	list := []*Instance{newInstance("user", "a", "", nil), newInstance("user", "b", "", nil)}
+	b := NewRoundRobin()
+	var ids []string
+	for i := 0; i < 4; i++ {
+		ins, done, err := b.Pick(list)
+		assert.Nil(t, err)
+		done()
+		ids = append(ids, ins.ID)
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b"}, ids)
+}
+
+func TestWeighted(t *testing.T) {
+	list := []*Instance{
+		newInstance("user", "a", "", map[string]string{MetaWeight: "5"}),
+		newInstance("user", "b", "", map[string]string{MetaWeight: "1"}),
+		newInstance("user", "c", "", map[string]string{MetaWeight: "1"}),
+		newInstance("user", "d", "", map[string]string{MetaWeight: "0"}),
+	}
+	b := NewWeighted()
+	count := make(map[string]int)
+	var ids []string
+	for i := 0; i < 7; i++ {
+		ins, _, err := b.Pick(list)
+		assert.Nil(t, err)
+		count[ins.ID]++
+		ids = append(ids, ins.ID)
+	}
+	assert.Equal(t, map[string]int{"a": 5, "b": 1, "c": 1}, count)
+	// 平滑加权，不会连续选中同一实例5次
+	assert.Equal(t, []string{"a", "a", "b", "a", "c", "a", "a"}, ids)
+
+	_, _, err := b.Pick(list[3:])
+	assert.Equal(t, ErrNoInstance, err)
+}
+
+func TestLeastPending(t *testing.T) {
+	list := []*Instance{newInstance("user", "a", "", nil), newInstance("user", "b", "", nil)}
+	b := NewLeastPending()
+
+	first, done1, _ := b.Pick(list)
+	second, done2, _ := b.Pick(list)
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, 1, b.Pending(first.ID))
+
+	done1()
+	done1() // 重复调用不影响计数
+	assert.Equal(t, 0, b.Pending(first.ID))
+
+	third, done3, _ := b.Pick(list)
+	assert.Equal(t, first.ID, third.ID)
+	done2()
+	done3()
+}
+
+func newServer(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write([]byte(name + ":" + r.Method + ":" + r.URL.Path + ":" + string(body)))
+	}))
+}
+
+func TestClient(t *testing.T) {
+	srvA := newServer("a")
+	defer srvA.Close()
+	srvB := newServer("b")
+	defer srvB.Close()
+
+	reg := NewMemory()
+	_, err := Discover("user")
+	assert.NotNil(t, err)
+
+	client, err := NewClient(reg, "user", nil)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	_, err = client.Get(context.Background(), "/version")
+	assert.Equal(t, ErrNoInstance, err)
+
+	assert.Nil(t, reg.Register(newInstance("user", "a", strings.TrimPrefix(srvA.URL, "http://"), nil)))
+	assert.Nil(t, reg.Register(newInstance("user", "b", strings.TrimPrefix(srvB.URL, "http://"), nil)))
+	assert.Equal(t, 2, len(client.Resolver().Instances()))
+
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(context.Background(), "version")
+		assert.Nil(t, err)
+		buf, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		bodies = append(bodies, string(buf))
+	}
+	assert.Equal(t, []string{"a:GET:/version:", "b:GET:/version:"}, bodies)
+
+	resp, err := client.Post(context.Background(), "/login", "text/plain", strings.NewReader("hello"))
+	assert.Nil(t, err)
+	buf, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "a:POST:/login:hello", string(buf))
+
+	// 实例下线后不再被选中
+	_ = reg.Deregister(&Instance{Name: "user", ID: "a"})
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(context.Background(), "/version")
+		assert.Nil(t, err)
+		buf, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.True(t, strings.HasPrefix(string(buf), "b:"))
+	}
+
+	// 请求失败时释放进行中请求计数
+	lp := NewLeastPending()
+	failClient, _ := NewClient(reg, "user", lp)
+	defer failClient.Close()
+	srvB.Close()
+	_, err = failClient.Get(context.Background(), "/version")
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, lp.Pending("b"))
+}
@@ -0,0 +1,266 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/yuanzhangcai/chaos/log"
+)
+
+// Etcd etcd服务注册，key为 前缀+服务名/实例ID，与srsd兼容
+type Etcd struct {
+	opt  *Option
+	cli  *clientv3.Client
+	lock sync.Mutex
+	regs map[string]*etcdLease // 已注册的实例，key为etcd key
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// etcdLease 已注册实例的租约
+type etcdLease struct {
+	ins  *Instance
+	id   clientv3.LeaseID
+	quit chan struct{}
+}
+
+// NewEtcd 创建etcd服务注册
+func NewEtcd(opt *Option) (*Etcd, error) {
+	if len(opt.Addrs) == 0 {
+		return nil, fmt.Errorf("etcd addrs is empty")
+	}
+	if opt.Prefix == "" {
+		opt.Prefix = "/srsd/services/"
+	}
+	if !strings.HasSuffix(opt.Prefix, "/") {
+		opt.Prefix += "/"
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = 5
+	}
+	if opt.TTL <= 0 {
+		opt.TTL = 30
+	}
+	if opt.Interval <= 0 || opt.Interval >= opt.TTL {
+		opt.Interval = opt.TTL / 3
+		if opt.Interval <= 0 {
+			opt.Interval = 1
+		}
+	}
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   opt.Addrs,
+		DialTimeout: time.Duration(opt.Timeout) * time.Second,
+		Username:    opt.Username,
+		Password:    opt.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Etcd{
+		opt:  opt,
+		cli:  cli,
+		regs: make(map[string]*etcdLease),
+		quit: make(chan struct{}),
+	}, nil
+}
+
+func (c *Etcd) timeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(c.opt.Timeout)*time.Second)
+}
+
+func (c *Etcd) key(ins *Instance) string {
+	return c.opt.Prefix + ins.Name + "/" + ins.ID
+}
+
+// Register 注册服务实例，按配置的间隔续约，租约失效时重新注册
+func (c *Etcd) Register(ins *Instance) error {
+	if ins.CreateTime == "" {
+		ins.CreateTime = time.Now().Format(timeFormat)
+	}
+
+	id, err := c.put(ins)
+	if err != nil {
+		return err
+	}
+
+	key := c.key(ins)
+	lease := &etcdLease{ins: ins, id: id, quit: make(chan struct{})}
+	c.lock.Lock()
+	if old, ok := c.regs[key]; ok {
+		close(old.quit)
+	}
+	c.regs[key] = lease
+	c.lock.Unlock()
+
+	c.wg.Add(1)
+	go c.keepAlive(key, lease)
+	return nil
+}
+
+// put 申请租约并写入实例信息
+func (c *Etcd) put(ins *Instance) (clientv3.LeaseID, error) {
+	val, err := json.Marshal(ins)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := c.timeout()
+	defer cancel()
+	grant, err := c.cli.Grant(ctx, c.opt.TTL)
+	if err != nil {
+		return 0, err
+	}
+
+	pCtx, pCancel := c.timeout()
+	defer pCancel()
+	if _, err = c.cli.Put(pCtx, c.key(ins), string(val), clientv3.WithLease(grant.ID)); err != nil {
+		return 0, err
+	}
+	return grant.ID, nil
+}
+
+func (c *Etcd) keepAlive(key string, lease *etcdLease) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(c.opt.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := c.timeout()
+			_, err := c.cli.KeepAliveOnce(ctx, lease.id)
+			cancel()
+			if err == nil {
+				continue
+			}
+
+			// 续约失败(如租约已过期)时重新注册
+			log.L().WithField("key", key).Warn("服务续约失败，重新注册：", err)
+			id, err := c.put(lease.ins)
+			if err != nil {
+				log.L().WithField("key", key).Error("服务重新注册失败：", err)
+				continue
+			}
+			lease.id = id
+		case <-lease.quit:
+			return
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// Deregister 取消注册服务实例
+func (c *Etcd) Deregister(ins *Instance) error {
+	key := c.key(ins)
+	c.lock.Lock()
+	lease, ok := c.regs[key]
+	delete(c.regs, key)
+	c.lock.Unlock()
+
+	if ok {
+		close(lease.quit)
+	}
+
+	ctx, cancel := c.timeout()
+	defer cancel()
+	_, err := c.cli.Delete(ctx, key)
+	return err
+}
+
+// GetService 获取服务的所有实例
+func (c *Etcd) GetService(name string) ([]*Instance, error) {
+	ctx, cancel := c.timeout()
+	defer cancel()
+	resp, err := c.cli.Get(ctx, c.opt.Prefix+name+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*Instance, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		ins := &Instance{}
+		if err := json.Unmarshal(kv.Value, ins); err != nil {
+			continue
+		}
+		if ins.Metadata == nil {
+			ins.Metadata = make(map[string]string)
+		}
+		list = append(list, ins)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// Watch 监听服务实例变化，有变化时重新获取服务的所有实例
+func (c *Etcd) Watch(name string, callback func([]*Instance)) (func(), error) {
+	list, err := c.GetService(name)
+	if err != nil {
+		return nil, err
+	}
+	callback(list)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			ch := c.cli.Watch(ctx, c.opt.Prefix+name+"/", clientv3.WithPrefix())
+			for resp := range ch {
+				if resp.Err() != nil {
+					break
+				}
+				if list, err := c.GetService(name); err == nil {
+					callback(list)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.quit:
+				return
+			case <-time.After(time.Duration(c.opt.Timeout) * time.Second):
+				// watch异常结束，重新获取后继续监听
+				if list, err := c.GetService(name); err == nil {
+					callback(list)
+				}
+			}
+		}
+	}()
+	return cancel, nil
+}
+
+// Close 取消所有注册与监听，关闭etcd连接
+func (c *Etcd) Close() error {
+	var err error
+	c.once.Do(func() {
+		c.lock.Lock()
+		var list []*Instance
+		for _, one := range c.regs {
+			list = append(list, one.ins)
+		}
+		c.lock.Unlock()
+
+		for _, one := range list {
+			if e := c.Deregister(one); e != nil {
+				log.L().WithField("id", one.ID).Error("服务取消注册失败：", e)
+			}
+		}
+
+		close(c.quit)
+		err = c.cli.Close()
+		c.wg.Wait()
+	})
+	return err
+}
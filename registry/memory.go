@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory 内存服务注册，同一进程内的注册与发现，测试时使用
+type Memory struct {
+	lock     sync.RWMutex
+	services map[string]map[string]*Instance
+	watchers *watchers
+}
+
+// NewMemory 创建内存服务注册
+func NewMemory() *Memory {
+	return &Memory{
+		services: make(map[string]map[string]*Instance),
+		watchers: newWatchers(),
+	}
+}
+
+// Register 注册服务实例
+func (c *Memory) Register(ins *Instance) error {
+	c.lock.Lock()
+	if c.services[ins.Name] == nil {
+		c.services[ins.Name] = make(map[string]*Instance)
+	}
+	if ins.CreateTime == "" {
+		ins.CreateTime = time.Now().Format(timeFormat)
+	}
+	c.services[ins.Name][ins.ID] = ins
+	list := c.list(ins.Name)
+	c.lock.Unlock()
+
+	c.watchers.notify(ins.Name, list)
+	return nil
+}
+
+// Deregister 取消注册服务实例
+func (c *Memory) Deregister(ins *Instance) error {
+	c.lock.Lock()
+	delete(c.services[ins.Name], ins.ID)
+	if len(c.services[ins.Name]) == 0 {
+		delete(c.services, ins.Name)
+	}
+	list := c.list(ins.Name)
+	c.lock.Unlock()
+
+	c.watchers.notify(ins.Name, list)
+	return nil
+}
+
+// GetService 获取服务的所有实例
+func (c *Memory) GetService(name string) ([]*Instance, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.list(name), nil
+}
+
+// Watch 监听服务实例变化
+func (c *Memory) Watch(name string, callback func([]*Instance)) (func(), error) {
+	cancel := c.watchers.add(name, callback)
+	list, _ := c.GetService(name)
+	callback(list)
+	return cancel, nil
+}
+
+// Close 清空所有注册
+func (c *Memory) Close() error {
+	c.lock.Lock()
+	names := make([]string, 0, len(c.services))
+	for name := range c.services {
+		names = append(names, name)
+	}
+	c.services = make(map[string]map[string]*Instance)
+	c.lock.Unlock()
+
+	for _, name := range names {
+		c.watchers.notify(name, nil)
+	}
+	return nil
+}
+
+// list 获取服务的所有实例，按ID排序保证顺序稳定，调用方需加锁
+func (c *Memory) list(name string) []*Instance {
+	list := make([]*Instance, 0, len(c.services[name]))
+	for _, one := range c.services[name] {
+		list = append(list, one)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
@@ -0,0 +1,333 @@
+// 服务注册与发现，支持etcd、静态文件、内存三种实现，并提供基于服务名的负载均衡HTTP客户端
+
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yuanzhangcai/chaos/common"
+	"github.com/yuanzhangcai/config"
+)
+
+const (
+	// TypeEtcd etcd服务注册
+	TypeEtcd = "etcd"
+	// TypeStatic 静态文件服务注册，本地开发测试时使用
+	TypeStatic = "static"
+	// TypeMemory 内存服务注册，单进程内使用，测试时使用
+	TypeMemory = "memory"
+
+	// MetaEnv 元数据：运行环境
+	MetaEnv = "env"
+	// MetaWeight 元数据：权重
+	MetaWeight = "weight"
+	// MetaZone 元数据：可用区
+	MetaZone = "zone"
+	// MetaGRPC 元数据：gRPC服务地址
+	MetaGRPC = "grpc"
+
+	// DefaultWeight 默认权重
+	DefaultWeight = 100
+
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+var (
+	// ErrNoInstance 没有可用的服务实例
+	ErrNoInstance = errors.New("no available instance")
+)
+
+// Instance 服务实例，字段与srsd保持一致，可与使用srsd的服务互相发现
+type Instance struct {
+	ID         string            `json:"id"`          // 实例唯一ID
+	Name       string            `json:"name"`        // 服务名称
+	Version    string            `json:"version"`     // 版本
+	Host       string            `json:"host"`        // 服务地址
+	PProf      string            `json:"pprof"`       // pprof地址
+	Metrics    string            `json:"metrics"`     // prometheus指标曝露地址
+	Metadata   map[string]string `json:"metadata"`    // 扩展信息：env、weight、zone等
+	CreateTime string            `json:"create_time"` // 注册时间
+}
+
+// Weight 实例权重，没有设置或设置错误时为默认权重
+func (c *Instance) Weight() int {
+	weight, err := strconv.Atoi(c.Metadata[MetaWeight])
+	if err != nil || weight < 0 {
+		return DefaultWeight
+	}
+	return weight
+}
+
+// Zone 实例所在可用区
+func (c *Instance) Zone() string {
+	return c.Metadata[MetaZone]
+}
+
+// Env 实例运行环境
+func (c *Instance) Env() string {
+	return c.Metadata[MetaEnv]
+}
+
+// Registry 服务注册接口
+type Registry interface {
+	// Register 注册服务实例
+	Register(ins *Instance) error
+	// Deregister 取消注册服务实例
+	Deregister(ins *Instance) error
+	// GetService 获取服务的所有实例
+	GetService(name string) ([]*Instance, error)
+	// Watch 监听服务实例变化，监听时立即回调一次当前实例列表，返回取消监听函数
+	Watch(name string, callback func([]*Instance)) (func(), error)
+	// Close 取消所有注册与监听
+	Close() error
+}
+
+// Option 服务注册配置
+type Option struct {
+	Type      string            `json:"type"`      // 服务注册类型：etcd/static/memory，为空时配置了etcd地址则使用etcd
+	Addrs     []string          `json:"addrs"`     // etcd地址，为空时使用common.etcd_addrs
+	Username  string            `json:"username"`  // etcd用户名
+	Password  string            `json:"password"`  // etcd密码
+	Prefix    string            `json:"prefix"`    // 服务注册key前缀
+	Timeout   int64             `json:"timeout"`   // etcd操作超时时间，单位秒
+	TTL       int64             `json:"ttl"`       // 服务失效时间，单位秒，为0时使用common.register_ttl
+	Interval  int64             `json:"interval"`  // 服务续约间隔，单位秒，为0时使用common.register_interval
+	File      string            `json:"file"`      // 静态服务列表文件，支持toml/json/yaml
+	Reload    int64             `json:"reload"`    // 静态服务列表文件检查变化的间隔，单位秒
+	Advertise string            `json:"advertise"` // 注册的对外地址，为空时根据监听地址与内网IP生成
+	Weight    int               `json:"weight"`    // 实例权重
+	Zone      string            `json:"zone"`      // 实例所在可用区
+	Metadata  map[string]string `json:"metadata"`  // 其它元数据
+	Balancer  string            `json:"balancer"`  // 调用其它服务时的负载均衡策略：round_robin/weighted/least_pending
+}
+
+var (
+	defaultRegistry Registry
+	defaultLock     sync.Mutex
+)
+
+// GetOptionFromConfig 读取服务注册配置，兼容common中的etcd_addrs、register_ttl、register_interval配置
+func GetOptionFromConfig() (*Option, error) {
+	opt := Option{
+		Prefix:  "/srsd/services/",
+		Timeout: 5,
+		Reload:  5,
+		Weight:  DefaultWeight,
+	}
+	err := config.Scan([]string{"registry"}, &opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(opt.Addrs) == 0 {
+		opt.Addrs = config.GetStringArray("common", "etcd_addrs")
+	}
+	if opt.TTL <= 0 {
+		opt.TTL = config.GetInt64("common", "register_ttl")
+	}
+	if opt.Interval <= 0 {
+		opt.Interval = config.GetInt64("common", "register_interval")
+	}
+	if opt.Type == "" && len(opt.Addrs) > 0 {
+		opt.Type = TypeEtcd
+	}
+	return &opt, nil
+}
+
+// New 根据配置创建服务注册
+func New(opt *Option) (Registry, error) {
+	switch opt.Type {
+	case TypeEtcd:
+		return NewEtcd(opt)
+	case TypeStatic:
+		return NewStatic(opt.File, time.Duration(opt.Reload)*time.Second)
+	case TypeMemory:
+		return NewMemory(), nil
+	}
+	return nil, fmt.Errorf("unknown registry type (%s)", opt.Type)
+}
+
+// Init 使用配置创建默认服务注册，没有配置服务注册类型时不创建
+func Init() error {
+	opt, err := GetOptionFromConfig()
+	if err != nil {
+		return err
+	}
+	if opt.Type == "" {
+		return nil
+	}
+
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	if defaultRegistry != nil {
+		return nil
+	}
+
+	reg, err := New(opt)
+	if err != nil {
+		return err
+	}
+	defaultRegistry = reg
+	return nil
+}
+
+// GetRegistry 获取默认服务注册，未创建时返回nil
+func GetRegistry() Registry {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	return defaultRegistry
+}
+
+// SetRegistry 设置默认服务注册
+func SetRegistry(reg Registry) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultRegistry = reg
+}
+
+// Stop 关闭默认服务注册，取消所有注册与监听
+func Stop() {
+	defaultLock.Lock()
+	reg := defaultRegistry
+	defaultRegistry = nil
+	defaultLock.Unlock()
+
+	if reg != nil {
+		_ = reg.Close()
+	}
+}
+
+// NewInstance 根据配置创建当前服务的实例信息，listen为服务监听地址
+func NewInstance(name, listen string) (*Instance, error) {
+	opt, err := GetOptionFromConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := opt.Advertise
+	if addr == "" {
+		addr = listen
+	}
+	host, err := AdvertiseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ins := &Instance{
+		ID:       uuid.New().String(),
+		Name:     name,
+		Version:  common.Version,
+		Host:     host,
+		Metadata: make(map[string]string),
+	}
+	if ins.Version == "" {
+		ins.Version = "latest"
+	}
+	for k, v := range opt.Metadata {
+		ins.Metadata[k] = v
+	}
+	ins.Metadata[MetaEnv] = common.Env
+	ins.Metadata[MetaWeight] = strconv.Itoa(opt.Weight)
+	if opt.Zone != "" {
+		ins.Metadata[MetaZone] = opt.Zone
+	}
+	return ins, nil
+}
+
+// AdvertiseAddr 将监听地址转换成其它服务可访问的地址，没有指定IP或为0.0.0.0时使用内网IP
+func AdvertiseAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if host != "" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsUnspecified() {
+			return addr, nil
+		}
+	}
+
+	host = common.GetIntranetIP()
+	if host == "" {
+		return "", fmt.Errorf("can not get intranet ip for (%s)", addr)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// copyList 复制实例列表，避免回调方修改内部数据
+func copyList(list []*Instance) []*Instance {
+	ret := make([]*Instance, len(list))
+	copy(ret, list)
+	return ret
+}
+
+// watchers 服务实例变化回调
+type watchers struct {
+	lock sync.Mutex
+	seq  int
+	list map[string]map[int]func([]*Instance)
+}
+
+func newWatchers() *watchers {
+	return &watchers{list: make(map[string]map[int]func([]*Instance))}
+}
+
+// add 添加回调，返回取消函数
+func (c *watchers) add(name string, callback func([]*Instance)) func() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.seq++
+	id := c.seq
+	if c.list[name] == nil {
+		c.list[name] = make(map[int]func([]*Instance))
+	}
+	c.list[name][id] = callback
+
+	return func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		delete(c.list[name], id)
+		if len(c.list[name]) == 0 {
+			delete(c.list, name)
+		}
+	}
+}
+
+// get 获取服务的所有回调
+func (c *watchers) get(name string) []func([]*Instance) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var ret []func([]*Instance)
+	for _, one := range c.list[name] {
+		ret = append(ret, one)
+	}
+	return ret
+}
+
+// names 获取所有被监听的服务名称
+func (c *watchers) names() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var ret []string
+	for name := range c.list {
+		ret = append(ret, name)
+	}
+	return ret
+}
+
+// notify 通知服务实例变化
+func (c *watchers) notify(name string, list []*Instance) {
+	for _, one := range c.get(name) {
+		one(copyList(list))
+	}
+}
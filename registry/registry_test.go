@@ -0,0 +1,206 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/chaos/common"
+	"github.com/yuanzhangcai/config"
+)
+
+func newInstance(name, id, host string, metadata map[string]string) *Instance {
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	return &Instance{ID: id, Name: name, Host: host, Metadata: metadata}
+}
+
+// recorder 记录Watch回调的实例列表
+type recorder struct {
+	lock  sync.Mutex
+	lists [][]*Instance
+}
+
+func (c *recorder) callback(list []*Instance) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lists = append(c.lists, list)
+}
+
+func (c *recorder) last() []*Instance {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.lists) == 0 {
+		return nil
+	}
+	return c.lists[len(c.lists)-1]
+}
+
+func TestInstance(t *testing.T) {
+	ins := newInstance("user", "1", "127.0.0.1:4444", nil)
+	assert.Equal(t, DefaultWeight, ins.Weight())
+	assert.Equal(t, "", ins.Zone())
+
+	ins.Metadata[MetaWeight] = "10"
+	ins.Metadata[MetaZone] = "sz"
+	ins.Metadata[MetaEnv] = "test"
+	assert.Equal(t, 10, ins.Weight())
+	assert.Equal(t, "sz", ins.Zone())
+	assert.Equal(t, "test", ins.Env())
+
+	ins.Metadata[MetaWeight] = "-1"
+	assert.Equal(t, DefaultWeight, ins.Weight())
+}
+
+func TestAdvertiseAddr(t *testing.T) {
+	addr, err := AdvertiseAddr("10.0.0.1:4444")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:4444", addr)
+
+	addr, err = AdvertiseAddr("localhost:4444")
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:4444", addr)
+
+	_, err = AdvertiseAddr("4444")
+	assert.NotNil(t, err)
+
+	if ip := common.GetIntranetIP(); ip != "" {
+		addr, err = AdvertiseAddr("0.0.0.0:4444")
+		assert.Nil(t, err)
+		assert.Equal(t, ip+":4444", addr)
+
+		addr, err = AdvertiseAddr(":4444")
+		assert.Nil(t, err)
+		assert.Equal(t, ip+":4444", addr)
+	}
+}
+
+func TestGetOptionFromConfig(t *testing.T) {
+	_ = config.LoadMemory(`{"common": {"etcd_addrs": ["127.0.0.1:2379"], "register_ttl": 30, "register_interval": 15},
+		"registry": {"advertise": "10.0.0.1:4444", "zone": "sz", "weight": 50, "metadata": {"group": "a"}}}`, "json")
+
+	opt, err := GetOptionFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, TypeEtcd, opt.Type)
+	assert.Equal(t, []string{"127.0.0.1:2379"}, opt.Addrs)
+	assert.Equal(t, int64(30), opt.TTL)
+	assert.Equal(t, int64(15), opt.Interval)
+
+	ins, err := NewInstance("user", "0.0.0.0:4444")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:4444", ins.Host)
+	assert.Equal(t, "sz", ins.Zone())
+	assert.Equal(t, 50, ins.Weight())
+	assert.Equal(t, "a", ins.Metadata["group"])
+	assert.NotEmpty(t, ins.ID)
+
+	_, err = New(&Option{Type: "zookeeper"})
+	assert.NotNil(t, err)
+	_, err = New(&Option{Type: TypeEtcd})
+	assert.NotNil(t, err)
+}
+
+func TestDefault(t *testing.T) {
+	assert.Nil(t, GetRegistry())
+	reg := NewMemory()
+	SetRegistry(reg)
+	assert.Equal(t, reg, GetRegistry())
+	Stop()
+	assert.Nil(t, GetRegistry())
+}
+
+func TestMemory(t *testing.T) {
+	reg := NewMemory()
+	r := &recorder{}
+	cancel, err := reg.Watch("user", r.callback)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(r.last()))
+
+	a := newInstance("user", "a", "127.0.0.1:1", nil)
+	b := newInstance("user", "b", "127.0.0.1:2", nil)
+	assert.Nil(t, reg.Register(b))
+	assert.Nil(t, reg.Register(a))
+	assert.Nil(t, reg.Register(newInstance("order", "c", "127.0.0.1:3", nil)))
+	assert.Equal(t, []*Instance{a, b}, r.last())
+	assert.NotEmpty(t, a.CreateTime)
+
+	list, err := reg.GetService("user")
+	assert.Nil(t, err)
+	assert.Equal(t, []*Instance{a, b}, list)
+
+	assert.Nil(t, reg.Deregister(a))
+	assert.Equal(t, []*Instance{b}, r.last())
+
+	cancel()
+	assert.Nil(t, reg.Deregister(b))
+	assert.Equal(t, []*Instance{b}, r.last())
+
+	assert.Nil(t, reg.Close())
+	list, _ = reg.GetService("order")
+	assert.Equal(t, 0, len(list))
+}
+
+func TestStatic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "services.toml")
+	content := `
+[[services.user]]
+host = "127.0.0.1:4445"
+[services.user.metadata]
+weight = "10"
+
+[[services.user]]
+host = "127.0.0.1:4446"
+`
+	assert.Nil(t, ioutil.WriteFile(file, []byte(content), 0644))
+
+	_, err = NewStatic(filepath.Join(dir, "none.toml"), 0)
+	assert.NotNil(t, err)
+
+	reg, err := NewStatic(file, 10*time.Millisecond)
+	assert.Nil(t, err)
+	defer reg.Close()
+
+	list, err := reg.GetService("user")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "user", list[0].Name)
+	assert.Equal(t, "127.0.0.1:4445", list[0].ID)
+	assert.Equal(t, 10, list[0].Weight())
+	assert.Equal(t, DefaultWeight, list[1].Weight())
+
+	r := &recorder{}
+	cancel, err := reg.Watch("user", r.callback)
+	assert.Nil(t, err)
+	defer cancel()
+	assert.Equal(t, 2, len(r.last()))
+
+	// 运行时注册的实例与文件中的实例合并
+	local := newInstance("user", "local", "127.0.0.1:4447", nil)
+	assert.Nil(t, reg.Register(local))
+	assert.Equal(t, 3, len(r.last()))
+	assert.Nil(t, reg.Deregister(local))
+	assert.Equal(t, 2, len(r.last()))
+
+	// 文件变化时自动重新加载
+	content = strings.Replace(content, "4446", "4448", 1)
+	assert.Nil(t, ioutil.WriteFile(file, []byte(content), 0644))
+	mtime := time.Now().Add(time.Second)
+	assert.Nil(t, os.Chtimes(file, mtime, mtime))
+	assert.Eventually(t, func() bool {
+		list := r.last()
+		return len(list) == 2 && list[1].Host == "127.0.0.1:4448"
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, reg.Close())
+	assert.Nil(t, reg.Close())
+}
@@ -0,0 +1,172 @@
+package registry
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/config"
+)
+
+// Static 静态文件服务注册，服务实例从文件中读取，文件变化时自动重新加载，本地开发时代替etcd
+//
+// 文件格式(toml)：
+//
+//	[[services."user.zacyuan.com"]]
+//	host = "127.0.0.1:4445"
+//	[services."user.zacyuan.com".metadata]
+//	weight = "100"
+//
+// 运行时注册的实例只保存在内存中，与文件中的实例合并后返回
+type Static struct {
+	file     string
+	mem      *Memory // 运行时注册的实例
+	watchers *watchers
+	lock     sync.RWMutex
+	services map[string][]*Instance
+	modTime  time.Time
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewStatic 创建静态文件服务注册，interval为检查文件变化的间隔，0表示不检查
+func NewStatic(file string, interval time.Duration) (*Static, error) {
+	c := &Static{
+		file:     file,
+		mem:      NewMemory(),
+		watchers: newWatchers(),
+		services: make(map[string][]*Instance),
+		quit:     make(chan struct{}),
+	}
+	if file != "" {
+		if _, err := c.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	if file != "" && interval > 0 {
+		c.wg.Add(1)
+		go c.loop(interval)
+	}
+	return c, nil
+}
+
+// load 加载服务列表文件，文件没有变化时返回false
+func (c *Static) load() (bool, error) {
+	fi, err := os.Stat(c.file)
+	if err != nil {
+		return false, err
+	}
+
+	c.lock.RLock()
+	modTime := c.modTime
+	c.lock.RUnlock()
+	if fi.ModTime().Equal(modTime) {
+		return false, nil
+	}
+
+	cfg := config.New()
+	if err = cfg.LoadFile(c.file); err != nil {
+		return false, err
+	}
+	services := make(map[string][]*Instance)
+	if err = cfg.Scan([]string{"services"}, &services); err != nil {
+		return false, err
+	}
+
+	for name, list := range services {
+		for _, one := range list {
+			one.Name = name
+			if one.ID == "" {
+				one.ID = one.Host
+			}
+			if one.Metadata == nil {
+				one.Metadata = make(map[string]string)
+			}
+		}
+	}
+
+	c.lock.Lock()
+	c.services = services
+	c.modTime = fi.ModTime()
+	c.lock.Unlock()
+	return true, nil
+}
+
+func (c *Static) loop(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := c.load()
+			if err != nil {
+				log.L().WithField("file", c.file).Error("加载静态服务列表失败：", err)
+				continue
+			}
+			if changed {
+				for _, name := range c.watchers.names() {
+					list, _ := c.GetService(name)
+					c.watchers.notify(name, list)
+				}
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// Register 注册服务实例，只保存在内存中
+func (c *Static) Register(ins *Instance) error {
+	if err := c.mem.Register(ins); err != nil {
+		return err
+	}
+	c.notify(ins.Name)
+	return nil
+}
+
+// Deregister 取消注册服务实例
+func (c *Static) Deregister(ins *Instance) error {
+	if err := c.mem.Deregister(ins); err != nil {
+		return err
+	}
+	c.notify(ins.Name)
+	return nil
+}
+
+func (c *Static) notify(name string) {
+	list, _ := c.GetService(name)
+	c.watchers.notify(name, list)
+}
+
+// GetService 获取服务的所有实例，包括文件中的实例与运行时注册的实例
+func (c *Static) GetService(name string) ([]*Instance, error) {
+	c.lock.RLock()
+	list := copyList(c.services[name])
+	c.lock.RUnlock()
+
+	registered, _ := c.mem.GetService(name)
+	return append(list, registered...), nil
+}
+
+// Watch 监听服务实例变化
+func (c *Static) Watch(name string, callback func([]*Instance)) (func(), error) {
+	cancel := c.watchers.add(name, callback)
+	list, _ := c.GetService(name)
+	callback(list)
+	return cancel, nil
+}
+
+// Close 停止检查文件变化
+func (c *Static) Close() error {
+	c.once.Do(func() {
+		close(c.quit)
+		c.wg.Wait()
+	})
+	return nil
+}
@@ -0,0 +1,284 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/chaos/monitor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDKey 请求ID的metadata key，与HTTP的X-Request-Id请求头对应
+const RequestIDKey = "x-request-id"
+
+type requestIDKey struct{}
+type loggerKey struct{}
+
+// RequestID 获取当前请求的请求ID
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Logger 获取当前请求的日志对象，日志中带有请求ID与方法名
+func Logger(ctx context.Context) log.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(log.Logger); ok {
+		return logger
+	}
+	return log.L()
+}
+
+// ChainUnaryServer 将多个一元调用拦截器串联成一个，按顺序执行
+func ChainUnaryServer(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+// ChainStreamServer 将多个流式调用拦截器串联成一个，按顺序执行
+func ChainStreamServer(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return chained(srv, ss)
+	}
+}
+
+// serverStream 替换流式调用的上下文
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// withContext 返回使用新上下文的流
+func withContext(ctx context.Context, ss grpc.ServerStream) grpc.ServerStream {
+	if ctx == ss.Context() {
+		return ss
+	}
+	return &serverStream{ServerStream: ss, ctx: ctx}
+}
+
+// requestID 从metadata中读取请求ID，没有时生成新的，并在响应头中返回
+func requestID(ctx context.Context, method string) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIDKey); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if id == "" {
+		id = uuid.New().String()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, id))
+
+	logger := log.L().WithFields(log.Fields{"request_id": id, "method": method})
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// UnaryRequestID 请求ID拦截器
+func UnaryRequestID() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(requestID(ctx, info.FullMethod), req)
+	}
+}
+
+// StreamRequestID 请求ID拦截器
+func StreamRequestID() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, withContext(requestID(ss.Context(), info.FullMethod), ss))
+	}
+}
+
+// metadataCarrier 使用gRPC metadata传递链路跟踪上下文
+type metadataCarrier metadata.MD
+
+// Set 实现opentracing.TextMapWriter
+func (c metadataCarrier) Set(key, val string) {
+	key = strings.ToLower(key)
+	c[key] = append(c[key], val)
+}
+
+// ForeachKey 实现opentracing.TextMapReader
+func (c metadataCarrier) ForeachKey(handler func(key, val string) error) error {
+	for key, values := range c {
+		for _, val := range values {
+			if err := handler(key, val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// startSpan 从metadata中读取上游的span上下文，创建服务端span
+func startSpan(ctx context.Context, method string) (opentracing.Span, context.Context) {
+	tracer := opentracing.GlobalTracer()
+	opts := []opentracing.StartSpanOption{
+		opentracing.Tag{Key: string(ext.Component), Value: "gRPC"},
+		ext.SpanKindRPCServer,
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if spCtx, err := tracer.Extract(opentracing.TextMap, metadataCarrier(md)); err == nil {
+			opts = append(opts, opentracing.ChildOf(spCtx))
+		}
+	}
+	if id := RequestID(ctx); id != "" {
+		opts = append(opts, opentracing.Tag{Key: "request_id", Value: id})
+	}
+
+	span := tracer.StartSpan(method, opts...)
+	return span, opentracing.ContextWithSpan(ctx, span)
+}
+
+// finishSpan 记录状态码并结束span
+func finishSpan(span opentracing.Span, err error) {
+	code := status.Code(err)
+	span.SetTag("grpc.code", code.String())
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogKV("error", err.Error())
+	}
+	span.Finish()
+}
+
+// UnaryTracing 链路跟踪拦截器，使用全局tracer
+func UnaryTracing() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		span, ctx := startSpan(ctx, info.FullMethod)
+		resp, err := handler(ctx, req)
+		finishSpan(span, err)
+		return resp, err
+	}
+}
+
+// StreamTracing 链路跟踪拦截器，使用全局tracer
+func StreamTracing() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		span, ctx := startSpan(ss.Context(), info.FullMethod)
+		err := handler(srv, withContext(ctx, ss))
+		finishSpan(span, err)
+		return err
+	}
+}
+
+// observe 上报监控并记录访问日志
+func observe(ctx context.Context, method string, start time.Time, err error, accessLog bool) {
+	cost := time.Since(start)
+	code := status.Code(err)
+	monitor.ObserveGRPC(method, code.String(), cost)
+	if !accessLog {
+		return
+	}
+
+	addr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	Logger(ctx).Info(fmt.Sprintf("GRPC: %-16s| %13v |%s %s err[%s]", code, cost, addr, method, errMsg))
+}
+
+// UnaryMonitor 监控与访问日志拦截器
+func UnaryMonitor(accessLog bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		observe(ctx, info.FullMethod, start, err, accessLog)
+		return resp, err
+	}
+}
+
+// StreamMonitor 监控与访问日志拦截器
+func StreamMonitor(accessLog bool) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		observe(ss.Context(), info.FullMethod, start, err, accessLog)
+		return err
+	}
+}
+
+// recovery 捕获panic，记录日志并返回Internal错误
+func recovery(ctx context.Context, err *error) {
+	if r := recover(); r != nil {
+		Logger(ctx).WithField("stack", string(debug.Stack())).Error("gRPC panic：", r)
+		*err = status.Errorf(codes.Internal, "panic: %v", r)
+	}
+}
+
+// UnaryRecovery panic恢复拦截器
+func UnaryRecovery() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer recovery(ctx, &err)
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecovery panic恢复拦截器
+func StreamRecovery() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer recovery(ss.Context(), &err)
+		return handler(srv, ss)
+	}
+}
+
+// UnaryClientInterceptor 客户端拦截器，将当前请求ID与链路跟踪上下文传递给下游服务
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		md, ok := metadata.FromOutgoingContext(ctx)
+		if ok {
+			md = md.Copy()
+		} else {
+			md = metadata.MD{}
+		}
+		if id := RequestID(ctx); id != "" && len(md.Get(RequestIDKey)) == 0 {
+			md.Set(RequestIDKey, id)
+		}
+
+		var span opentracing.Span
+		if parent := opentracing.SpanFromContext(ctx); parent != nil {
+			span = parent.Tracer().StartSpan(method,
+				opentracing.ChildOf(parent.Context()),
+				opentracing.Tag{Key: string(ext.Component), Value: "gRPC"},
+				ext.SpanKindRPCClient)
+			_ = span.Tracer().Inject(span.Context(), opentracing.TextMap, metadataCarrier(md))
+		}
+
+		err := invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+		if span != nil {
+			finishSpan(span, err)
+		}
+		return err
+	}
+}
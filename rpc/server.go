@@ -0,0 +1,255 @@
+// gRPC服务，与gin HTTP服务共用配置、日志、监控、链路跟踪与服务注册，由services.StartServer统一启动与停止
+
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yuanzhangcai/chaos/graceful"
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// Option gRPC服务配置
+type Option struct {
+	Server         string `json:"server"`            // 监听地址，为空时不启动
+	Reflection     bool   `json:"reflection"`        // 是否开启反射服务，grpcurl等工具依赖该服务
+	AccessLog      bool   `json:"access_log"`        // 是否记录每个请求的访问日志
+	MaxRecvMsgSize int    `json:"max_recv_msg_size"` // 最大接收消息大小，单位KB，0表示使用默认值4MB
+	MaxSendMsgSize int    `json:"max_send_msg_size"` // 最大发送消息大小，单位KB，0表示使用默认值
+	StopTimeout    int64  `json:"stop_timeout"`      // 优雅停止超时时间，单位秒，超时后强制停止
+}
+
+// Server gRPC服务
+type Server struct {
+	option  *Option
+	srv     *grpc.Server
+	health  *health.Server
+	lock    sync.Mutex
+	addr    string // 实际监听地址
+	started bool
+}
+
+var (
+	defaultServer *Server
+	defaultLock   sync.Mutex
+
+	registrars         []func(s *grpc.Server) // 业务gRPC服务注册函数
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+)
+
+// GetOptionFromConfig 读取gRPC服务配置
+func GetOptionFromConfig() (*Option, error) {
+	opt := Option{
+		Reflection:  true,
+		AccessLog:   true,
+		StopTimeout: 5,
+	}
+	err := config.Scan([]string{"grpc"}, &opt)
+	if err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
+// Register 注册业务gRPC服务，需在服务启动前调用
+func Register(fn func(s *grpc.Server)) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	registrars = append(registrars, fn)
+}
+
+// UseUnary 添加一元调用拦截器，在内置拦截器之后执行，需在服务启动前调用
+func UseUnary(interceptors ...grpc.UnaryServerInterceptor) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	unaryInterceptors = append(unaryInterceptors, interceptors...)
+}
+
+// UseStream 添加流式调用拦截器，在内置拦截器之后执行，需在服务启动前调用
+func UseStream(interceptors ...grpc.StreamServerInterceptor) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	streamInterceptors = append(streamInterceptors, interceptors...)
+}
+
+// New 创建gRPC服务，内置请求ID、链路跟踪、监控与访问日志、panic恢复拦截器，并注册健康检查服务
+func New(opt *Option, opts ...grpc.ServerOption) *Server {
+	unary := []grpc.UnaryServerInterceptor{
+		UnaryRequestID(),
+		UnaryTracing(),
+		UnaryMonitor(opt.AccessLog),
+		UnaryRecovery(),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		StreamRequestID(),
+		StreamTracing(),
+		StreamMonitor(opt.AccessLog),
+		StreamRecovery(),
+	}
+
+	defaultLock.Lock()
+	unary = append(unary, unaryInterceptors...)
+	stream = append(stream, streamInterceptors...)
+	defaultLock.Unlock()
+
+	opts = append([]grpc.ServerOption{
+		grpc.UnaryInterceptor(ChainUnaryServer(unary...)),
+		grpc.StreamInterceptor(ChainStreamServer(stream...)),
+	}, opts...)
+	if opt.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(opt.MaxRecvMsgSize*1024))
+	}
+	if opt.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(opt.MaxSendMsgSize*1024))
+	}
+
+	s := &Server{
+		option: opt,
+		srv:    grpc.NewServer(opts...),
+		health: health.NewServer(),
+	}
+	healthpb.RegisterHealthServer(s.srv, s.health)
+	if opt.Reflection {
+		reflection.Register(s.srv)
+	}
+	return s
+}
+
+// GRPC 获取grpc.Server，用于注册业务服务
+func (s *Server) GRPC() *grpc.Server {
+	return s.srv
+}
+
+// Health 获取健康检查服务，可单独设置各业务服务的状态
+func (s *Server) Health() *health.Server {
+	return s.health
+}
+
+// Start 启动gRPC服务，监听失败时返回错误
+func (s *Server) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.started {
+		return nil
+	}
+
+	ln, err := graceful.Listen("tcp", s.option.Server)
+	if err != nil {
+		return err
+	}
+	s.addr = ln.Addr().String()
+	s.started = true
+
+	// 所有已注册的服务都设为正常
+	for name := range s.srv.GetServiceInfo() {
+		s.health.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+
+	srv := s.srv
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != grpc.ErrServerStopped {
+			log.L().Error("gRPC服务异常退出：", err)
+		}
+	}()
+	return nil
+}
+
+// Addr 返回实际监听地址，未启动时为空
+func (s *Server) Addr() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.addr
+}
+
+// Stop 优雅停止gRPC服务，等待进行中的请求完成，ctx超时后强制停止
+func (s *Server) Stop(ctx context.Context) {
+	s.lock.Lock()
+	started := s.started
+	s.started = false
+	s.lock.Unlock()
+	if !started {
+		return
+	}
+
+	// 先将健康检查设为不可用，让负载均衡不再转发新请求
+	s.health.Shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		s.srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.srv.Stop()
+		<-done
+	}
+}
+
+// Start 使用配置启动默认gRPC服务，并注册通过Register添加的业务服务，没有配置监听地址时不启动
+func Start() error {
+	opt, err := GetOptionFromConfig()
+	if err != nil {
+		return err
+	}
+	if opt.Server == "" {
+		return nil
+	}
+
+	defaultLock.Lock()
+	if defaultServer != nil {
+		defaultLock.Unlock()
+		return nil
+	}
+	fns := make([]func(s *grpc.Server), len(registrars))
+	copy(fns, registrars)
+	defaultLock.Unlock()
+
+	s := New(opt)
+	for _, fn := range fns {
+		fn(s.GRPC())
+	}
+	if err = s.Start(); err != nil {
+		return err
+	}
+
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultServer = s
+	return nil
+}
+
+// GetServer 获取默认gRPC服务，未启动时返回nil
+func GetServer() *Server {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	return defaultServer
+}
+
+// Stop 优雅停止默认gRPC服务
+func Stop() {
+	defaultLock.Lock()
+	s := defaultServer
+	defaultServer = nil
+	defaultLock.Unlock()
+
+	if s == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.option.StopTimeout)*time.Second)
+	defer cancel()
+	log.L().Info("gRPC Shutdown Server ...")
+	s.Stop(ctx)
+}
@@ -0,0 +1,184 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testServer 测试用服务，请求与响应复用健康检查的消息
+type testServer interface {
+	Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error)
+}
+
+type testService struct {
+	ctx context.Context // 最后一次请求的上下文
+}
+
+func (c *testService) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	c.ctx = ctx
+	if req.Service == "panic" {
+		panic("test panic")
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func checkHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(healthpb.HealthCheckRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/chaos.Test/Check"}
+	return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(testServer).Check(ctx, req.(*healthpb.HealthCheckRequest))
+	})
+}
+
+var testServiceDesc = grpc.ServiceDesc{
+	ServiceName: "chaos.Test",
+	HandlerType: (*testServer)(nil),
+	Methods:     []grpc.MethodDesc{{MethodName: "Check", Handler: checkHandler}},
+}
+
+func startTestServer(t *testing.T, svc *testService) (*Server, *grpc.ClientConn) {
+	s := New(&Option{Server: "127.0.0.1:0", Reflection: true, AccessLog: true})
+	s.GRPC().RegisterService(&testServiceDesc, svc)
+	assert.Nil(t, s.Start())
+
+	conn, err := grpc.Dial(s.Addr(), grpc.WithInsecure(), grpc.WithUnaryInterceptor(UnaryClientInterceptor()))
+	assert.Nil(t, err)
+	return s, conn
+}
+
+func invoke(ctx context.Context, conn *grpc.ClientConn, service string, opts ...grpc.CallOption) error {
+	resp := new(healthpb.HealthCheckResponse)
+	return conn.Invoke(ctx, "/chaos.Test/Check", &healthpb.HealthCheckRequest{Service: service}, resp, opts...)
+}
+
+func TestGetOptionFromConfig(t *testing.T) {
+	_ = config.LoadMemory(`{"grpc": {"server": ":4447", "reflection": false}}`, "json")
+	opt, err := GetOptionFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, ":4447", opt.Server)
+	assert.False(t, opt.Reflection)
+	assert.True(t, opt.AccessLog)
+	assert.Equal(t, int64(5), opt.StopTimeout)
+}
+
+func TestServer(t *testing.T) {
+	logger := log.NewMemoryLogger(logrus.DebugLevel)
+	old := log.L()
+	log.SetLogger(logger)
+	defer log.SetLogger(old)
+
+	svc := &testService{}
+	s, conn := startTestServer(t, svc)
+	defer conn.Close()
+
+	// 健康检查
+	health := healthpb.NewHealthClient(conn)
+	resp, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+	resp, err = health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "chaos.Test"})
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	// 请求ID传递与返回
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), RequestIDKey, "abc")
+	assert.Nil(t, invoke(ctx, conn, "", grpc.Header(&header)))
+	assert.Equal(t, "abc", RequestID(svc.ctx))
+	assert.Equal(t, []string{"abc"}, header.Get(RequestIDKey))
+
+	// 没有请求ID时自动生成
+	assert.Nil(t, invoke(context.Background(), conn, ""))
+	assert.NotEmpty(t, RequestID(svc.ctx))
+	assert.NotEqual(t, "abc", RequestID(svc.ctx))
+
+	// panic恢复
+	err = invoke(context.Background(), conn, "panic")
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.True(t, logger.Contains(logrus.ErrorLevel, "gRPC panic"))
+	assert.True(t, logger.Contains(logrus.InfoLevel, "/chaos.Test/Check"))
+
+	// 优雅停止后健康检查不可用
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.Stop(ctx)
+	_, err = health.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NotNil(t, err)
+	s.Stop(ctx)
+}
+
+func TestTracing(t *testing.T) {
+	tracer := mocktracer.New()
+	old := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(old)
+
+	svc := &testService{}
+	s, conn := startTestServer(t, svc)
+	defer s.Stop(context.Background())
+	defer conn.Close()
+
+	parent := tracer.StartSpan("parent")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	ctx = context.WithValue(ctx, requestIDKey{}, "req-1")
+	assert.Nil(t, invoke(ctx, conn, ""))
+	parent.Finish()
+
+	// 服务端span是客户端span的子span，请求ID传递给下游
+	assert.Equal(t, "req-1", RequestID(svc.ctx))
+	spans := tracer.FinishedSpans()
+	assert.Equal(t, 3, len(spans))
+	server, client := spans[0], spans[1]
+	assert.Equal(t, "/chaos.Test/Check", server.OperationName)
+	assert.Equal(t, client.SpanContext.SpanID, server.ParentID)
+	assert.Equal(t, parent.Context().(mocktracer.MockSpanContext).SpanID, client.ParentID)
+	assert.Equal(t, "OK", server.Tag("grpc.code"))
+}
+
+func TestDefault(t *testing.T) {
+	_ = config.LoadMemory(`{"grpc": {"server": ""}}`, "json")
+	assert.Nil(t, Start())
+	assert.Nil(t, GetServer())
+
+	registered := false
+	Register(func(s *grpc.Server) {
+		registered = true
+		s.RegisterService(&testServiceDesc, &testService{})
+	})
+	called := false
+	UseUnary(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		called = true
+		return handler(ctx, req)
+	})
+
+	_ = config.LoadMemory(`{"grpc": {"server": "127.0.0.1:0"}}`, "json")
+	assert.Nil(t, Start())
+	s := GetServer()
+	assert.NotNil(t, s)
+	assert.True(t, registered)
+
+	conn, err := grpc.Dial(s.Addr(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Nil(t, invoke(context.Background(), conn, ""))
+	assert.True(t, called)
+
+	Stop()
+	assert.Nil(t, GetServer())
+}
@@ -0,0 +1,111 @@
+package rule
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yuanzhangcai/chaos/errors"
+	"github.com/yuanzhangcai/chaos/expression"
+)
+
+// Result 规则集执行结果
+type Result struct {
+	RuleSet string                 `json:"rule_set"`
+	Mode    string                 `json:"mode"`
+	DryRun  bool                   `json:"dry_run"`
+	Matched []string               `json:"matched"` // 匹配的规则名，按执行顺序
+	Values  map[string]interface{} `json:"values"`  // 匹配的规则设置的结果
+	Err     *errors.Error          `json:"-"`       // 匹配的规则返回的错误码，没有时为nil
+	Trace   []*Trace               `json:"trace"`   // 执行过程，只有Explain与DryRun记录
+}
+
+// Trace 单个规则的执行过程
+type Trace struct {
+	Rule      string                 `json:"rule"`
+	Priority  int                    `json:"priority"`
+	Condition string                 `json:"condition"`
+	Vars      map[string]interface{} `json:"vars"` // 条件中引用的变量的值，不存在的变量不记录
+	Matched   bool                   `json:"matched"`
+	Actions   []string               `json:"actions"` // 匹配后执行的动作
+	Error     string                 `json:"error,omitempty"`
+}
+
+func newTrace(r *rule, params expression.Parameters) *Trace {
+	t := &Trace{Rule: r.Name, Priority: r.Priority, Condition: r.Condition, Vars: map[string]interface{}{}}
+	if r.program != nil {
+		for _, name := range r.program.Vars() {
+			if value, err := params.Get(name); err == nil {
+				t.Vars[name] = value
+			}
+		}
+	}
+	return t
+}
+
+func (c *Trace) addAction(action string) {
+	if c != nil {
+		c.Actions = append(c.Actions, action)
+	}
+}
+
+// Fired 是否有规则匹配
+func (c *Result) Fired() bool {
+	return len(c.Matched) > 0
+}
+
+// Get 获取规则设置的结果
+func (c *Result) Get(key string) (interface{}, bool) {
+	value, ok := c.Values[key]
+	return value, ok
+}
+
+// Explain 返回可读的执行过程，如：
+//
+//	rule set checkin (first match)
+//	[x] vip priority=10 condition: level >= 3 vars: level=5
+//	    set bonus = 2
+//	result: matched [vip]
+func (c *Result) Explain() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "rule set %s (%s match)", c.RuleSet, c.Mode)
+	if c.DryRun {
+		buf.WriteString(" dry run")
+	}
+	buf.WriteString("\n")
+
+	for _, t := range c.Trace {
+		mark := "[ ]"
+		if t.Matched {
+			mark = "[x]"
+		}
+		fmt.Fprintf(&buf, "%s %s priority=%d", mark, t.Rule, t.Priority)
+		if t.Condition != "" {
+			fmt.Fprintf(&buf, " condition: %s", t.Condition)
+		}
+		if len(t.Vars) > 0 {
+			names := make([]string, 0, len(t.Vars))
+			for name := range t.Vars {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			buf.WriteString(" vars:")
+			for _, name := range names {
+				fmt.Fprintf(&buf, " %s=%v", name, t.Vars[name])
+			}
+		}
+		buf.WriteString("\n")
+		for _, action := range t.Actions {
+			buf.WriteString("    " + action + "\n")
+		}
+		if t.Error != "" {
+			buf.WriteString("    error: " + t.Error + "\n")
+		}
+	}
+
+	fmt.Fprintf(&buf, "result: matched %v", c.Matched)
+	if c.Err != nil {
+		fmt.Fprintf(&buf, " error %d %s", c.Err.Code(), c.Err.Msg())
+	}
+	return buf.String()
+}
@@ -0,0 +1,319 @@
+// 规则引擎，基于expression沙箱执行规则条件，用于活动、流程的条件判断
+
+package rule
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/yuanzhangcai/chaos/errors"
+	"github.com/yuanzhangcai/chaos/expression"
+	"github.com/yuanzhangcai/config"
+)
+
+const (
+	// FirstMatch 按优先级执行第一个匹配的规则
+	FirstMatch = "first"
+	// AllMatch 按优先级执行所有匹配的规则
+	AllMatch = "all"
+)
+
+// RuleOption 规则配置，匹配后依次设置结果、调用动作、返回错误码
+type RuleOption struct {
+	Name      string                 `json:"name"`      // 规则名，规则集内唯一
+	Condition string                 `json:"condition"` // 条件表达式，结果必须为bool，为空时总是匹配
+	Priority  int                    `json:"priority"`  // 优先级，大的先执行，相同时按配置顺序
+	Set       map[string]interface{} `json:"set"`       // 匹配后设置的结果，后执行的规则覆盖先执行的
+	Actions   []string               `json:"actions"`   // 匹配后调用的动作，见RegisterAction
+	Code      int64                  `json:"code"`      // 匹配后返回的错误码，非0时生效，并结束执行
+	Msg       string                 `json:"msg"`       // 错误信息
+	Disabled  bool                   `json:"disabled"`  // 是否停用
+}
+
+// RuleSetOption 规则集配置
+type RuleSetOption struct {
+	Mode    string                    `json:"mode"`    // 匹配模式：first/all，默认first
+	Sandbox *expression.SandboxOption `json:"sandbox"` // 条件表达式的沙箱配置，为空时使用默认配置
+	Rules   []*RuleOption             `json:"rules"`
+}
+
+// GetRuleSetOptionFromConfig 读取rule下的所有规则集配置
+func GetRuleSetOptionFromConfig() (map[string]*RuleSetOption, error) {
+	opts := map[string]*RuleSetOption{}
+	err := config.Scan([]string{"rule"}, &opts)
+	if err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// Action 规则匹配后调用的动作，result为当前的执行结果，可以读取或修改其中的Values
+type Action func(params expression.Parameters, result *Result) error
+
+var (
+	actions    = map[string]Action{}
+	actionLock sync.RWMutex
+
+	ruleSets    = map[string]*RuleSet{}
+	ruleSetLock sync.RWMutex
+)
+
+// RegisterAction 注册动作，同名的动作会被替换
+func RegisterAction(name string, fn Action) error {
+	if name == "" || fn == nil {
+		return fmt.Errorf("action name and function are required")
+	}
+	actionLock.Lock()
+	defer actionLock.Unlock()
+	actions[name] = fn
+	return nil
+}
+
+func getAction(name string) Action {
+	actionLock.RLock()
+	defer actionLock.RUnlock()
+	return actions[name]
+}
+
+// RuleSet 规则集，创建后不可修改，可以并发执行
+type RuleSet struct {
+	name    string
+	mode    string
+	sandbox *expression.Sandbox
+	rules   []*rule // 按优先级排序
+}
+
+type rule struct {
+	*RuleOption
+	program *expression.Program // 条件为空时为nil
+}
+
+// NewRuleSet 创建规则集，编译所有条件，条件错误可以通过errors.As获取*expression.Error得到出错位置
+func NewRuleSet(name string, opt *RuleSetOption) (*RuleSet, error) {
+	c := &RuleSet{name: name, mode: opt.Mode, sandbox: expression.NewSandbox(opt.Sandbox)}
+	if c.mode == "" {
+		c.mode = FirstMatch
+	}
+	if c.mode != FirstMatch && c.mode != AllMatch {
+		return nil, fmt.Errorf("rule set %s: unknown mode %q", name, opt.Mode)
+	}
+
+	names := map[string]bool{}
+	for i, one := range opt.Rules {
+		if one.Name == "" {
+			return nil, fmt.Errorf("rule set %s: rule %d has no name", name, i)
+		}
+		if names[one.Name] {
+			return nil, fmt.Errorf("rule set %s: duplicate rule %s", name, one.Name)
+		}
+		names[one.Name] = true
+		if one.Disabled {
+			continue
+		}
+
+		r := &rule{RuleOption: one}
+		if one.Condition != "" {
+			p, err := c.sandbox.Compile(one.Condition)
+			if err != nil {
+				return nil, fmt.Errorf("rule set %s: rule %s: %w", name, one.Name, err)
+			}
+			r.program = p
+		}
+		c.rules = append(c.rules, r)
+	}
+	sort.SliceStable(c.rules, func(i, j int) bool {
+		return c.rules[i].Priority > c.rules[j].Priority
+	})
+	return c, nil
+}
+
+// Name 规则集名称
+func (c *RuleSet) Name() string {
+	return c.name
+}
+
+// Mode 匹配模式
+func (c *RuleSet) Mode() string {
+	return c.mode
+}
+
+// Execute 执行规则集
+func (c *RuleSet) Execute(params expression.Parameters) (*Result, error) {
+	return c.run(params, false, false)
+}
+
+// ExecuteWithParams 使用map中的变量执行规则集
+func (c *RuleSet) ExecuteWithParams(params map[string]interface{}) (*Result, error) {
+	return c.run(expression.Params(params), false, false)
+}
+
+// Explain 执行规则集并记录每个规则的执行过程，见Result.Trace与Result.Explain
+func (c *RuleSet) Explain(params expression.Parameters) (*Result, error) {
+	return c.run(params, true, false)
+}
+
+// DryRun 试运行，记录执行过程并计算结果，但不调用动作，用于后台配置规则后检查效果
+func (c *RuleSet) DryRun(params expression.Parameters) (*Result, error) {
+	return c.run(params, true, true)
+}
+
+func (c *RuleSet) run(params expression.Parameters, trace, dryRun bool) (*Result, error) {
+	if params == nil {
+		params = expression.Params{}
+	}
+	result := &Result{RuleSet: c.name, Mode: c.mode, DryRun: dryRun, Values: map[string]interface{}{}}
+
+	for _, r := range c.rules {
+		var t *Trace
+		if trace {
+			t = newTrace(r, params)
+			result.Trace = append(result.Trace, t)
+		}
+
+		matched, err := r.match(params)
+		if err != nil {
+			err = fmt.Errorf("rule set %s: rule %s: %w", c.name, r.Name, err)
+			if t != nil {
+				t.Error = err.Error()
+			}
+			return result, err
+		}
+		if !matched {
+			continue
+		}
+		if t != nil {
+			t.Matched = true
+		}
+		result.Matched = append(result.Matched, r.Name)
+
+		if err = r.apply(params, result, t, dryRun); err != nil {
+			err = fmt.Errorf("rule set %s: rule %s: %w", c.name, r.Name, err)
+			if t != nil {
+				t.Error = err.Error()
+			}
+			return result, err
+		}
+		if result.Err != nil || c.mode == FirstMatch {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (c *rule) match(params expression.Parameters) (bool, error) {
+	if c.program == nil {
+		return true, nil
+	}
+	value, err := c.program.Eval(params)
+	if err != nil {
+		return false, err
+	}
+	matched, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition must be a bool, got %T", value)
+	}
+	return matched, nil
+}
+
+// apply 执行匹配后的动作
+func (c *rule) apply(params expression.Parameters, result *Result, t *Trace, dryRun bool) error {
+	keys := make([]string, 0, len(c.Set))
+	for key := range c.Set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Values[key] = c.Set[key]
+		t.addAction(fmt.Sprintf("set %s = %v", key, c.Set[key]))
+	}
+
+	for _, name := range c.Actions {
+		fn := getAction(name)
+		if fn == nil {
+			return fmt.Errorf("action %s is not registered", name)
+		}
+		if dryRun {
+			t.addAction("call " + name + " (dry run, skipped)")
+			continue
+		}
+		t.addAction("call " + name)
+		if err := fn(params, result); err != nil {
+			return fmt.Errorf("action %s: %w", name, err)
+		}
+	}
+
+	if c.Code != 0 {
+		result.Err = errors.New(c.Code, c.Msg)
+		t.addAction(fmt.Sprintf("error %d %s", c.Code, c.Msg))
+	}
+	return nil
+}
+
+// Load 从TOML或JSON内容加载规则集，格式与配置文件中的rule相同，如{"rule": {"checkin": {"rules": [...]}}}
+func Load(data, format string) (map[string]*RuleSet, error) {
+	cfg := config.New()
+	if err := cfg.LoadMemory(data, format); err != nil {
+		return nil, err
+	}
+	return load(cfg)
+}
+
+// LoadFile 从TOML或JSON文件加载规则集，按扩展名判断格式
+func LoadFile(file string) (map[string]*RuleSet, error) {
+	cfg := config.New()
+	if err := cfg.LoadFile(file); err != nil {
+		return nil, err
+	}
+	return load(cfg)
+}
+
+func load(cfg config.Config) (map[string]*RuleSet, error) {
+	opts := map[string]*RuleSetOption{}
+	if err := cfg.Scan([]string{"rule"}, &opts); err != nil {
+		return nil, err
+	}
+	return newRuleSets(opts)
+}
+
+func newRuleSets(opts map[string]*RuleSetOption) (map[string]*RuleSet, error) {
+	sets := make(map[string]*RuleSet, len(opts))
+	for name, opt := range opts {
+		rs, err := NewRuleSet(name, opt)
+		if err != nil {
+			return nil, err
+		}
+		sets[name] = rs
+	}
+	return sets, nil
+}
+
+// Init 按配置加载所有规则集，条件中使用的自定义函数需要在调用前通过expression.Register注册
+func Init() error {
+	opts, err := GetRuleSetOptionFromConfig()
+	if err != nil {
+		return err
+	}
+	sets, err := newRuleSets(opts)
+	if err != nil {
+		return err
+	}
+	for _, rs := range sets {
+		SetRuleSet(rs)
+	}
+	return nil
+}
+
+// SetRuleSet 注册规则集，同名的规则集会被替换，可用于后台修改规则后热更新
+func SetRuleSet(rs *RuleSet) {
+	ruleSetLock.Lock()
+	defer ruleSetLock.Unlock()
+	ruleSets[rs.name] = rs
+}
+
+// GetRuleSet 获取规则集，没有配置时返回nil
+func GetRuleSet(name string) *RuleSet {
+	ruleSetLock.RLock()
+	defer ruleSetLock.RUnlock()
+	return ruleSets[name]
+}
@@ -0,0 +1,225 @@
+package rule
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/chaos/expression"
+	"github.com/yuanzhangcai/config"
+)
+
+const checkinRules = `
+[rule.checkin]
+mode = "first"
+
+[[rule.checkin.rules]]
+name = "blocked"
+condition = "in_array(nid, blocked)"
+priority = 100
+code = -1001
+msg = "账号已冻结"
+
+[[rule.checkin.rules]]
+name = "vip"
+condition = "level >= 3 && act_id == 12"
+priority = 10
+actions = ["test_grant"]
+set = {bonus = 2, tag = "vip"}
+
+[[rule.checkin.rules]]
+name = "default"
+set = {bonus = 1}
+
+[[rule.checkin.rules]]
+name = "disabled"
+condition = "level >"
+disabled = true
+`
+
+func TestRuleSet(t *testing.T) {
+	var granted []interface{}
+	assert.Nil(t, RegisterAction("test_grant", func(params expression.Parameters, result *Result) error {
+		nid, _ := params.Get("nid")
+		granted = append(granted, nid)
+		result.Values["granted"] = true
+		return nil
+	}))
+	assert.NotNil(t, RegisterAction("", nil))
+
+	sets, err := Load(checkinRules, "toml")
+	assert.Nil(t, err)
+	rs := sets["checkin"]
+	assert.Equal(t, "checkin", rs.Name())
+	assert.Equal(t, FirstMatch, rs.Mode())
+
+	params := map[string]interface{}{"nid": 1, "level": 5, "act_id": 12, "blocked": []int{2, 3}}
+	result, err := rs.ExecuteWithParams(params)
+	assert.Nil(t, err)
+	assert.True(t, result.Fired())
+	assert.Equal(t, []string{"vip"}, result.Matched)
+	assert.Equal(t, map[string]interface{}{"bonus": float64(2), "tag": "vip", "granted": true}, result.Values)
+	assert.Nil(t, result.Err)
+	assert.Nil(t, result.Trace)
+	assert.Equal(t, []interface{}{1}, granted)
+
+	params["level"] = 1
+	result, err = rs.ExecuteWithParams(params)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"default"}, result.Matched)
+	bonus, ok := result.Get("bonus")
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), bonus)
+
+	params["nid"] = 2
+	result, err = rs.ExecuteWithParams(params)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"blocked"}, result.Matched)
+	assert.Equal(t, int64(-1001), result.Err.Code())
+	assert.Equal(t, "账号已冻结", result.Err.Msg())
+
+	// 条件执行出错
+	delete(params, "blocked")
+	_, err = rs.ExecuteWithParams(params)
+	e := &expression.Error{}
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, expression.KindVariable, e.Kind)
+	assert.EqualError(t, err, "rule set checkin: rule blocked: variable error at position 14: unknown variable 'blocked'")
+}
+
+func TestAllMatch(t *testing.T) {
+	rs, err := NewRuleSet("score", &RuleSetOption{
+		Mode: AllMatch,
+		Rules: []*RuleOption{
+			{Name: "base", Set: map[string]interface{}{"score": 1, "base": true}},
+			{Name: "level", Condition: "level >= 3", Priority: 10, Set: map[string]interface{}{"score": 3}},
+			{Name: "new", Condition: "days < 7", Priority: 5, Set: map[string]interface{}{"new": true}},
+			{Name: "limit", Condition: "count > 10", Priority: 1, Code: -1002, Msg: "超过次数限制"},
+			{Name: "after_limit", Condition: "true", Set: map[string]interface{}{"after": true}},
+		},
+	})
+	assert.Nil(t, err)
+
+	result, err := rs.ExecuteWithParams(map[string]interface{}{"level": 3, "days": 10, "count": 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"level", "base", "after_limit"}, result.Matched)
+	// 后执行的规则覆盖先执行的
+	assert.Equal(t, map[string]interface{}{"score": 1, "base": true, "after": true}, result.Values)
+
+	// 返回错误码后结束执行
+	result, err = rs.ExecuteWithParams(map[string]interface{}{"level": 1, "days": 1, "count": 11})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"new", "limit"}, result.Matched)
+	assert.Equal(t, int64(-1002), result.Err.Code())
+}
+
+func TestExplainAndDryRun(t *testing.T) {
+	called := 0
+	assert.Nil(t, RegisterAction("test_count", func(params expression.Parameters, result *Result) error {
+		called++
+		return nil
+	}))
+	assert.Nil(t, RegisterAction("test_fail", func(params expression.Parameters, result *Result) error {
+		return errors.New("coupon is sold out")
+	}))
+
+	rs, err := NewRuleSet("coupon", &RuleSetOption{
+		Rules: []*RuleOption{
+			{Name: "new_user", Condition: `days < 7 && name != ""`, Priority: 10, Actions: []string{"test_count"}, Set: map[string]interface{}{"coupon": "NEW"}},
+			{Name: "fallback", Actions: []string{"test_count", "test_fail"}},
+		},
+	})
+	assert.Nil(t, err)
+
+	params := expression.Params{"days": 30, "name": "chaos"}
+	result, err := rs.DryRun(params)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, called)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, []string{"fallback"}, result.Matched)
+	if assert.Len(t, result.Trace, 2) {
+		assert.Equal(t, &Trace{Rule: "new_user", Priority: 10, Condition: `days < 7 && name != ""`, Vars: map[string]interface{}{"days": 30, "name": "chaos"}}, result.Trace[0])
+		assert.True(t, result.Trace[1].Matched)
+		assert.Equal(t, []string{"call test_count (dry run, skipped)", "call test_fail (dry run, skipped)"}, result.Trace[1].Actions)
+	}
+	assert.Equal(t, "rule set coupon (first match) dry run\n"+
+		"[ ] new_user priority=10 condition: days < 7 && name != \"\" vars: days=30 name=chaos\n"+
+		"[x] fallback priority=0\n"+
+		"    call test_count (dry run, skipped)\n"+
+		"    call test_fail (dry run, skipped)\n"+
+		"result: matched [fallback]", result.Explain())
+
+	params["days"] = 1
+	result, err = rs.Explain(params)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, called)
+	assert.Equal(t, []string{"set coupon = NEW", "call test_count"}, result.Trace[0].Actions)
+
+	// 动作出错时返回错误
+	params["days"] = 30
+	result, err = rs.Explain(params)
+	assert.EqualError(t, err, "rule set coupon: rule fallback: action test_fail: coupon is sold out")
+	assert.Equal(t, 2, called)
+	assert.Equal(t, "rule set coupon: rule fallback: action test_fail: coupon is sold out", result.Trace[1].Error)
+
+	// 条件结果不是bool
+	rs, err = NewRuleSet("bad", &RuleSetOption{Rules: []*RuleOption{{Name: "num", Condition: "days + 1"}, {Name: "missing", Actions: []string{"test_missing"}}}})
+	assert.Nil(t, err)
+	_, err = rs.Execute(params)
+	assert.EqualError(t, err, "rule set bad: rule num: condition must be a bool, got float64")
+	rs, _ = NewRuleSet("bad", &RuleSetOption{Rules: []*RuleOption{{Name: "missing", Actions: []string{"test_missing"}}}})
+	_, err = rs.Execute(nil)
+	assert.EqualError(t, err, "rule set bad: rule missing: action test_missing is not registered")
+}
+
+func TestNewRuleSetErrors(t *testing.T) {
+	_, err := NewRuleSet("a", &RuleSetOption{Mode: "any"})
+	assert.EqualError(t, err, `rule set a: unknown mode "any"`)
+	_, err = NewRuleSet("a", &RuleSetOption{Rules: []*RuleOption{{}}})
+	assert.EqualError(t, err, "rule set a: rule 0 has no name")
+	_, err = NewRuleSet("a", &RuleSetOption{Rules: []*RuleOption{{Name: "x"}, {Name: "x"}}})
+	assert.EqualError(t, err, "rule set a: duplicate rule x")
+
+	// 条件错误带有位置
+	_, err = NewRuleSet("a", &RuleSetOption{
+		Sandbox: &expression.SandboxOption{Functions: []string{"strlen"}},
+		Rules:   []*RuleOption{{Name: "x", Condition: "level > 1 && upper(name) == \"A\""}},
+	})
+	e := &expression.Error{}
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, expression.KindFunction, e.Kind)
+		assert.Equal(t, 13, e.Pos)
+	}
+
+	_, err = Load(`{"rule": {"a": {"rules": [{"name": "x", "condition": "level >"}]}}}`, "json")
+	assert.NotNil(t, err)
+	_, err = Load(`{"rule": `, "json")
+	assert.NotNil(t, err)
+}
+
+func TestInit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rule")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "rules.json")
+	assert.Nil(t, ioutil.WriteFile(file, []byte(`{"rule": {"flow": {"mode": "all", "rules": [{"name": "open", "condition": "flow_id == 1"}]}}}`), 0644))
+	sets, err := LoadFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, AllMatch, sets["flow"].Mode())
+	_, err = LoadFile(filepath.Join(dir, "none.json"))
+	assert.NotNil(t, err)
+
+	err = config.LoadMemory(`{"rule": {"test_init": {"rules": [{"name": "open", "condition": "flow_id == 1", "set": {"open": true}}]}}}`, "json")
+	assert.Nil(t, err)
+	assert.Nil(t, Init())
+	rs := GetRuleSet("test_init")
+	if assert.NotNil(t, rs) {
+		result, err := rs.ExecuteWithParams(map[string]interface{}{"flow_id": 1})
+		assert.Nil(t, err)
+		assert.Equal(t, true, result.Values["open"])
+	}
+	assert.Nil(t, GetRuleSet("none"))
+}
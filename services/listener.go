@@ -0,0 +1,146 @@
+package services
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/yuanzhangcai/chaos/graceful"
+	"github.com/yuanzhangcai/config"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// ServerOption HTTP服务配置
+type ServerOption struct {
+	ReadTimeout       int64     `json:"read_timeout"`        // 读取整个请求的超时时间，单位秒，0表示不限制
+	ReadHeaderTimeout int64     `json:"read_header_timeout"` // 读取请求头的超时时间，单位秒
+	WriteTimeout      int64     `json:"write_timeout"`       // 写响应的超时时间，单位秒，0表示不限制
+	IdleTimeout       int64     `json:"idle_timeout"`        // keep-alive连接空闲超时时间，单位秒
+	MaxHeaderBytes    int       `json:"max_header_bytes"`    // 请求头最大大小，单位KB
+	HTTP2             bool      `json:"http2"`               // HTTPS是否支持HTTP/2
+	H2C               bool      `json:"h2c"`                 // 非HTTPS端口是否支持h2c(不加密的HTTP/2)
+	Unix              string    `json:"unix"`                // unix socket文件路径，不为空时同时监听unix socket
+	UnixMode          string    `json:"unix_mode"`           // unix socket文件权限，八进制
+	TLS               TLSOption `json:"tls"`                 // HTTPS配置
+}
+
+// listener 服务监听
+type listener struct {
+	net.Listener
+	tls bool // 是否为HTTPS
+}
+
+// GetServerOptionFromConfig 读取HTTP服务配置
+func GetServerOptionFromConfig() (*ServerOption, error) {
+	opt := ServerOption{
+		ReadHeaderTimeout: 10,
+		IdleTimeout:       120,
+		MaxHeaderBytes:    1024,
+		HTTP2:             true,
+		UnixMode:          "0660",
+	}
+	err := config.Scan([]string{"http"}, &opt)
+	if err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
+// applyServerOption 设置http.Server的超时时间、请求头大小、HTTP/2与HTTPS配置，返回的关闭函数用于停止证书自动加载
+func applyServerOption(srv *http.Server, opt *ServerOption) (func(), error) {
+	srv.ReadTimeout = time.Duration(opt.ReadTimeout) * time.Second
+	srv.ReadHeaderTimeout = time.Duration(opt.ReadHeaderTimeout) * time.Second
+	srv.WriteTimeout = time.Duration(opt.WriteTimeout) * time.Second
+	srv.IdleTimeout = time.Duration(opt.IdleTimeout) * time.Second
+	srv.MaxHeaderBytes = opt.MaxHeaderBytes * 1024
+
+	if opt.H2C {
+		srv.Handler = h2c.NewHandler(srv.Handler, &http2.Server{IdleTimeout: srv.IdleTimeout})
+	}
+	if !opt.HTTP2 {
+		// TLSNextProto不为nil时不会自动开启HTTP/2
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
+	if opt.TLS.CertFile == "" {
+		return func() {}, nil
+	}
+
+	cfg, closer, err := NewTLSConfig(&opt.TLS)
+	if err != nil {
+		return nil, err
+	}
+	srv.TLSConfig = cfg
+	if opt.HTTP2 {
+		// HTTP与HTTPS监听在不同协程中启动，先行配置HTTP/2，避免HTTP监听先启动时HTTPS不支持HTTP/2
+		if err = http2.ConfigureServer(srv, &http2.Server{IdleTimeout: srv.IdleTimeout}); err != nil {
+			closer()
+			return nil, err
+		}
+	}
+	return closer, nil
+}
+
+// listen 监听HTTP、HTTPS与unix socket地址，平滑重启时使用父进程传递的监听，任意一个监听失败时关闭已监听的地址
+func listen(srv *http.Server, opt *ServerOption) ([]*listener, error) {
+	var listeners []*listener
+	add := func(network, addr string, isTLS bool) error {
+		ln, err := graceful.Listen(network, addr)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, &listener{Listener: ln, tls: isTLS})
+		return nil
+	}
+
+	err := func() error {
+		hasTLS := srv.TLSConfig != nil
+		if hasTLS && opt.TLS.Address != "" {
+			if err := add("tcp", opt.TLS.Address, true); err != nil {
+				return err
+			}
+			hasTLS = false
+		}
+		if srv.Addr != "" {
+			// 没有单独配置HTTPS地址时，服务地址使用HTTPS
+			if err := add("tcp", srv.Addr, hasTLS); err != nil {
+				return err
+			}
+		}
+
+		if opt.Unix != "" {
+			if err := add("unix", opt.Unix, false); err != nil {
+				return err
+			}
+			mode, err := strconv.ParseUint(opt.UnixMode, 8, 32)
+			if err != nil {
+				return err
+			}
+			if err = os.Chmod(opt.Unix, os.FileMode(mode)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+
+	if err != nil {
+		for _, one := range listeners {
+			_ = one.Close()
+		}
+		return nil, err
+	}
+	return listeners, nil
+}
+
+// serve 在监听上提供服务
+func serve(srv *http.Server, ln *listener) error {
+	if ln.tls {
+		// 证书由TLSConfig.GetCertificate提供
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+// testCert 测试用证书
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert 生成证书，parent为空时生成自签名的CA证书
+func newTestCert(t *testing.T, dir, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	assert.Nil(t, ioutil.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	assert.Nil(t, ioutil.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return c
+}
+
+func (c *testCert) pair(t *testing.T) tls.Certificate {
+	pair, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	assert.Nil(t, err)
+	return pair
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca)
+
+	_, _, err := NewTLSConfig(&TLSOption{CertFile: server.certFile, KeyFile: server.keyFile, MinVersion: "2.0"})
+	assert.NotNil(t, err)
+	_, _, err = NewTLSConfig(&TLSOption{CertFile: server.certFile, KeyFile: server.keyFile, ClientAuth: "always"})
+	assert.NotNil(t, err)
+	_, _, err = NewTLSConfig(&TLSOption{CertFile: filepath.Join(dir, "none.crt"), KeyFile: server.keyFile})
+	assert.NotNil(t, err)
+	_, _, err = NewTLSConfig(&TLSOption{CertFile: server.certFile, KeyFile: server.keyFile, ClientCA: server.keyFile})
+	assert.NotNil(t, err)
+
+	cfg, closer, err := NewTLSConfig(&TLSOption{CertFile: server.certFile, KeyFile: server.keyFile})
+	assert.Nil(t, err)
+	defer closer()
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+
+	cfg, closer, err = NewTLSConfig(&TLSOption{CertFile: server.certFile, KeyFile: server.keyFile, ClientCA: ca.certFile})
+	assert.Nil(t, err)
+	defer closer()
+	assert.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+	assert.NotNil(t, cfg.ClientCAs)
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	old := newTestCert(t, dir, "server", ca)
+
+	reloader, err := newCertReloader(old.certFile, old.keyFile, 10*time.Millisecond)
+	assert.Nil(t, err)
+	defer reloader.Close()
+
+	cert, _ := reloader.GetCertificate(nil)
+	assert.Equal(t, old.cert.Raw, cert.Certificate[0])
+
+	// 证书更新后自动加载新证书
+	renewed := newTestCert(t, dir, "server", ca)
+	mtime := time.Now().Add(time.Second)
+	assert.Nil(t, os.Chtimes(renewed.certFile, mtime, mtime))
+	assert.Eventually(t, func() bool {
+		cert, _ := reloader.GetCertificate(nil)
+		return string(cert.Certificate[0]) == string(renewed.cert.Raw)
+	}, time.Second, 10*time.Millisecond)
+
+	reloader.Close()
+	reloader.Close()
+}
+
+// startTestServer 按配置启动服务，返回各监听地址
+func startTestServer(t *testing.T, opt *ServerOption) (*http.Server, []*listener) {
+	srv := &http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Proto))
+		}),
+	}
+	closer, err := applyServerOption(srv, opt)
+	assert.Nil(t, err)
+	t.Cleanup(closer)
+
+	listeners, err := listen(srv, opt)
+	assert.Nil(t, err)
+	for _, ln := range listeners {
+		go func(ln *listener) { _ = serve(srv, ln) }(ln)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+	return srv, listeners
+}
+
+func get(t *testing.T, client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return string(buf), nil
+}
+
+func TestListen(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca)
+	sock := filepath.Join(dir, "chaos.sock")
+	assert.Nil(t, ioutil.WriteFile(sock, nil, 0644)) // 残留的socket文件
+
+	opt := &ServerOption{
+		ReadTimeout:    5,
+		WriteTimeout:   6,
+		IdleTimeout:    7,
+		MaxHeaderBytes: 8,
+		HTTP2:          true,
+		H2C:            true,
+		Unix:           sock,
+		UnixMode:       "0600",
+		TLS:            TLSOption{Address: "127.0.0.1:0", CertFile: server.certFile, KeyFile: server.keyFile},
+	}
+	srv, listeners := startTestServer(t, opt)
+	assert.Equal(t, 5*time.Second, srv.ReadTimeout)
+	assert.Equal(t, 6*time.Second, srv.WriteTimeout)
+	assert.Equal(t, 7*time.Second, srv.IdleTimeout)
+	assert.Equal(t, 8*1024, srv.MaxHeaderBytes)
+	assert.Equal(t, 3, len(listeners))
+	httpsAddr, httpAddr := listeners[0].Addr().String(), listeners[1].Addr().String()
+	assert.True(t, listeners[0].tls)
+	assert.False(t, listeners[1].tls)
+
+	// HTTPS与HTTP/2
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	tr := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	assert.Nil(t, http2.ConfigureTransport(tr))
+	body, err := get(t, &http.Client{Transport: tr}, "https://"+httpsAddr+"/")
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/2.0", body)
+
+	// 普通HTTP
+	body, err = get(t, &http.Client{}, "http://"+httpAddr+"/")
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/1.1", body)
+
+	// h2c
+	h2c := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	body, err = get(t, &http.Client{Transport: h2c}, "http://"+httpAddr+"/")
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/2.0", body)
+
+	// unix socket
+	fi, err := os.Stat(sock)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	unix := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", sock)
+		},
+	}
+	body, err = get(t, &http.Client{Transport: unix}, "http://unix/")
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/1.1", body)
+
+	// 端口被占用时关闭已监听的地址
+	_, err = listen(&http.Server{Addr: httpAddr}, &ServerOption{Unix: filepath.Join(dir, "other.sock")})
+	assert.NotNil(t, err)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca)
+	client := newTestCert(t, dir, "client", ca)
+	other := newTestCert(t, dir, "other", newTestCert(t, dir, "other_ca", nil))
+
+	// 没有单独配置HTTPS地址时，服务地址使用HTTPS，关闭HTTP/2
+	_, listeners := startTestServer(t, &ServerOption{
+		TLS: TLSOption{CertFile: server.certFile, KeyFile: server.keyFile, ClientCA: ca.certFile},
+	})
+	assert.Equal(t, 1, len(listeners))
+	assert.True(t, listeners[0].tls)
+	url := "https://" + listeners[0].Addr().String() + "/"
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: pool, Certificates: certs},
+			ForceAttemptHTTP2: true,
+		}}
+	}
+
+	_, err := get(t, newClient(), url)
+	assert.NotNil(t, err)
+	_, err = get(t, newClient(other.pair(t)), url)
+	assert.NotNil(t, err)
+
+	body, err := get(t, newClient(client.pair(t)), url)
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/1.1", body)
+}
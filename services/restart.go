@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package services
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyRestart 收到SIGUSR2时平滑重启
+func notifyRestart(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR2)
+}
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package services
+
+import "os"
+
+// notifyRestart windows没有SIGUSR2，也不能向子进程传递监听，不支持平滑重启
+func notifyRestart(ch chan<- os.Signal) {}
@@ -9,22 +9,32 @@ import (
 	"os/signal"
 	"path/filepath"
 	"reflect"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
+	"github.com/yuanzhangcai/chaos/admin"
+	"github.com/yuanzhangcai/chaos/alert"
 	"github.com/yuanzhangcai/chaos/common"
 	"github.com/yuanzhangcai/chaos/controllers"
+	"github.com/yuanzhangcai/chaos/graceful"
+	"github.com/yuanzhangcai/chaos/log"
 	"github.com/yuanzhangcai/chaos/middleware"
 	"github.com/yuanzhangcai/chaos/monitor"
+	"github.com/yuanzhangcai/chaos/profiler"
+	"github.com/yuanzhangcai/chaos/registry"
+	"github.com/yuanzhangcai/chaos/rpc"
 	"github.com/yuanzhangcai/config"
-	"github.com/yuanzhangcai/srsd/registry"
-	"github.com/yuanzhangcai/srsd/service"
 )
 
-var register *registry.Registry
+var instance *registry.Instance
+var tlsCloser func() // 停止证书自动加载
 var quit chan os.Signal
+var exit = os.Exit // 启动失败时退出进程
+
+// quitLock 保护quit，Stop可能在其它协程中调用
+var quitLock sync.Mutex
 
 // CreateServer 创建路由
 func CreateServer() *gin.Engine {
@@ -40,7 +50,7 @@ func CreateServer() *gin.Engine {
 	if config.GetBool("common", "used_time") {
 		ware = append(ware, middleware.UsedTime())
 	}
-	ware = append(ware, gin.Recovery())
+	ware = append(ware, middleware.DebugLog(), gin.Recovery())
 	router.Use(ware...)
 	return router
 }
@@ -152,8 +162,8 @@ func initialize(c interface{}) controllers.ControllerInterface {
 // 	}
 // }
 
-// StartGin 开启gin服务
-func StartGin(router *gin.Engine, srv *http.Server) {
+// StartGin 开启gin服务，监听或服务注册失败时返回错误
+func StartGin(router *gin.Engine, srv *http.Server) error {
 	serverName := config.GetString("common", "server_name") // 微务服名称
 	if common.Env != common.EnvProd {
 		serverName += "." + common.Env // 如果当前环境不是正式环境，服务名称添加环境后缀
@@ -162,31 +172,74 @@ func StartGin(router *gin.Engine, srv *http.Server) {
 	srv.Addr = config.GetString("common", "address")
 	srv.Handler = router
 
-	go func() {
-		// 服务连接
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logrus.Fatalf("listen: %s\n", err)
-			close(quit) // 关闭服务
+	opt, err := GetServerOptionFromConfig()
+	if err != nil {
+		log.L().Errorf("read http config: %s\n", err)
+		return err
+	}
+	if tlsCloser, err = applyServerOption(srv, opt); err != nil {
+		log.L().Errorf("tls: %s\n", err)
+		return err
+	}
+
+	listeners, err := listen(srv, opt)
+	if err != nil {
+		log.L().Errorf("listen: %s\n", err)
+		return err
+	}
+	for _, ln := range listeners {
+		go func(ln *listener) {
+			// 服务连接
+			if err := serve(srv, ln); err != nil && err != http.ErrServerClosed {
+				log.L().Errorf("serve %s: %s\n", ln.Addr(), err)
+				shutdown() // 关闭服务
+			}
+		}(ln)
+	}
+
+	if err := register(serverName, srv.Addr); err != nil {
+		fmt.Println("服务注册失败:", err)
+		log.L().Error("服务注册失败:", err)
+		return err
+	}
+	return nil
+}
+
+// register 服务注册，没有配置服务注册时不注册
+func register(name, addr string) error {
+	if err := registry.Init(); err != nil {
+		return err
+	}
+	reg := registry.GetRegistry()
+	if reg == nil {
+		return nil
+	}
+
+	ins, err := registry.NewInstance(name, addr)
+	if err != nil {
+		return err
+	}
+	if opt, err := admin.GetOptionFromConfig(); err == nil && opt.Server != "" {
+		// 监控指标与pprof均由管理服务提供，使用monitor.server时不提供pprof
+		if metrics, err := registry.AdvertiseAddr(opt.Server); err == nil {
+			ins.Metrics = metrics
+			if !opt.MetricsOnly {
+				ins.PProf = metrics
+			}
 		}
-	}()
-
-	etcdAddrs := config.GetStringArray("common", "etcd_addrs")
-	if len(etcdAddrs) > 0 { // 配有etcd地址，则开启服务注册功能
-		info := service.NewService()
-		info.Name = serverName
-		info.Host = srv.Addr
-		info.Metrics = config.GetString("monitor", "server")
-		info.PProf = config.GetString("pprof", "server")
-		register = registry.NewRegistry(info,
-			registry.Addresses(config.GetStringArray("common", "etcd_addrs")),
-			registry.TTL(time.Duration(time.Duration(config.GetInt("common", "register_ttl")))*time.Second))
-		err := register.Start()
-		if err != nil {
-			fmt.Println("服务注册失败:", err)
-			logrus.Error("服务注册失败:", err)
-			close(quit) // 关闭服务
+	}
+
+	if s := rpc.GetServer(); s != nil {
+		if addr, err := registry.AdvertiseAddr(s.Addr()); err == nil {
+			ins.Metadata[registry.MetaGRPC] = addr
 		}
 	}
+
+	if err = reg.Register(ins); err != nil {
+		return err
+	}
+	instance = ins
+	return nil
 }
 
 // StaticRouter 静态文件路由注册
@@ -212,49 +265,151 @@ func StaticRouter(router *gin.Engine, uri, dir string) error {
 	})
 }
 
-// StartServer 启动服务
+// StartServer 启动服务，启动失败时停止已启动的服务并以非0状态码退出，不通知父进程就绪
 func StartServer(router *gin.Engine) {
+	quitLock.Lock()
 	quit = make(chan os.Signal, 1)
-	srv := &http.Server{}
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	quitLock.Unlock()
+	srv := &http.Server{}
 
 	microCtx, microcancel := context.WithCancel(context.Background())
 	defer microcancel()
 
-	// logrus.Info("mode = " + common.Mode)
-	// if common.Mode == "micro" {
-	// 	// 以微服务型式启动
-	// 	startMicro(router, srv, &microCtx)
-	// } else {
-	// 	// 以传统web服务启动
-	// 	startGin(router, srv)
-	// }
+	gracefulOpt, err := graceful.GetOptionFromConfig()
+	if err != nil {
+		log.L().Error("读取平滑重启配置失败：", err)
+		gracefulOpt = &graceful.Option{}
+	}
+
+	err = start(router, srv)
+	if err == nil {
+		// 所有监听与服务注册都成功后才通知父进程已就绪，父进程停止服务后退出
+		if err = graceful.Ready(); err != nil {
+			log.L().Error("通知父进程就绪失败：", err)
+		}
+	}
+	if err != nil {
+		// 平滑重启时父进程等待就绪超时后继续服务
+		stopServer(microCtx, srv)
+		log.Close()
+		exit(1)
+		return
+	}
+
+	// 写入pid文件，平滑重启时新进程覆盖旧进程的pid
+	if err = graceful.WritePidFile(gracefulOpt.PidFile); err != nil {
+		log.L().Error("写入pid文件失败：", err)
+	}
+
+	wait(gracefulOpt) // 等待退出信号或平滑重启完成
+
+	stopServer(microCtx, srv)
+
+	if err = graceful.RemovePidFile(gracefulOpt.PidFile); err != nil {
+		log.L().Error("删除pid文件失败：", err)
+	}
+
+	log.L().Info("Server exiting")
+	fmt.Println("Server exiting")
+
+	// 写入异步日志缓冲区中剩余的日志
+	log.Close()
+}
+
+// start 启动gRPC服务、HTTP服务、管理服务与持续性能分析，监听或服务注册失败时返回错误
+func start(router *gin.Engine, srv *http.Server) error {
+	// 启动gRPC服务，需在服务注册之前启动，以便注册gRPC地址
+	if err := rpc.Start(); err != nil {
+		fmt.Println("gRPC服务启动失败:", err)
+		log.L().Error("gRPC服务启动失败:", err)
+		return err
+	}
 
 	// 以传统web服务启动
-	StartGin(router, srv)
+	if err := StartGin(router, srv); err != nil {
+		return err
+	}
 
-	<-quit // 等待退出信号
+	// 启动管理服务
+	if err := admin.Start(router.Routes()); err != nil {
+		log.L().Error("管理服务启动失败：", err)
+		return err
+	}
+
+	// 启动持续性能分析
+	if err := profiler.Init(); err != nil {
+		log.L().Error("性能分析启动失败：", err)
+	} else if p, s := profiler.GetProfiler(), admin.GetServer(); p != nil && s != nil {
+		s.Handle("/debug/profiler", p.Handler())
+	}
+	return nil
+}
 
-	if register != nil {
+// stopServer 停止服务注册及已启动的各项服务
+func stopServer(ctx context.Context, srv *http.Server) {
+	if instance != nil {
 		// 停止服务注册
-		_ = register.Stop()
+		if reg := registry.GetRegistry(); reg != nil {
+			_ = reg.Deregister(instance)
+		}
+		instance = nil
 	}
+	registry.Stop()
 
 	// 关闭服务，设置超时时间为5秒
-	ctx, cancel := context.WithTimeout(microCtx, 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	logrus.Info("Shutdown Server ...")
+	log.L().Info("Shutdown Server ...")
 	if err := srv.Shutdown(ctx); err != nil {
-		logrus.Error("Server Shutdown:", err)
+		log.L().Error("Server Shutdown:", err)
+	}
+	if tlsCloser != nil {
+		tlsCloser()
+		tlsCloser = nil
 	}
 
+	// 停止gRPC服务
+	rpc.Stop()
+
+	// 停止性能分析
+	profiler.Stop()
+
+	// 停止管理服务
+	admin.Stop()
+
 	// 停止监控
 	monitor.Stop()
 
+	// 停止告警，发送队列中剩余的告警
+	alert.Stop()
+
+	quitLock.Lock()
+	signal.Stop(quit)
 	quit = nil
+	quitLock.Unlock()
+}
 
-	logrus.Info("Server exiting")
-	fmt.Println("Server exiting")
+// wait 等待退出信号，收到SIGUSR2时启动新进程并传递监听，新进程就绪后返回，启动失败时继续服务
+func wait(opt *graceful.Option) {
+	restart := make(chan os.Signal, 1)
+	notifyRestart(restart)
+	defer signal.Stop(restart)
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-restart:
+			log.L().Info("Restart Server ...")
+			if err := graceful.Restart(time.Duration(opt.Timeout) * time.Second); err != nil {
+				log.L().Error("平滑重启失败：", err)
+				continue
+			}
+			log.L().Info("新进程已就绪，停止当前服务")
+			return
+		}
+	}
 }
 
 // Start 开启服务
@@ -273,7 +428,15 @@ func Start(setRouter func(router *gin.Engine)) {
 
 // Stop 停止服务
 func Stop() {
-	if quit != nil {
-		close(quit)
+	shutdown()
+}
+
+// shutdown 通知服务退出，可以重复调用
+func shutdown() {
+	quitLock.Lock()
+	defer quitLock.Unlock()
+	select {
+	case quit <- syscall.SIGTERM:
+	default: // 已有退出信号或服务没有启动
 	}
 }
@@ -160,6 +160,34 @@ func TestServer(t *testing.T) {
 	checkNoMethod(t, r, "/no_method")
 }
 
+func TestStartServerFailed(t *testing.T) {
+	initConfig()
+	_ = config.LoadMemory(`{"common":{"address":"127.0.0.1:0"},"registry":{"type":"unknown"}}`, "json")
+	code := 0
+	exit = func(c int) { code = c }
+	t.Cleanup(func() {
+		exit = os.Exit
+		_ = config.LoadMemory(`{"common":{"address":"0.0.0.0:4444"},"registry":{"type":""}}`, "json")
+	})
+
+	done := make(chan struct{})
+	go func() {
+		StartServer(CreateServer())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartServer should return when register failed")
+	}
+	assert.Equal(t, 1, code)
+
+	// 重复停止不会panic
+	Stop()
+	Stop()
+}
+
 func TestStart(t *testing.T) {
 	initConfig()
 
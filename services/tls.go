@@ -0,0 +1,183 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yuanzhangcai/chaos/log"
+)
+
+// TLSOption HTTPS配置
+type TLSOption struct {
+	Address    string `json:"address"`     // HTTPS监听地址，为空时common.address改为HTTPS
+	CertFile   string `json:"cert_file"`   // 证书文件，为空时不开启HTTPS
+	KeyFile    string `json:"key_file"`    // 私钥文件
+	ClientCA   string `json:"client_ca"`   // 校验客户端证书的CA文件，不为空时开启mTLS
+	ClientAuth string `json:"client_auth"` // 客户端证书校验方式：request/require/verify_if_given/require_and_verify
+	MinVersion string `json:"min_version"` // 最低TLS版本：1.0/1.1/1.2/1.3
+	Reload     int64  `json:"reload"`      // 检查证书文件变化的间隔，单位秒，0表示不自动重新加载
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader 证书加载器，证书文件变化时自动重新加载，不需要重启服务
+type certReloader struct {
+	certFile string
+	keyFile  string
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		quit:     make(chan struct{}),
+	}
+	if _, err := c.load(); err != nil {
+		return nil, err
+	}
+
+	if interval > 0 {
+		c.wg.Add(1)
+		go c.loop(interval)
+	}
+	return c, nil
+}
+
+// load 加载证书，证书与私钥文件都没有变化时返回false
+func (c *certReloader) load() (bool, error) {
+	modTime, err := latestModTime(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.lock.RLock()
+	changed := !modTime.Equal(c.modTime)
+	c.lock.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.lock.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.lock.Unlock()
+	return true, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, one := range files {
+		fi, err := os.Stat(one)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) loop(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 证书更新过程中可能只写入了一个文件，加载失败时保留旧证书，等待下次检查
+			changed, err := c.load()
+			if err != nil {
+				log.L().WithField("cert", c.certFile).Error("重新加载证书失败：", err)
+			} else if changed {
+				log.L().WithField("cert", c.certFile).Info("证书已重新加载")
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// GetCertificate 实现tls.Config.GetCertificate，返回当前证书
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cert, nil
+}
+
+// Close 停止检查证书文件变化
+func (c *certReloader) Close() {
+	c.once.Do(func() {
+		close(c.quit)
+		c.wg.Wait()
+	})
+}
+
+// NewTLSConfig 根据配置创建tls.Config，证书文件变化时自动重新加载，返回的关闭函数用于停止检查证书文件
+func NewTLSConfig(opt *TLSOption) (*tls.Config, func(), error) {
+	minVersion, ok := tlsVersions[opt.MinVersion]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown tls min version (%s)", opt.MinVersion)
+	}
+	clientAuth, ok := clientAuthTypes[opt.ClientAuth]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown tls client auth (%s)", opt.ClientAuth)
+	}
+
+	cfg := &tls.Config{MinVersion: minVersion}
+	if opt.ClientCA != "" {
+		buf, err := ioutil.ReadFile(opt.ClientCA)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, nil, fmt.Errorf("invalid tls client ca (%s)", opt.ClientCA)
+		}
+		cfg.ClientCAs = pool
+		if opt.ClientAuth == "" {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	cfg.ClientAuth = clientAuth
+
+	reloader, err := newCertReloader(opt.CertFile, opt.KeyFile, time.Duration(opt.Reload)*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.GetCertificate = reloader.GetCertificate
+	return cfg, reloader.Close, nil
+}
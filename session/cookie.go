@@ -0,0 +1,88 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/yuanzhangcai/chaos/crypto"
+)
+
+const timestampSize = 8
+
+var (
+	// ErrInvalidCookie cookie格式错误或签名不匹配
+	ErrInvalidCookie = errors.New("invalid cookie")
+	// ErrCookieExpired cookie已过期
+	ErrCookieExpired = errors.New("cookie expired")
+)
+
+// CookieCodec cookie值加密与签名，先使用AES-GCM加密，再对时间戳与密文签名
+type CookieCodec struct {
+	hashKey []byte
+	aead    *crypto.AEAD
+	now     func() time.Time // 测试时替换
+}
+
+// NewCookieCodec 创建cookie编码，hashKey为签名密钥，blockKey为16、24或32字节的AES密钥
+func NewCookieCodec(hashKey, blockKey []byte) (*CookieCodec, error) {
+	if len(hashKey) == 0 {
+		return nil, errors.New("cookie hash key is empty")
+	}
+	if n := len(blockKey); n != 16 && n != 24 && n != 32 {
+		return nil, errors.New("cookie block key must be 16, 24 or 32 bytes")
+	}
+	aead, err := crypto.NewAEAD(crypto.AESGCM, "", blockKey)
+	if err != nil {
+		return nil, err
+	}
+	return &CookieCodec{hashKey: hashKey, aead: aead, now: time.Now}, nil
+}
+
+// mac 签名内容包含cookie名称，防止将一个cookie的值用于另一个cookie
+func (c *CookieCodec) mac(name string, data []byte) []byte {
+	h := hmac.New(sha256.New, c.hashKey)
+	_, _ = h.Write([]byte(name + "|"))
+	_, _ = h.Write(data)
+	return h.Sum(nil)
+}
+
+// Encode 加密并签名，返回base64url(时间戳|密文|签名)
+func (c *CookieCodec) Encode(name string, value []byte) (string, error) {
+	cipherText, err := c.aead.Encrypt(value, []byte(name))
+	if err != nil {
+		return "", err
+	}
+
+	data := make([]byte, timestampSize, timestampSize+len(cipherText)+sha256.Size)
+	binary.BigEndian.PutUint64(data, uint64(c.now().Unix()))
+	data = append(data, cipherText...)
+	data = append(data, c.mac(name, data)...)
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Decode 校验签名与有效期后解密，maxAge为0时不校验有效期
+func (c *CookieCodec) Decode(name, value string, maxAge time.Duration) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) < timestampSize+sha256.Size {
+		return nil, ErrInvalidCookie
+	}
+	body, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(sum, c.mac(name, body)) {
+		return nil, ErrInvalidCookie
+	}
+
+	ts := time.Unix(int64(binary.BigEndian.Uint64(body)), 0)
+	if maxAge > 0 && c.now().Sub(ts) > maxAge {
+		return nil, ErrCookieExpired
+	}
+
+	plain, err := c.aead.Decrypt(body[timestampSize:], []byte(name))
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	return plain, nil
+}
@@ -0,0 +1,55 @@
+package session
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieCodec(t *testing.T) {
+	_, err := NewCookieCodec(nil, []byte("1234567890123456"))
+	assert.NotNil(t, err)
+	_, err = NewCookieCodec([]byte("hash"), []byte("123"))
+	assert.NotNil(t, err)
+
+	codec, err := NewCookieCodec([]byte("hash"), []byte("1234567890123456"))
+	assert.Nil(t, err)
+
+	value, err := codec.Encode("sid", []byte("session-id"))
+	assert.Nil(t, err)
+	buf, err := codec.Decode("sid", value, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "session-id", string(buf))
+
+	// 相同的值每次加密结果不同
+	other, _ := codec.Encode("sid", []byte("session-id"))
+	assert.NotEqual(t, value, other)
+
+	// 不能用于其它cookie
+	_, err = codec.Decode("other", value, time.Minute)
+	assert.Equal(t, ErrInvalidCookie, err)
+
+	// 篡改
+	data, _ := base64.RawURLEncoding.DecodeString(value)
+	data[10] ^= 1
+	_, err = codec.Decode("sid", base64.RawURLEncoding.EncodeToString(data), time.Minute)
+	assert.Equal(t, ErrInvalidCookie, err)
+	_, err = codec.Decode("sid", "abc", time.Minute)
+	assert.Equal(t, ErrInvalidCookie, err)
+	_, err = codec.Decode("sid", "!!!", time.Minute)
+	assert.Equal(t, ErrInvalidCookie, err)
+
+	// 签名密钥或加密密钥不同
+	other2, _ := NewCookieCodec([]byte("hash2"), []byte("1234567890123456"))
+	_, err = other2.Decode("sid", value, time.Minute)
+	assert.Equal(t, ErrInvalidCookie, err)
+
+	// 过期
+	codec.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	_, err = codec.Decode("sid", value, time.Minute)
+	assert.Equal(t, ErrCookieExpired, err)
+	_, err = codec.Decode("sid", value, 0)
+	assert.Nil(t, err)
+}
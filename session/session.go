@@ -0,0 +1,299 @@
+// 会话管理，会话数据保存在redis或内存中，cookie中只保存加密并签名的会话ID，支持滑动过期、登录时更换会话ID与CSRF token
+
+package session
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuanzhangcai/chaos/tools"
+	"github.com/yuanzhangcai/config"
+)
+
+const (
+	// StoreMemory 内存存储
+	StoreMemory = "memory"
+	// StoreRedis 使用tools.GetRedis()存储
+	StoreRedis = "redis"
+
+	// ContextKey gin.Context中保存会话的key
+	ContextKey = "chaos:session"
+
+	csrfKey = "_csrf" // 会话中保存CSRF token的key
+)
+
+// Option 会话配置
+type Option struct {
+	Store      string `json:"store"`       // 存储方式：memory/redis
+	Prefix     string `json:"prefix"`      // redis key前缀
+	Name       string `json:"name"`        // cookie名称
+	HashKey    string `json:"hash_key"`    // cookie签名密钥
+	BlockKey   string `json:"block_key"`   // cookie加密密钥，16、24或32字节
+	MaxAge     int64  `json:"max_age"`     // 闲置过期时间，单位秒，每次请求后重新计算
+	Path       string `json:"path"`        // cookie Path
+	Domain     string `json:"domain"`      // cookie Domain
+	Secure     bool   `json:"secure"`      // cookie是否只通过HTTPS发送
+	HTTPOnly   bool   `json:"http_only"`   // cookie是否禁止js读取
+	SameSite   string `json:"same_site"`   // cookie SameSite：lax/strict/none，为空时不设置
+	CSRFHeader string `json:"csrf_header"` // 读取CSRF token的请求头
+	CSRFField  string `json:"csrf_field"`  // 读取CSRF token的表单字段
+}
+
+var sameSites = map[string]http.SameSite{
+	"":       http.SameSiteDefaultMode,
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// GetOptionFromConfig 读取会话配置
+func GetOptionFromConfig() (*Option, error) {
+	opt := Option{
+		Store:      StoreMemory,
+		Prefix:     "session:",
+		Name:       "chaos_session",
+		MaxAge:     1800,
+		Path:       "/",
+		HTTPOnly:   true,
+		SameSite:   "lax",
+		CSRFHeader: "X-CSRF-Token",
+		CSRFField:  "csrf_token",
+	}
+	err := config.Scan([]string{"session"}, &opt)
+	if err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
+// Manager 会话管理
+type Manager struct {
+	option   *Option
+	store    Store
+	codec    *CookieCodec
+	sameSite http.SameSite
+}
+
+// NewManager 创建会话管理，store为nil时按配置创建存储
+func NewManager(opt *Option, store Store) (*Manager, error) {
+	sameSite, ok := sameSites[strings.ToLower(opt.SameSite)]
+	if !ok {
+		return nil, errors.New("unknown session same site (" + opt.SameSite + ")")
+	}
+	if opt.Name == "" || opt.MaxAge <= 0 {
+		return nil, errors.New("session name or max age is empty")
+	}
+	codec, err := NewCookieCodec([]byte(opt.HashKey), []byte(opt.BlockKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if store == nil {
+		switch opt.Store {
+		case "", StoreMemory:
+			store = NewMemoryStore()
+		case StoreRedis:
+			r := tools.GetRedis()
+			if r == nil {
+				return nil, errors.New("redis is not initialized for session store")
+			}
+			store = NewRedisStore(r, opt.Prefix)
+		default:
+			return nil, errors.New("unknown session store (" + opt.Store + ")")
+		}
+	}
+
+	return &Manager{
+		option:   opt,
+		store:    store,
+		codec:    codec,
+		sameSite: sameSite,
+	}, nil
+}
+
+func (c *Manager) maxAge() time.Duration {
+	return time.Duration(c.option.MaxAge) * time.Second
+}
+
+// newID 生成随机会话ID
+func newID() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Load 读取请求cookie中的会话，cookie无效或会话已过期时创建新会话
+func (c *Manager) Load(r *http.Request) (*Session, error) {
+	if cookie, err := r.Cookie(c.option.Name); err == nil && cookie.Value != "" {
+		if id, err := c.codec.Decode(c.option.Name, cookie.Value, c.maxAge()); err == nil {
+			values, err := c.store.Load(string(id))
+			if err != nil {
+				return nil, err
+			}
+			if values != nil {
+				return &Session{id: string(id), values: values}, nil
+			}
+		}
+	}
+	return c.New(), nil
+}
+
+// New 创建新会话
+func (c *Manager) New() *Session {
+	return &Session{id: newID(), values: map[string]interface{}{}, isNew: true}
+}
+
+// Save 保存会话并设置cookie，新会话没有数据时不保存，已有会话每次保存都会重新计算过期时间
+func (c *Manager) Save(w http.ResponseWriter, s *Session) error {
+	if s.destroyed {
+		if !s.isNew {
+			if err := c.store.Delete(s.id); err != nil {
+				return err
+			}
+		}
+		c.setCookie(w, "", -1)
+		return nil
+	}
+
+	if s.oldID != "" {
+		// 登录后更换会话ID，旧ID不再有效
+		if err := c.store.Delete(s.oldID); err != nil {
+			return err
+		}
+		s.oldID = ""
+	}
+	if s.isNew && len(s.values) == 0 {
+		return nil
+	}
+
+	if err := c.store.Save(s.id, s.values, c.maxAge()); err != nil {
+		return err
+	}
+	value, err := c.codec.Encode(c.option.Name, []byte(s.id))
+	if err != nil {
+		return err
+	}
+	c.setCookie(w, value, int(c.option.MaxAge))
+	s.isNew = false
+	return nil
+}
+
+func (c *Manager) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	cookie := &http.Cookie{
+		Name:     c.option.Name,
+		Value:    value,
+		Path:     c.option.Path,
+		Domain:   c.option.Domain,
+		MaxAge:   maxAge,
+		Secure:   c.option.Secure,
+		HttpOnly: c.option.HTTPOnly,
+		SameSite: c.sameSite,
+	}
+	if maxAge > 0 {
+		cookie.Expires = time.Now().Add(time.Duration(maxAge) * time.Second)
+	}
+	http.SetCookie(w, cookie)
+}
+
+// CheckCSRF 校验请求头或表单中的CSRF token，GET、HEAD、OPTIONS、TRACE请求不校验
+func (c *Manager) CheckCSRF(r *http.Request, s *Session) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	expect, _ := s.values[csrfKey].(string)
+	if expect == "" {
+		return false
+	}
+	token := r.Header.Get(c.option.CSRFHeader)
+	if token == "" && c.option.CSRFField != "" {
+		token = r.PostFormValue(c.option.CSRFField)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expect)) == 1
+}
+
+// Session 单个会话，同一请求内使用，不支持并发修改
+type Session struct {
+	id        string
+	oldID     string // 更换会话ID前的ID，保存时删除
+	values    map[string]interface{}
+	isNew     bool
+	destroyed bool
+}
+
+// ID 返回会话ID
+func (c *Session) ID() string {
+	return c.id
+}
+
+// IsNew 是否为本次请求新建的会话
+func (c *Session) IsNew() bool {
+	return c.isNew
+}
+
+// Get 读取会话数据，不存在时返回nil
+func (c *Session) Get(key string) interface{} {
+	return c.values[key]
+}
+
+// GetString 读取字符串类型的会话数据
+func (c *Session) GetString(key string) string {
+	str, _ := c.values[key].(string)
+	return str
+}
+
+// Set 设置会话数据，值需要能够JSON序列化
+func (c *Session) Set(key string, value interface{}) {
+	c.values[key] = value
+}
+
+// Delete 删除会话数据
+func (c *Session) Delete(key string) {
+	delete(c.values, key)
+}
+
+// Rotate 更换会话ID并重新生成CSRF token，保留会话数据，登录成功后调用，防止会话固定攻击
+func (c *Session) Rotate() {
+	if !c.isNew && c.oldID == "" {
+		c.oldID = c.id
+	}
+	c.id = newID()
+	delete(c.values, csrfKey)
+}
+
+// Destroy 销毁会话，退出登录时调用
+func (c *Session) Destroy() {
+	c.destroyed = true
+	c.values = map[string]interface{}{}
+}
+
+// CSRFToken 返回会话的CSRF token，没有时生成，表单中以csrf_field字段或请求头提交
+func (c *Session) CSRFToken() string {
+	token, _ := c.values[csrfKey].(string)
+	if token == "" {
+		token = newID()
+		c.values[csrfKey] = token
+	}
+	return token
+}
+
+// FromContext 获取会话中间件保存的会话，没有使用会话中间件时返回nil
+func FromContext(ctx *gin.Context) *Session {
+	if ctx != nil {
+		if value, ok := ctx.Get(ContextKey); ok {
+			if s, ok := value.(*Session); ok {
+				return s
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/config"
+)
+
+// testOption 测试用的会话配置
+var testOption = Option{
+	Name:       "chaos_session",
+	HashKey:    "hash",
+	BlockKey:   "1234567890123456",
+	MaxAge:     1800,
+	Path:       "/",
+	Domain:     "example.com",
+	Secure:     true,
+	HTTPOnly:   true,
+	SameSite:   "lax",
+	CSRFHeader: "X-CSRF-Token",
+	CSRFField:  "csrf_token",
+}
+
+// cookieOf 返回响应中设置的会话cookie
+func cookieOf(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, one := range w.Result().Cookies() {
+		if one.Name == "chaos_session" {
+			return one
+		}
+	}
+	return nil
+}
+
+func requestWith(cookie *http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestGetOptionFromConfig(t *testing.T) {
+	_ = config.LoadMemory(`{"session": {"store": "redis", "max_age": 60, "same_site": "strict"}}`, "json")
+	opt, err := GetOptionFromConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, StoreRedis, opt.Store)
+	assert.Equal(t, int64(60), opt.MaxAge)
+	assert.Equal(t, "strict", opt.SameSite)
+	assert.Equal(t, "chaos_session", opt.Name)
+	assert.True(t, opt.HTTPOnly)
+
+	opt.HashKey, opt.BlockKey = "hash", "1234567890123456"
+	_, err = NewManager(opt, nil) // redis没有初始化
+	assert.NotNil(t, err)
+	opt.Store = "file"
+	_, err = NewManager(opt, nil)
+	assert.NotNil(t, err)
+	opt.Store, opt.SameSite = StoreMemory, "always"
+	_, err = NewManager(opt, nil)
+	assert.NotNil(t, err)
+	opt.SameSite, opt.MaxAge = "none", 0
+	_, err = NewManager(opt, nil)
+	assert.NotNil(t, err)
+	opt.MaxAge, opt.BlockKey = 60, ""
+	_, err = NewManager(opt, nil)
+	assert.NotNil(t, err)
+}
+
+func TestManager(t *testing.T) {
+	opt := testOption
+	store := NewMemoryStore()
+	m, err := NewManager(&opt, store)
+	assert.Nil(t, err)
+
+	// 新会话没有数据时不保存
+	s, err := m.Load(requestWith(nil))
+	assert.Nil(t, err)
+	assert.True(t, s.IsNew())
+	w := httptest.NewRecorder()
+	assert.Nil(t, m.Save(w, s))
+	assert.Nil(t, cookieOf(w))
+
+	s.Set("nid", "1707357")
+	s.Set("score", 100)
+	w = httptest.NewRecorder()
+	assert.Nil(t, m.Save(w, s))
+	cookie := cookieOf(w)
+	assert.NotNil(t, cookie)
+	assert.Equal(t, 1800, cookie.MaxAge)
+	assert.Equal(t, "/", cookie.Path)
+	assert.Equal(t, "example.com", cookie.Domain)
+	assert.True(t, cookie.Secure)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	assert.NotContains(t, cookie.Value, s.ID())
+
+	// 读取已有会话，数字读取后为float64
+	s2, err := m.Load(requestWith(cookie))
+	assert.Nil(t, err)
+	assert.False(t, s2.IsNew())
+	assert.Equal(t, s.ID(), s2.ID())
+	assert.Equal(t, "1707357", s2.GetString("nid"))
+	assert.Equal(t, float64(100), s2.Get("score"))
+	s2.Delete("score")
+	assert.Nil(t, s2.Get("score"))
+
+	// 登录后更换会话ID，旧cookie失效
+	oldID := s2.ID()
+	s2.Rotate()
+	assert.NotEqual(t, oldID, s2.ID())
+	w = httptest.NewRecorder()
+	assert.Nil(t, m.Save(w, s2))
+	values, _ := store.Load(oldID)
+	assert.Nil(t, values)
+	s3, _ := m.Load(requestWith(cookie))
+	assert.True(t, s3.IsNew())
+	cookie = cookieOf(w)
+	s3, _ = m.Load(requestWith(cookie))
+	assert.Equal(t, s2.ID(), s3.ID())
+	assert.Equal(t, "1707357", s3.GetString("nid"))
+
+	// 伪造的cookie
+	s4, err := m.Load(requestWith(&http.Cookie{Name: "chaos_session", Value: s3.ID()}))
+	assert.Nil(t, err)
+	assert.True(t, s4.IsNew())
+
+	// 退出登录
+	s3.Destroy()
+	w = httptest.NewRecorder()
+	assert.Nil(t, m.Save(w, s3))
+	assert.True(t, cookieOf(w).MaxAge < 0)
+	values, _ = store.Load(s3.ID())
+	assert.Nil(t, values)
+}
+
+func TestSlidingExpiry(t *testing.T) {
+	opt := testOption
+	opt.MaxAge = 1
+	store := NewMemoryStore()
+	m, err := NewManager(&opt, store)
+	assert.Nil(t, err)
+
+	s := m.New()
+	s.Set("nid", "1")
+	assert.Nil(t, m.Save(httptest.NewRecorder(), s))
+
+	// 每次请求后重新计算过期时间
+	for i := 0; i < 3; i++ {
+		time.Sleep(600 * time.Millisecond)
+		values, _ := store.Load(s.ID())
+		assert.NotNil(t, values)
+		assert.Nil(t, m.Save(httptest.NewRecorder(), s))
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	values, _ := store.Load(s.ID())
+	assert.Nil(t, values)
+}
+
+func TestCSRF(t *testing.T) {
+	opt := testOption
+	m, err := NewManager(&opt, nil)
+	assert.Nil(t, err)
+	s := m.New()
+
+	post := func(header, field string) *http.Request {
+		form := url.Values{}
+		if field != "" {
+			form.Set("csrf_token", field)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			r.Header.Set("X-CSRF-Token", header)
+		}
+		return r
+	}
+
+	assert.True(t, m.CheckCSRF(httptest.NewRequest(http.MethodGet, "/", nil), s))
+	assert.False(t, m.CheckCSRF(post("", ""), s)) // 没有生成过token
+
+	token := s.CSRFToken()
+	assert.Equal(t, token, s.CSRFToken())
+	assert.True(t, m.CheckCSRF(post(token, ""), s))
+	assert.True(t, m.CheckCSRF(post("", token), s))
+	assert.False(t, m.CheckCSRF(post("abc", ""), s))
+	assert.False(t, m.CheckCSRF(post("", ""), s))
+
+	// 更换会话ID后重新生成
+	s.Rotate()
+	assert.False(t, m.CheckCSRF(post(token, ""), s))
+	assert.NotEqual(t, token, s.CSRFToken())
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Nil(t, FromContext(nil))
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Nil(t, FromContext(c))
+	s := &Session{}
+	c.Set(ContextKey, s)
+	assert.Equal(t, s, FromContext(c))
+}
@@ -0,0 +1,93 @@
+package session
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis"
+	cache "github.com/patrickmn/go-cache"
+	"github.com/yuanzhangcai/chaos/tools"
+)
+
+// Store 会话的服务端存储，值使用JSON序列化，数字读取后为float64
+type Store interface {
+	// Load 读取会话，不存在或已过期时返回nil
+	Load(id string) (map[string]interface{}, error)
+	// Save 保存会话，ttl后过期
+	Save(id string, values map[string]interface{}, ttl time.Duration) error
+	// Delete 删除会话
+	Delete(id string) error
+}
+
+// MemoryStore 内存存储，只适用于单实例部署
+type MemoryStore struct {
+	cache *cache.Cache
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{cache: cache.New(time.Minute, time.Minute)}
+}
+
+// Load 实现Store
+func (c *MemoryStore) Load(id string) (map[string]interface{}, error) {
+	buf, ok := c.cache.Get(id)
+	if !ok {
+		return nil, nil
+	}
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(buf.([]byte), &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Save 实现Store，与redis存储一样保存JSON，避免请求之间共享同一个map
+func (c *MemoryStore) Save(id string, values map[string]interface{}, ttl time.Duration) error {
+	buf, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	c.cache.Set(id, buf, ttl)
+	return nil
+}
+
+// Delete 实现Store
+func (c *MemoryStore) Delete(id string) error {
+	c.cache.Delete(id)
+	return nil
+}
+
+// RedisStore redis存储，使用SetObject/GetObject保存JSON
+type RedisStore struct {
+	redis  *tools.Redis
+	prefix string
+}
+
+// NewRedisStore 创建redis存储，key为prefix+会话ID
+func NewRedisStore(r *tools.Redis, prefix string) *RedisStore {
+	return &RedisStore{redis: r, prefix: prefix}
+}
+
+// Load 实现Store
+func (c *RedisStore) Load(id string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	err := c.redis.GetObject(c.prefix+id, &values)
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Save 实现Store
+func (c *RedisStore) Save(id string, values map[string]interface{}, ttl time.Duration) error {
+	return c.redis.SetObject(c.prefix+id, values, ttl)
+}
+
+// Delete 实现Store
+func (c *RedisStore) Delete(id string) error {
+	return c.redis.DelObject(c.prefix + id)
+}
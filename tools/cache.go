@@ -0,0 +1,332 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	gocache "github.com/patrickmn/go-cache"
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/chaos/monitor"
+)
+
+// ErrNotFound 数据不存在，Loader返回该错误时按CacheOption.NegativeTTL缓存不存在的结果
+var ErrNotFound = errors.New("cache: not found")
+
+// 缓存值的第一个字节，区分数据与不存在的标记
+const (
+	cacheValue    byte = 1
+	cacheNegative byte = 2
+)
+
+// CacheOption 缓存配置
+type CacheOption struct {
+	Name        string        // 缓存名，作为redis key的前缀与监控的标签，不能为空
+	TTL         time.Duration // 默认过期时间，GetOrLoad与Set的ttl为0时使用
+	Jitter      float64       // 过期时间随机增加的比例，避免同时过期，如0.1表示增加0~10%
+	NegativeTTL time.Duration // 数据不存在时的缓存时间，0表示不缓存
+	LocalTTL    time.Duration // 进程内缓存的时间，0表示不使用，多实例之间不同步，应设置为秒级
+	Codec       Codec         // 编码方式，默认为JSONCodec
+}
+
+// Loader 缓存未命中时加载数据，数据不存在时返回ErrNotFound
+type Loader[T any] func(ctx context.Context) (T, error)
+
+// Cache 类型化的redis缓存，支持进程内缓存、并发加载合并与不存在结果的缓存
+type Cache[T any] struct {
+	option CacheOption
+	prefix string
+	store  cacheStore
+	local  *gocache.Cache // 未开启时为nil
+	group  flightGroup[T]
+}
+
+// localEntry 进程内缓存的值
+type localEntry[T any] struct {
+	value T
+	found bool
+}
+
+// NewCache 创建缓存，r为nil时只使用进程内缓存
+func NewCache[T any](r *Redis, opt CacheOption) (*Cache[T], error) {
+	if opt.Name == "" {
+		return nil, errors.New("cache name is required")
+	}
+	if r == nil && opt.LocalTTL <= 0 {
+		return nil, errors.New("cache " + opt.Name + " needs redis or local ttl")
+	}
+	if opt.Codec == nil {
+		opt.Codec = JSONCodec
+	}
+
+	c := &Cache[T]{option: opt, prefix: opt.Name + ":"}
+	if r != nil {
+		c.store = redisStore{r}
+		c.prefix = r.prefix + c.prefix
+	}
+	if opt.LocalTTL > 0 {
+		c.local = gocache.New(opt.LocalTTL, 2*opt.LocalTTL)
+	}
+	return c, nil
+}
+
+// Name 缓存名
+func (c *Cache[T]) Name() string {
+	return c.option.Name
+}
+
+// Get 获取缓存，不存在或缓存了不存在的结果时返回ErrNotFound
+func (c *Cache[T]) Get(ctx context.Context, key string) (T, error) {
+	if value, found, ok := c.getLocal(key); ok {
+		return c.result(value, found)
+	}
+	value, found, ok, err := c.getRemote(ctx, key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	if !ok {
+		monitor.AddCacheRequest(c.option.Name, "miss")
+		var zero T
+		return zero, ErrNotFound
+	}
+	return c.result(value, found)
+}
+
+// Set 写入缓存，ttl为0时使用默认过期时间
+func (c *Cache[T]) Set(ctx context.Context, key string, value T, ttl time.Duration) error {
+	data, err := c.option.Codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.save(ctx, key, localEntry[T]{value: value, found: true}, append([]byte{cacheValue}, data...), c.ttl(ttl))
+}
+
+// Delete 删除缓存，数据修改后调用，其它实例的进程内缓存在LocalTTL后过期
+func (c *Cache[T]) Delete(ctx context.Context, key string) error {
+	if c.local != nil {
+		c.local.Delete(key)
+	}
+	if c.store == nil {
+		return nil
+	}
+	return c.store.del(ctx, c.prefix+key)
+}
+
+// GetOrLoad 获取缓存，未命中时调用loader加载并写入缓存，ttl为0时使用默认过期时间。
+// 同一个key同时只有一个loader在执行，其它调用等待并共享结果，loader使用第一个调用的ctx
+func (c *Cache[T]) GetOrLoad(ctx context.Context, key string, loader Loader[T], ttl time.Duration) (T, error) {
+	if value, found, ok := c.getLocal(key); ok {
+		return c.result(value, found)
+	}
+
+	entry, err := c.group.do(key, func() (localEntry[T], error) {
+		value, found, ok, err := c.getRemote(ctx, key)
+		if err != nil {
+			// redis不可用时直接加载，避免缓存故障导致服务不可用
+			log.L().Warn("cache "+c.option.Name+" get "+key+" failed: ", err)
+		} else if ok {
+			return localEntry[T]{value: value, found: found}, nil
+		}
+
+		monitor.AddCacheRequest(c.option.Name, "miss")
+		return c.load(ctx, key, loader, ttl)
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return c.result(entry.value, entry.found)
+}
+
+// load 加载数据并写入缓存，写入失败只记录日志
+func (c *Cache[T]) load(ctx context.Context, key string, loader Loader[T], ttl time.Duration) (localEntry[T], error) {
+	start := time.Now()
+	value, err := loader(ctx)
+	loadErr := err
+	if errors.Is(err, ErrNotFound) {
+		loadErr = nil
+	}
+	monitor.ObserveCacheLoad(c.option.Name, time.Since(start), loadErr)
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if c.option.NegativeTTL > 0 {
+			entry := localEntry[T]{}
+			if err := c.save(ctx, key, entry, []byte{cacheNegative}, c.jitter(c.option.NegativeTTL)); err != nil {
+				log.L().Warn("cache "+c.option.Name+" set "+key+" failed: ", err)
+			}
+		}
+		return localEntry[T]{}, nil
+	case err != nil:
+		return localEntry[T]{}, err
+	}
+
+	if err := c.Set(ctx, key, value, ttl); err != nil {
+		log.L().Warn("cache "+c.option.Name+" set "+key+" failed: ", err)
+	}
+	return localEntry[T]{value: value, found: true}, nil
+}
+
+func (c *Cache[T]) result(value T, found bool) (T, error) {
+	if !found {
+		return value, ErrNotFound
+	}
+	return value, nil
+}
+
+// getLocal 读取进程内缓存，ok表示命中
+func (c *Cache[T]) getLocal(key string) (value T, found bool, ok bool) {
+	if c.local == nil {
+		return value, false, false
+	}
+	tmp, ok := c.local.Get(key)
+	if !ok {
+		return value, false, false
+	}
+	entry := tmp.(localEntry[T])
+	if entry.found {
+		monitor.AddCacheRequest(c.option.Name, "local_hit")
+	} else {
+		monitor.AddCacheRequest(c.option.Name, "negative_hit")
+	}
+	return entry.value, entry.found, true
+}
+
+// getRemote 读取redis，ok表示命中，解码失败按未命中处理，加载后会覆盖
+func (c *Cache[T]) getRemote(ctx context.Context, key string) (value T, found bool, ok bool, err error) {
+	if c.store == nil {
+		return value, false, false, nil
+	}
+	data, err := c.store.get(ctx, c.prefix+key)
+	if err == redis.Nil {
+		return value, false, false, nil
+	}
+	if err != nil {
+		monitor.AddCacheRequest(c.option.Name, "error")
+		return value, false, false, err
+	}
+
+	switch {
+	case len(data) == 1 && data[0] == cacheNegative:
+		monitor.AddCacheRequest(c.option.Name, "negative_hit")
+		c.setLocal(key, localEntry[T]{}, c.option.NegativeTTL)
+		return value, false, true, nil
+	case len(data) > 0 && data[0] == cacheValue:
+		if err = c.option.Codec.Unmarshal(data[1:], &value); err == nil {
+			monitor.AddCacheRequest(c.option.Name, "hit")
+			c.setLocal(key, localEntry[T]{value: value, found: true}, c.option.TTL)
+			return value, true, true, nil
+		}
+	default:
+		err = errors.New("unknown cache format")
+	}
+	monitor.AddCacheRequest(c.option.Name, "error")
+	log.L().Warn("cache "+c.option.Name+" decode "+key+" failed: ", err)
+	var zero T
+	return zero, false, false, nil
+}
+
+// save 写入redis与进程内缓存
+func (c *Cache[T]) save(ctx context.Context, key string, entry localEntry[T], data []byte, ttl time.Duration) error {
+	c.setLocal(key, entry, ttl)
+	if c.store == nil {
+		return nil
+	}
+	return c.store.set(ctx, c.prefix+key, data, ttl)
+}
+
+// setLocal 写入进程内缓存，过期时间不超过ttl
+func (c *Cache[T]) setLocal(key string, entry localEntry[T], ttl time.Duration) {
+	if c.local == nil {
+		return
+	}
+	localTTL := c.option.LocalTTL
+	if ttl > 0 && ttl < localTTL {
+		localTTL = ttl
+	}
+	c.local.Set(key, entry, localTTL)
+}
+
+// ttl 返回增加随机时间后的过期时间
+func (c *Cache[T]) ttl(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		ttl = c.option.TTL
+	}
+	return c.jitter(ttl)
+}
+
+func (c *Cache[T]) jitter(ttl time.Duration) time.Duration {
+	if c.option.Jitter <= 0 || ttl <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Float64()*c.option.Jitter*float64(ttl))
+}
+
+// cacheStore 缓存的远程存储
+type cacheStore interface {
+	get(ctx context.Context, key string) ([]byte, error) // 不存在时返回redis.Nil
+	set(ctx context.Context, key string, data []byte, ttl time.Duration) error
+	del(ctx context.Context, key string) error
+}
+
+type redisStore struct {
+	redis *Redis
+}
+
+func (c redisStore) get(ctx context.Context, key string) ([]byte, error) {
+	return c.redis.WithContext(ctx).Get(key).Bytes()
+}
+
+func (c redisStore) set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	return c.redis.WithContext(ctx).Set(key, data, ttl).Err()
+}
+
+func (c redisStore) del(ctx context.Context, key string) error {
+	return c.redis.WithContext(ctx).Del(key).Err()
+}
+
+// flightGroup 合并同一个key的并发调用
+type flightGroup[T any] struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+type flightCall[T any] struct {
+	wg    sync.WaitGroup
+	value localEntry[T]
+	err   error
+}
+
+func (c *flightGroup[T]) do(key string, fn func() (localEntry[T], error)) (value localEntry[T], err error) {
+	c.lock.Lock()
+	if c.calls == nil {
+		c.calls = map[string]*flightCall[T]{}
+	}
+	if call, ok := c.calls[key]; ok {
+		c.lock.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+	call := &flightCall[T]{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.lock.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = fmt.Errorf("cache load panic: %v", r)
+			value, err = call.value, call.err
+		}
+		call.wg.Done()
+		c.lock.Lock()
+		delete(c.calls, key)
+		c.lock.Unlock()
+	}()
+	call.value, call.err = fn()
+	return call.value, call.err
+}
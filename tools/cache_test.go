@@ -0,0 +1,277 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type cacheUser struct {
+	ID   int64    `json:"id"`
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+func TestNewCache(t *testing.T) {
+	_, err := NewCache[int](nil, CacheOption{})
+	assert.NotNil(t, err)
+	_, err = NewCache[int](nil, CacheOption{Name: "user"})
+	assert.NotNil(t, err)
+
+	c, err := NewCache[int](nil, CacheOption{Name: "user", LocalTTL: time.Second})
+	assert.Nil(t, err)
+	assert.Equal(t, "user", c.Name())
+	assert.Equal(t, JSONCodec, c.option.Codec)
+
+	ctx := context.Background()
+	_, err = c.Get(ctx, "1")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Nil(t, c.Set(ctx, "1", 100, 0))
+	value, err := c.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, 100, value)
+	assert.Nil(t, c.Delete(ctx, "1"))
+	_, err = c.Get(ctx, "1")
+	assert.Equal(t, ErrNotFound, err)
+
+	// 使用redis时key带有redis前缀
+	mr := miniredis.RunT(t)
+	r, err := NewRedis(mr.Addr(), "", "chaos:")
+	assert.Nil(t, err)
+	defer r.Close()
+	c, err = NewCache[int](r, CacheOption{Name: "user"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Set(ctx, "1", 100, time.Minute))
+	data, err := mr.Get("chaos:user:1")
+	assert.Nil(t, err)
+	assert.Equal(t, "\x01100", data)
+	assert.Equal(t, time.Minute, mr.TTL("chaos:user:1"))
+	value, err = c.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, 100, value)
+	assert.Nil(t, c.Delete(ctx, "1"))
+	assert.False(t, mr.Exists("chaos:user:1"))
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r, err := NewRedis(mr.Addr(), "", "")
+	assert.Nil(t, err)
+	defer r.Close()
+	c, err := NewCache[cacheUser](r, CacheOption{Name: "user", TTL: time.Minute})
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	var loads int32
+	loader := func(ctx context.Context) (cacheUser, error) {
+		atomic.AddInt32(&loads, 1)
+		return cacheUser{ID: 1, Name: "chaos", Tags: []string{"vip"}}, nil
+	}
+
+	user, err := c.GetOrLoad(ctx, "1", loader, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "chaos", user.Name)
+	data, _ := mr.Get("user:1")
+	assert.Equal(t, "\x01"+`{"id":1,"name":"chaos","tags":["vip"]}`, data)
+	assert.Equal(t, time.Minute, mr.TTL("user:1"))
+
+	user, err = c.GetOrLoad(ctx, "1", loader, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), user.ID)
+	assert.Equal(t, int32(1), loads)
+
+	user, err = c.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"vip"}, user.Tags)
+
+	// 自定义过期时间
+	_, err = c.GetOrLoad(ctx, "2", loader, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, mr.TTL("user:2"))
+
+	// 数据损坏时重新加载
+	assert.Nil(t, mr.Set("user:1", "\x01{"))
+	_, err = c.GetOrLoad(ctx, "1", loader, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), loads)
+	assert.Nil(t, mr.Set("user:1", "legacy"))
+	_, err = c.GetOrLoad(ctx, "1", loader, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), loads)
+
+	// 加载失败不缓存
+	_, err = c.GetOrLoad(ctx, "3", func(ctx context.Context) (cacheUser, error) {
+		return cacheUser{}, errors.New("db error")
+	}, 0)
+	assert.EqualError(t, err, "db error")
+	assert.False(t, mr.Exists("user:3"))
+
+	// redis不可用时直接加载
+	mr.SetError("connection refused")
+	user, err = c.GetOrLoad(ctx, "1", loader, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "chaos", user.Name)
+	_, err = c.Get(ctx, "1")
+	assert.EqualError(t, err, "connection refused")
+
+	// loader panic
+	mr.SetError("")
+	_, err = c.GetOrLoad(ctx, "4", func(ctx context.Context) (cacheUser, error) {
+		panic("oops")
+	}, 0)
+	assert.EqualError(t, err, "cache load panic: oops")
+}
+
+func TestCacheNegative(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r, err := NewRedis(mr.Addr(), "", "")
+	assert.Nil(t, err)
+	defer r.Close()
+	c, err := NewCache[cacheUser](r, CacheOption{Name: "user", TTL: time.Minute, NegativeTTL: 10 * time.Second})
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	var loads int32
+	loader := func(ctx context.Context) (cacheUser, error) {
+		atomic.AddInt32(&loads, 1)
+		return cacheUser{}, ErrNotFound
+	}
+	for i := 0; i < 3; i++ {
+		_, err := c.GetOrLoad(ctx, "404", loader, 0)
+		assert.Equal(t, ErrNotFound, err)
+	}
+	assert.Equal(t, int32(1), loads)
+	data, _ := mr.Get("user:404")
+	assert.Equal(t, "\x02", data)
+	assert.Equal(t, 10*time.Second, mr.TTL("user:404"))
+	_, err = c.Get(ctx, "404")
+	assert.Equal(t, ErrNotFound, err)
+
+	// 不缓存不存在的结果
+	c, err = NewCache[cacheUser](r, CacheOption{Name: "user", TTL: time.Minute})
+	assert.Nil(t, err)
+	_, _ = c.GetOrLoad(ctx, "405", loader, 0)
+	_, err = c.GetOrLoad(ctx, "405", loader, 0)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, int32(3), loads)
+}
+
+func TestCacheLocal(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r, err := NewRedis(mr.Addr(), "", "")
+	assert.Nil(t, err)
+	defer r.Close()
+	c, err := NewCache[cacheUser](r, CacheOption{Name: "user", TTL: time.Minute, LocalTTL: time.Minute, NegativeTTL: time.Minute})
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	var loads int32
+	loader := func(ctx context.Context) (cacheUser, error) {
+		atomic.AddInt32(&loads, 1)
+		return cacheUser{ID: 1}, nil
+	}
+	_, err = c.GetOrLoad(ctx, "1", loader, 0)
+	assert.Nil(t, err)
+
+	// 进程内缓存命中时不读取redis
+	mr.SetError("connection refused")
+	user, err := c.GetOrLoad(ctx, "1", loader, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), user.ID)
+	assert.Equal(t, int32(1), loads)
+
+	// redis命中后写入进程内缓存
+	mr.SetError("")
+	assert.Nil(t, mr.Set("user:2", "\x01"+`{"id":2}`))
+	_, err = c.Get(ctx, "2")
+	assert.Nil(t, err)
+	mr.Del("user:2")
+	user, err = c.Get(ctx, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), user.ID)
+
+	assert.Nil(t, c.Delete(ctx, "1"))
+	_, err = c.GetOrLoad(ctx, "1", loader, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), loads)
+}
+
+func TestCacheSingleflight(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r, err := NewRedis(mr.Addr(), "", "")
+	assert.Nil(t, err)
+	defer r.Close()
+	c, err := NewCache[cacheUser](r, CacheOption{Name: "user", TTL: time.Minute})
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	var loads int32
+	start := make(chan struct{})
+	loader := func(ctx context.Context) (cacheUser, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(50 * time.Millisecond)
+		return cacheUser{ID: 7}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			user, err := c.GetOrLoad(ctx, "7", loader, 0)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(7), user.ID)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.Equal(t, int32(1), loads)
+}
+
+func TestCacheJitter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r, err := NewRedis(mr.Addr(), "", "")
+	assert.Nil(t, err)
+	defer r.Close()
+	c, err := NewCache[cacheUser](r, CacheOption{Name: "user", TTL: 100 * time.Second, Jitter: 0.1})
+	assert.Nil(t, err)
+	ctx := context.Background()
+	for _, key := range []string{"1", "2", "3", "4", "5"} {
+		assert.Nil(t, c.Set(ctx, key, cacheUser{}, 0))
+		ttl := mr.TTL("user:" + key)
+		assert.True(t, ttl >= 100*time.Second && ttl <= 110*time.Second, ttl)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	user := cacheUser{ID: 1, Name: "chaos", Tags: []string{"a", "b"}}
+	for _, codec := range []Codec{JSONCodec, GobCodec, MsgpackCodec} {
+		data, err := codec.Marshal(user)
+		assert.Nil(t, err)
+		var tmp cacheUser
+		assert.Nil(t, codec.Unmarshal(data, &tmp))
+		assert.Equal(t, user, tmp)
+		assert.NotNil(t, codec.Unmarshal([]byte{0xc1}, &tmp))
+	}
+
+	mr := miniredis.RunT(t)
+	r, err := NewRedis(mr.Addr(), "", "")
+	assert.Nil(t, err)
+	defer r.Close()
+	c, err := NewCache[cacheUser](r, CacheOption{Name: "user", TTL: time.Minute, Codec: MsgpackCodec})
+	assert.Nil(t, err)
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, "1", user, 0))
+	data, _ := mr.Get("user:1")
+	assert.Equal(t, cacheValue, data[0])
+	tmp, err := c.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, user, tmp)
+}
@@ -1,19 +1,23 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"strconv"
 	"time"
 
 	"github.com/go-redis/redis"
-	"github.com/sirupsen/logrus"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/yuanzhangcai/chaos/log"
+	"github.com/yuanzhangcai/chaos/monitor"
 )
 
 // Redis redis组件
 type Redis struct {
 	*redis.Client
 	prefix string
+	base   *redis.Client // 只统计监控指标、未绑定上下文的客户端，WithContext基于它创建
 }
 
 var client *Redis
@@ -31,11 +35,13 @@ func NewRedis(server, password, prefix string) (*Redis, error) {
 		}),
 		prefix: prefix,
 	}
+	cli.instrument()
+	cli.base = cli.Client
 
 	pong, err := cli.Ping().Result()
 	if err != nil {
-		logrus.Error(pong)
-		logrus.Error(err)
+		log.L().Error(pong)
+		log.L().Error(err)
 		return nil, err
 	}
 	return cli, nil
@@ -61,6 +67,78 @@ func GetRedis() *Redis {
 	return client
 }
 
+// WithContext 返回绑定请求上下文的redis对象，之后的命令会作为上下文中span的子span
+func (c *Redis) WithContext(ctx context.Context) *Redis {
+	// 复制的客户端会保留原客户端包装过的命令处理函数，所以只在未绑定上下文的客户端上增加跟踪，
+	// 监控指标仍由原客户端统计一次
+	cli := &Redis{
+		Client: c.base.WithContext(ctx),
+		prefix: c.prefix,
+		base:   c.base,
+	}
+	cli.trace()
+	return cli
+}
+
+// instrument 上报redis命令耗时与错误数
+func (c *Redis) instrument() {
+	observe := func(command string, process func() error) error {
+		start := time.Now()
+		err := process()
+
+		cmdErr := err
+		if cmdErr == redis.Nil { // key不存在不算错误
+			cmdErr = nil
+		}
+		monitor.ObserveRedisCmd(command, time.Since(start), cmdErr)
+		return err
+	}
+
+	c.WrapProcess(func(old func(redis.Cmder) error) func(redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			return observe(cmd.Name(), func() error { return old(cmd) })
+		}
+	})
+
+	c.WrapProcessPipeline(func(old func([]redis.Cmder) error) func([]redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			return observe("pipeline", func() error { return old(cmds) })
+		}
+	})
+}
+
+// trace 将redis命令作为客户端上下文中span的子span
+func (c *Redis) trace() {
+	trace := func(command string, process func() error) error {
+		span := monitor.StartChildSpan(c.Context(), "redis."+command, map[string]interface{}{
+			string(ext.DBType):     "redis",
+			string(ext.DBInstance): c.Options().Addr,
+		})
+
+		err := process()
+		if span != nil {
+			if err != nil && err != redis.Nil {
+				ext.Error.Set(span, true)
+				span.SetTag("error.message", err.Error())
+			}
+			span.Finish()
+		}
+		return err
+	}
+
+	c.WrapProcess(func(old func(redis.Cmder) error) func(redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			return trace(cmd.Name(), func() error { return old(cmd) })
+		}
+	})
+
+	c.WrapProcessPipeline(func(old func([]redis.Cmder) error) func([]redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			return trace("pipeline", func() error { return old(cmds) })
+		}
+	})
+}
+
 // SetObject 设置redis对象
 func (c *Redis) SetObject(key string, value interface{}, expire time.Duration) error {
 	key = c.prefix + key
@@ -80,12 +158,12 @@ func (c *Redis) GetObject(key string, value interface{}) error {
 		return ret.Err()
 	}
 
-	err := json.Unmarshal([]byte(ret.Val()), value)
-	if err == nil {
-		return err
-	}
+	return json.Unmarshal([]byte(ret.Val()), value)
+}
 
-	return nil
+// DelObject 删除redis对象
+func (c *Redis) DelObject(key string) error {
+	return c.Del(c.prefix + key).Err()
 }
 
 // GenerateScoreKey 生成积分redis的key
@@ -1,10 +1,15 @@
 package tools
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"github.com/yuanzhangcai/chaos/monitor"
 )
 
 var (
@@ -13,6 +18,43 @@ var (
 	prefix   = ""
 )
 
+// redisCmdCount 返回redis命令耗时分布中某个命令的统计次数
+func redisCmdCount(t *testing.T, command string) uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		if !strings.Contains(family.GetName(), "redis_cmd_duration_seconds") {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "command" && label.GetValue() == command {
+					return metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestWithContextMetrics(t *testing.T) {
+	monitor.SetMetrics()
+	mr := miniredis.RunT(t)
+	cli, err := NewRedis(mr.Addr(), "", "")
+	assert.Nil(t, err)
+
+	// 绑定上下文后的命令只统计一次
+	count := redisCmdCount(t, "get")
+	_ = cli.WithContext(context.Background()).Get("key").Err()
+	assert.Equal(t, count+1, redisCmdCount(t, "get"))
+
+	_ = cli.WithContext(context.Background()).WithContext(context.Background()).Get("key").Err()
+	assert.Equal(t, count+2, redisCmdCount(t, "get"))
+
+	_ = cli.Get("key").Err()
+	assert.Equal(t, count+3, redisCmdCount(t, "get"))
+}
+
 func TestInitRedis(t *testing.T) {
 	err := InitRedis("", password, prefix)
 	assert.NotNil(t, err)